@@ -1,48 +1,553 @@
 package main
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
     "log"
     "net/http"
+    "os"
+    "os/signal"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
 
+    "pricingserver/internal/audit"
+    "pricingserver/internal/common/secrets"
+    "pricingserver/internal/contracts"
     "pricingserver/internal/server"
+    "pricingserver/internal/simulation"
 
     "github.com/gorilla/websocket"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
     "pricingserver/internal/common/logging"
 )
 
+const (
+    defaultLongPollTimeout = 30 * time.Second
+    maxLongPollTimeout     = 60 * time.Second
+)
+
+// upgrader accepts the subprotocols of the hub, set in main
 var upgrader = websocket.Upgrader{
     CheckOrigin: func(r *http.Request) bool { return true },
 }
 
 func serveWs(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
-    conn, err := upgrader.Upgrade(w, r, nil)
+    if !hub.AcceptingConnections() {
+        http.Error(w, "Server is not accepting connections", http.StatusServiceUnavailable)
+        return
+    }
+
+    // Connections carrying the admin token may send admin messages
+    admin := server.ValidAdminRequest(r)
+    claims := &server.Claims{}
+    if !admin {
+        var err error
+        claims, err = server.AuthenticateRequest(r)
+        if err != nil {
+            logging.DebugLog("Rejecting connection: %v", err)
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+    }
+
+    config := server.ClientConfig{
+        SerializationFormat: r.Header.Get("X-Serialization-Format"),
+        MessageEncoding:     r.Header.Get("X-Message-Encoding"),
+        Compression:         r.Header.Get("X-Compression"),
+    }
+    if subprotocol := server.NegotiateSubprotocol(r, hub.Subprotocols); subprotocol != "" {
+        config.Subprotocol = subprotocol
+        config.SerializationFormat = server.SubprotocolSerializationFormat(subprotocol)
+    } else if len(websocket.Subprotocols(r)) > 0 {
+        // Clients offering no subprotocol still negotiate with headers
+        logging.DebugLog("Rejecting connection offering unsupported subprotocols %v", websocket.Subprotocols(r))
+        w.Header().Set("Upgrade", "websocket")
+        w.Header().Set("Sec-WebSocket-Protocol", strings.Join(hub.Subprotocols, ", "))
+        http.Error(w, "Unsupported WebSocket subprotocol", http.StatusUpgradeRequired)
+        return
+    }
+    if err := server.ValidateMessageEncoding(config.MessageEncoding, config.SerializationFormat); err != nil {
+        logging.DebugLog("Rejecting connection: %v", err)
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err := server.ValidateCompression(config.Compression, config.MessageEncoding); err != nil {
+        logging.DebugLog("Rejecting connection: %v", err)
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    // The session ID lets the client restore its contracts when it reconnects
+    sessionID := server.GenerateUniqueID()
+    conn, err := upgrader.Upgrade(w, r, http.Header{"X-Session-ID": []string{sessionID}})
     if err != nil {
-        logging.DebugLog("Upgrade error:", err)
+        logging.DebugLog("Upgrade error: %v", err)
         return
     }
-    clientID := server.GenerateUniqueID()
-    client := &server.Client{
-        ID:        clientID,
-        Conn:      conn,
-        Send:      make(chan []byte, 256),
-        Contracts: make(map[string]string),
-        Hub:       hub,
+    client, err := server.NewClient(hub, conn, config)
+    if err != nil {
+        logging.DebugLog("Failed to create client: %v", err)
+        conn.Close()
+        return
     }
-    hub.Register <- client
+    client.TenantID = claims.Tenant
+    client.UserID = claims.Subject
+    client.SetConnectionMetadata(r)
+    client.Admin = admin
+    client.SessionID = sessionID
+    // Restored contract states may not fit in the send buffer
     go client.WritePump()
+    server.NewReconnectHandler(hub).Restore(r, client)
+    hub.Register <- client
     go client.ReadPump()
 }
 
+// handleAPIContracts serves POST /api/contracts
+func handleAPIContracts(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    tenantID, err := server.AuthenticateTenant(r)
+    if err != nil {
+        logging.DebugLog("Rejecting API request: %v", err)
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    var data server.ContractData
+    if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+        http.Error(w, "Invalid contract data format", http.StatusBadRequest)
+        return
+    }
+
+    contractID, err := hub.SubmitContract(tenantID, data)
+    var validationErr *server.ValidationError
+    if errors.As(err, &validationErr) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err == server.ErrTenantLimitExceeded {
+        http.Error(w, "Tenant contract limit exceeded", http.StatusTooManyRequests)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadGateway)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, map[string]string{"contractID": contractID})
+}
+
+// handleAPIValidateContract validates contract data without creating the
+// contract, answering 422 when it is invalid
+func handleAPIValidateContract(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    var data server.ContractData
+    if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+        http.Error(w, "Invalid contract data format", http.StatusBadRequest)
+        return
+    }
+
+    result := server.ValidateContract(data)
+    status := http.StatusOK
+    if !result.Valid {
+        status = http.StatusUnprocessableEntity
+    }
+    writeJSON(w, status, result)
+}
+
+// handleAdminTenantMetrics serves GET /admin/tenants/metrics, which reports
+// the usage of every tenant
+func handleAdminTenantMetrics(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if !server.ValidAdminRequest(r) {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+    writeJSON(w, http.StatusOK, hub.TenantMetricsReport())
+}
+
+// handleAdminClient serves DELETE /admin/clients/{clientID}, which
+// disconnects a WebSocket client, GET /admin/clients/{clientID}/bandwidth and
+// GET /admin/clients/{clientID}/activity
+func handleAdminClient(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
+    if !server.ValidAdminRequest(r) {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/clients/"), "/")
+    clientID := parts[0]
+    if clientID == "" || len(parts) > 2 {
+        http.NotFound(w, r)
+        return
+    }
+    action := ""
+    if len(parts) == 2 {
+        action = parts[1]
+    }
+
+    switch {
+    case action == "" && r.Method == http.MethodDelete:
+        if err := hub.KickClient(clientID, server.AdminKickReason); err == server.ErrClientNotFound {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        } else if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+
+    case action == "bandwidth" && r.Method == http.MethodGet:
+        bandwidth, err := hub.GetClientBandwidth(clientID)
+        if err == server.ErrClientNotFound {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, bandwidth)
+
+    case action == "activity" && r.Method == http.MethodGet:
+        activity, err := hub.GetClientActivity(clientID)
+        if err == server.ErrClientNotFound {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, activity)
+
+    case action == "" || action == "bandwidth" || action == "activity":
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// handleAdminMigrate serves POST /admin/migrate, which hands every WebSocket
+// session over to the pricing server at the targetAddr of the request body
+func handleAdminMigrate(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if !server.ValidAdminRequest(r) {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+    var req struct {
+        TargetAddr string `json:"targetAddr"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetAddr == "" {
+        http.Error(w, "targetAddr is required", http.StatusBadRequest)
+        return
+    }
+    if err := hub.Migrate(req.TargetAddr); err != nil {
+        http.Error(w, err.Error(), http.StatusBadGateway)
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"status": "migrated"})
+}
+
+// handleStats serves GET /stats with the traffic of every connected client
+// and the effect of broadcast compression
+func handleStats(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if !server.ValidAdminRequest(r) {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+    clients := hub.ClientStatsReport()
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "connectedClients": len(clients),
+        "clients":          clients,
+        "compression":      hub.CompressionStats(),
+    })
+}
+
+// sensitivityRequest is the body of POST /admin/backtest/sensitivity. The
+// scenario is either a pre-defined one named by Scenario or the custom Prices.
+type sensitivityRequest struct {
+    BaseParams     server.ContractData `json:"baseParams"`
+    ParamName      string              `json:"paramName"`
+    Values         []float64           `json:"values"`
+    Scenario       string              `json:"scenario"`
+    Prices         []float64           `json:"prices"`
+    TickIntervalMs int                 `json:"tickIntervalMs"`
+}
+
+func handleAdminSensitivity(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if !server.ValidAdminRequest(r) {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    var req sensitivityRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid sensitivity request", http.StatusBadRequest)
+        return
+    }
+    if len(req.Values) == 0 {
+        http.Error(w, "values are required", http.StatusBadRequest)
+        return
+    }
+
+    var scenario simulation.Scenario
+    if len(req.Prices) > 0 {
+        scenario = simulation.Scenario{
+            Name:         "custom",
+            Prices:       req.Prices,
+            TickInterval: time.Duration(req.TickIntervalMs) * time.Millisecond,
+        }
+    } else {
+        var ok bool
+        if scenario, ok = simulation.ScenarioByName(req.Scenario); !ok {
+            http.Error(w, "Unknown scenario: "+req.Scenario, http.StatusBadRequest)
+            return
+        }
+    }
+
+    results, err := hub.SensitivityAnalysis(req.BaseParams, req.ParamName, req.Values, scenario)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    writeJSON(w, http.StatusOK, results)
+}
+
+// handleAPIContract serves /api/contracts/{id}, /api/contracts/{id}/state,
+// /api/contracts/{id}/updates and /api/contracts/{id}/stream
+func handleAPIContract(hub *server.Hub, w http.ResponseWriter, r *http.Request) {
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/contracts/"), "/")
+    contractID := parts[0]
+    if contractID == "" || len(parts) > 2 {
+        http.NotFound(w, r)
+        return
+    }
+    action := ""
+    if len(parts) == 2 {
+        action = parts[1]
+    }
+    tenantID, err := server.AuthenticateTenant(r)
+    if err != nil {
+        logging.DebugLog("Rejecting API request: %v", err)
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    switch {
+    case action == "" && r.Method == http.MethodDelete:
+        if err := hub.CancelContract(tenantID, contractID); err == server.ErrContractNotFound {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        } else if err != nil {
+            http.Error(w, err.Error(), http.StatusBadGateway)
+            return
+        }
+        writeJSON(w, http.StatusOK, map[string]string{"status": "cancelled"})
+
+    case action == "state" && r.Method == http.MethodGet:
+        state, err := hub.GetContractState(tenantID, contractID)
+        if err == server.ErrContractNotFound {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        } else if err != nil {
+            http.Error(w, err.Error(), http.StatusBadGateway)
+            return
+        }
+        writeJSON(w, http.StatusOK, state)
+
+    case action == "updates" && r.Method == http.MethodGet:
+        timeout := defaultLongPollTimeout
+        if t := r.URL.Query().Get("timeout"); t != "" {
+            seconds, err := strconv.Atoi(t)
+            if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxLongPollTimeout {
+                http.Error(w, "timeout must be between 1 and 60 seconds", http.StatusBadRequest)
+                return
+            }
+            timeout = time.Duration(seconds) * time.Second
+        }
+
+        ctx, cancel := context.WithTimeout(r.Context(), timeout)
+        defer cancel()
+        state, err := hub.WaitForContractUpdate(ctx, tenantID, contractID)
+        if err == server.ErrContractNotFound {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        } else if err != nil {
+            // No change before the timeout
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        writeJSON(w, http.StatusOK, state)
+
+    case action == "stream" && r.Method == http.MethodGet:
+        hub.ServeContractStream(w, r, tenantID, contractID)
+
+    case action == "" || action == "state" || action == "updates" || action == "stream":
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        logging.DebugLog("Error encoding response: %v", err)
+    }
+}
+
 func main() {
+    backtestCSV := flag.String("backtest-csv", "", "replay prices from a timestamp_ms,price CSV file instead of simulating them")
+    backtestSpeed := flag.Float64("backtest-speed", 1, "playback speed multiplier for --backtest-csv (0 replays without delays)")
+    backtestOutput := flag.String("backtest-output", "", "write per-tick contract states of a --backtest-csv replay to this CSV file")
+    configPath := flag.String("config", "", "read [product_simulation.<type>] simulation overrides from this config file")
+    flag.Parse()
+
+    if err := server.LoadSecrets(secrets.NewSecretsProviderFromEnv()); err != nil {
+        log.Fatalf("Failed to load secrets: %v", err)
+    }
+
     hub := server.NewHub()
-    go hub.Run()
+    upgrader.Subprotocols = hub.Subprotocols
+    hub.TenantLimits = server.LoadTenantLimits()
+    if *configPath != "" {
+        overrides, err := server.LoadProductSimulationOverrides(*configPath)
+        if err != nil {
+            log.Fatalf("Failed to load config: %v", err)
+        }
+        hub.ProductSimulationOverrides = overrides
+    }
+    storageTLS, err := contracts.StorageTLSConfigFromEnv()
+    if err != nil {
+        log.Fatalf("Failed to load storage service TLS configuration: %v", err)
+    }
+    if storageTLS != nil {
+        hub.StorageService.UseTLS(storageTLS)
+    }
+    if auditPath := os.Getenv("AUDIT_LOG_PATH"); auditPath != "" {
+        auditLog, err := audit.NewFileAuditLog(auditPath)
+        if err != nil {
+            log.Fatalf("Failed to open audit log: %v", err)
+        }
+        hub.AuditLog = auditLog
+    }
+    if *backtestCSV != "" {
+        feed, err := simulation.NewCSVFeed(*backtestCSV)
+        if err != nil {
+            log.Fatalf("Failed to load backtest prices: %v", err)
+        }
+        feed.Speed = *backtestSpeed
+        hub.SimulationEngine = feed
+
+        if *backtestOutput != "" {
+            recorder, err := simulation.NewContractResultRecorder(*backtestOutput)
+            if err != nil {
+                log.Fatalf("Failed to create backtest output: %v", err)
+            }
+            hub.PriceRecorder = recorder
+            go func() {
+                <-feed.Done()
+                if err := recorder.Close(); err != nil {
+                    logging.DebugLog("Failed to write backtest output: %v", err)
+                    return
+                }
+                logging.DebugLog("Backtest results written to %s", *backtestOutput)
+            }()
+        }
+    }
+    if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+        distributed, err := server.NewDistributedHub(hub, redisURL)
+        if err != nil {
+            log.Fatalf("Failed to connect hub to Redis: %v", err)
+        }
+        go distributed.Run()
+    } else {
+        go hub.Run()
+    }
     http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
         serveWs(hub, w, r)
     })
+    http.Handle("/health", server.RecoveryMiddleware(server.NewHealthHandler(hub)))
+    http.Handle("/metrics", promhttp.Handler())
+    http.Handle("/oauth2/authorize", server.RecoveryMiddleware(http.HandlerFunc(server.OAuth2AuthorizeHandler)))
+    http.Handle("/oauth2/callback", server.RecoveryMiddleware(http.HandlerFunc(server.OAuth2CallbackHandler)))
+    http.Handle("/stats", server.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handleStats(hub, w, r)
+    })))
+    http.Handle("/api/contracts", server.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handleAPIContracts(hub, w, r)
+    })))
+    http.Handle("/api/contracts/validate", server.RecoveryMiddleware(http.HandlerFunc(handleAPIValidateContract)))
+    http.Handle("/api/contracts/", server.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handleAPIContract(hub, w, r)
+    })))
+    http.Handle("/sse/contracts/", server.RecoveryMiddleware(http.HandlerFunc(hub.ServeSSE)))
+    http.Handle("/admin/tenants/metrics", server.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handleAdminTenantMetrics(hub, w, r)
+    })))
+    http.Handle("/admin/clients/", server.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handleAdminClient(hub, w, r)
+    })))
+    http.Handle("/admin/migrate", server.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handleAdminMigrate(hub, w, r)
+    })))
+    http.Handle("/admin/backtest/sensitivity", server.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handleAdminSensitivity(hub, w, r)
+    })))
+
+    schema, err := server.NewGraphQLSchema(hub)
+    if err != nil {
+        log.Fatalf("Failed to parse GraphQL schema: %v", err)
+    }
+    http.Handle("/graphql", server.RecoveryMiddleware(server.NewGraphQLHandler(schema)))
+    http.Handle("/graphql/subscriptions", server.NewGraphQLSubscriptionHandler(schema))
 
     addr := ":8080"
-    logging.DebugLog("Server started on", addr)
-    if err := http.ListenAndServe(addr, nil); err != nil {
-        log.Fatal("ListenAndServe:", err)
+    httpServer := &http.Server{Addr: addr}
+    go func() {
+        logging.DebugLog("Server started on %s", addr)
+        if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Fatal("ListenAndServe:", err)
+        }
+    }()
+
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+    <-stop
+
+    logging.DebugLog("Shutting down pricing server...")
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := httpServer.Shutdown(ctx); err != nil {
+        logging.DebugLog("HTTP server shutdown error: %v", err)
+    }
+    // WebSocket connections are hijacked, so Shutdown does not wait for them
+    if err := hub.Drain(ctx); err != nil {
+        logging.DebugLog("Failed to drain WebSocket clients: %v", err)
+    }
+
+    // Keep the accumulated product state so it can be restored on startup
+    if err := hub.SaveProductSnapshots(); err != nil {
+        logging.DebugLog("Failed to save product snapshots: %v", err)
     }
 }
\ No newline at end of file
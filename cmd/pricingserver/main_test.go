@@ -0,0 +1,429 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "pricingserver/internal/common/secrets"
+    "pricingserver/internal/contracts"
+    "pricingserver/internal/server"
+    "pricingserver/internal/simulation"
+
+    "github.com/gorilla/websocket"
+)
+
+// tickEmitter is a price source that emits a price only when tick is called
+type tickEmitter struct {
+    mu       sync.Mutex
+    handlers map[string]simulation.PriceHandler
+}
+
+func (e *tickEmitter) Start() {}
+func (e *tickEmitter) Stop()  {}
+
+func (e *tickEmitter) Subscribe(contractID string, handler simulation.PriceHandler) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.handlers[contractID] = handler
+}
+
+func (e *tickEmitter) Unsubscribe(contractID string) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    delete(e.handlers, contractID)
+}
+
+func (e *tickEmitter) tick(price float64) {
+    e.mu.Lock()
+    handlers := make([]simulation.PriceHandler, 0, len(e.handlers))
+    for _, handler := range e.handlers {
+        handlers = append(handlers, handler)
+    }
+    e.mu.Unlock()
+    for _, handler := range handlers {
+        handler.HandlePriceUpdate(price, time.Now())
+    }
+}
+
+// newAPITestServer serves the contracts and admin APIs of a hub backed by a
+// mock contracts service, with authentication disabled
+func newAPITestServer(t *testing.T) (*httptest.Server, *server.Hub, *tickEmitter) {
+    mock := contracts.NewMockContractServer()
+    storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Write([]byte("{}"))
+    }))
+    t.Setenv("CONTRACTS_SERVICE_URL", mock.URL)
+    t.Setenv("STORAGE_SERVICE_URL", storage.URL)
+
+    hub := server.NewHub()
+    prices := &tickEmitter{handlers: make(map[string]simulation.PriceHandler)}
+    hub.SimulationEngine = prices
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/api/contracts", func(w http.ResponseWriter, r *http.Request) {
+        handleAPIContracts(hub, w, r)
+    })
+    mux.HandleFunc("/api/contracts/", func(w http.ResponseWriter, r *http.Request) {
+        handleAPIContract(hub, w, r)
+    })
+    mux.HandleFunc("/api/contracts/validate", handleAPIValidateContract)
+    mux.HandleFunc("/admin/tenants/metrics", func(w http.ResponseWriter, r *http.Request) {
+        handleAdminTenantMetrics(hub, w, r)
+    })
+    ts := httptest.NewServer(mux)
+    t.Cleanup(func() {
+        ts.Close()
+        mock.Close()
+        storage.Close()
+    })
+    return ts, hub, prices
+}
+
+func TestContractsAPICreateStateAndLongPoll(t *testing.T) {
+    ts, _, prices := newAPITestServer(t)
+
+    body, _ := json.Marshal(server.ContractData{
+        ProductType: "LuckyLadder",
+        Rungs:       []float64{101, 102, 103},
+        Duration:    60000,
+        Payoff:      10,
+    })
+    resp, err := http.Post(ts.URL+"/api/contracts", "application/json", bytes.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var created struct {
+        ContractID string `json:"contractID"`
+    }
+    json.NewDecoder(resp.Body).Decode(&created)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated || created.ContractID == "" {
+        t.Fatalf("POST /api/contracts answered %d with %+v, want 201 and a contract ID", resp.StatusCode, created)
+    }
+
+    // The long poll returns the state after the next tick
+    polled := make(chan *http.Response, 1)
+    go func() {
+        resp, err := http.Get(ts.URL + "/api/contracts/" + created.ContractID + "/updates?timeout=2")
+        if err != nil {
+            t.Error(err)
+            resp = nil
+        }
+        polled <- resp
+    }()
+    ticker := time.NewTicker(20 * time.Millisecond)
+    defer ticker.Stop()
+    var update *http.Response
+    for update == nil {
+        select {
+        case update = <-polled:
+            if update == nil {
+                t.FailNow()
+            }
+        case <-ticker.C:
+            prices.tick(101.5)
+        }
+    }
+    var state map[string]interface{}
+    json.NewDecoder(update.Body).Decode(&state)
+    update.Body.Close()
+    if update.StatusCode != http.StatusOK || state["price"] != 101.5 {
+        t.Fatalf("GET /updates answered %d with %v, want 200 and the state after the tick at 101.5", update.StatusCode, state)
+    }
+
+    resp, err = http.Get(ts.URL + "/api/contracts/" + created.ContractID + "/state")
+    if err != nil {
+        t.Fatal(err)
+    }
+    state = nil
+    json.NewDecoder(resp.Body).Decode(&state)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || state["status"] != "active" {
+        t.Fatalf("GET /state answered %d with %v, want 200 and an active contract", resp.StatusCode, state)
+    }
+
+    resp, err = http.Get(ts.URL + "/api/contracts/unknown/state")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotFound {
+        t.Fatalf("GET /state of an unknown contract answered %d, want 404", resp.StatusCode)
+    }
+}
+
+func TestContractsAPIValidatesWithoutCreating(t *testing.T) {
+    ts, _, prices := newAPITestServer(t)
+
+    for _, tc := range []struct {
+        rungs  []float64
+        status int
+        result server.ValidationResult
+    }{
+        {[]float64{101, 102, 103}, http.StatusOK, server.ValidationResult{Valid: true}},
+        {[]float64{101, 101}, http.StatusUnprocessableEntity, server.ValidationResult{Errors: []string{"duplicate rung values are not allowed"}}},
+    } {
+        body, _ := json.Marshal(server.ContractData{ProductType: "LuckyLadder", Rungs: tc.rungs, Duration: 60000, Payoff: 10})
+        resp, err := http.Post(ts.URL+"/api/contracts/validate", "application/json", bytes.NewReader(body))
+        if err != nil {
+            t.Fatal(err)
+        }
+        var result server.ValidationResult
+        json.NewDecoder(resp.Body).Decode(&result)
+        resp.Body.Close()
+        if resp.StatusCode != tc.status || !reflect.DeepEqual(result, tc.result) {
+            t.Errorf("Validating rungs %v answered %d with %+v, want %d with %+v", tc.rungs, resp.StatusCode, result, tc.status, tc.result)
+        }
+    }
+    // Created contracts would be subscribed to prices
+    prices.mu.Lock()
+    defer prices.mu.Unlock()
+    if len(prices.handlers) != 0 {
+        t.Errorf("Validation subscribed %d contracts to prices, want none", len(prices.handlers))
+    }
+}
+
+// withAdminToken requires token on admin endpoints for the duration of the
+// test
+func withAdminToken(t *testing.T, token string) {
+    // Runs after t.Setenv restored the environment
+    t.Cleanup(func() { server.LoadSecrets(secrets.EnvSecretsProvider{}) })
+    t.Setenv("ADMIN_AUTH_TOKEN", token)
+    if err := server.LoadSecrets(secrets.EnvSecretsProvider{}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestAdminTenantMetricsReportsEveryTenant(t *testing.T) {
+    ts, hub, _ := newAPITestServer(t)
+    withAdminToken(t, "admin-token")
+
+    submissions := map[string]int{"tenant-a": 2, "tenant-b": 1}
+    for tenantID, count := range submissions {
+        for i := 0; i < count; i++ {
+            if _, err := hub.SubmitContract(tenantID, server.ContractData{
+                ProductType: "LuckyLadder",
+                Rungs:       []float64{101, 102, 103},
+                Duration:    60000,
+                Payoff:      10,
+            }); err != nil {
+                t.Fatalf("SubmitContract for %s: %v", tenantID, err)
+            }
+        }
+    }
+
+    resp, err := http.Get(ts.URL + "/admin/tenants/metrics")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusUnauthorized {
+        t.Fatalf("GET /admin/tenants/metrics without the admin token answered %d, want 401", resp.StatusCode)
+    }
+
+    req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/tenants/metrics", nil)
+    req.Header.Set("Authorization", "Bearer admin-token")
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var report map[string]server.TenantMetricsSummary
+    json.NewDecoder(resp.Body).Decode(&report)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("GET /admin/tenants/metrics answered %d, want 200", resp.StatusCode)
+    }
+    for tenantID, count := range submissions {
+        summary := report[tenantID]
+        if summary.ActiveContracts != count || summary.TotalPayoffOutstanding != float64(10*count) || summary.TicksPerSec <= 0 {
+            t.Errorf("Tenant %s reported %+v, want %d active contracts paying %d in total", tenantID, summary, count, 10*count)
+        }
+    }
+}
+
+// newWSTestServer serves the WebSocket endpoint of the hub of
+// newAPITestServer and runs the hub
+func newWSTestServer(t *testing.T) (*httptest.Server, *server.Hub) {
+    _, hub, _ := newAPITestServer(t)
+    go hub.Run()
+    subprotocols := upgrader.Subprotocols
+    t.Cleanup(func() { upgrader.Subprotocols = subprotocols })
+    upgrader.Subprotocols = hub.Subprotocols
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        serveWs(hub, w, r)
+    }))
+    t.Cleanup(ts.Close)
+    return ts, hub
+}
+
+// dialWS connects to the WebSocket endpoint of ts offering subprotocols
+func dialWS(ts *httptest.Server, subprotocols ...string) (*websocket.Conn, *http.Response, error) {
+    dialer := websocket.Dialer{Subprotocols: subprotocols}
+    return dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+}
+
+func TestConnectionsUseTheNegotiatedSerializationFormat(t *testing.T) {
+    ts, _ := newWSTestServer(t)
+
+    for _, tc := range []struct {
+        offered    []string
+        negotiated string
+        frameType  int
+        serializer server.Serializer
+    }{
+        {[]string{server.SubprotocolJSON}, server.SubprotocolJSON, websocket.TextMessage, server.JSONSerializer{}},
+        {[]string{server.SubprotocolMsgpack}, server.SubprotocolMsgpack, websocket.BinaryMessage, server.MsgpackSerializer{}},
+        {nil, "", websocket.TextMessage, server.JSONSerializer{}},
+    } {
+        conn, _, err := dialWS(ts, tc.offered...)
+        if err != nil {
+            t.Fatalf("Dial offering %v: %v", tc.offered, err)
+        }
+        defer conn.Close()
+        if conn.Subprotocol() != tc.negotiated {
+            t.Fatalf("Connection offering %v negotiated %q, want %q", tc.offered, conn.Subprotocol(), tc.negotiated)
+        }
+
+        request, err := tc.serializer.Marshal(map[string]interface{}{
+            "type": server.MessageTypeValidateContract,
+            "data": server.ContractData{
+                ProductType: "LuckyLadder",
+                Rungs:       []float64{101, 102, 103},
+                Duration:    60000,
+                Payoff:      10,
+            },
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        conn.WriteMessage(tc.frameType, request)
+        conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+        for {
+            frameType, data, err := conn.ReadMessage()
+            if err != nil {
+                t.Fatalf("Connection offering %v sent no validation result: %v", tc.offered, err)
+            }
+            var message map[string]interface{}
+            if frameType != tc.frameType || tc.serializer.Unmarshal(data, &message) != nil {
+                t.Fatalf("Connection offering %v sent %q in a frame of type %d, want %T in frames of type %d", tc.offered, data, frameType, tc.serializer, tc.frameType)
+            }
+            if message["type"] == server.MessageTypeValidationResult {
+                break
+            }
+        }
+    }
+}
+
+func TestConnectionsOfferingOnlyUnsupportedSubprotocolsAreRejected(t *testing.T) {
+    ts, hub := newWSTestServer(t)
+
+    conn, _, err := dialWS(ts, "pricing.v0.xml", hub.Subprotocols[0])
+    if err != nil {
+        t.Fatalf("Dial offering a supported subprotocol: %v", err)
+    }
+    if conn.Subprotocol() != hub.Subprotocols[0] {
+        t.Errorf("Connection negotiated %q, want %q", conn.Subprotocol(), hub.Subprotocols[0])
+    }
+    conn.Close()
+
+    _, resp, err := dialWS(ts, "pricing.v0.xml")
+    if err == nil {
+        t.Fatal("Dial offering only an unsupported subprotocol succeeded")
+    }
+    if resp == nil || resp.StatusCode != http.StatusUpgradeRequired {
+        t.Fatalf("Dial offering only an unsupported subprotocol answered %v, want 426", resp)
+    }
+    if offered := resp.Header.Get("Sec-WebSocket-Protocol"); offered != strings.Join(hub.Subprotocols, ", ") {
+        t.Errorf("Rejection lists subprotocols %q, want %q", offered, strings.Join(hub.Subprotocols, ", "))
+    }
+}
+
+func TestConnectionsRecordTheClientsUserAgentAndAddress(t *testing.T) {
+    ts, hub := newWSTestServer(t)
+    header := http.Header{
+        "User-Agent":      []string{"pricing-client/2.3.1"},
+        "X-Forwarded-For": []string{"203.0.113.7, 10.0.0.1"},
+    }
+    before := time.Now()
+    conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+    if err != nil {
+        t.Fatalf("Dial: %v", err)
+    }
+    defer conn.Close()
+
+    // Clients are registered by the hub in the background
+    deadline := time.Now().Add(time.Second)
+    for len(hub.ClientStatsReport()) == 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("Connection was not registered as a client")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    for clientID, stats := range hub.ClientStatsReport() {
+        if stats.UserAgent != "pricing-client/2.3.1" {
+            t.Errorf("Client %s has UserAgent %q, want pricing-client/2.3.1", clientID, stats.UserAgent)
+        }
+        // The first forwarded address is the client behind the proxy
+        if stats.RemoteAddr != "203.0.113.7" {
+            t.Errorf("Client %s has RemoteAddr %q, want 203.0.113.7", clientID, stats.RemoteAddr)
+        }
+        if stats.ConnectedAt.Before(before) || stats.ConnectedAt.After(time.Now()) {
+            t.Errorf("Client %s has ConnectedAt %v, want the time it connected", clientID, stats.ConnectedAt)
+        }
+    }
+}
+
+func TestAdminClientActivityRequiresTheAdminToken(t *testing.T) {
+    ts, hub := newWSTestServer(t)
+    withAdminToken(t, "admin-token")
+    conn, _, err := dialWS(ts)
+    if err != nil {
+        t.Fatalf("Dial: %v", err)
+    }
+    defer conn.Close()
+    deadline := time.Now().Add(time.Second)
+    for len(hub.ClientStatsReport()) == 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("Connection was not registered as a client")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    var clientID string
+    for id := range hub.ClientStatsReport() {
+        clientID = id
+    }
+
+    for _, tc := range []struct {
+        clientID, token string
+        status          int
+    }{
+        {clientID, "", http.StatusUnauthorized},
+        {clientID, "wrong-token", http.StatusUnauthorized},
+        {clientID, "admin-token", http.StatusOK},
+        {"unknown-client", "admin-token", http.StatusNotFound},
+    } {
+        req := httptest.NewRequest(http.MethodGet, "/admin/clients/"+tc.clientID+"/activity", nil)
+        if tc.token != "" {
+            req.Header.Set("Authorization", "Bearer "+tc.token)
+        }
+        rec := httptest.NewRecorder()
+        handleAdminClient(hub, rec, req)
+        if rec.Code != tc.status {
+            t.Fatalf("GET /admin/clients/%s/activity with token %q answered %d, want %d", tc.clientID, tc.token, rec.Code, tc.status)
+        }
+        if tc.status == http.StatusOK {
+            var activity []server.ActivityEntry
+            if err := json.NewDecoder(rec.Body).Decode(&activity); err != nil || activity == nil || len(activity) != 0 {
+                t.Errorf("Activity of a new client is %v (%v), want an empty list", activity, err)
+            }
+        }
+    }
+}
@@ -1,4 +1,4 @@
-from fastapi import FastAPI, HTTPException, Request
+from fastapi import FastAPI, HTTPException, Request, Response
 import logging
 import json
 import uuid
@@ -62,8 +62,18 @@ async def create_contract(request: Request):
                 "client_id": "system",
                 "contract_id": contract_id,
                 "rungs": params["rungs"],
+                "rungs_relative_to_strike": params.get("rungs_relative_to_strike", False),
                 "duration": duration,
-                "payoff": params["payoff"]
+                "payoff": params["payoff"],
+                "strike": params.get("strike", 0.0),
+                "notional": params.get("notional", 0.0),
+                "payoff_per_unit": params.get("payoff_per_unit", 0.0),
+                "max_payoff": params.get("max_payoff", 0.0),
+                "parent_contract_id": params.get("parent_contract_id"),
+                "instrument": params.get("instrument", ""),
+                "payoff_function": params.get("payoff_function", "fixed"),
+                "pending": params.get("pending", False),
+                "precondition": params.get("precondition")
             }
         elif contract_type == "momentum_catcher":
             if "target_movement" not in params:
@@ -73,8 +83,18 @@ async def create_contract(request: Request):
                 "client_id": "system",
                 "contract_id": contract_id,
                 "target_movement": params["target_movement"],
+                "target_relative_to_strike": params.get("target_relative_to_strike", False),
                 "duration": duration,
-                "payoff": params["payoff"]
+                "payoff": params["payoff"],
+                "strike": params.get("strike", 0.0),
+                "notional": params.get("notional", 0.0),
+                "payoff_per_unit": params.get("payoff_per_unit", 0.0),
+                "max_payoff": params.get("max_payoff", 0.0),
+                "parent_contract_id": params.get("parent_contract_id"),
+                "instrument": params.get("instrument", ""),
+                "payoff_function": params.get("payoff_function", "fixed"),
+                "pending": params.get("pending", False),
+                "precondition": params.get("precondition")
             }
         else:
             raise HTTPException(status_code=400, detail=f"Unsupported product type: {contract_type}")
@@ -82,7 +102,8 @@ async def create_contract(request: Request):
         logger.debug(f"Initializing product with params: {json.dumps(init_params, indent=2)}")
 
         product.init(init_params)
-        product.start()
+        if not product.pending:
+            product.start()
         contract_manager.add_contract(contract_id, product)
 
         logger.debug(f"Contract {contract_id} created and started, is_active: {product.is_active}, duration: {product.duration}ms")
@@ -161,7 +182,7 @@ async def get_last_update(contract_id: str):
     
     if product.last_update is None:
         return {
-            "status": "active" if product.is_active else "inactive",
+            "status": "pending" if product.pending else "active" if product.is_active else "inactive",
             "contractID": contract_id,
             "price": product.current_price,
             "timestamp": datetime.now().isoformat()
@@ -187,8 +208,17 @@ async def get_contract_state(contract_id: str):
         "elapsed_ms": elapsed_ms,
         "duration": product.duration,
         "price": product.current_price,
-        "product_type": product.__class__.__name__  # Add product type to response
+        "product_type": product.__class__.__name__,  # Add product type to response
+        "notional": product.notional,
+        "current_pnl": product.current_pnl,
+        **product.pnl_state(),
+        "payoff": product.effective_payoff(),
+        "instrument": product.instrument,
+        "payoff_function": product.payoff_function
     }
+    if product.pending:
+        state["status"] = "pending"
+        state["precondition"] = product.precondition
     
     # Add product-specific state
     if isinstance(product, LuckyLadder):
@@ -216,6 +246,13 @@ async def remove_contract(contract_id: str):
     contract_manager.remove_contract(contract_id)
     return {"status": "success"}
 
+@app.head("/contracts/{contract_id}")
+async def contract_exists(contract_id: str):
+    """Answer 200 if the contract is known, 404 otherwise"""
+    if contract_manager.get_product(contract_id) is None:
+        raise HTTPException(status_code=404, detail="Contract not found")
+    return Response(status_code=200)
+
 @app.get("/health")
 async def health_check():
     return {"status": "healthy"}
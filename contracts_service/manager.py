@@ -13,6 +13,15 @@ logger = logging.getLogger(__name__)
 class StorageClient:
     def __init__(self):
         self.base_url = os.getenv('STORAGE_SERVICE_URL', 'http://storage-service:8001')
+        # Client certificate and CA for a storage service that requires mTLS
+        self.tls = {}
+        cert_file = os.getenv('STORAGE_CLIENT_CERT_FILE')
+        key_file = os.getenv('STORAGE_CLIENT_KEY_FILE')
+        if cert_file and key_file:
+            self.tls['cert'] = (cert_file, key_file)
+        ca_file = os.getenv('STORAGE_CA_CERT_FILE')
+        if ca_file:
+            self.tls['verify'] = ca_file
 
     def save_contract(self, contract_id: str, product: Product) -> None:
         url = f"{self.base_url}/contract"
@@ -28,21 +37,32 @@ class StorageClient:
                 "start_time": product.start_time,
                 "current_price": product.current_price,
                 "last_update": product.last_update,
-                # Store product-specific parameters
-                **({"rungs": product.rungs} if isinstance(product, LuckyLadder) else {}),
-                **({"target_movement": product.target_movement} if isinstance(product, MomentumCatcher) else {})
+                "strike": product.strike,
+                "notional": product.notional,
+                "payoff_per_unit": product.payoff_per_unit,
+                "max_payoff": product.max_payoff,
+                "start_price": product.start_price,
+                "parent_contract_id": product.parent_contract_id,
+                "instrument": product.instrument,
+                "payoff_function": product.payoff_function,
+                # Store product-specific parameters as submitted
+                **({"rungs": product.rung_levels,
+                    "rungs_relative_to_strike": product.rungs_relative_to_strike} if isinstance(product, LuckyLadder) else {}),
+                **({"target_movement": product.target_level,
+                    "target_relative_to_strike": product.target_relative_to_strike} if isinstance(product, MomentumCatcher) else {})
             },
             "created_at": int(time.time() * 1000),
             "is_active": product.is_active,
-            "duration": product.duration
+            "duration": product.duration,
+            "parent_contract_id": product.parent_contract_id
         }
         logger.debug(f"Saving contract data: {json.dumps(data, indent=2)}")
-        response = requests.post(url, json=data)
+        response = requests.post(url, json=data, **self.tls)
         response.raise_for_status()
 
     def get_contract(self, contract_id: str) -> Optional[dict]:
         url = f"{self.base_url}/contract"
-        response = requests.get(url, params={"id": contract_id})
+        response = requests.get(url, params={"id": contract_id}, **self.tls)
         if response.status_code == 404:
             return None
         response.raise_for_status()
@@ -53,7 +73,7 @@ class StorageClient:
     def get_all_contracts(self) -> List[dict]:
         url = f"{self.base_url}/contract"
         try:
-            response = requests.get(url)
+            response = requests.get(url, **self.tls)
             response.raise_for_status()
             data = response.json()
             if data is None:
@@ -70,7 +90,7 @@ class StorageClient:
 
     def delete_contract(self, contract_id: str) -> None:
         url = f"{self.base_url}/contract"
-        response = requests.delete(url, params={"id": contract_id})
+        response = requests.delete(url, params={"id": contract_id}, **self.tls)
         response.raise_for_status()
 
 class ContractManager:
@@ -98,14 +118,23 @@ class ContractManager:
             "client_id": parameters.get("client_id", "system"),
             "contract_id": parameters["contract_id"],
             "duration": parameters["duration"],
-            "payoff": parameters["payoff"]
+            "payoff": parameters["payoff"],
+            "strike": parameters.get("strike", 0.0),
+            "notional": parameters.get("notional", 0.0),
+            "payoff_per_unit": parameters.get("payoff_per_unit", 0.0),
+            "max_payoff": parameters.get("max_payoff", 0.0),
+            "parent_contract_id": parameters.get("parent_contract_id"),
+            "instrument": parameters.get("instrument", ""),
+            "payoff_function": parameters.get("payoff_function", "fixed")
         }
         
         # Add product-specific parameters
         if isinstance(product, LuckyLadder):
             init_params["rungs"] = parameters.get("rungs", [])
+            init_params["rungs_relative_to_strike"] = parameters.get("rungs_relative_to_strike", False)
         elif isinstance(product, MomentumCatcher):
             init_params["target_movement"] = parameters.get("target_movement", 0)
+            init_params["target_relative_to_strike"] = parameters.get("target_relative_to_strike", False)
         
         logger.debug(f"Initializing product with params: {json.dumps(init_params, indent=2)}")
         product.init(init_params)
@@ -126,6 +155,9 @@ class ContractManager:
             logger.debug(f"Restored start_time: {product.start_time}, elapsed_ms: {elapsed_ms}, is_active: {product.is_active}")
         if parameters.get('current_price') is not None:
             product.current_price = parameters['current_price']
+        if parameters.get('start_price') is not None:
+            product.start_price = parameters['start_price']
+            product.update_pnl(product.current_price if product.current_price is not None else product.start_price)
         if parameters.get('last_update') is not None:
             product.last_update = parameters['last_update']
         
@@ -10,6 +10,15 @@ class ContractParameters(BaseModel):
     payoff: float = 0.0
     rungs: Optional[List[float]] = None
     target_movement: Optional[float] = None
+    strike: float = 0.0  # set from the first price when 0
+    rungs_relative_to_strike: bool = False
+    target_relative_to_strike: bool = False
+    notional: float = 0.0  # 0 pays the flat payoff
+    payoff_per_unit: float = 0.0
+    max_payoff: float = 0.0
+    parent_contract_id: Optional[str] = None  # set on the legs of a multi-leg contract
+    instrument: str = ""  # e.g. "EUR/USD"; empty for the default price
+    payoff_function: str = "fixed"  # name of the pricing server payoff function
 
 class ContractRequest(BaseModel):
     contract_type: Literal["lucky_ladder", "momentum_catcher"]
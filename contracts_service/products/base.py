@@ -6,6 +6,9 @@ import logging
 
 logger = logging.getLogger(__name__)
 
+# Notional amount payoff_per_unit is paid for
+NOTIONAL_UNIT = 1.0
+
 class Product(ABC):
     def __init__(self):
         self.client_id: str = ""
@@ -16,6 +19,27 @@ class Product(ABC):
         self.is_active: bool = False  # Will be set to True in start()
         self.last_update: Optional[Dict[str, Any]] = None
         self.current_price: Optional[float] = None
+        self.strike: float = 0.0  # 0 until set from the first price update
+        # Position size; 0 pays the flat payoff
+        self.notional: float = 0.0
+        self.payoff_per_unit: float = 0.0
+        self.max_payoff: float = 0.0  # caps the notional that earns a payoff, 0 for no cap
+        self.start_price: Optional[float] = None
+        self.current_pnl: float = 0.0
+        # P&L earned so far if the contract settled now, updated every price,
+        # and the P&L it settled with
+        self.unrealised_pnl: float = 0.0
+        self.realised_pnl: float = 0.0
+        # Multi-leg contract this contract is a leg of
+        self.parent_contract_id: Optional[str] = None
+        # Traded instrument, e.g. "EUR/USD"; empty for the default price
+        self.instrument: str = ""
+        # Name of the function the pricing server settles the payoff with
+        self.payoff_function: str = "fixed"
+        # Pending contracts start with the first price update, once the pricing
+        # server found their precondition met
+        self.pending: bool = False
+        self.precondition: Optional[Dict[str, Any]] = None
 
     @abstractmethod
     def init(self, params: Dict[str, Any]) -> None:
@@ -24,12 +48,51 @@ class Product(ABC):
         self.contract_id = params["contract_id"]
         self.duration = int(params["duration"])  # milliseconds
         self.payoff = params["payoff"]
-        logger.debug(f"Contract {self.contract_id} initialized with duration: {self.duration} ms")
+        self.strike = float(params.get("strike") or 0.0)
+        self.notional = float(params.get("notional") or 0.0)
+        self.payoff_per_unit = float(params.get("payoff_per_unit") or 0.0)
+        self.max_payoff = float(params.get("max_payoff") or 0.0)
+        self.parent_contract_id = params.get("parent_contract_id") or None
+        self.instrument = params.get("instrument") or ""
+        self.payoff_function = params.get("payoff_function") or "fixed"
+        self.pending = bool(params.get("pending"))
+        self.precondition = params.get("precondition") or None
+        logger.debug(f"Contract {self.contract_id} initialized with duration: {self.duration} ms, strike: {self.strike}")
+
+    def set_strike(self, strike: float) -> None:
+        """Set the strike price relative to which parameters may be expressed"""
+        logger.debug(f"Contract {self.contract_id} strike set to {strike}")
+        self.strike = strike
+
+    def effective_payoff(self) -> float:
+        """Payoff of the contract, scaled by its notional when it has one"""
+        if self.notional <= 0:
+            return self.payoff
+        notional = min(self.notional, self.max_payoff) if self.max_payoff > 0 else self.notional
+        return notional * self.payoff_per_unit / NOTIONAL_UNIT
+
+    def update_pnl(self, price: float) -> None:
+        """Mark the position to price relative to the first price seen"""
+        if self.start_price is None:
+            self.start_price = price
+        self.current_pnl = (price - self.start_price) * self.notional / NOTIONAL_UNIT
+
+    def compute_unrealised_pnl(self) -> float:
+        """P&L the contract would earn if it settled at the current price"""
+        return 0.0
+
+    def settlement_pnl(self) -> float:
+        """P&L the contract settles with once it is no longer active"""
+        return self.unrealised_pnl
+
+    def pnl_state(self) -> Dict[str, float]:
+        return {"unrealised_pnl": self.unrealised_pnl, "realised_pnl": self.realised_pnl}
 
     def start(self) -> None:
         logger.debug(f"Starting contract {self.contract_id}")
         self.start_time = time.monotonic()
         self.is_active = True
+        self.pending = False
         logger.debug(f"Contract {self.contract_id} started at monotonic time {self.start_time}, is_active: {self.is_active}, duration: {self.duration} ms")
 
     def get_elapsed_ms(self) -> int:
@@ -44,6 +107,9 @@ class Product(ABC):
         logger.debug(f"Contract state - is_active: {self.is_active}, start_time: {self.start_time}, duration: {self.duration} ms")
         
         self.current_price = price
+        if not self.strike:
+            self.set_strike(price)
+        self.update_pnl(price)
         
         # Check if contract has been started
         if self.start_time is None:
@@ -53,7 +119,8 @@ class Product(ABC):
                 "status": "active",
                 "price": price,
                 "elapsed_ms": 0,
-                "duration": self.duration
+                "duration": self.duration,
+                **self.pnl_state()
             }
         
         if not self.is_active:
@@ -67,19 +134,32 @@ class Product(ABC):
         if elapsed_ms >= self.duration:
             logger.debug(f"Contract {self.contract_id} expired (elapsed: {elapsed_ms}ms >= duration: {self.duration}ms)")
             self.is_active = False
+            self.realised_pnl = self.settlement_pnl()
+            # Keep the last product state, e.g. the rungs hit, for settlement
             return {
+                **(self.last_update or {}),
                 "status": "expired",
                 "price": price,
                 "elapsed_ms": elapsed_ms,
-                "duration": self.duration
+                "duration": self.duration,
+                "current_pnl": self.current_pnl,
+                **self.pnl_state()
             }
             
         result = self.process_price(price)
+        self.unrealised_pnl = self.compute_unrealised_pnl()
+        if not self.is_active:
+            self.realised_pnl = self.settlement_pnl()
         self.last_update = result
         # Add duration info to result for debugging
         result.update({
             "elapsed_ms": elapsed_ms,
-            "duration": self.duration
+            "duration": self.duration,
+            "strike": self.strike,
+            "notional": self.notional,
+            "current_pnl": self.current_pnl,
+            "payoff": self.effective_payoff(),
+            **self.pnl_state()
         })
         logger.debug(f"Contract {self.contract_id} processed price: {result}")
         return result
@@ -9,12 +9,35 @@ class LuckyLadder(Product):
         super().__init__()
         self.rungs: List[float] = []
         self.hit_rungs: List[float] = []
+        # Rungs as submitted, as fractions of the strike when relative
+        self.rung_levels: List[float] = []
+        self.rungs_relative_to_strike: bool = False
 
     def init(self, params: Dict[str, Any]) -> None:
         super().init(params)
-        self.rungs = sorted(params["rungs"])
+        self.rung_levels = sorted(params["rungs"])
+        self.rungs_relative_to_strike = bool(params.get("rungs_relative_to_strike", False))
+        self._resolve_rungs()
         logger.debug(f"Initialized LuckyLadder contract {self.contract_id} with rungs: {self.rungs}")
+
+    def set_strike(self, strike: float) -> None:
+        super().set_strike(strike)
+        self._resolve_rungs()
+
+    def _resolve_rungs(self) -> None:
+        """Convert the submitted rungs to price levels"""
+        if not self.rungs_relative_to_strike:
+            self.rungs = list(self.rung_levels)
+        elif self.strike:
+            self.rungs = [self.strike * level for level in self.rung_levels]
+        else:
+            # Resolved once the strike is set from the first price
+            self.rungs = []
     
+    def compute_unrealised_pnl(self) -> float:
+        """The payoff for every rung hit"""
+        return self.effective_payoff() * len(self.hit_rungs)
+
     def process_price(self, price: float) -> Dict[str, Any]:
         current_hits = [rung for rung in self.rungs if abs(price - rung) < 0.0001]
         self.hit_rungs.extend(current_hits)
@@ -10,12 +10,41 @@ class MomentumCatcher(Product):
         self.target_movement: float = 0.0
         self.last_price: Optional[float] = None
         self.max_movement: float = 0.0
+        # Target as submitted, as a fraction of the strike when relative
+        self.target_level: float = 0.0
+        self.target_relative_to_strike: bool = False
 
     def init(self, params: Dict[str, Any]) -> None:
         super().init(params)
-        self.target_movement = params["target_movement"]
+        self.target_level = params["target_movement"]
+        self.target_relative_to_strike = bool(params.get("target_relative_to_strike", False))
+        self._resolve_target()
         logger.debug(f"Initialized MomentumCatcher contract {self.contract_id} with target movement: {self.target_movement}")
+
+    def set_strike(self, strike: float) -> None:
+        super().set_strike(strike)
+        self._resolve_target()
+
+    def _resolve_target(self) -> None:
+        """Convert the submitted target to a price movement"""
+        if self.target_relative_to_strike:
+            # Resolved once the strike is set from the first price
+            self.target_movement = self.strike * self.target_level
+        else:
+            self.target_movement = self.target_level
     
+    def compute_unrealised_pnl(self) -> float:
+        """Payoff in proportion to the progress towards the target"""
+        if not self.target_movement:
+            return 0.0
+        return self.effective_payoff() * min(self.max_movement / abs(self.target_movement), 1.0)
+
+    def settlement_pnl(self) -> float:
+        """The payoff if the target was hit, nothing otherwise"""
+        if self.target_movement and self.max_movement >= abs(self.target_movement):
+            return self.effective_payoff()
+        return 0.0
+
     def process_price(self, price: float) -> Dict[str, Any]:
         if self.last_price is None:
             self.last_price = price
@@ -15,7 +15,52 @@ CREATE TABLE IF NOT EXISTS contracts (
     parameters JSONB NOT NULL,
     created_at BIGINT NOT NULL,
     is_active BOOLEAN NOT NULL,
-    duration INTEGER NOT NULL
+    duration INTEGER NOT NULL,
+    final_price FLOAT,
+    hit_rungs JSONB,
+    time_to_target_ms INT,
+    parent_contract_id TEXT,
+    cloned_from TEXT,
+    tenant_id TEXT NOT NULL DEFAULT current_setting('app.tenant_id')
+);
+
+CREATE TABLE IF NOT EXISTS contract_events (
+    id BIGSERIAL PRIMARY KEY,
+    contract_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    occurred_at BIGINT NOT NULL,
+    tenant_id TEXT NOT NULL DEFAULT current_setting('app.tenant_id')
+);
+
+CREATE INDEX IF NOT EXISTS contract_events_contract_id_idx ON contract_events (contract_id, id);
+
+CREATE TABLE IF NOT EXISTS simulation_snapshot (
+    id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    contract_ids JSONB NOT NULL,
+    saved_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS archived_contracts (LIKE contracts INCLUDING ALL);
+
+CREATE TABLE IF NOT EXISTS product_snapshots (
+    contract_id TEXT PRIMARY KEY,
+    state JSONB NOT NULL,
+    saved_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contract_templates (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    parameters JSONB NOT NULL,
+    created_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS migrated_sessions (
+    session_id TEXT PRIMARY KEY,
+    session JSONB NOT NULL,
+    saved_at BIGINT NOT NULL
 );
 
 -- Reset role
@@ -23,6 +68,30 @@ RESET ROLE;
 
 -- Set ownership
 ALTER TABLE contracts OWNER TO pricingserver;
+ALTER TABLE contract_events OWNER TO pricingserver;
+ALTER TABLE simulation_snapshot OWNER TO pricingserver;
+ALTER TABLE archived_contracts OWNER TO pricingserver;
+ALTER TABLE product_snapshots OWNER TO pricingserver;
+ALTER TABLE contract_templates OWNER TO pricingserver;
+ALTER TABLE migrated_sessions OWNER TO pricingserver;
+
+-- Scope contracts and their events to the tenant in app.tenant_id, including
+-- for their owner
+ALTER TABLE contracts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE contracts FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON contracts
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+ALTER TABLE archived_contracts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE archived_contracts FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON archived_contracts
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+ALTER TABLE contract_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE contract_events FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON contract_events
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
 
 -- Set default privileges
 ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL ON TABLES TO pricingserver;
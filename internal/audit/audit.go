@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Contract lifecycle event types
+const (
+	EventContractCreated   = "contract_created"
+	EventContractCancelled = "contract_cancelled"
+	EventContractRemoved   = "contract_removed"
+	EventContractSettled   = "contract_settled"
+)
+
+// AuditEvent is a single entry of the audit log
+type AuditEvent struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	EventType  string      `json:"eventType"`
+	ContractID string      `json:"contractID"`
+	ClientID   string      `json:"clientID,omitempty"`
+	UserID     string      `json:"userID,omitempty"`
+	IPAddress  string      `json:"ipAddress,omitempty"`
+	Payload    interface{} `json:"payload,omitempty"`
+	// PrevHash and Hash chain the entries together so that editing or
+	// removing an entry breaks every hash after it
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditLog records contract lifecycle events
+type AuditLog interface {
+	Record(event AuditEvent) error
+}
+
+// FileAuditLog appends events as newline-delimited JSON to a file
+type FileAuditLog struct {
+	file     *os.File
+	lastHash string
+	mu       sync.Mutex
+}
+
+// NewFileAuditLog opens path for appending, creating it if needed, and
+// continues the hash chain of any entries already in it
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	lastHash, err := readLastHash(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditLog{file: file, lastHash: lastHash}, nil
+}
+
+// Record implements AuditLog. The timestamp defaults to now.
+func (l *FileAuditLog) Record(event AuditEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event.PrevHash = l.lastHash
+	event.Hash = ""
+	unsigned, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append([]byte(l.lastHash), unsigned...))
+	event.Hash = hex.EncodeToString(sum[:])
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.lastHash = event.Hash
+	return nil
+}
+
+// Close closes the underlying file
+func (l *FileAuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// readLastHash returns the hash of the last entry in path, or "" if the file
+// does not exist or is empty
+func readLastHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var lastHash string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry struct {
+			Hash string `json:"hash"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			lastHash = entry.Hash
+		}
+	}
+	return lastHash, scanner.Err()
+}
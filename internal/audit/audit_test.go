@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readEntries returns the entries of the audit log at path, failing on any
+// line that is not valid JSON
+func readEntries(t *testing.T, path string) []AuditEvent {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Line %d is not valid JSON: %v", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestFileAuditLogWritesOneLinePerEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := NewFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := log.Record(AuditEvent{
+			EventType:  EventContractCreated,
+			ContractID: fmt.Sprintf("contract-%d", i),
+			ClientID:   "client-1",
+			UserID:     "user-1",
+			IPAddress:  "203.0.113.7",
+			Payload:    map[string]interface{}{"productType": "LuckyLadder"},
+		}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readEntries(t, path)
+	if len(entries) != 10 {
+		t.Fatalf("Audit log has %d lines, want 10", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.ContractID != fmt.Sprintf("contract-%d", i) || entry.EventType != EventContractCreated {
+			t.Errorf("Entry %d is %+v", i, entry)
+		}
+		if entry.Timestamp.IsZero() {
+			t.Errorf("Entry %d has no timestamp", i)
+		}
+	}
+}
+
+func TestFileAuditLogChainsEntriesAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < 2; i++ {
+		log, err := NewFileAuditLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := log.Record(AuditEvent{EventType: EventContractSettled, ContractID: fmt.Sprintf("contract-%d", i)}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		log.Close()
+	}
+
+	entries := readEntries(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("Audit log has %d lines, want 2", len(entries))
+	}
+	if entries[0].PrevHash != "" || entries[0].Hash == "" {
+		t.Errorf("First entry has prevHash %q and hash %q", entries[0].PrevHash, entries[0].Hash)
+	}
+	if entries[1].PrevHash != entries[0].Hash {
+		t.Errorf("Second entry has prevHash %q, want the first entry's hash %q", entries[1].PrevHash, entries[0].Hash)
+	}
+}
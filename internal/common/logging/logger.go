@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+type loggerKey struct{}
+
+// Logger writes log lines that end with its default fields, e.g. the ID of
+// the client being served. A Logger is also a context, so passing it, or a
+// context derived from it, to DebugLogContext adds its fields to the line.
+type Logger struct {
+	context.Context
+	fields map[string]interface{}
+}
+
+// New returns a Logger with default fields
+func New(fields map[string]interface{}) *Logger {
+	return newLogger(context.Background(), nil, fields)
+}
+
+func newLogger(ctx context.Context, base, fields map[string]interface{}) *Logger {
+	merged := make(map[string]interface{}, len(base)+len(fields))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &Logger{Context: ctx, fields: merged}
+}
+
+// Value implements context.Context, returning the Logger for FromContext
+func (l *Logger) Value(key interface{}) interface{} {
+	if key == (loggerKey{}) {
+		return l
+	}
+	return l.Context.Value(key)
+}
+
+// FromContext returns the Logger ctx was derived from, or nil
+func FromContext(ctx context.Context) *Logger {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.Value(loggerKey{}).(*Logger)
+	return l
+}
+
+// WithFields returns a copy of ctx whose Logger has extra default fields
+func WithFields(ctx context.Context, fields map[string]interface{}) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var base map[string]interface{}
+	if l := FromContext(ctx); l != nil {
+		base = l.fields
+	}
+	return newLogger(ctx, base, fields)
+}
+
+// Debug logs a message when DEBUG is enabled
+func (l *Logger) Debug(format string, v ...interface{}) {
+	if debugLogging {
+		l.output(l.context(), "DEBUG", format, v)
+	}
+}
+
+// Info logs a message
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.output(l.context(), "INFO", format, v)
+}
+
+// Warn logs a message
+func (l *Logger) Warn(format string, v ...interface{}) {
+	l.output(l.context(), "WARN", format, v)
+}
+
+// Error logs a message
+func (l *Logger) Error(format string, v ...interface{}) {
+	l.output(l.context(), "ERROR", format, v)
+}
+
+func (l *Logger) context() context.Context {
+	if l == nil {
+		return context.Background()
+	}
+	return l
+}
+
+// output writes a line with the correlation ID of ctx as a prefix and the
+// default fields as a suffix
+func (l *Logger) output(ctx context.Context, level, format string, v []interface{}) {
+	var line strings.Builder
+	line.WriteString(level)
+	if id := CorrelationIDFromContext(ctx); id != "" {
+		fmt.Fprintf(&line, " [%s]", id)
+	}
+	line.WriteString(" ")
+	fmt.Fprintf(&line, format, v...)
+
+	if l != nil {
+		keys := make([]string, 0, len(l.fields))
+		for key := range l.fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&line, " %s=%v", key, l.fields[key])
+		}
+	}
+	log.Print(line.String())
+}
@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"context"
 	"log"
 	"os"
 	"strconv"
@@ -20,4 +21,36 @@ func DebugLog(format string, v ...interface{}) {
 	if debugLogging {
 		log.Printf(format, v...)
 	}
-} 
\ No newline at end of file
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying a correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID of ctx, or ""
+func CorrelationIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// DebugLogContext is DebugLog with the correlation ID of ctx as a prefix and
+// the fields of its Logger, if any, as a suffix
+func DebugLogContext(ctx context.Context, format string, v ...interface{}) {
+	if l := FromContext(ctx); l != nil {
+		if debugLogging {
+			l.output(ctx, "DEBUG", format, v)
+		}
+		return
+	}
+	if id := CorrelationIDFromContext(ctx); id != "" {
+		format = "[%s] " + format
+		v = append([]interface{}{id}, v...)
+	}
+	DebugLog(format, v...)
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// captureLogs enables debug logging and returns the buffer log output is
+// written to until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var logs bytes.Buffer
+	previousOutput, previousFlags, previousDebug := log.Writer(), log.Flags(), debugLogging
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	debugLogging = true
+	t.Cleanup(func() {
+		log.SetOutput(previousOutput)
+		log.SetFlags(previousFlags)
+		debugLogging = previousDebug
+	})
+	return &logs
+}
+
+func TestCorrelationIDSeparatesConcurrentSubmissions(t *testing.T) {
+	logs := captureLogs(t)
+
+	// Each submission logs from several layers with the context it was given
+	submit := func(ctx context.Context, submission string) {
+		DebugLogContext(ctx, "Handling submission %s", submission)
+		for i := 0; i < 20; i++ {
+			DebugLogContext(ctx, "Forwarding price update %d of submission %s", i, submission)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, submission := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(submission string) {
+			defer wg.Done()
+			submit(WithCorrelationID(context.Background(), "correlation-"+submission), submission)
+		}(submission)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 42 {
+		t.Fatalf("Captured %d log lines, want 42", len(lines))
+	}
+	for _, line := range lines {
+		submission := line[len(line)-1:]
+		if prefix := fmt.Sprintf("[correlation-%s] ", submission); !strings.HasPrefix(line, prefix) {
+			t.Errorf("Log line %q of submission %s does not start with %q", line, submission, prefix)
+		}
+	}
+}
+
+func TestLoggerAppendsItsFieldsToEveryLine(t *testing.T) {
+	logs := captureLogs(t)
+
+	logger := New(map[string]interface{}{"clientID": "client-1"})
+	logger.Info("Handling %s", "ContractSubmission")
+	// Contexts derived from the logger keep its fields
+	ctx := WithFields(WithCorrelationID(logger, "correlation-a"), map[string]interface{}{"contractID": "contract-1"})
+	DebugLogContext(ctx, "Forwarding price update")
+
+	expected := []string{
+		"INFO Handling ContractSubmission clientID=client-1",
+		"DEBUG [correlation-a] Forwarding price update clientID=client-1 contractID=contract-1",
+	}
+	if lines := strings.Split(strings.TrimSpace(logs.String()), "\n"); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Captured %q, want %q", lines, expected)
+	}
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+
+	"pricingserver/internal/common/logging"
+)
+
+// ErrSecretNotFound is returned when a provider has no value for a secret
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretsProvider resolves secrets by name, e.g. "JWT_SECRET"
+type SecretsProvider interface {
+	GetSecret(path string) (string, error)
+}
+
+// EnvSecretsProvider reads secrets from environment variables of the same
+// name. Unset variables resolve to "".
+type EnvSecretsProvider struct{}
+
+// GetSecret implements SecretsProvider
+func (EnvSecretsProvider) GetSecret(path string) (string, error) {
+	return os.Getenv(path), nil
+}
+
+// FallbackSecretsProvider resolves secrets with Primary and uses Fallback
+// when Primary fails or does not have the secret
+type FallbackSecretsProvider struct {
+	Primary  SecretsProvider
+	Fallback SecretsProvider
+}
+
+// GetSecret implements SecretsProvider
+func (p FallbackSecretsProvider) GetSecret(path string) (string, error) {
+	value, err := p.Primary.GetSecret(path)
+	if err == nil {
+		return value, nil
+	}
+	if err != ErrSecretNotFound {
+		logging.DebugLog("Failed to resolve secret %s, falling back: %v", path, err)
+	}
+	return p.Fallback.GetSecret(path)
+}
+
+// NewSecretsProviderFromEnv returns a Vault provider falling back to
+// environment variables when VAULT_ADDR is set, and the environment provider
+// otherwise
+func NewSecretsProviderFromEnv() SecretsProvider {
+	if os.Getenv("VAULT_ADDR") == "" {
+		return EnvSecretsProvider{}
+	}
+	vault, err := NewVaultSecretsProvider(os.Getenv("VAULT_KV_MOUNT"), os.Getenv("VAULT_SECRET_PATH"))
+	if err != nil {
+		logging.DebugLog("Failed to create Vault client, using environment variables: %v", err)
+		return EnvSecretsProvider{}
+	}
+	return FallbackSecretsProvider{Primary: vault, Fallback: EnvSecretsProvider{}}
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Default location of the pricing server secrets in Vault
+const (
+	defaultVaultKVMount    = "secret"
+	defaultVaultSecretPath = "pricing-server"
+)
+
+// vaultTimeout bounds each read from Vault
+const vaultTimeout = 5 * time.Second
+
+// VaultSecretsProvider reads secrets from the fields of a single KV version 2
+// secret in Vault. The client is configured from VAULT_ADDR, VAULT_TOKEN and
+// the other standard Vault environment variables.
+type VaultSecretsProvider struct {
+	client     *api.Client
+	mount      string
+	secretPath string
+}
+
+// NewVaultSecretsProvider creates a provider for the secret at secretPath in
+// the KV engine mounted at mount. Empty arguments select secret/pricing-server.
+func NewVaultSecretsProvider(mount, secretPath string) (*VaultSecretsProvider, error) {
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	if mount == "" {
+		mount = defaultVaultKVMount
+	}
+	if secretPath == "" {
+		secretPath = defaultVaultSecretPath
+	}
+	return &VaultSecretsProvider{client: client, mount: mount, secretPath: secretPath}, nil
+}
+
+// GetSecret implements SecretsProvider. path names a field of the secret,
+// e.g. "JWT_SECRET".
+func (p *VaultSecretsProvider) GetSecret(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+
+	secret, err := p.client.KVv2(p.mount).Get(ctx, p.secretPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s/%s from Vault: %v", p.mount, p.secretPath, err)
+	}
+	value, ok := secret.Data[path]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret field %s is not a string", path)
+	}
+	return str, nil
+}
@@ -0,0 +1,60 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newMockVault serves the pricing server secret of a KV version 2 engine
+// until failing is set, and answers 500 afterwards
+func newMockVault(t *testing.T, fields map[string]string, failing *atomic.Bool) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, `{"errors": ["internal error"]}`, http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/pricing-server" || r.Header.Get("X-Vault-Token") != "test-token" {
+			http.Error(w, `{"errors": []}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     fields,
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestVaultSecretsFallBackToEnvironment(t *testing.T) {
+	var failing atomic.Bool
+	vault := newMockVault(t, map[string]string{"JWT_SECRET": "vault-jwt-secret"}, &failing)
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	t.Setenv("JWT_SECRET", "env-jwt-secret")
+	t.Setenv("DB_PASSWORD", "env-db-password")
+	provider := NewSecretsProviderFromEnv()
+
+	for _, tc := range []struct {
+		failing  bool
+		name     string
+		expected string
+	}{
+		{false, "JWT_SECRET", "vault-jwt-secret"},
+		// Secrets missing from Vault are read from the environment
+		{false, "DB_PASSWORD", "env-db-password"},
+		{true, "JWT_SECRET", "env-jwt-secret"},
+	} {
+		failing.Store(tc.failing)
+		if value, err := provider.GetSecret(tc.name); err != nil || value != tc.expected {
+			t.Errorf("GetSecret(%s) with Vault failing %v returned %q (%v), want %q", tc.name, tc.failing, value, err, tc.expected)
+		}
+	}
+}
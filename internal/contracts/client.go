@@ -2,7 +2,9 @@ package contracts
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +13,22 @@ import (
 	"time"
 )
 
+// ErrContractNotFound is returned by UpdatePrice when the contracts service
+// does not know the contract, e.g. because it restarted and lost its state
+var ErrContractNotFound = errors.New("contract not found in contracts service")
+
+// CorrelationIDHeader carries the correlation ID of a request to the
+// contracts service
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// addContractAttempts is how many times AddContract sends a contract while
+// the contracts service answers 503 Service Unavailable
+const addContractAttempts = 3
+
+// addContractRetryDelay is the delay before the first retry of AddContract,
+// growing linearly with each attempt
+var addContractRetryDelay = 100 * time.Millisecond
+
 // ContractServiceClient handles communication with the Python contracts service
 type ContractServiceClient struct {
 	baseURL string
@@ -37,33 +55,43 @@ type ContractParams struct {
 }
 
 // AddContract forwards contract creation to the Python service
-func (c *ContractServiceClient) AddContract(contractID string, params ContractParams) error {
+func (c *ContractServiceClient) AddContract(ctx context.Context, contractID string, params ContractParams) error {
 	// Ensure contract_id is set in parameters
 	if params.Parameters == nil {
 		params.Parameters = make(map[string]interface{})
 	}
 	params.Parameters["contract_id"] = contractID
+	defer observePythonRequest(params.ContractType, time.Now())
 
 	jsonBody, err := json.Marshal(params)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	logging.DebugLog("Sending contract creation request to Python service: %s", string(jsonBody))
-
-	// Send request to Python service
-	resp, err := c.client.Post(
-		fmt.Sprintf("%s/contracts", c.baseURL),
-		"application/json",
-		bytes.NewBuffer(jsonBody),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+	logging.DebugLogContext(ctx, "Sending contract creation request to Python service: %s", string(jsonBody))
+
+	// Send request to Python service, retrying while it is unavailable
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		resp, err = c.post(ctx, fmt.Sprintf("%s/contracts", c.baseURL), jsonBody)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable || attempt == addContractAttempts {
+			break
+		}
+		resp.Body.Close()
+		logging.DebugLogContext(ctx, "Contract service unavailable, retrying contract creation (attempt %d of %d)", attempt, addContractAttempts)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to send request: %v", ctx.Err())
+		case <-time.After(time.Duration(attempt) * addContractRetryDelay):
+		}
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	logging.DebugLog("Received response from Python service: %s", string(body))
+	logging.DebugLogContext(ctx, "Received response from Python service: %s", string(body))
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("contract service returned status %d: %s", resp.StatusCode, string(body))
@@ -101,7 +129,7 @@ func (c *ContractServiceClient) RemoveContract(contractID string) error {
 }
 
 // UpdatePrice forwards price updates to the Python service and returns the response
-func (c *ContractServiceClient) UpdatePrice(contractID string, price float64) ([]byte, error) {
+func (c *ContractServiceClient) UpdatePrice(ctx context.Context, contractID string, price float64) ([]byte, error) {
 	body := map[string]interface{}{
 		"price":     price,
 		"timestamp": time.Now().Format(time.RFC3339),
@@ -112,13 +140,9 @@ func (c *ContractServiceClient) UpdatePrice(contractID string, price float64) ([
 		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	logging.DebugLog("Sending price update for contract %s: %s", contractID, string(jsonBody))
+	logging.DebugLogContext(ctx, "Sending price update for contract %s: %s", contractID, string(jsonBody))
 
-	resp, err := c.client.Post(
-		fmt.Sprintf("%s/contracts/%s/price-update", c.baseURL, contractID),
-		"application/json",
-		bytes.NewBuffer(jsonBody),
-	)
+	resp, err := c.post(ctx, fmt.Sprintf("%s/contracts/%s/price-update", c.baseURL, contractID), jsonBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
@@ -129,8 +153,11 @@ func (c *ContractServiceClient) UpdatePrice(contractID string, price float64) ([
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
 
-	logging.DebugLog("Received price update response for contract %s: %s", contractID, string(responseBody))
+	logging.DebugLogContext(ctx, "Received price update response for contract %s: %s", contractID, string(responseBody))
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrContractNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("contract service returned status %d: %s", resp.StatusCode, string(responseBody))
 	}
@@ -138,6 +165,25 @@ func (c *ContractServiceClient) UpdatePrice(contractID string, price float64) ([
 	return responseBody, nil
 }
 
+// Ping reports whether the Python service knows a contract, which it forgets
+// when it restarts
+func (c *ContractServiceClient) Ping(contractID string) (bool, error) {
+	resp, err := c.client.Head(fmt.Sprintf("%s/contracts/%s", c.baseURL, contractID))
+	if err != nil {
+		return false, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("contract service returned status %d", resp.StatusCode)
+	}
+}
+
 // GetProduct checks if a contract exists in the Python service
 func (c *ContractServiceClient) GetProduct(contractID string) (bool, error) {
 	logging.DebugLog("Checking if contract %s exists in Python service", contractID)
@@ -155,21 +201,25 @@ func (c *ContractServiceClient) GetProduct(contractID string) (bool, error) {
 }
 
 // GetContractState retrieves the current state of a contract from the Python service
-func (c *ContractServiceClient) GetContractState(contractID string) (map[string]interface{}, error) {
-	logging.DebugLog("Getting state for contract %s from Python service", contractID)
-	resp, err := c.client.Get(fmt.Sprintf("%s/contracts/%s/state", c.baseURL, contractID))
+func (c *ContractServiceClient) GetContractState(ctx context.Context, contractID string) (map[string]interface{}, error) {
+	logging.DebugLogContext(ctx, "Getting state for contract %s from Python service", contractID)
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s/contracts/%s/state", c.baseURL, contractID), nil)
 	if err != nil {
-		logging.DebugLog("Failed to get contract state: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		logging.DebugLogContext(ctx, "Failed to get contract state: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		logging.DebugLog("Failed to read response body: %v", err)
+		logging.DebugLogContext(ctx, "Failed to read response body: %v", err)
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
-	logging.DebugLog("Received contract state response: %s", string(body))
+	logging.DebugLogContext(ctx, "Received contract state response: %s", string(body))
 
 	if resp.StatusCode == http.StatusNotFound {
 		return nil, nil
@@ -181,11 +231,11 @@ func (c *ContractServiceClient) GetContractState(contractID string) (map[string]
 
 	var state map[string]interface{}
 	if err := json.Unmarshal(body, &state); err != nil {
-		logging.DebugLog("Failed to decode response: %v", err)
+		logging.DebugLogContext(ctx, "Failed to decode response: %v", err)
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
-	logging.DebugLog("Decoded contract state: %+v", state)
+	logging.DebugLogContext(ctx, "Decoded contract state: %+v", state)
 	return state, nil
 }
 
@@ -221,3 +271,25 @@ func (c *ContractServiceClient) GetActiveContracts() ([]string, error) {
 	logging.DebugLog("Found %d active contracts", len(response.Contracts))
 	return response.Contracts, nil
 }
+
+// post sends a JSON body to url with the correlation ID of ctx
+func (c *ContractServiceClient) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.client.Do(req)
+}
+
+// newRequest creates a request bound to ctx that carries its correlation ID
+func (c *ContractServiceClient) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if id := logging.CorrelationIDFromContext(ctx); id != "" {
+		req.Header.Set(CorrelationIDHeader, id)
+	}
+	return req, nil
+}
@@ -0,0 +1,95 @@
+package contracts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddContractSendsContractParams(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	client := mock.ContractServiceClient()
+
+	params := ContractParams{
+		ContractType: "LuckyLadder",
+		Parameters: map[string]interface{}{
+			"payoff": 10.0,
+			"rungs":  []interface{}{100.0, 101.0},
+		},
+	}
+	if err := client.AddContract(context.Background(), "contract-1", params); err != nil {
+		t.Fatalf("AddContract: %v", err)
+	}
+
+	requests := mock.RecordedRequests()
+	if len(requests) != 1 {
+		t.Fatalf("Mock server received %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.Method != http.MethodPost || req.Path != "/contracts" {
+		t.Errorf("AddContract sent %s %s, want POST /contracts", req.Method, req.Path)
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("AddContract sent Content-Type %q, want application/json", contentType)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		t.Fatalf("AddContract sent invalid JSON %q: %v", req.Body, err)
+	}
+	want := map[string]interface{}{
+		"contract_type": "LuckyLadder",
+		"parameters": map[string]interface{}{
+			"contract_id": "contract-1",
+			"payoff":      10.0,
+			"rungs":       []interface{}{100.0, 101.0},
+		},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("AddContract sent %v, want %v", body, want)
+	}
+}
+
+func TestAddContractRetriesWhileServiceUnavailable(t *testing.T) {
+	defer func(delay time.Duration) { addContractRetryDelay = delay }(addContractRetryDelay)
+	addContractRetryDelay = time.Millisecond
+
+	tests := []struct {
+		name        string
+		unavailable int
+		wantErr     bool
+	}{
+		{name: "recovers", unavailable: addContractAttempts - 1},
+		{name: "stays unavailable", unavailable: addContractAttempts + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := NewMockContractServer()
+			defer mock.Close()
+			failures := 0
+			mock.OnAddContract = func(w http.ResponseWriter, r *http.Request) {
+				if failures < tt.unavailable {
+					failures++
+					writeMockJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"detail": "Service unavailable"})
+					return
+				}
+				mock.addContract(w, r)
+			}
+
+			err := mock.ContractServiceClient().AddContract(context.Background(), "contract-1", ContractParams{ContractType: "LuckyLadder"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddContract returned %v, want error %v", err, tt.wantErr)
+			}
+			wantRequests := tt.unavailable + 1
+			if wantRequests > addContractAttempts {
+				wantRequests = addContractAttempts
+			}
+			if got := len(mock.RecordedRequests()); got != wantRequests {
+				t.Errorf("AddContract sent %d requests, want %d", got, wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package contracts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// ContractManager keeps the proxies of the contracts running on this server
+type ContractManager struct {
+	// OnExpire, when set, is called with the ID of every contract the expiry
+	// scanner removes, before it is removed from the contracts service
+	OnExpire func(contractID string)
+
+	service   *ContractServiceClient
+	contracts map[string]*ContractProxy
+	mu        sync.RWMutex
+}
+
+// NewContractManager creates a manager backed by the contracts service
+func NewContractManager(service *ContractServiceClient) *ContractManager {
+	return &ContractManager{
+		service:   service,
+		contracts: make(map[string]*ContractProxy),
+	}
+}
+
+// AddContract creates a contract in the contracts service and returns its
+// proxy. The proxy logs its price updates with the correlation ID and logger
+// fields of ctx, plus the contract ID.
+func (m *ContractManager) AddContract(ctx context.Context, contractID string, params ContractParams) (*ContractProxy, error) {
+	proxy := NewContractProxy(contractID, nil, m.service)
+	proxy.productType = productTypes[params.ContractType]
+	proxy.ctx = logging.WithFields(ctx, map[string]interface{}{"contractID": contractID})
+	proxy.params = &params
+	if err := m.service.AddContract(ctx, contractID, params); err != nil {
+		return nil, err
+	}
+	m.track(contractID, proxy)
+	proxy.startHealthCheck(healthCheckInterval)
+	return proxy, nil
+}
+
+// RestoreContract returns a proxy for a contract that already exists in the
+// contracts service
+func (m *ContractManager) RestoreContract(contractID string) *ContractProxy {
+	proxy := NewContractProxy(contractID, nil, m.service)
+	m.track(contractID, proxy)
+	return proxy
+}
+
+// RemoveContract forgets a contract and removes it from the contracts service
+func (m *ContractManager) RemoveContract(contractID string) error {
+	m.mu.Lock()
+	if proxy, ok := m.contracts[contractID]; ok {
+		proxy.stopHealthCheck()
+	}
+	delete(m.contracts, contractID)
+	m.mu.Unlock()
+	return m.service.RemoveContract(contractID)
+}
+
+// GetContract returns the proxy of a managed contract
+func (m *ContractManager) GetContract(contractID string) (*ContractProxy, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	proxy, ok := m.contracts[contractID]
+	return proxy, ok
+}
+
+// ContractIDs returns the IDs of every managed contract
+func (m *ContractManager) ContractIDs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.contracts))
+	for contractID := range m.contracts {
+		ids = append(ids, contractID)
+	}
+	return ids
+}
+
+// StartExpiryScanner removes contracts that are no longer active every
+// interval
+func (m *ContractManager) StartExpiryScanner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			m.RemoveExpired()
+		}
+	}()
+}
+
+// RemoveExpired removes every managed contract whose state is no longer
+// active and returns their IDs
+func (m *ContractManager) RemoveExpired() []string {
+	m.mu.RLock()
+	var expired []string
+	for contractID, proxy := range m.contracts {
+		if !isActiveState(proxy.GetState()) {
+			expired = append(expired, contractID)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, contractID := range expired {
+		logging.DebugLog("Contract %s has expired, removing", contractID)
+		if m.OnExpire != nil {
+			m.OnExpire(contractID)
+		}
+		if err := m.RemoveContract(contractID); err != nil {
+			logging.DebugLog("Failed to remove expired contract %s: %v", contractID, err)
+		}
+	}
+	return expired
+}
+
+func (m *ContractManager) track(contractID string, proxy *ContractProxy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	logging.DebugLog("Managing contract %s", contractID)
+	proxy.registry = m
+	m.contracts[contractID] = proxy
+}
+
+// isActiveState reports whether a contract state returned by the contracts
+// service is still active. Proxies that handled a price update hold the state
+// in the data of their last ContractUpdate message.
+func isActiveState(state map[string]interface{}) bool {
+	if data, ok := state["data"].(map[string]interface{}); ok {
+		state = data
+	}
+	if active, ok := state["is_active"].(bool); ok {
+		return active
+	}
+	status, _ := state["status"].(string)
+	return status == "" || status == "active"
+}
@@ -0,0 +1,73 @@
+package contracts
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExpiryScannerRemovesExpiredContracts(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	added := time.Now()
+	// The contracts service reports the contract expired after its 200ms
+	mock.OnUpdatePrice = func(w http.ResponseWriter, r *http.Request) {
+		status := "active"
+		if time.Since(added) >= 200*time.Millisecond {
+			status = "expired"
+		}
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{"status": status, "is_active": status == "active"})
+	}
+	manager := NewContractManager(mock.ContractServiceClient())
+	expired := make(chan string, 1)
+	manager.OnExpire = func(contractID string) { expired <- contractID }
+
+	proxy, err := manager.AddContract(context.Background(), "contract-1", ContractParams{
+		ContractType: "MomentumCatcher",
+		Parameters:   map[string]interface{}{"payoff": 10.0, "targetMovement": 5.0, "duration": 200},
+	})
+	if err != nil {
+		t.Fatalf("AddContract: %v", err)
+	}
+	manager.StartExpiryScanner(50 * time.Millisecond)
+
+	// Feed the contract a price every 20ms, as the simulation engine would
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				proxy.HandlePriceUpdate(100, now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	<-stopped
+
+	select {
+	case contractID := <-expired:
+		if contractID != "contract-1" {
+			t.Errorf("Scanner expired %s, want contract-1", contractID)
+		}
+	default:
+		t.Fatal("Scanner did not expire contract-1")
+	}
+	if _, ok := manager.GetContract("contract-1"); ok {
+		t.Error("Manager still manages contract-1 after it expired")
+	}
+	removed := false
+	for _, req := range mock.RecordedRequests() {
+		removed = removed || (req.Method == http.MethodDelete && req.Path == "/contracts/contract-1")
+	}
+	if !removed {
+		t.Error("Expired contract was not removed from the contracts service")
+	}
+}
@@ -0,0 +1,36 @@
+package contracts
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pythonRequestDuration measures requests to the contracts service by the
+// type of contract they concern
+var pythonRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "pricing_python_request_duration_seconds",
+	Help:    "Duration of requests to the contracts service.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"contract_type"})
+
+func init() {
+	prometheus.MustRegister(pythonRequestDuration)
+}
+
+// ContractTypeOf returns the contracts service type of a product type, e.g.
+// "lucky_ladder" for "LuckyLadder", or "unknown"
+func ContractTypeOf(productType string) string {
+	for contractType, name := range productTypes {
+		if name == productType {
+			return contractType
+		}
+	}
+	return "unknown"
+}
+
+// observePythonRequest records a request to the contracts service that
+// started at started
+func observePythonRequest(contractType string, started time.Time) {
+	pythonRequestDuration.WithLabelValues(contractType).Observe(time.Since(started).Seconds())
+}
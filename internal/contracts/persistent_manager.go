@@ -0,0 +1,109 @@
+package contracts
+
+import (
+	"context"
+	"time"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/simulation"
+)
+
+// PersistentContractManager records the managed contracts in the storage
+// service so they can be restored after a restart
+type PersistentContractManager struct {
+	*ContractManager
+	storage *StorageServiceClient
+}
+
+// NewPersistentContractManager wraps manager with persistence to storage
+func NewPersistentContractManager(manager *ContractManager, storage *StorageServiceClient) *PersistentContractManager {
+	return &PersistentContractManager{ContractManager: manager, storage: storage}
+}
+
+// AddContract creates a contract and persists the managed contract list
+func (m *PersistentContractManager) AddContract(ctx context.Context, contractID string, params ContractParams) (*ContractProxy, error) {
+	proxy, err := m.ContractManager.AddContract(ctx, contractID, params)
+	if err != nil {
+		return nil, err
+	}
+	m.persist()
+	return proxy, nil
+}
+
+// RemoveContract removes a contract and persists the managed contract list
+func (m *PersistentContractManager) RemoveContract(contractID string) error {
+	err := m.ContractManager.RemoveContract(contractID)
+	m.persist()
+	return err
+}
+
+// Restore recreates proxies for the contracts that were managed before a
+// restart and that the contracts service still reports as active, and
+// subscribes them to emitter. Without a stored list every active contract is
+// restored. It returns the IDs of the restored contracts.
+func (m *PersistentContractManager) Restore(emitter simulation.PriceEmitter) []string {
+	activeContracts, err := m.service.GetActiveContracts()
+	if err != nil {
+		logging.DebugLog("Failed to get active contracts: %v", err)
+		return nil
+	}
+
+	var managed map[string]bool
+	if snapshot, err := m.storage.GetSimulationSnapshot(); err != nil {
+		logging.DebugLog("Failed to load managed contracts, restoring all active contracts: %v", err)
+	} else if snapshot != nil {
+		managed = make(map[string]bool, len(snapshot))
+		for _, contractID := range snapshot {
+			managed[contractID] = true
+		}
+	}
+
+	var restored []string
+	for _, contractID := range activeContracts {
+		if managed != nil && !managed[contractID] {
+			logging.DebugLog("Contract %s was not managed before restart, skipping", contractID)
+			continue
+		}
+		logging.DebugLog("Restoring contract: %s", contractID)
+		state, err := m.service.GetContractState(context.Background(), contractID)
+		if err != nil {
+			logging.DebugLog("Failed to get contract state: %v", err)
+			continue
+		}
+		if state == nil {
+			logging.DebugLog("Contract state not found: %s", contractID)
+			continue
+		}
+		if status, ok := state["status"].(string); !ok || status != "active" {
+			logging.DebugLog("Contract is not active: %s, status: %s", contractID, status)
+			continue
+		}
+		proxy := m.RestoreContract(contractID)
+		proxy.productType, _ = state["product_type"].(string)
+		proxy.Start()
+		emitter.Subscribe(contractID, proxy)
+		logging.DebugLog("Restored active contract: %s", contractID)
+		restored = append(restored, contractID)
+	}
+	return restored
+}
+
+// StartExpiryScanner removes contracts that are no longer active every
+// interval and persists the managed contract list when any were removed
+func (m *PersistentContractManager) StartExpiryScanner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if expired := m.RemoveExpired(); len(expired) > 0 {
+				m.persist()
+			}
+		}
+	}()
+}
+
+func (m *PersistentContractManager) persist() {
+	if err := m.storage.SaveSimulationSnapshot(m.ContractIDs()); err != nil {
+		logging.DebugLog("Failed to persist managed contracts: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package contracts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"pricingserver/internal/simulation"
+)
+
+// snapshotStore serves the simulation snapshot endpoints of the storage
+// service, keeping the last saved snapshot in memory
+type snapshotStore struct {
+	mu       sync.Mutex
+	snapshot json.RawMessage
+}
+
+func newSnapshotStorageClient(t *testing.T) *StorageServiceClient {
+	store := &snapshotStore{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/simulation/snapshot" {
+			http.NotFound(w, r)
+			return
+		}
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&store.snapshot)
+		case http.MethodGet:
+			if store.snapshot == nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(store.snapshot)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return &StorageServiceClient{baseURL: ts.URL, client: ts.Client()}
+}
+
+// subscriptionRecorder is a PriceEmitter that only records its subscriptions
+type subscriptionRecorder struct {
+	mu          sync.Mutex
+	contractIDs []string
+}
+
+func (e *subscriptionRecorder) Start() {}
+func (e *subscriptionRecorder) Stop()  {}
+
+func (e *subscriptionRecorder) Subscribe(contractID string, handler simulation.PriceHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.contractIDs = append(e.contractIDs, contractID)
+}
+
+func (e *subscriptionRecorder) Unsubscribe(contractID string) {}
+
+func TestPersistentContractManagerRestoresContractsAfterRestart(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	storage := newSnapshotStorageClient(t)
+	params := ContractParams{
+		ContractType: "LuckyLadder",
+		Parameters:   map[string]interface{}{"payoff": 10.0, "rungs": []interface{}{100.0, 101.0}},
+	}
+
+	before := NewPersistentContractManager(NewContractManager(mock.ContractServiceClient()), storage)
+	for _, contractID := range []string{"contract-1", "contract-2"} {
+		proxy, err := before.AddContract(context.Background(), contractID, params)
+		if err != nil {
+			t.Fatalf("AddContract: %v", err)
+		}
+		defer proxy.stopHealthCheck()
+	}
+	// A contract another server added is active but was not managed here
+	if err := mock.ContractServiceClient().AddContract(context.Background(), "contract-other", params); err != nil {
+		t.Fatalf("AddContract: %v", err)
+	}
+
+	// The restarted server starts with an empty manager
+	after := NewPersistentContractManager(NewContractManager(mock.ContractServiceClient()), storage)
+	emitter := &subscriptionRecorder{}
+	restored := after.Restore(emitter)
+	sort.Strings(restored)
+	want := []string{"contract-1", "contract-2"}
+	if !reflect.DeepEqual(restored, want) {
+		t.Fatalf("Restore returned %v, want %v", restored, want)
+	}
+	for _, contractID := range want {
+		if _, ok := after.GetContract(contractID); !ok {
+			t.Errorf("Restored manager does not manage %s", contractID)
+		}
+	}
+	if _, ok := after.GetContract("contract-other"); ok {
+		t.Error("Restored manager manages contract-other, which was not managed before the restart")
+	}
+	sort.Strings(emitter.contractIDs)
+	if !reflect.DeepEqual(emitter.contractIDs, want) {
+		t.Errorf("Restore subscribed %v to prices, want %v", emitter.contractIDs, want)
+	}
+}
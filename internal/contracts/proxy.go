@@ -1,21 +1,48 @@
 package contracts
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"pricingserver/internal/common/logging"
+	"strings"
+	"sync"
 	"time"
 )
 
 // ContractProxy implements both Product and MessageSender interfaces
 type ContractProxy struct {
 	contractID    string
+	productType   string
+	ctx           context.Context
+	registry      ProxyRegistry
 	client        *ContractServiceClient
 	priceCallback func(price float64, timestamp time.Time)
-	lastResponse  map[string]interface{}
-	isActive      bool
-	startTime     time.Time
+	// lastResponse is the last state received for the contract. It is
+	// guarded by stateMu, as the expiry scanner reads it concurrently with
+	// price updates.
+	lastResponse map[string]interface{}
+	stateMu      sync.RWMutex
+	isActive     bool
+	startTime    time.Time
+	// params are the parameters the contract was created with, unknown for
+	// restored contracts
+	params *ContractParams
+	// NeedsReRegistration is set when the contracts service no longer knows
+	// the contract, which is then created again before the next price
+	// update. It is guarded by reRegisterMu.
+	NeedsReRegistration bool
+	reRegisterMu        sync.Mutex
+	// stopHealth ends the health check loop started by startHealthCheck
+	stopHealth     chan struct{}
+	stopHealthOnce sync.Once
 }
 
+// healthCheckInterval is the time between the checks that the contracts
+// service still knows a contract
+const healthCheckInterval = 30 * time.Second
+
 // NewContractProxy creates a new proxy for a contract
 func NewContractProxy(contractID string, _ interface{}, client *ContractServiceClient) *ContractProxy {
 	logging.DebugLog("Creating new contract proxy for contract %s", contractID)
@@ -37,7 +64,7 @@ func (cp *ContractProxy) SendMessage(message []byte) {
 	}
 
 	// Store the update in lastResponse
-	cp.lastResponse = update
+	cp.setLastResponse(update)
 	logging.DebugLog("Contract %s stored update in lastResponse", cp.contractID)
 }
 
@@ -62,27 +89,117 @@ func (cp *ContractProxy) Stop() {
 
 // HandlePriceUpdate forwards price updates to the Python service and processes the response
 func (cp *ContractProxy) HandlePriceUpdate(price float64, timestamp time.Time) {
-	logging.DebugLog("Contract %s handling price update: %f at %v", cp.contractID, price, timestamp)
+	ctx := cp.context()
+	logging.DebugLogContext(ctx, "Contract %s handling price update: %f at %v", cp.contractID, price, timestamp)
 
 	// Only forward updates if the contract is active
 	if !cp.isActive {
-		logging.DebugLog("Contract %s is inactive, skipping price update", cp.contractID)
+		logging.DebugLogContext(ctx, "Contract %s is inactive, skipping price update", cp.contractID)
+		return
+	}
+
+	if !cp.reRegister(ctx) {
 		return
 	}
 
 	// Forward to Python service and get response directly
-	resp, err := cp.client.UpdatePrice(cp.contractID, price)
+	started := time.Now()
+	resp, err := cp.client.UpdatePrice(ctx, cp.contractID, price)
+	observePythonRequest(ContractTypeOf(cp.productType), started)
+	if errors.Is(err, ErrContractNotFound) && cp.params != nil {
+		logging.DebugLogContext(ctx, "Contract %s is unknown to the Python service, registering it again before the next price update", cp.contractID)
+		cp.reRegisterMu.Lock()
+		cp.NeedsReRegistration = true
+		cp.reRegisterMu.Unlock()
+		return
+	}
 	if err != nil {
-		logging.DebugLog("Failed to forward price update to Python service: %v", err)
+		logging.DebugLogContext(ctx, "Failed to forward price update to Python service: %v", err)
+		return
+	}
+
+	logging.DebugLogContext(ctx, "Contract %s received response from Python service: %s", cp.contractID, string(resp))
+	cp.handleResponse(ctx, price, timestamp, resp)
+}
+
+// reRegister creates the contract again in the Python service when it lost
+// it, e.g. after a restart, and reports whether price updates can be sent
+func (cp *ContractProxy) reRegister(ctx context.Context) bool {
+	cp.reRegisterMu.Lock()
+	defer cp.reRegisterMu.Unlock()
+	if !cp.NeedsReRegistration {
+		return true
+	}
+	// AddContract sets the contract ID in the parameters
+	params := ContractParams{ContractType: cp.params.ContractType, Parameters: make(map[string]interface{}, len(cp.params.Parameters))}
+	for name, value := range cp.params.Parameters {
+		params.Parameters[name] = value
+	}
+	if err := cp.client.AddContract(ctx, cp.contractID, params); err != nil {
+		logging.DebugLogContext(ctx, "Failed to register contract %s again: %v", cp.contractID, err)
+		return false
+	}
+	logging.DebugLogContext(ctx, "Registered contract %s again with the Python service", cp.contractID)
+	cp.NeedsReRegistration = false
+	return true
+}
+
+// startHealthCheck pings the contracts service every interval and registers
+// the contract again as soon as the service lost it, rather than on the next
+// price update. Price updates received meanwhile wait for the registration.
+func (cp *ContractProxy) startHealthCheck(interval time.Duration) {
+	cp.stopHealth = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cp.checkHealth()
+			case <-cp.stopHealth:
+				return
+			}
+		}
+	}()
+}
+
+// stopHealthCheck ends the health check loop, if any
+func (cp *ContractProxy) stopHealthCheck() {
+	if cp.stopHealth == nil {
 		return
 	}
+	cp.stopHealthOnce.Do(func() { close(cp.stopHealth) })
+}
 
-	logging.DebugLog("Contract %s received response from Python service: %s", cp.contractID, string(resp))
+// checkHealth registers an active contract again if the contracts service
+// no longer knows it
+func (cp *ContractProxy) checkHealth() {
+	if !cp.isActive || cp.params == nil {
+		return
+	}
+	ctx := cp.context()
+	found, err := cp.client.Ping(cp.contractID)
+	if err != nil {
+		logging.DebugLogContext(ctx, "Failed to check contract %s with the Python service: %v", cp.contractID, err)
+		return
+	}
+	if found {
+		return
+	}
+	logging.DebugLogContext(ctx, "Contract %s is unknown to the Python service, registering it again", cp.contractID)
+	cp.reRegisterMu.Lock()
+	cp.NeedsReRegistration = true
+	cp.reRegisterMu.Unlock()
+	cp.reRegister(ctx)
+}
 
+// handleResponse stores a contracts service response to a price update and
+// notifies the update callback
+func (cp *ContractProxy) handleResponse(ctx context.Context, price float64, timestamp time.Time, resp []byte) {
 	// Parse the response
 	var pythonResp map[string]interface{}
 	if err := json.Unmarshal(resp, &pythonResp); err != nil {
-		logging.DebugLog("Failed to unmarshal Python response: %v", err)
+		logging.DebugLogContext(ctx, "Failed to unmarshal Python response: %v", err)
 		return
 	}
 
@@ -95,12 +212,12 @@ func (cp *ContractProxy) HandlePriceUpdate(price float64, timestamp time.Time) {
 	}
 
 	// Store the response
-	cp.lastResponse = pythonResp
-	logging.DebugLog("Contract %s stored Python response in lastResponse", cp.contractID)
+	cp.setLastResponse(pythonResp)
+	logging.DebugLogContext(ctx, "Contract %s stored Python response in lastResponse", cp.contractID)
 
 	// Handle different status responses
 	status, _ := pythonResp["status"].(string)
-	logging.DebugLog("Contract %s status: %s", cp.contractID, status)
+	logging.DebugLogContext(ctx, "Contract %s status: %s", cp.contractID, status)
 
 	// Create contract update message
 	update := map[string]interface{}{
@@ -123,12 +240,83 @@ func (cp *ContractProxy) HandlePriceUpdate(price float64, timestamp time.Time) {
 	}
 }
 
+// bulkResponseWorkers bounds the goroutines used by HandleBulkResponse
+const bulkResponseWorkers = 8
+
+// ProxyRegistry looks up the proxy of a contract
+type ProxyRegistry interface {
+	GetContract(contractID string) (*ContractProxy, bool)
+}
+
+// HandleBulkResponse dispatches the responses of a bulk price update, keyed
+// by contract ID, to the proxy of each contract in the registry this proxy
+// belongs to. Responses are processed concurrently by a bounded pool of
+// workers. Responses for unknown contracts are reported in the error and the
+// others are still processed.
+func (cp *ContractProxy) HandleBulkResponse(responses map[string]json.RawMessage) error {
+	if cp.registry == nil {
+		return fmt.Errorf("contract %s has no proxy registry", cp.contractID)
+	}
+
+	type job struct {
+		proxy *ContractProxy
+		resp  json.RawMessage
+	}
+	var unknown []string
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < bulkResponseWorkers && i < len(responses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if !j.proxy.isActive {
+					continue
+				}
+				var update struct {
+					Price float64 `json:"price"`
+				}
+				json.Unmarshal(j.resp, &update)
+				j.proxy.handleResponse(j.proxy.context(), update.Price, time.Now(), j.resp)
+			}
+		}()
+	}
+	for contractID, resp := range responses {
+		proxy, ok := cp.registry.GetContract(contractID)
+		if !ok {
+			unknown = append(unknown, contractID)
+			continue
+		}
+		jobs <- job{proxy: proxy, resp: resp}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("bulk response for unknown contracts: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// context returns the context of the request that created the contract
+func (cp *ContractProxy) context() context.Context {
+	if cp.ctx == nil {
+		return context.Background()
+	}
+	return cp.ctx
+}
+
 // SetUpdateCallback sets the callback for price updates (implements Product interface)
 func (cp *ContractProxy) SetUpdateCallback(callback func(price float64, timestamp time.Time)) {
 	logging.DebugLog("Setting update callback for contract %s", cp.contractID)
 	cp.priceCallback = callback
 }
 
+// HasUpdateCallback reports whether a callback for price updates is set
+func (cp *ContractProxy) HasUpdateCallback() bool {
+	return cp.priceCallback != nil
+}
+
 // CheckConditions checks conditions for the proxy (implements Product interface)
 func (cp *ContractProxy) CheckConditions() {
 	// No local conditions to check as everything is handled by Python service
@@ -137,9 +325,9 @@ func (cp *ContractProxy) CheckConditions() {
 // GetState gets the state of the proxy (implements Product interface)
 func (cp *ContractProxy) GetState() map[string]interface{} {
 	logging.DebugLog("Getting state for contract %s", cp.contractID)
-	if cp.lastResponse != nil {
-		logging.DebugLog("Returning lastResponse for contract %s: %+v", cp.contractID, cp.lastResponse)
-		return cp.lastResponse
+	if lastResponse := cp.getLastResponse(); lastResponse != nil {
+		logging.DebugLog("Returning lastResponse for contract %s: %+v", cp.contractID, lastResponse)
+		return lastResponse
 	}
 	// Return a basic state if no response is available
 	logging.DebugLog("No lastResponse available for contract %s, returning basic state", cp.contractID)
@@ -149,3 +337,77 @@ func (cp *ContractProxy) GetState() map[string]interface{} {
 		"timestamp":  time.Now().Format(time.RFC3339),
 	}
 }
+
+func (cp *ContractProxy) getLastResponse() map[string]interface{} {
+	cp.stateMu.RLock()
+	defer cp.stateMu.RUnlock()
+	return cp.lastResponse
+}
+
+func (cp *ContractProxy) setLastResponse(response map[string]interface{}) {
+	cp.stateMu.Lock()
+	defer cp.stateMu.Unlock()
+	cp.lastResponse = response
+}
+
+// ProductType returns the product type of the contract, e.g. "LuckyLadder"
+func (cp *ContractProxy) ProductType() string {
+	return cp.productType
+}
+
+// proxyState is the serialized form of a ContractProxy. Type is the product
+// type discriminator, e.g. "MomentumCatcher".
+type proxyState struct {
+	Type         string                 `json:"type"`
+	ContractID   string                 `json:"contractID"`
+	Active       bool                   `json:"active"`
+	StartTime    time.Time              `json:"startTime"`
+	LastResponse map[string]interface{} `json:"lastResponse,omitempty"`
+}
+
+// MarshalState serializes the proxy state to JSON
+func (cp *ContractProxy) MarshalState() ([]byte, error) {
+	return json.Marshal(proxyState{
+		Type:         cp.productType,
+		ContractID:   cp.contractID,
+		Active:       cp.isActive,
+		StartTime:    cp.startTime,
+		LastResponse: cp.getLastResponse(),
+	})
+}
+
+// UnmarshalState replaces the proxy state with one produced by MarshalState
+func (cp *ContractProxy) UnmarshalState(data []byte) error {
+	var state proxyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	cp.productType = state.Type
+	cp.contractID = state.ContractID
+	cp.isActive = state.Active
+	cp.startTime = state.StartTime
+	cp.setLastResponse(state.LastResponse)
+	return nil
+}
+
+// Snapshot returns the proxy state so it can survive a restart of the
+// pricing server
+func (cp *ContractProxy) Snapshot() ([]byte, error) {
+	return cp.MarshalState()
+}
+
+// Restore replaces the proxy state with a snapshot taken by Snapshot before a
+// restart. The snapshot must be of the same contract and of a known product.
+func (cp *ContractProxy) Restore(snapshot []byte) error {
+	var state proxyState
+	if err := json.Unmarshal(snapshot, &state); err != nil {
+		return err
+	}
+	if !isProductType(state.Type) {
+		return fmt.Errorf("unknown product type: %q", state.Type)
+	}
+	if state.ContractID != cp.contractID {
+		return fmt.Errorf("snapshot of contract %s cannot restore contract %s", state.ContractID, cp.contractID)
+	}
+	return cp.UnmarshalState(snapshot)
+}
@@ -0,0 +1,156 @@
+package contracts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleBulkResponseUpdatesEveryProxy(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	manager := NewContractManager(mock.ContractServiceClient())
+
+	proxies := make([]*ContractProxy, 5)
+	responses := make(map[string]json.RawMessage, len(proxies))
+	for i := range proxies {
+		contractID := fmt.Sprintf("contract-%d", i)
+		proxies[i] = manager.RestoreContract(contractID)
+		responses[contractID] = json.RawMessage(fmt.Sprintf(`{"status": "active", "price": %d}`, 100+i))
+	}
+
+	if err := proxies[0].HandleBulkResponse(responses); err != nil {
+		t.Fatalf("HandleBulkResponse: %v", err)
+	}
+
+	for i, proxy := range proxies {
+		if proxy.lastResponse == nil {
+			t.Errorf("Proxy %d has no lastResponse", i)
+			continue
+		}
+		data, _ := proxy.lastResponse["data"].(map[string]interface{})
+		if price, _ := data["price"].(float64); price != float64(100+i) {
+			t.Errorf("Proxy %d lastResponse is %v, want price %d", i, proxy.lastResponse, 100+i)
+		}
+		if contractID, _ := data["contractID"].(string); contractID != proxy.contractID {
+			t.Errorf("Proxy %d lastResponse has contract ID %q, want %q", i, contractID, proxy.contractID)
+		}
+	}
+	if requests := mock.RecordedRequests(); len(requests) != 0 {
+		t.Errorf("HandleBulkResponse sent %d requests to the contracts service, want none", len(requests))
+	}
+}
+
+func TestHandleBulkResponseReportsUnknownContracts(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	manager := NewContractManager(mock.ContractServiceClient())
+	proxy := manager.RestoreContract("contract-0")
+
+	err := proxy.HandleBulkResponse(map[string]json.RawMessage{
+		"contract-0": json.RawMessage(`{"status": "active", "price": 100}`),
+		"unknown":    json.RawMessage(`{"status": "active", "price": 101}`),
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("HandleBulkResponse returned %v, want an error naming the unknown contract", err)
+	}
+	if proxy.lastResponse == nil {
+		t.Error("HandleBulkResponse did not update the known contract")
+	}
+}
+
+func TestProxyRegistersItsContractAgainAfterServiceRestart(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	manager := NewContractManager(mock.ContractServiceClient())
+	params := ContractParams{ContractType: "LuckyLadder", Parameters: map[string]interface{}{"payoff": 10.0, "rungs": []interface{}{101.0, 102.0}}}
+	proxy, err := manager.AddContract(context.Background(), "contract-1", params)
+	if err != nil {
+		t.Fatalf("AddContract: %v", err)
+	}
+	defer proxy.stopHealthCheck()
+	lastPrice := func() interface{} {
+		data, _ := proxy.getLastResponse()["data"].(map[string]interface{})
+		return data["price"]
+	}
+
+	proxy.HandlePriceUpdate(100.5, time.Now())
+	if price := lastPrice(); price != 100.5 {
+		t.Fatalf("Proxy state has price %v, want 100.5", price)
+	}
+
+	// The restarted service no longer knows the contract
+	mock.mu.Lock()
+	mock.contracts = make(map[string]ContractParams)
+	mock.mu.Unlock()
+	proxy.HandlePriceUpdate(101.5, time.Now())
+	proxy.reRegisterMu.Lock()
+	needsReRegistration := proxy.NeedsReRegistration
+	proxy.reRegisterMu.Unlock()
+	if !needsReRegistration {
+		t.Fatal("Proxy does not need registering again after the service answered 404")
+	}
+	if price := lastPrice(); price != 100.5 {
+		t.Errorf("Proxy state has price %v after a failed update, want 100.5", price)
+	}
+
+	before := len(mock.RecordedRequests())
+	proxy.HandlePriceUpdate(102.5, time.Now())
+	requests := mock.RecordedRequests()[before:]
+	if len(requests) != 2 || requests[0].Path != "/contracts" || requests[1].Path != "/contracts/contract-1/price-update" {
+		t.Fatalf("Price update after the restart sent %+v, want the contract and then the price", requests)
+	}
+	if registered, ok := mock.lookup("contract-1"); !ok || registered.ContractType != "LuckyLadder" || registered.Parameters["payoff"] != 10.0 {
+		t.Errorf("Service has contract %+v after the registration, want the original parameters", registered)
+	}
+	if price := lastPrice(); price != 102.5 {
+		t.Errorf("Proxy state has price %v, want 102.5", price)
+	}
+	proxy.reRegisterMu.Lock()
+	defer proxy.reRegisterMu.Unlock()
+	if proxy.NeedsReRegistration {
+		t.Error("Proxy still needs registering again after a successful update")
+	}
+}
+
+func TestHealthCheckRegistersContractsUnknownToTheService(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	manager := NewContractManager(mock.ContractServiceClient())
+	params := ContractParams{ContractType: "MomentumCatcher", Parameters: map[string]interface{}{"payoff": 10.0, "target_movement": 5.0}}
+	proxy, err := manager.AddContract(context.Background(), "contract-1", params)
+	if err != nil {
+		t.Fatalf("AddContract: %v", err)
+	}
+	defer proxy.stopHealthCheck()
+
+	// A known contract is only pinged
+	before := len(mock.RecordedRequests())
+	proxy.checkHealth()
+	if requests := mock.RecordedRequests()[before:]; len(requests) != 1 || requests[0].Method != http.MethodHead {
+		t.Fatalf("Health check of a known contract sent %+v, want a HEAD request", requests)
+	}
+
+	mock.mu.Lock()
+	mock.contracts = make(map[string]ContractParams)
+	mock.mu.Unlock()
+	before = len(mock.RecordedRequests())
+	proxy.checkHealth()
+	requests := mock.RecordedRequests()[before:]
+	if len(requests) != 2 || requests[0].Method != http.MethodHead || requests[0].Path != "/contracts/contract-1" ||
+		requests[1].Method != http.MethodPost || requests[1].Path != "/contracts" {
+		t.Fatalf("Health check after the service restarted sent %+v, want a HEAD request and the contract", requests)
+	}
+	if registered, ok := mock.lookup("contract-1"); !ok || registered.ContractType != "MomentumCatcher" || registered.Parameters["target_movement"] != 5.0 {
+		t.Errorf("Service has contract %+v after the health check, want the original parameters", registered)
+	}
+	proxy.reRegisterMu.Lock()
+	defer proxy.reRegisterMu.Unlock()
+	if proxy.NeedsReRegistration {
+		t.Error("Proxy still needs registering again after the health check registered it")
+	}
+}
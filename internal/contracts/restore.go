@@ -0,0 +1,42 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// productTypes maps the contract types accepted by the contracts service to
+// their product type names
+var productTypes = map[string]string{
+	"lucky_ladder":     "LuckyLadder",
+	"momentum_catcher": "MomentumCatcher",
+}
+
+// RestoreProduct recreates a contract proxy from data produced by
+// MarshalState. The type discriminator must name a known product.
+func RestoreProduct(data []byte, client *ContractServiceClient) (*ContractProxy, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	if !isProductType(header.Type) {
+		return nil, fmt.Errorf("unknown product type: %q", header.Type)
+	}
+
+	proxy := &ContractProxy{client: client}
+	if err := proxy.UnmarshalState(data); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+func isProductType(name string) bool {
+	for _, productType := range productTypes {
+		if productType == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package contracts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRestoreProductRoundTripsEveryProductType(t *testing.T) {
+	mock := NewMockContractServer()
+	defer mock.Close()
+	client := mock.ContractServiceClient()
+
+	for contractType, productType := range productTypes {
+		proxy := NewContractProxy("contract-"+contractType, nil, client)
+		proxy.productType = productType
+		proxy.SendMessage([]byte(`{"type": "ContractUpdate", "data": {"status": "active", "price": 101.5}}`))
+
+		data, err := proxy.MarshalState()
+		if err != nil {
+			t.Fatalf("MarshalState of %s: %v", productType, err)
+		}
+		if !strings.Contains(string(data), `"type":"`+productType+`"`) {
+			t.Errorf("%s state %s has no type discriminator", productType, data)
+		}
+		restored, err := RestoreProduct(data, client)
+		if err != nil {
+			t.Fatalf("RestoreProduct of %s: %v", productType, err)
+		}
+		if restored.ProductType() != productType || restored.contractID != proxy.contractID {
+			t.Errorf("Restored %s contract %s, want %s contract %s", restored.ProductType(), restored.contractID, productType, proxy.contractID)
+		}
+		if !reflect.DeepEqual(restored.GetState(), proxy.GetState()) {
+			t.Errorf("Restored %s state is %v, want %v", productType, restored.GetState(), proxy.GetState())
+		}
+	}
+}
+
+func TestRestoreProductRejectsUnknownType(t *testing.T) {
+	if _, err := RestoreProduct([]byte(`{"type": "Straddle", "contractID": "contract-1"}`), nil); err == nil {
+		t.Fatal("RestoreProduct accepted an unknown product type")
+	}
+}
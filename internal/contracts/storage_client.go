@@ -0,0 +1,321 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pricingserver/internal/common/logging"
+	"time"
+)
+
+// StorageServiceClient handles communication with the Go storage service
+type StorageServiceClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewStorageServiceClient creates a new client for the storage service
+func NewStorageServiceClient() *StorageServiceClient {
+	baseURL := os.Getenv("STORAGE_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://storage-service:8001" // default URL
+	}
+	logging.DebugLog("Creating new storage service client with base URL: %s", baseURL)
+	return &StorageServiceClient{
+		baseURL: baseURL,
+		client:  &http.Client{},
+	}
+}
+
+// UpdateFinalPrice records the price at which a contract settled
+func (c *StorageServiceClient) UpdateFinalPrice(contractID string, price float64) error {
+	return c.post("/contract/final-price", map[string]interface{}{
+		"id":          contractID,
+		"final_price": price,
+	})
+}
+
+// UpdateHitRungs records the rungs a LuckyLadder contract hit before settling
+func (c *StorageServiceClient) UpdateHitRungs(contractID string, rungs []float64) error {
+	if rungs == nil {
+		rungs = []float64{}
+	}
+	return c.post("/contract/hit-rungs", map[string]interface{}{
+		"id":        contractID,
+		"hit_rungs": rungs,
+	})
+}
+
+// UpdateTimeToTarget records how long a MomentumCatcher contract took to hit
+// its target
+func (c *StorageServiceClient) UpdateTimeToTarget(contractID string, elapsed time.Duration) error {
+	return c.post("/contract/time-to-target", map[string]interface{}{
+		"id":                contractID,
+		"time_to_target_ms": elapsed.Milliseconds(),
+	})
+}
+
+// SaveSimulationSnapshot stores the IDs of the contracts subscribed to price
+// updates, replacing the previous snapshot
+func (c *StorageServiceClient) SaveSimulationSnapshot(ids []string) error {
+	if ids == nil {
+		ids = []string{}
+	}
+	return c.post("/simulation/snapshot", map[string]interface{}{
+		"contract_ids": ids,
+	})
+}
+
+// GetSimulationSnapshot returns the last saved snapshot, or nil if none has
+// been saved yet
+func (c *StorageServiceClient) GetSimulationSnapshot() ([]string, error) {
+	resp, err := c.client.Get(c.baseURL + "/simulation/snapshot")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var snapshot struct {
+		ContractIDs []string `json:"contract_ids"`
+	}
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if snapshot.ContractIDs == nil {
+		snapshot.ContractIDs = []string{}
+	}
+	return snapshot.ContractIDs, nil
+}
+
+// SaveProductSnapshots stores the state of every managed contract proxy,
+// replacing the previous snapshots
+func (c *StorageServiceClient) SaveProductSnapshots(snapshots map[string][]byte) error {
+	states := make(map[string]json.RawMessage, len(snapshots))
+	for contractID, snapshot := range snapshots {
+		states[contractID] = snapshot
+	}
+	return c.post("/simulation/products", map[string]interface{}{
+		"snapshots": states,
+	})
+}
+
+// GetProductSnapshots returns the last saved contract proxy states keyed by
+// contract ID
+func (c *StorageServiceClient) GetProductSnapshots() (map[string][]byte, error) {
+	resp, err := c.client.Get(c.baseURL + "/simulation/products")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Snapshots map[string]json.RawMessage `json:"snapshots"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	snapshots := make(map[string][]byte, len(response.Snapshots))
+	for contractID, snapshot := range response.Snapshots {
+		snapshots[contractID] = snapshot
+	}
+	return snapshots, nil
+}
+
+// SaveMigratedSessions stores the WebSocket sessions handed over to another
+// pricing server, keyed by session ID
+func (c *StorageServiceClient) SaveMigratedSessions(sessions map[string][]byte) error {
+	states := make(map[string]json.RawMessage, len(sessions))
+	for sessionID, session := range sessions {
+		states[sessionID] = session
+	}
+	return c.post("/simulation/sessions", map[string]interface{}{
+		"sessions": states,
+	})
+}
+
+// TakeMigratedSessions removes and returns the stored sessions with the given
+// IDs, or every stored session when sessionIDs is empty
+func (c *StorageServiceClient) TakeMigratedSessions(sessionIDs []string) (map[string][]byte, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.sessionsURL(sessionIDs), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Sessions map[string]json.RawMessage `json:"sessions"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	sessions := make(map[string][]byte, len(response.Sessions))
+	for sessionID, session := range response.Sessions {
+		sessions[sessionID] = session
+	}
+	return sessions, nil
+}
+
+// PendingMigratedSessions returns which of sessionIDs have not been taken by
+// a pricing server yet
+func (c *StorageServiceClient) PendingMigratedSessions(sessionIDs []string) ([]string, error) {
+	resp, err := c.client.Get(c.sessionsURL(sessionIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		SessionIDs []string `json:"session_ids"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return response.SessionIDs, nil
+}
+
+func (c *StorageServiceClient) sessionsURL(sessionIDs []string) string {
+	query := url.Values{"id": sessionIDs}
+	return c.baseURL + "/simulation/sessions?" + query.Encode()
+}
+
+// ContractTemplate is a set of contract parameters saved in the storage
+// service. Parameters use the field names of a contract submission.
+type ContractTemplate struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Parameters json.RawMessage `json:"parameters"`
+	CreatedAt  int64           `json:"created_at"`
+}
+
+// GetContractTemplate returns a saved contract template, or nil if it does
+// not exist
+func (c *StorageServiceClient) GetContractTemplate(id string) (*ContractTemplate, error) {
+	resp, err := c.client.Get(c.baseURL + "/contract/template/" + url.PathEscape(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var template ContractTemplate
+	if err := json.Unmarshal(body, &template); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return &template, nil
+}
+
+// StoredContract is a contract record of the storage service
+type StoredContract struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Parameters json.RawMessage `json:"parameters"`
+	CreatedAt  int64           `json:"created_at"`
+	IsActive   bool            `json:"is_active"`
+	Duration   int             `json:"duration"`
+}
+
+// SaveContractIfNotExists stores a contract unless the storage service
+// already has one with the same ID, which is left untouched, and reports
+// whether it was stored
+func (c *StorageServiceClient) SaveContractIfNotExists(contract StoredContract) (bool, error) {
+	jsonBody, err := json.Marshal(contract)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	resp, err := c.client.Post(c.baseURL+"/contract?if_not_exists=true", "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return false, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("storage service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Created bool `json:"created"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return false, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return result.Created, nil
+}
+
+func (c *StorageServiceClient) post(path string, body interface{}) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	logging.DebugLog("Sending request to storage service %s: %s", path, string(jsonBody))
+
+	resp, err := c.client.Post(c.baseURL+path, "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storage service returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
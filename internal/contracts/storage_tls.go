@@ -0,0 +1,55 @@
+package contracts
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// StorageTLSConfigFromEnv returns the TLS configuration used to call a
+// storage service that requires mutual TLS. STORAGE_CLIENT_CERT_FILE and
+// STORAGE_CLIENT_KEY_FILE hold the client certificate and STORAGE_CA_CERT_FILE
+// the CA the storage service certificate is verified against. It returns nil
+// when none of them is set.
+func StorageTLSConfigFromEnv() (*tls.Config, error) {
+	certFile := os.Getenv("STORAGE_CLIENT_CERT_FILE")
+	keyFile := os.Getenv("STORAGE_CLIENT_KEY_FILE")
+	caFile := os.Getenv("STORAGE_CA_CERT_FILE")
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("STORAGE_CLIENT_CERT_FILE and STORAGE_CLIENT_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load storage client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// UseTLS makes the client connect to the storage service with config, e.g.
+// to present a client certificate
+func (c *StorageServiceClient) UseTLS(config *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	c.client.Transport = transport
+}
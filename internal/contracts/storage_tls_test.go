@@ -0,0 +1,131 @@
+package contracts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate with its key, written as PEM files
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate issues a certificate for name signed by issuer, or a
+// self-signed CA certificate when issuer is nil
+func newTestCertificate(t *testing.T, name string, issuer *testCertificate, usage x509.ExtKeyUsage) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	c := &testCertificate{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return c
+}
+
+func TestStorageClientAuthenticatesWithItsCertificate(t *testing.T) {
+	ca := newTestCertificate(t, "storage-ca", nil, x509.ExtKeyUsageAny)
+	serverCert := newTestCertificate(t, "storage-service", ca, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCertificate(t, "pricing-server", ca, x509.ExtKeyUsageClientAuth)
+
+	// The storage service only accepts clients with a certificate of its CA
+	clients := make(chan string, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clients <- r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	// Rejected handshakes are logged by the server
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	t.Setenv("STORAGE_SERVICE_URL", ts.URL)
+	t.Setenv("STORAGE_CA_CERT_FILE", ca.certFile)
+
+	for _, tc := range []struct {
+		certFile, keyFile string
+		accepted          bool
+	}{
+		{clientCert.certFile, clientCert.keyFile, true},
+		{"", "", false},
+	} {
+		t.Setenv("STORAGE_CLIENT_CERT_FILE", tc.certFile)
+		t.Setenv("STORAGE_CLIENT_KEY_FILE", tc.keyFile)
+		config, err := StorageTLSConfigFromEnv()
+		if err != nil {
+			t.Fatalf("StorageTLSConfigFromEnv: %v", err)
+		}
+		storage := NewStorageServiceClient()
+		storage.UseTLS(config)
+
+		err = storage.UpdateFinalPrice("contract-1", 101.5)
+		if !tc.accepted {
+			if err == nil {
+				t.Error("Storage service accepted a client without a certificate")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("UpdateFinalPrice with the client certificate: %v", err)
+		}
+		if name := <-clients; name != "pricing-server" {
+			t.Errorf("Storage service authenticated %q, want pricing-server", name)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecordedRequest is a request received by a MockContractServer
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// MockContractServer serves the contracts service endpoints used by
+// ContractServiceClient, so the client can be exercised without the Python
+// service. By default it behaves like a healthy service that keeps the
+// contracts it is sent in memory. The On* handlers replace the default
+// response of an endpoint and must be set before requests are sent.
+type MockContractServer struct {
+	*httptest.Server
+
+	OnAddContract    http.HandlerFunc // POST /contracts
+	OnUpdatePrice    http.HandlerFunc // POST /contracts/{id}/price-update
+	OnGetState       http.HandlerFunc // GET /contracts/{id}/state
+	OnRemoveContract http.HandlerFunc // DELETE /contracts/{id}
+	OnGetActive      http.HandlerFunc // GET /contracts/active
+
+	mu        sync.Mutex
+	requests  []RecordedRequest
+	contracts map[string]ContractParams
+}
+
+// NewMockContractServer starts a MockContractServer. Call Close when done.
+func NewMockContractServer() *MockContractServer {
+	m := &MockContractServer{contracts: make(map[string]ContractParams)}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	return m
+}
+
+// ContractServiceClient returns a client that talks to the mock server
+func (m *MockContractServer) ContractServiceClient() *ContractServiceClient {
+	return &ContractServiceClient{
+		baseURL: m.URL,
+		client:  m.Client(),
+	}
+}
+
+// RecordedRequests returns every request received so far, oldest first
+func (m *MockContractServer) RecordedRequests() []RecordedRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	requests := make([]RecordedRequest, len(m.requests))
+	copy(requests, m.requests)
+	return requests
+}
+
+func (m *MockContractServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	m.mu.Lock()
+	m.requests = append(m.requests, RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	m.mu.Unlock()
+
+	if r.URL.Path == "/contracts" && r.Method == http.MethodPost {
+		m.handle(w, r, m.OnAddContract, m.addContract)
+		return
+	}
+	if r.URL.Path == "/contracts/active" && r.Method == http.MethodGet {
+		m.handle(w, r, m.OnGetActive, m.getActive)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/contracts/")
+	if rest == r.URL.Path || rest == "" {
+		http.NotFound(w, r)
+		return
+	}
+	contractID, action, _ := strings.Cut(rest, "/")
+	switch {
+	case action == "price-update" && r.Method == http.MethodPost:
+		m.handle(w, r, m.OnUpdatePrice, func(w http.ResponseWriter, r *http.Request) {
+			m.updatePrice(w, r, contractID)
+		})
+	case action == "price-update" && r.Method == http.MethodGet:
+		m.getLastUpdate(w, contractID)
+	case action == "state" && r.Method == http.MethodGet:
+		m.handle(w, r, m.OnGetState, func(w http.ResponseWriter, r *http.Request) {
+			m.getState(w, contractID)
+		})
+	case action == "" && r.Method == http.MethodHead:
+		m.ping(w, contractID)
+	case action == "" && r.Method == http.MethodDelete:
+		m.handle(w, r, m.OnRemoveContract, func(w http.ResponseWriter, r *http.Request) {
+			m.removeContract(w, contractID)
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handle serves r with override when it is set and with fallback otherwise
+func (m *MockContractServer) handle(w http.ResponseWriter, r *http.Request, override, fallback http.HandlerFunc) {
+	if override != nil {
+		override(w, r)
+		return
+	}
+	fallback(w, r)
+}
+
+// lookup returns the parameters of a contract added to the mock server
+func (m *MockContractServer) lookup(contractID string) (ContractParams, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	params, ok := m.contracts[contractID]
+	return params, ok
+}
+
+func (m *MockContractServer) addContract(w http.ResponseWriter, r *http.Request) {
+	var params ContractParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeMockJSON(w, http.StatusBadRequest, map[string]interface{}{"detail": err.Error()})
+		return
+	}
+	contractID, _ := params.Parameters["contract_id"].(string)
+	if contractID == "" {
+		writeMockJSON(w, http.StatusBadRequest, map[string]interface{}{"detail": "contract_id is required"})
+		return
+	}
+	m.mu.Lock()
+	m.contracts[contractID] = params
+	m.mu.Unlock()
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"contract_id": contractID,
+	})
+}
+
+func (m *MockContractServer) updatePrice(w http.ResponseWriter, r *http.Request, contractID string) {
+	if _, ok := m.lookup(contractID); !ok {
+		writeMockJSON(w, http.StatusNotFound, map[string]interface{}{"detail": "Contract not found"})
+		return
+	}
+	var update struct {
+		Price     float64 `json:"price"`
+		Timestamp string  `json:"timestamp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeMockJSON(w, http.StatusBadRequest, map[string]interface{}{"detail": err.Error()})
+		return
+	}
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "active",
+		"contractID": contractID,
+		"price":      update.Price,
+		"timestamp":  update.Timestamp,
+	})
+}
+
+func (m *MockContractServer) getLastUpdate(w http.ResponseWriter, contractID string) {
+	if _, ok := m.lookup(contractID); !ok {
+		writeMockJSON(w, http.StatusNotFound, map[string]interface{}{"detail": "Contract not found"})
+		return
+	}
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "active",
+		"contractID": contractID,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
+}
+
+func (m *MockContractServer) getState(w http.ResponseWriter, contractID string) {
+	params, ok := m.lookup(contractID)
+	if !ok {
+		writeMockJSON(w, http.StatusNotFound, map[string]interface{}{"detail": "Contract not found"})
+		return
+	}
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"status":       "active",
+		"elapsed_ms":   0,
+		"duration":     params.Parameters["duration"],
+		"product_type": params.ContractType,
+		"current_pnl":  0,
+	})
+}
+
+func (m *MockContractServer) ping(w http.ResponseWriter, contractID string) {
+	if _, ok := m.lookup(contractID); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *MockContractServer) removeContract(w http.ResponseWriter, contractID string) {
+	m.mu.Lock()
+	_, ok := m.contracts[contractID]
+	delete(m.contracts, contractID)
+	m.mu.Unlock()
+	if !ok {
+		writeMockJSON(w, http.StatusNotFound, map[string]interface{}{"detail": "Contract not found"})
+		return
+	}
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{"status": "success"})
+}
+
+func (m *MockContractServer) getActive(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.contracts))
+	for id := range m.contracts {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	sort.Strings(ids)
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{"contracts": ids})
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,52 @@
+package server
+
+import "time"
+
+// maxActivityEntries bounds the activity log of a client
+const maxActivityEntries = 100
+
+// Client activity actions
+const (
+	ActivityContractCreated = "contract_created"
+	ActivityContractQueried = "contract_queried"
+)
+
+// ActivityEntry is something a client did with a contract during its
+// session
+type ActivityEntry struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	Action     string                 `json:"action"`
+	ContractID string                 `json:"contractID"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// recordActivity appends an entry to the client's activity log, dropping the
+// oldest entry once it holds maxActivityEntries. The caller must hold c.mu.
+func (c *Client) recordActivity(action, contractID string, details map[string]interface{}) {
+	if len(c.ActivityLog) >= maxActivityEntries {
+		c.ActivityLog = append(c.ActivityLog[:0], c.ActivityLog[len(c.ActivityLog)-maxActivityEntries+1:]...)
+	}
+	c.ActivityLog = append(c.ActivityLog, ActivityEntry{
+		Timestamp:  time.Now(),
+		Action:     action,
+		ContractID: contractID,
+		Details:    details,
+	})
+}
+
+// GetClientActivity returns the activity log of a connected client, oldest
+// entry first
+func (h *Hub) GetClientActivity(clientID string) ([]ActivityEntry, error) {
+	h.mu.Lock()
+	client := h.findClient(clientID)
+	h.mu.Unlock()
+	if client == nil {
+		return nil, ErrClientNotFound
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	activity := make([]ActivityEntry, len(client.ActivityLog))
+	copy(activity, client.ActivityLog)
+	return activity, nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"testing"
+)
+
+func TestActivityLogRecordsCreationsAndQueries(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	client := newTenantTestClient(h, "client-1", "")
+
+	var contractIDs []string
+	for i := 0; i < 2; i++ {
+		accepted := submitThroughClient(t, client, prices, testLuckyLadder())
+		contractID, _ := accepted["contractID"].(string)
+		if accepted["type"] != MessageTypeContractAccepted || contractID == "" {
+			t.Fatalf("Submission answered %v, want ContractAccepted", accepted)
+		}
+		contractIDs = append(contractIDs, contractID)
+	}
+	client.handleContractQuery(context.Background(), contractIDs[0])
+
+	activity, err := h.GetClientActivity("client-1")
+	if err != nil {
+		t.Fatalf("GetClientActivity: %v", err)
+	}
+	expected := []struct{ action, contractID string }{
+		{ActivityContractCreated, contractIDs[0]},
+		{ActivityContractCreated, contractIDs[1]},
+		{ActivityContractQueried, contractIDs[0]},
+	}
+	if len(activity) != len(expected) {
+		t.Fatalf("Activity log has %d entries, want %d: %+v", len(activity), len(expected), activity)
+	}
+	for i, entry := range activity {
+		if entry.Action != expected[i].action || entry.ContractID != expected[i].contractID || entry.Timestamp.IsZero() {
+			t.Errorf("Activity entry %d is %+v, want %s of %s", i, entry, expected[i].action, expected[i].contractID)
+		}
+	}
+	if productType := activity[0].Details["productType"]; productType != "LuckyLadder" {
+		t.Errorf("Creation entry has product type %v, want LuckyLadder", productType)
+	}
+
+	if _, err := h.GetClientActivity("unknown-client"); err != ErrClientNotFound {
+		t.Errorf("GetClientActivity of an unknown client returned %v, want ErrClientNotFound", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"pricingserver/internal/common/logging"
+
+	"github.com/gorilla/websocket"
+)
+
+// AdminKickReason is the close reason sent to clients disconnected by an admin
+const AdminKickReason = "Disconnected by an administrator"
+
+// ErrClientNotFound is returned when no client with the given ID is connected
+var ErrClientNotFound = errors.New("client not found")
+
+// adminKickClientData is the data of an AdminKickClient message
+type adminKickClientData struct {
+	TargetClientID string `json:"targetClientID"`
+}
+
+// KickClient closes the connection of a locally connected client with a
+// policy violation close frame and removes it from the hub
+func (h *Hub) KickClient(clientID, reason string) error {
+	h.mu.Lock()
+	target := h.findClient(clientID)
+	h.mu.Unlock()
+	if target == nil {
+		return ErrClientNotFound
+	}
+
+	logging.DebugLog("Kicking client %s: %s", clientID, reason)
+	closeFrame := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	if err := target.Conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(time.Second)); err != nil {
+		logging.DebugLog("Failed to send close frame to client %s: %v", clientID, err)
+	}
+	target.kicked = true
+	target.Conn.Close()
+	h.Unregister <- target
+	return nil
+}
+
+// handleAdminKickClient processes AdminKickClient messages from admin clients
+func (c *Client) handleAdminKickClient(ctx context.Context, data json.RawMessage) {
+	if !c.Admin {
+		logging.DebugLogContext(ctx, "Rejecting admin message from non-admin client %s", c.ID)
+		c.sendError(ErrorTypeUnauthorized, "Admin privileges are required")
+		return
+	}
+
+	var kick adminKickClientData
+	if err := json.Unmarshal(data, &kick); err != nil || kick.TargetClientID == "" {
+		logging.DebugLogContext(ctx, "Invalid kick client data: %s", string(data))
+		c.sendError(ErrorTypeValidation, "targetClientID is required")
+		return
+	}
+
+	if err := c.Hub.KickClient(kick.TargetClientID, AdminKickReason); err != nil {
+		logging.DebugLogContext(ctx, "Failed to kick client %s: %v", kick.TargetClientID, err)
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Client not found: %s", kick.TargetClientID))
+		return
+	}
+	logging.DebugLogContext(ctx, "Client %s kicked by admin client %s", kick.TargetClientID, c.ID)
+	if kick.TargetClientID != c.ID {
+		c.sendMessage(map[string]interface{}{
+			"type": MessageTypeClientKicked,
+			"data": kick,
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAdminKickClosesTheKickedClientsConnection(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	go h.Run()
+	conn, kicked := dialTestClient(t, h, ClientConfig{})
+	admin := newTenantTestClient(h, "admin", "")
+	admin.Admin = true
+
+	data, _ := json.Marshal(adminKickClientData{TargetClientID: kicked.ID})
+	message, _ := json.Marshal(Message{Type: MessageTypeAdminKickClient, Data: data})
+	admin.handleMessage(message)
+	if reply := nextMessageOfType(admin, MessageTypeClientKicked); reply == nil {
+		t.Fatal("Admin received no ClientKicked confirmation")
+	}
+
+	// The kicked client sees a policy violation close frame
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != AdminKickReason {
+			t.Fatalf("Kicked connection ended with %v, want a policy violation close frame", err)
+		}
+		break
+	}
+	waitFor(t, "the kicked client to be removed from the hub", func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.findClient(kicked.ID) == nil
+	})
+}
+
+func TestAdminKickRequiresAdminClient(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	go h.Run()
+	_, target := dialTestClient(t, h, ClientConfig{})
+	regular := newTenantTestClient(h, "regular", "")
+
+	data, _ := json.Marshal(adminKickClientData{TargetClientID: target.ID})
+	message, _ := json.Marshal(Message{Type: MessageTypeAdminKickClient, Data: data})
+	regular.handleMessage(message)
+	if reply := nextMessageOfType(regular, MessageTypeError); reply == nil || reply["errorType"] != ErrorTypeUnauthorized {
+		t.Fatalf("Non-admin kick answered %v, want an unauthorized error", reply)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.findClient(target.ID) == nil {
+		t.Error("Non-admin kick removed the target client")
+	}
+}
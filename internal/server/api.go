@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"pricingserver/internal/audit"
+	"pricingserver/internal/common/logging"
+)
+
+// apiContractRetention is how long a finished API contract stays queryable
+// through WaitForContractUpdate
+const apiContractRetention = time.Minute
+
+// ErrContractNotFound is returned when a contract is unknown to the hub
+var ErrContractNotFound = errors.New("contract not found")
+
+// ValidationError wraps an invalid contract submission
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// apiContract tracks the latest state of a contract created outside of a
+// WebSocket connection, so callers can wait for changes
+type apiContract struct {
+	mu       sync.Mutex
+	state    map[string]interface{}
+	changed  chan struct{}
+	terminal bool
+}
+
+func newAPIContract() *apiContract {
+	return &apiContract{changed: make(chan struct{})}
+}
+
+// update stores a new state and wakes up every waiter
+func (ac *apiContract) update(state map[string]interface{}) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.terminal {
+		return
+	}
+	ac.state = state
+	ac.terminal = isTerminalState(state)
+	close(ac.changed)
+	ac.changed = make(chan struct{})
+}
+
+// snapshot returns the current state and a channel closed on the next change
+func (ac *apiContract) snapshot() (map[string]interface{}, <-chan struct{}, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.state, ac.changed, ac.terminal
+}
+
+// SubmitContract validates and creates a contract of tenantID that is not
+// owned by any WebSocket client, returning its ID. It returns
+// ErrTenantLimitExceeded if the tenant has no capacity left.
+func (h *Hub) SubmitContract(tenantID string, data ContractData) (string, error) {
+	if err := ValidateContractData(&data); err != nil {
+		return "", &ValidationError{Err: err}
+	}
+
+	contractID := GenerateUniqueID()
+	if err := h.reserveTenantContract(tenantID, contractID, data.EffectivePayoff()); err != nil {
+		return "", err
+	}
+	logging.DebugLog("Creating new API contract with ID: %s", contractID)
+
+	tracked := newAPIContract()
+	h.apiMu.Lock()
+	h.apiContracts[contractID] = tracked
+	h.apiMu.Unlock()
+
+	onUpdate := func(state map[string]interface{}) {
+		tracked.update(state)
+		if isTerminalState(state) {
+			time.AfterFunc(apiContractRetention, func() {
+				h.apiMu.Lock()
+				delete(h.apiContracts, contractID)
+				h.apiMu.Unlock()
+			})
+		}
+	}
+	if _, err := h.startContract(context.Background(), tenantID, contractID, newContractParams(data), onUpdate); err != nil {
+		h.apiMu.Lock()
+		delete(h.apiContracts, contractID)
+		h.apiMu.Unlock()
+		h.forgetContractTenant(contractID)
+		return "", fmt.Errorf("failed to create contract: %v", err)
+	}
+	return contractID, nil
+}
+
+// GetContractState returns the current state of a contract visible to
+// tenantID from the contracts service
+func (h *Hub) GetContractState(tenantID, contractID string) (map[string]interface{}, error) {
+	if !h.contractVisibleTo(tenantID, contractID) {
+		return nil, ErrContractNotFound
+	}
+	state, err := h.ContractService.GetContractState(context.Background(), contractID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, ErrContractNotFound
+	}
+	return state, nil
+}
+
+// CancelContract stops a contract of tenantID created with SubmitContract
+func (h *Hub) CancelContract(tenantID, contractID string) error {
+	if !h.contractVisibleTo(tenantID, contractID) {
+		return ErrContractNotFound
+	}
+	h.apiMu.Lock()
+	tracked, ok := h.apiContracts[contractID]
+	delete(h.apiContracts, contractID)
+	h.apiMu.Unlock()
+	if !ok {
+		return ErrContractNotFound
+	}
+
+	logging.DebugLog("Cancelling API contract %s", contractID)
+	h.untrackActiveContract(contractID)
+	h.unsubscribePrices(contractID)
+	tracked.update(map[string]interface{}{
+		"contractID": contractID,
+		"status":     "inactive",
+	})
+	h.forgetContractTenant(contractID)
+	h.recordAudit(audit.AuditEvent{EventType: audit.EventContractCancelled, ContractID: contractID})
+	return h.Contracts.RemoveContract(contractID)
+}
+
+// WaitForContractUpdate blocks until a contract of tenantID created with
+// SubmitContract changes state or ctx is done. It returns immediately with the
+// final state if the contract has already reached a terminal state.
+func (h *Hub) WaitForContractUpdate(ctx context.Context, tenantID, contractID string) (map[string]interface{}, error) {
+	if !h.contractVisibleTo(tenantID, contractID) {
+		return nil, ErrContractNotFound
+	}
+	h.apiMu.Lock()
+	tracked, ok := h.apiContracts[contractID]
+	h.apiMu.Unlock()
+	if !ok {
+		return nil, ErrContractNotFound
+	}
+
+	state, changed, terminal := tracked.snapshot()
+	if terminal {
+		return state, nil
+	}
+
+	select {
+	case <-changed:
+		state, _, _ = tracked.snapshot()
+		return state, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"pricingserver/internal/contracts"
+	"pricingserver/internal/simulation"
+)
+
+// manualEmitter is a price source that emits a price only when tick is
+// called
+type manualEmitter struct {
+	mu       sync.Mutex
+	handlers map[string]simulation.PriceHandler
+}
+
+func newManualEmitter() *manualEmitter {
+	return &manualEmitter{handlers: make(map[string]simulation.PriceHandler)}
+}
+
+func (e *manualEmitter) Start() {}
+func (e *manualEmitter) Stop()  {}
+
+func (e *manualEmitter) Subscribe(contractID string, handler simulation.PriceHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers[contractID] = handler
+}
+
+func (e *manualEmitter) Unsubscribe(contractID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.handlers, contractID)
+}
+
+// tick sends price to every subscribed contract
+func (e *manualEmitter) tick(price float64) {
+	e.mu.Lock()
+	handlers := make([]simulation.PriceHandler, 0, len(e.handlers))
+	for _, handler := range e.handlers {
+		handlers = append(handlers, handler)
+	}
+	e.mu.Unlock()
+	for _, handler := range handlers {
+		handler.HandlePriceUpdate(price, time.Now())
+	}
+}
+
+// subscribed reports whether contractID is subscribed to the emitter
+func (e *manualEmitter) subscribed(contractID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.handlers[contractID]
+	return ok
+}
+
+// newServiceTestHub returns a hub whose contracts service is a mock server,
+// whose storage service accepts every request, and whose prices are emitted
+// by the returned manualEmitter
+func newServiceTestHub(t *testing.T) (*Hub, *contracts.MockContractServer, *manualEmitter) {
+	mock := contracts.NewMockContractServer()
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(func() {
+		mock.Close()
+		storage.Close()
+	})
+	t.Setenv("CONTRACTS_SERVICE_URL", mock.URL)
+	t.Setenv("STORAGE_SERVICE_URL", storage.URL)
+
+	h := NewHub()
+	prices := newManualEmitter()
+	h.SimulationEngine = prices
+	return h, mock, prices
+}
+
+// testLuckyLadder is a valid LuckyLadder submission
+func testLuckyLadder() ContractData {
+	return ContractData{
+		ProductType: "LuckyLadder",
+		Rungs:       []float64{101, 102, 103},
+		Duration:    60000,
+		Payoff:      10,
+	}
+}
+
+// waitForUpdateWhileTicking long-polls a contract of tenantID, ticking price
+// until the poll returns, as a tick sent before the poll starts waiting would
+// not wake it up
+func waitForUpdateWhileTicking(t *testing.T, h *Hub, prices *manualEmitter, tenantID, contractID string, price float64) map[string]interface{} {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	type result struct {
+		state map[string]interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		state, err := h.WaitForContractUpdate(ctx, tenantID, contractID)
+		done <- result{state, err}
+	}()
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case res := <-done:
+			if res.err != nil {
+				t.Fatalf("WaitForContractUpdate: %v", res.err)
+			}
+			return res.state
+		case <-ticker.C:
+			prices.tick(price)
+		}
+	}
+}
+
+func TestSubmitContractReportsPriceUpdates(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+
+	contractID, err := h.SubmitContract("tenant-a", testLuckyLadder())
+	if err != nil {
+		t.Fatalf("SubmitContract: %v", err)
+	}
+	if !prices.subscribed(contractID) {
+		t.Fatal("Submitted contract is not subscribed to prices")
+	}
+
+	state := waitForUpdateWhileTicking(t, h, prices, "tenant-a", contractID, 101.5)
+	if state["price"] != 101.5 || state["contractID"] != contractID {
+		t.Errorf("Long poll returned %v, want the state after the tick at 101.5", state)
+	}
+
+	state, err = h.GetContractState("tenant-a", contractID)
+	if err != nil {
+		t.Fatalf("GetContractState: %v", err)
+	}
+	if state["product_type"] != "lucky_ladder" || state["status"] != "active" {
+		t.Errorf("GetContractState returned %v, want the active LuckyLadder", state)
+	}
+}
+
+func TestCancelContractEndsLongPoll(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+
+	contractID, err := h.SubmitContract("tenant-a", testLuckyLadder())
+	if err != nil {
+		t.Fatalf("SubmitContract: %v", err)
+	}
+	if err := h.CancelContract("tenant-a", contractID); err != nil {
+		t.Fatalf("CancelContract: %v", err)
+	}
+	if prices.subscribed(contractID) {
+		t.Error("Cancelled contract is still subscribed to prices")
+	}
+	if _, err := h.WaitForContractUpdate(context.Background(), "tenant-a", contractID); !errors.Is(err, ErrContractNotFound) {
+		t.Errorf("WaitForContractUpdate of a cancelled contract: %v, want ErrContractNotFound", err)
+	}
+}
+
+func TestContractAPIIsScopedToTenant(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+
+	contractID, err := h.SubmitContract("tenant-a", testLuckyLadder())
+	if err != nil {
+		t.Fatalf("SubmitContract: %v", err)
+	}
+
+	for _, tenantID := range []string{"tenant-b", ""} {
+		if _, err := h.GetContractState(tenantID, contractID); !errors.Is(err, ErrContractNotFound) {
+			t.Errorf("GetContractState as %q: %v, want ErrContractNotFound", tenantID, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, err := h.WaitForContractUpdate(ctx, tenantID, contractID)
+		cancel()
+		if !errors.Is(err, ErrContractNotFound) {
+			t.Errorf("WaitForContractUpdate as %q: %v, want ErrContractNotFound", tenantID, err)
+		}
+		if err := h.CancelContract(tenantID, contractID); !errors.Is(err, ErrContractNotFound) {
+			t.Errorf("CancelContract as %q: %v, want ErrContractNotFound", tenantID, err)
+		}
+	}
+
+	if _, err := h.GetContractState("tenant-a", contractID); err != nil {
+		t.Errorf("GetContractState as the owner after other tenants' requests: %v", err)
+	}
+}
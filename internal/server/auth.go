@@ -0,0 +1,458 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/common/secrets"
+)
+
+// apiAuthToken is the shared token required by HTTP endpoints that accept a
+// token query parameter. Authentication is disabled when it is empty.
+var apiAuthToken = os.Getenv("API_AUTH_TOKEN")
+
+// adminAuthToken is the bearer token required by admin endpoints. Admin
+// endpoints are unavailable when it is empty.
+var adminAuthToken = os.Getenv("ADMIN_AUTH_TOKEN")
+
+// jwtSecret is the HS256 key used to verify bearer tokens on WebSocket
+// connections. Authentication is disabled when it is empty.
+var jwtSecret = os.Getenv("JWT_SECRET")
+
+// OAuth2 settings for browser clients using the authorization code flow with
+// PKCE. The flow is disabled when OAUTH2_PROVIDER_URL or OAUTH2_CLIENT_ID is
+// empty.
+var (
+	oauth2ProviderURL  = os.Getenv("OAUTH2_PROVIDER_URL")
+	oauth2ClientID     = os.Getenv("OAUTH2_CLIENT_ID")
+	oauth2ClientSecret = os.Getenv("OAUTH2_CLIENT_SECRET")
+	// oauth2RedirectURL is the callback URL registered with the provider,
+	// derived from the request when empty
+	oauth2RedirectURL = os.Getenv("OAUTH2_REDIRECT_URL")
+	// oauth2SPAURL is where the browser is sent after signing in
+	oauth2SPAURL = os.Getenv("OAUTH2_SPA_URL")
+)
+
+// OAuth2 cookies
+const (
+	oauth2TokenCookie = "pricing_access_token"
+	oauth2StateCookie = "pricing_oauth2_state"
+)
+
+// oauth2StateTTL is how long a sign-in may take before the callback is
+// rejected
+const oauth2StateTTL = 5 * time.Minute
+
+var oauth2HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// LoadSecrets resolves the authentication secrets with provider, replacing
+// the values read from the environment
+func LoadSecrets(provider secrets.SecretsProvider) error {
+	targets := map[string]*string{
+		"API_AUTH_TOKEN":       &apiAuthToken,
+		"ADMIN_AUTH_TOKEN":     &adminAuthToken,
+		"JWT_SECRET":           &jwtSecret,
+		"OAUTH2_CLIENT_SECRET": &oauth2ClientSecret,
+	}
+	for name, target := range targets {
+		value, err := provider.GetSecret(name)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", name, err)
+		}
+		*target = value
+	}
+	return nil
+}
+
+// ErrUnauthorized is returned when a request carries no valid credentials
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Claims holds the JWT claims used by the pricing server
+type Claims struct {
+	Subject   string `json:"sub"`
+	Tenant    string `json:"tenant"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// ValidToken reports whether token grants access to token-protected endpoints
+func ValidToken(token string) bool {
+	if apiAuthToken == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(apiAuthToken)) == 1
+}
+
+// ValidAdminRequest reports whether r carries the admin bearer token
+func ValidAdminRequest(r *http.Request) bool {
+	if adminAuthToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminAuthToken)) == 1
+}
+
+// AuthenticateRequest verifies the bearer token in the Authorization header,
+// or the OAuth2 access token cookie set by OAuth2CallbackHandler. When neither
+// JWT_SECRET nor OAuth2 is configured every request is accepted with empty
+// claims.
+func AuthenticateRequest(r *http.Request) (*Claims, error) {
+	if jwtSecret == "" && !oauth2Enabled() {
+		return &Claims{}, nil
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+		if jwtSecret != "" {
+			claims, err := ParseJWT(token, []byte(jwtSecret))
+			if err == nil || !oauth2Enabled() {
+				return claims, err
+			}
+		}
+		return oauth2Claims(r.Context(), token)
+	}
+	if cookie, err := r.Cookie(oauth2TokenCookie); err == nil && cookie.Value != "" && oauth2Enabled() {
+		return oauth2Claims(r.Context(), cookie.Value)
+	}
+	return nil, ErrUnauthorized
+}
+
+// AuthenticateTenant authenticates a REST or streaming request like WebSocket
+// connections and returns the tenant it acts for. Requests carrying the admin
+// token act for the default tenant.
+func AuthenticateTenant(r *http.Request) (string, error) {
+	if ValidAdminRequest(r) {
+		return "", nil
+	}
+	claims, err := AuthenticateRequest(r)
+	if err != nil {
+		return "", err
+	}
+	return claims.Tenant, nil
+}
+
+// ParseJWT verifies an HS256-signed JWT and returns its claims
+func ParseJWT(token string, secret []byte) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnauthorized
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrUnauthorized
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrUnauthorized
+	}
+
+	var claims Claims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, ErrUnauthorized
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrUnauthorized
+	}
+	return &claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func oauth2Enabled() bool {
+	return oauth2ProviderURL != "" && oauth2ClientID != ""
+}
+
+// oidcConfiguration holds the provider endpoints used by the OAuth2 flow
+type oidcConfiguration struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+var (
+	oidcConfig   *oidcConfiguration
+	oidcConfigMu sync.Mutex
+)
+
+// discoverOIDC fetches the provider configuration once it succeeds
+func discoverOIDC(ctx context.Context) (*oidcConfiguration, error) {
+	oidcConfigMu.Lock()
+	defer oidcConfigMu.Unlock()
+	if oidcConfig != nil {
+		return oidcConfig, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(oauth2ProviderURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oauth2HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC configuration: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery returned status %d", resp.StatusCode)
+	}
+
+	var config oidcConfiguration
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to decode OIDC configuration: %v", err)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.UserinfoEndpoint == "" {
+		return nil, errors.New("OIDC configuration is missing an endpoint")
+	}
+	oidcConfig = &config
+	return oidcConfig, nil
+}
+
+// oauth2Claims validates an access token against the provider's userinfo
+// endpoint and returns its subject and tenant
+func oauth2Claims(ctx context.Context, token string) (*Claims, error) {
+	config, err := discoverOIDC(ctx)
+	if err != nil {
+		logging.DebugLog("OAuth2 token validation failed: %v", err)
+		return nil, ErrUnauthorized
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := oauth2HTTPClient.Do(req)
+	if err != nil {
+		logging.DebugLog("OAuth2 userinfo request failed: %v", err)
+		return nil, ErrUnauthorized
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrUnauthorized
+	}
+
+	var claims Claims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil || claims.Subject == "" {
+		return nil, ErrUnauthorized
+	}
+	return &claims, nil
+}
+
+// randomURLString returns n random bytes encoded for use in URLs
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge returns the S256 code challenge of verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func oauth2Callback(r *http.Request) string {
+	if oauth2RedirectURL != "" {
+		return oauth2RedirectURL
+	}
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + "/oauth2/callback"
+}
+
+// OAuth2AuthorizeHandler serves GET /oauth2/authorize, which starts the
+// authorization code flow by redirecting to the provider. The state and PKCE
+// code verifier are kept in a short-lived cookie.
+func OAuth2AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !oauth2Enabled() {
+		http.Error(w, "OAuth2 is not configured", http.StatusNotFound)
+		return
+	}
+	config, err := discoverOIDC(r.Context())
+	if err != nil {
+		logging.DebugLog("OAuth2 authorize failed: %v", err)
+		http.Error(w, "OAuth2 provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomURLString(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verifier, err := randomURLString(32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauth2StateCookie,
+		Value:    state + "." + verifier,
+		Path:     "/oauth2/",
+		MaxAge:   int(oauth2StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oauth2ClientID},
+		"redirect_uri":          {oauth2Callback(r)},
+		"scope":                 {"openid"},
+		"state":                 {state},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, config.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// OAuth2CallbackHandler serves GET /oauth2/callback. It exchanges the
+// authorization code for an access token, stores the token in a secure
+// cookie accepted by WebSocket connections and redirects to the SPA.
+func OAuth2CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !oauth2Enabled() {
+		http.Error(w, "OAuth2 is not configured", http.StatusNotFound)
+		return
+	}
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		http.Error(w, "Authorization failed: "+errParam, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oauth2StateCookie)
+	if err != nil {
+		http.Error(w, "Missing OAuth2 state", http.StatusBadRequest)
+		return
+	}
+	state, verifier, ok := strings.Cut(cookie.Value, ".")
+	if !ok || subtle.ConstantTimeCompare([]byte(state), []byte(r.URL.Query().Get("state"))) != 1 {
+		http.Error(w, "Invalid OAuth2 state", http.StatusBadRequest)
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	token, expiresIn, err := exchangeOAuth2Code(r.Context(), code, verifier, oauth2Callback(r))
+	if err != nil {
+		logging.DebugLog("OAuth2 code exchange failed: %v", err)
+		http.Error(w, "Failed to exchange authorization code", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauth2StateCookie,
+		Path:     "/oauth2/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauth2TokenCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   expiresIn,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	target := oauth2SPAURL
+	if target == "" {
+		target = "/"
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// exchangeOAuth2Code redeems an authorization code at the provider's token
+// endpoint and returns the access token and its lifetime in seconds
+func exchangeOAuth2Code(ctx context.Context, code, verifier, redirectURI string) (string, int, error) {
+	config, err := discoverOIDC(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {oauth2ClientID},
+		"code_verifier": {verifier},
+	}
+	if oauth2ClientSecret != "" {
+		form.Set("client_secret", oauth2ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := oauth2HTTPClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", 0, fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("token response has no access token")
+	}
+	if token.ExpiresIn <= 0 {
+		token.ExpiresIn = int(time.Hour.Seconds())
+	}
+	return token.AccessToken, token.ExpiresIn, nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockOIDCProvider is an OpenID Connect provider issuing access-token for
+// test-code, provided the token request carries the verifier of the code
+// challenge sent to its authorization endpoint
+type mockOIDCProvider struct {
+	*httptest.Server
+	mu        sync.Mutex
+	challenge string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	p := &mockOIDCProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcConfiguration{
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			UserinfoEndpoint:      p.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		challenge := p.challenge
+		p.mu.Unlock()
+		if r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("code") != "test-code" ||
+			r.Form.Get("client_id") != "pricing-spa" || pkceChallenge(r.Form.Get("code_verifier")) != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access-token", "expires_in": 600})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Claims{Subject: "user-1", Tenant: "tenant-a"})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize plays the provider's authorization endpoint for a redirect from
+// OAuth2AuthorizeHandler, returning the state to send back to the callback
+func (p *mockOIDCProvider) authorize(t *testing.T, location string) string {
+	t.Helper()
+	redirect, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+	if redirect.Path != "/authorize" || query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "pricing-spa" {
+		t.Fatalf("Authorize redirected to %s, want the provider's authorization endpoint with an S256 challenge", location)
+	}
+	p.mu.Lock()
+	p.challenge = query.Get("code_challenge")
+	p.mu.Unlock()
+	return query.Get("state")
+}
+
+// withOAuth2Provider enables the OAuth2 flow with provider for the duration
+// of the test
+func withOAuth2Provider(t *testing.T, provider *mockOIDCProvider) {
+	providerURL, clientID, spaURL := oauth2ProviderURL, oauth2ClientID, oauth2SPAURL
+	oauth2ProviderURL, oauth2ClientID, oauth2SPAURL = provider.URL, "pricing-spa", "/app"
+	oidcConfigMu.Lock()
+	oidcConfig = nil
+	oidcConfigMu.Unlock()
+	t.Cleanup(func() {
+		oauth2ProviderURL, oauth2ClientID, oauth2SPAURL = providerURL, clientID, spaURL
+		oidcConfigMu.Lock()
+		oidcConfig = nil
+		oidcConfigMu.Unlock()
+	})
+}
+
+// startOAuth2SignIn calls OAuth2AuthorizeHandler and returns its redirect
+// location and state cookie
+func startOAuth2SignIn(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	OAuth2AuthorizeHandler(rec, httptest.NewRequest(http.MethodGet, "/oauth2/authorize", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Authorize answered %d: %s", rec.Code, rec.Body)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oauth2StateCookie {
+			return rec.Header().Get("Location"), cookie
+		}
+	}
+	t.Fatal("Authorize set no state cookie")
+	return "", nil
+}
+
+// callOAuth2Callback calls OAuth2CallbackHandler with code, state and cookie
+func callOAuth2Callback(state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?"+url.Values{"code": {"test-code"}, "state": {state}}.Encode(), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	OAuth2CallbackHandler(rec, req)
+	return rec
+}
+
+func TestOAuth2PKCEFlowAuthenticatesWebSocketRequests(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	withOAuth2Provider(t, provider)
+
+	location, stateCookie := startOAuth2SignIn(t)
+	state := provider.authorize(t, location)
+
+	rec := callOAuth2Callback(state, stateCookie)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/app" {
+		t.Fatalf("Callback answered %d to %q: %s, want a redirect to the SPA", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	var tokenCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oauth2TokenCookie {
+			tokenCookie = cookie
+		}
+	}
+	if tokenCookie == nil || tokenCookie.Value != "access-token" || !tokenCookie.HttpOnly || !tokenCookie.Secure {
+		t.Fatalf("Callback set token cookie %+v, want a secure HttpOnly cookie with the access token", tokenCookie)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.AddCookie(tokenCookie)
+	claims, err := AuthenticateRequest(req)
+	if err != nil {
+		t.Fatalf("AuthenticateRequest with the token cookie: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Tenant != "tenant-a" {
+		t.Fatalf("AuthenticateRequest returned %+v, want the provider's user and tenant", claims)
+	}
+}
+
+func TestOAuth2CallbackRejectsMismatchedStateAndVerifier(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	withOAuth2Provider(t, provider)
+
+	location, stateCookie := startOAuth2SignIn(t)
+	state := provider.authorize(t, location)
+
+	if rec := callOAuth2Callback("forged-state", stateCookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("Callback with another state answered %d, want 400", rec.Code)
+	}
+
+	// The state of this sign-in with the verifier of another one
+	_, otherCookie := startOAuth2SignIn(t)
+	_, otherVerifier, _ := strings.Cut(otherCookie.Value, ".")
+	forged := &http.Cookie{Name: oauth2StateCookie, Value: state + "." + otherVerifier}
+	if rec := callOAuth2Callback(state, forged); rec.Code != http.StatusBadGateway {
+		t.Errorf("Callback with another verifier answered %d, want 502 as the provider rejects the code", rec.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"pricingserver/internal/contracts"
+	"pricingserver/internal/simulation"
+)
+
+// scenarioContract is a contracts service contract driven by a backtest
+// scenario instead of the live price source
+type scenarioContract struct {
+	*contracts.ContractProxy
+	contractID string
+	service    *contracts.ContractServiceClient
+}
+
+// Close removes the contract from the contracts service
+func (sc *scenarioContract) Close() error {
+	return sc.service.RemoveContract(sc.contractID)
+}
+
+// ScenarioProduct returns a constructor that creates contracts described by
+// data in the contracts service, for use with a simulation.ScenarioRunner
+func (h *Hub) ScenarioProduct(name string, data ContractData) (simulation.ProductConstructor, error) {
+	if err := ValidateContractData(&data); err != nil {
+		return simulation.ProductConstructor{}, &ValidationError{Err: err}
+	}
+	return simulation.ProductConstructor{
+		Name:   name,
+		Payoff: data.EffectivePayoff(),
+		New: func(contractID string) (simulation.ScenarioProduct, error) {
+			if err := h.ContractService.AddContract(context.Background(), contractID, newContractParams(data)); err != nil {
+				return nil, err
+			}
+			proxy := contracts.NewContractProxy(contractID, nil, h.ContractService)
+			proxy.Start()
+			return &scenarioContract{ContractProxy: proxy, contractID: contractID, service: h.ContractService}, nil
+		},
+	}, nil
+}
+
+// SensitivityAnalysis sweeps paramName (targetMovement, payoff or duration)
+// of baseParams over values and runs each resulting contract against scenario
+func (h *Hub) SensitivityAnalysis(baseParams ContractData, paramName string, values []float64, scenario simulation.Scenario) ([]simulation.SensitivityResult, error) {
+	if _, err := withContractParam(baseParams, paramName, 0); err != nil {
+		return nil, err
+	}
+	return simulation.SensitivityAnalysis(paramName, values, scenario, func(value float64) (simulation.ProductConstructor, error) {
+		data, _ := withContractParam(baseParams, paramName, value)
+		return h.ScenarioProduct(fmt.Sprintf("%s=%g", paramName, value), data)
+	}), nil
+}
+
+// withContractParam returns a copy of data with the named parameter set to value
+func withContractParam(data ContractData, paramName string, value float64) (ContractData, error) {
+	switch paramName {
+	case "targetMovement":
+		data.TargetMovement = value
+	case "payoff":
+		data.Payoff = value
+	case "duration":
+		data.Duration = int64(value)
+	default:
+		return data, &ValidationError{Err: fmt.Errorf("unsupported sensitivity parameter: %s", paramName)}
+	}
+	return data, nil
+}
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
+	"pricingserver/internal/audit"
 	"pricingserver/internal/common/logging"
 	"pricingserver/internal/contracts"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -24,17 +28,35 @@ func init() {
 
 // Message types
 const (
-	MessageTypeContractSubmission = "ContractSubmission"
-	MessageTypeContractAccepted   = "ContractAccepted"
-	MessageTypeContractUpdate     = "ContractUpdate"
-	MessageTypeContractQuery      = "ContractQuery"
-	MessageTypeError              = "Error"
+	MessageTypeContractSubmission     = "ContractSubmission"
+	MessageTypeContractAccepted       = "ContractAccepted"
+	MessageTypeContractUpdate         = "ContractUpdate"
+	MessageTypeContractQuery          = "ContractQuery"
+	MessageTypeError                  = "Error"
+	MessageTypeContractSettlement     = "ContractSettlement"
+	MessageTypeAdminKickClient        = "AdminKickClient"
+	MessageTypeClientKicked           = "ClientKicked"
+	MessageTypeMultiLegSubmission     = "MultiLegSubmission"
+	MessageTypeMultiLegAccepted       = "MultiLegAccepted"
+	MessageTypeMultiSubscribe         = "MultiSubscribe"
+	MessageTypeMultiSubscribeResponse = "MultiSubscribeResponse"
+	MessageTypeContractShare          = "ContractShare"
+	MessageTypeContractShared         = "ContractShared"
+	MessageTypeCreateFromTemplate     = "CreateFromTemplate"
+	MessageTypeReconnected            = "Reconnected"
+	MessageTypeServerMigrate          = "ServerMigrate"
+	MessageTypeValidateContract       = "ValidateContract"
+	MessageTypeValidationResult       = "ValidationResult"
+	MessageTypeSetFilter              = "SetFilter"
+	MessageTypeFilterSet              = "FilterSet"
 )
 
 // Error types
 const (
-	ErrorTypeValidation = "ValidationError"
-	ErrorTypeParse      = "ParseError"
+	ErrorTypeValidation   = "ValidationError"
+	ErrorTypeParse        = "ParseError"
+	ErrorTypeRateLimit    = "RateLimitError"
+	ErrorTypeUnauthorized = "UnauthorizedError"
 )
 
 // Message structure
@@ -51,6 +73,62 @@ type ContractData struct {
 	TargetMovement float64   `json:"targetMovement,omitempty"`
 	Duration       int64     `json:"duration"` // milliseconds
 	Payoff         float64   `json:"payoff"`
+	// Strike is the reference price of the contract, set from the first
+	// price update when zero
+	Strike float64 `json:"strike,omitempty"`
+	// RungsRelativeToStrike makes LuckyLadder rungs fractions of the strike,
+	// e.g. 1.05 for 5% above it
+	RungsRelativeToStrike bool `json:"rungsRelativeToStrike,omitempty"`
+	// TargetRelativeToStrike makes the MomentumCatcher target movement a
+	// fraction of the strike, e.g. 0.05 for 5% of it
+	TargetRelativeToStrike bool `json:"targetRelativeToStrike,omitempty"`
+	// Notional is the position size. When positive, the payoff is scaled by
+	// it and PayoffPerUnit instead of being the flat Payoff.
+	Notional      float64 `json:"notional,omitempty"`
+	PayoffPerUnit float64 `json:"payoffPerUnit,omitempty"`
+	// MaxPayoff caps the notional that earns a payoff, 0 for no cap
+	MaxPayoff float64 `json:"maxPayoff,omitempty"`
+	// Instrument is the traded instrument, e.g. "EUR/USD". Contracts
+	// without one are priced against the default simulated price.
+	Instrument string `json:"instrument,omitempty"`
+	// PayoffFunction names the PayoffRegistry function the contract settles
+	// with, "fixed" when empty
+	PayoffFunction string `json:"payoffFunction,omitempty"`
+	// RungGenerator generates the rungs of a LuckyLadder submitted without
+	// any: "manual" (default, rungs are required), "fibonacci", "arithmetic"
+	// or "geometric". RungCount rungs are generated above RungStart, which
+	// defaults to the strike, RungStep apart; for "geometric" RungStep is the
+	// growth rate between rungs.
+	RungGenerator string  `json:"rungGenerator,omitempty"`
+	RungStart     float64 `json:"rungStart,omitempty"`
+	RungStep      float64 `json:"rungStep,omitempty"`
+	RungCount     int     `json:"rungCount,omitempty"`
+	// Precondition, when set, keeps the contract pending until the price
+	// meets it
+	Precondition *PreconditionSpec `json:"precondition,omitempty"`
+}
+
+// firstPriceTimeout is how long a submission waits for the first update of
+// the new contract before it is accepted
+const firstPriceTimeout = 5 * time.Second
+
+// NotionalUnit is the notional amount PayoffPerUnit is paid for
+const NotionalUnit = 1.0
+
+// EffectivePayoff returns the payoff of the contract, scaled by its notional
+// when it has one
+func (d ContractData) EffectivePayoff() float64 {
+	return scaledPayoff(d.Payoff, d.Notional, d.PayoffPerUnit, d.MaxPayoff)
+}
+
+func scaledPayoff(payoff, notional, payoffPerUnit, maxPayoff float64) float64 {
+	if notional <= 0 {
+		return payoff
+	}
+	if maxPayoff > 0 {
+		notional = math.Min(notional, maxPayoff)
+	}
+	return notional * payoffPerUnit / NotionalUnit
 }
 
 // ErrorResponse represents an error message
@@ -60,25 +138,180 @@ type ErrorResponse struct {
 	Message   string `json:"message"`
 }
 
+// Message encodings
+const (
+	MessageEncodingText   = "text"
+	MessageEncodingBinary = "binary"
+)
+
+// contentTypeJSON is the prefix byte that marks a binary frame as JSON
+const contentTypeJSON byte = 0x01
+
+// ClientConfig holds per-connection settings negotiated at upgrade time
+type ClientConfig struct {
+	// SerializationFormat is either "json" (default) or "msgpack"
+	SerializationFormat string
+	// Subprotocol is the WebSocket subprotocol negotiated at upgrade time,
+	// which takes precedence over the X-Serialization-Format header
+	Subprotocol string
+	// MessageEncoding is either "text" or "binary". When empty, JSON uses
+	// text frames and every other format binary frames, and frames of either
+	// type are accepted.
+	MessageEncoding string
+	// Compression is either "" or "deflate". With deflate, outgoing messages
+	// are binary frames starting with a content encoding byte.
+	Compression string
+}
+
 // Client represents a connected client
 type Client struct {
-	ID        string
-	Conn      *websocket.Conn
-	Send      chan []byte
-	Contracts map[string]string
-	Hub       *Hub
-	mu        sync.Mutex
+	ID         string
+	TenantID   string
+	UserID     string
+	RemoteAddr string
+	// UserAgent is the User-Agent header of the WebSocket handshake, see
+	// SetConnectionMetadata
+	UserAgent string
+	// ConnectedAt is when the client connected
+	ConnectedAt time.Time
+	// Admin is set for connections authenticated with the admin token
+	Admin bool
+	// SessionID identifies the connection to restore its contracts when the
+	// client reconnects, see ReconnectHandler
+	SessionID string
+	// kicked is set when an admin removed the client, whose contracts are
+	// then not kept for it to reconnect
+	kicked bool
+	// migrated is set when the client's session was handed over to another
+	// server by Hub.Migrate
+	migrated   bool
+	Conn       *websocket.Conn
+	Send       chan []byte
+	Contracts  map[string]string
+	Hub        *Hub
+	Config     ClientConfig
+	serializer Serializer
+	mu         sync.Mutex
+	// correlationID identifies the message being handled in log lines
+	correlationID string
+	// Logger adds the client ID to the log lines about the client
+	Logger *logging.Logger
+	// lastSentHashes holds the last message sent for each contract, guarded
+	// by the hub lock
+	lastSentHashes map[string]sentHash
+	// SubscriptionFilters holds the filters on the ContractUpdate messages
+	// of each contract, guarded by the hub lock
+	SubscriptionFilters map[string][]FilterPredicate
+	// ActivityLog holds the latest contract activity of the client, guarded
+	// by mu
+	ActivityLog []ActivityEntry
+	// multiLegParents holds the IDs of the unsettled multi-leg contracts of
+	// the client, whose settlement it is subscribed to, guarded by mu
+	multiLegParents map[string]bool
+	// BytesSent and BytesReceived count the WebSocket frame payloads
+	// exchanged with the client. They are updated atomically.
+	BytesSent     int64
+	BytesReceived int64
+
+	// writeDone is closed when WritePump returns
+	writeDone chan struct{}
+	// sendMu guards sending on Send against it being closed, which sets
+	// sendClosed, see queue and closeSend. stopSend is closed first to wake
+	// up senders waiting for room in Send.
+	sendMu     sync.RWMutex
+	sendClosed bool
+	stopSend   chan struct{}
+	stopOnce   sync.Once
 }
 
 // NewClient creates a new client instance
-func NewClient(hub *Hub, conn *websocket.Conn) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, config ClientConfig) (*Client, error) {
+	serializer, err := NewSerializer(config.SerializationFormat)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMessageEncoding(config.MessageEncoding, serializer); err != nil {
+		return nil, err
+	}
+	id := GenerateUniqueID()
 	return &Client{
-		ID:        GenerateUniqueID(),
-		Hub:       hub,
-		Conn:      conn,
-		Send:      make(chan []byte, 256),
-		Contracts: make(map[string]string),
+		ID:         id,
+		Hub:        hub,
+		Conn:       conn,
+		Send:       make(chan []byte, 256),
+		Contracts:  make(map[string]string),
+		Config:     config,
+		serializer: serializer,
+		Logger:     logging.New(map[string]interface{}{"clientID": id}),
+		writeDone:  make(chan struct{}),
+		stopSend:   make(chan struct{}),
+		// Connections are upgraded right before their client is created
+		ConnectedAt: time.Now(),
+	}, nil
+}
+
+// logContext returns the context of the client's log lines, which carries
+// its Logger
+func (c *Client) logContext() context.Context {
+	if c.Logger == nil {
+		return context.Background()
+	}
+	return c.Logger
+}
+
+// codec returns the client's serializer, falling back to JSON
+func (c *Client) codec() Serializer {
+	if c.serializer == nil {
+		return JSONSerializer{}
 	}
+	return c.serializer
+}
+
+// ValidateMessageEncoding checks that encoding can carry messages in format
+func ValidateMessageEncoding(encoding, format string) error {
+	serializer, err := NewSerializer(format)
+	if err != nil {
+		return err
+	}
+	return validateMessageEncoding(encoding, serializer)
+}
+
+func validateMessageEncoding(encoding string, serializer Serializer) error {
+	switch encoding {
+	case "", MessageEncodingBinary:
+		return nil
+	case MessageEncodingText:
+		if _, isJSON := serializer.(JSONSerializer); !isJSON {
+			return fmt.Errorf("text message encoding requires json serialization")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported message encoding: %s", encoding)
+	}
+}
+
+// frameType returns the WebSocket frame type used for outgoing messages
+func (c *Client) frameType() int {
+	if c.compresses() {
+		return websocket.BinaryMessage
+	}
+	switch c.Config.MessageEncoding {
+	case MessageEncodingText:
+		return websocket.TextMessage
+	case MessageEncodingBinary:
+		return websocket.BinaryMessage
+	}
+	if _, isJSON := c.codec().(JSONSerializer); isJSON {
+		return websocket.TextMessage
+	}
+	return websocket.BinaryMessage
+}
+
+// binaryJSON reports whether JSON messages are carried in binary frames,
+// which start with a content type prefix byte
+func (c *Client) binaryJSON() bool {
+	_, isJSON := c.codec().(JSONSerializer)
+	return isJSON && c.Config.MessageEncoding == MessageEncodingBinary
 }
 
 // ReadPump handles incoming messages from the client
@@ -88,14 +321,31 @@ func (c *Client) ReadPump() {
 		c.Conn.Close()
 	}()
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		frameType, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			logging.DebugLog("ReadPump error: %v", err)
 			break
 		}
+		atomic.AddInt64(&c.BytesReceived, int64(len(message)))
+		wsMessageSize.WithLabelValues("received").Observe(float64(len(message)))
+
+		// Reject frames that do not match the negotiated encoding
+		if c.Config.MessageEncoding != "" && frameType != c.frameType() {
+			logging.DebugLog("Rejecting frame of type %d on %s connection", frameType, c.Config.MessageEncoding)
+			c.sendError(ErrorTypeParse, fmt.Sprintf("Only %s frames are accepted", c.Config.MessageEncoding))
+			continue
+		}
+		if c.binaryJSON() {
+			if len(message) == 0 || message[0] != contentTypeJSON {
+				logging.DebugLog("Binary JSON frame without content type prefix")
+				c.sendError(ErrorTypeParse, "Binary JSON frames must start with the application/json content type byte")
+				continue
+			}
+			message = message[1:]
+		}
 
 		// Try to parse as JSON first
-		if !json.Valid(message) {
+		if _, isJSON := c.codec().(JSONSerializer); isJSON && !json.Valid(message) {
 			logging.DebugLog("Invalid JSON received")
 			c.sendError(ErrorTypeParse, "Invalid JSON format")
 			continue
@@ -107,76 +357,139 @@ func (c *Client) ReadPump() {
 
 // handleMessage processes messages from the client
 func (c *Client) handleMessage(message []byte) {
+	c.correlationID = GenerateUniqueID()
+	ctx := logging.WithCorrelationID(c.logContext(), c.correlationID)
+
 	var msg Message
-	if err := json.Unmarshal(message, &msg); err != nil {
-		logging.DebugLog("Failed to unmarshal message: %v", err)
+	if err := c.codec().Unmarshal(message, &msg); err != nil {
+		logging.DebugLogContext(ctx, "Failed to unmarshal message: %v", err)
 		c.sendError(ErrorTypeParse, "Invalid message format")
 		return
 	}
 
 	if msg.Type == "" {
-		logging.DebugLog("Missing message type")
+		logging.DebugLogContext(ctx, "Missing message type")
 		c.sendError(ErrorTypeValidation, "Message type is required")
 		return
 	}
 
-	logging.DebugLog("Received message type: %s", msg.Type)
+	logging.DebugLogContext(ctx, "Received message type: %s", msg.Type)
 
 	switch msg.Type {
 	case MessageTypeContractSubmission:
 		if msg.Data == nil {
-			logging.DebugLog("Missing data field in contract submission")
+			logging.DebugLogContext(ctx, "Missing data field in contract submission")
 			c.sendError(ErrorTypeValidation, "Data field is required for contract submission")
 			return
 		}
-		c.handleContractSubmission(msg.Data)
+		c.handleContractSubmission(ctx, msg.Data)
+	case MessageTypeMultiLegSubmission:
+		if msg.Data == nil {
+			logging.DebugLogContext(ctx, "Missing data field in multi-leg submission")
+			c.sendError(ErrorTypeValidation, "Data field is required for multi-leg submission")
+			return
+		}
+		c.handleMultiLegSubmission(ctx, msg.Data)
 	case MessageTypeContractQuery:
 		if msg.ContractID == "" {
-			logging.DebugLog("Missing contractID in contract query")
+			logging.DebugLogContext(ctx, "Missing contractID in contract query")
 			c.sendError(ErrorTypeValidation, "ContractID is required for contract query")
 			return
 		}
-		logging.DebugLog("Querying contract: %s", msg.ContractID)
-		c.handleContractQuery(msg.ContractID)
+		logging.DebugLogContext(ctx, "Querying contract: %s", msg.ContractID)
+		c.handleContractQuery(ctx, msg.ContractID)
+	case MessageTypeMultiSubscribe:
+		if msg.Data == nil {
+			logging.DebugLogContext(ctx, "Missing data field in multi-subscribe")
+			c.sendError(ErrorTypeValidation, "Data field is required for multi-subscribe")
+			return
+		}
+		c.handleMultiSubscribe(ctx, msg.Data)
+	case MessageTypeContractShare:
+		if msg.Data == nil {
+			logging.DebugLogContext(ctx, "Missing data field in contract share")
+			c.sendError(ErrorTypeValidation, "Data field is required for contract share")
+			return
+		}
+		c.handleContractShare(ctx, msg.Data)
+	case MessageTypeCreateFromTemplate:
+		if msg.Data == nil {
+			logging.DebugLogContext(ctx, "Missing data field in create from template")
+			c.sendError(ErrorTypeValidation, "Data field is required for create from template")
+			return
+		}
+		c.handleCreateFromTemplate(ctx, msg.Data)
+	case MessageTypeValidateContract:
+		if msg.Data == nil {
+			logging.DebugLogContext(ctx, "Missing data field in contract validation")
+			c.sendError(ErrorTypeValidation, "Data field is required for contract validation")
+			return
+		}
+		c.handleValidateContract(ctx, msg.Data)
+	case MessageTypeSetFilter:
+		if msg.Data == nil {
+			logging.DebugLogContext(ctx, "Missing data field in set filter")
+			c.sendError(ErrorTypeValidation, "Data field is required for set filter")
+			return
+		}
+		c.handleSetFilter(ctx, msg.Data)
+	case MessageTypeAdminKickClient:
+		c.handleAdminKickClient(ctx, msg.Data)
 	default:
-		logging.DebugLog("Unknown message type: %s", msg.Type)
+		logging.DebugLogContext(ctx, "Unknown message type: %s", msg.Type)
 		c.sendError(ErrorTypeValidation, fmt.Sprintf("Unknown message type: %s", msg.Type))
 	}
 }
 
 // handleContractQuery processes contract query requests
-func (c *Client) handleContractQuery(contractID string) {
+func (c *Client) handleContractQuery(ctx context.Context, contractID string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	logging.DebugLog("Getting contract state for: %s", contractID)
+	logging.DebugLogContext(ctx, "Getting contract state for: %s", contractID)
+
+	// Contracts of other tenants are reported as missing
+	if !c.Hub.contractVisibleTo(c.TenantID, contractID) {
+		logging.DebugLogContext(ctx, "Contract %s is not visible to tenant %q", contractID, c.TenantID)
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Contract not found: %s", contractID))
+		return
+	}
+	// So are contracts the client does not own or follow, with the same
+	// error so that contract IDs cannot be enumerated
+	if _, ok := c.Contracts[contractID]; !ok {
+		logging.DebugLogContext(ctx, "Contract %s is not in the namespace of client %s", contractID, c.ID)
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Contract not found: %s", contractID))
+		return
+	}
+	c.recordActivity(ActivityContractQueried, contractID, nil)
+	c.replayContractUpdates(ctx, contractID)
 
 	// Get contract state from service
-	state, err := c.Hub.ContractService.GetContractState(contractID)
+	state, err := c.Hub.ContractService.GetContractState(ctx, contractID)
 	if err != nil {
-		logging.DebugLog("Failed to get contract state: %v", err)
+		logging.DebugLogContext(ctx, "Failed to get contract state: %v", err)
 		c.sendError(ErrorTypeValidation, fmt.Sprintf("Failed to get contract state: %v", err))
 		return
 	}
 
 	// If contract exists, send its state
 	if state != nil {
-		logging.DebugLog("Got contract state: %+v", state)
+		logging.DebugLogContext(ctx, "Got contract state: %+v", state)
 		update := map[string]interface{}{
 			"type":       MessageTypeContractUpdate,
 			"contractID": contractID,
 			"data":       state,
 		}
-		logging.DebugLog("Sending contract update: %+v", update)
+		logging.DebugLogContext(ctx, "Sending contract update: %+v", update)
 		c.sendMessage(update)
 	} else {
-		logging.DebugLog("Contract not found: %s", contractID)
+		logging.DebugLogContext(ctx, "Contract not found: %s", contractID)
 		c.sendError(ErrorTypeValidation, fmt.Sprintf("Contract not found: %s", contractID))
 	}
 }
 
-// validateContractData validates the contract data
-func (c *Client) validateContractData(data *ContractData) error {
+// ValidateContractData validates the contract data
+func ValidateContractData(data *ContractData) error {
 	if data.ProductType == "" {
 		return fmt.Errorf("productType is required")
 	}
@@ -185,12 +498,41 @@ func (c *Client) validateContractData(data *ContractData) error {
 		return fmt.Errorf("duration must be positive")
 	}
 
-	if data.Payoff <= 0 {
+	if data.Notional < 0 || data.PayoffPerUnit < 0 || data.MaxPayoff < 0 {
+		return fmt.Errorf("notional, payoffPerUnit and maxPayoff must not be negative")
+	}
+
+	if data.Notional > 0 {
+		if data.PayoffPerUnit <= 0 {
+			return fmt.Errorf("payoffPerUnit must be positive when notional is set")
+		}
+	} else if data.Payoff <= 0 {
 		return fmt.Errorf("payoff must be positive")
 	}
 
+	if data.Strike < 0 {
+		return fmt.Errorf("strike must not be negative")
+	}
+
+	if err := validatePayoffFunction(data); err != nil {
+		return err
+	}
+
+	if data.Precondition != nil {
+		if err := validatePrecondition(data.Precondition); err != nil {
+			return err
+		}
+	}
+
 	switch data.ProductType {
 	case "LuckyLadder":
+		if len(data.Rungs) == 0 {
+			rungs, err := generateRungs(data)
+			if err != nil {
+				return err
+			}
+			data.Rungs = rungs
+		}
 		if len(data.Rungs) == 0 {
 			return fmt.Errorf("rungs are required for LuckyLadder")
 		}
@@ -223,92 +565,179 @@ func (c *Client) validateContractData(data *ContractData) error {
 	return nil
 }
 
-// handleContractSubmission processes contract submission requests
-func (c *Client) handleContractSubmission(data json.RawMessage) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// newContractParams converts validated contract data into Python service parameters
+func newContractParams(data ContractData) contracts.ContractParams {
+	parameters := map[string]interface{}{
+		"duration": data.Duration,
+		"payoff":   data.Payoff,
+		"strike":   data.Strike,
+	}
+	if data.Notional > 0 {
+		parameters["notional"] = data.Notional
+		parameters["payoff_per_unit"] = data.PayoffPerUnit
+		parameters["max_payoff"] = data.MaxPayoff
+	}
+	if data.Instrument != "" {
+		parameters["instrument"] = data.Instrument
+	}
+	if data.PayoffFunction != "" {
+		parameters["payoff_function"] = data.PayoffFunction
+	}
+	if data.Precondition != nil {
+		parameters["pending"] = true
+		parameters["precondition"] = data.Precondition
+	}
 
+	var contractParams contracts.ContractParams
+	switch data.ProductType {
+	case "LuckyLadder":
+		contractParams = contracts.ContractParams{
+			ContractType: "lucky_ladder",
+			Parameters:   parameters,
+		}
+		contractParams.Parameters["rungs"] = data.Rungs
+		contractParams.Parameters["rungs_relative_to_strike"] = data.RungsRelativeToStrike
+	case "MomentumCatcher":
+		contractParams = contracts.ContractParams{
+			ContractType: "momentum_catcher",
+			Parameters:   parameters,
+		}
+		contractParams.Parameters["target_movement"] = data.TargetMovement
+		contractParams.Parameters["target_relative_to_strike"] = data.TargetRelativeToStrike
+	}
+	return contractParams
+}
+
+// isTerminalState reports whether a contract state means the contract is no longer active
+func isTerminalState(state map[string]interface{}) bool {
+	status, ok := state["status"].(string)
+	return ok && (status == "inactive" || status == "expired" || status == "target_hit" || status == statusPreconditionTimeout)
+}
+
+// handleContractSubmission processes contract submission requests
+func (c *Client) handleContractSubmission(ctx context.Context, data json.RawMessage) {
 	var contractData ContractData
 	if err := json.Unmarshal(data, &contractData); err != nil {
-		logging.DebugLog("Failed to unmarshal contract data: %v", err)
+		logging.DebugLogContext(ctx, "Failed to unmarshal contract data: %v", err)
 		c.sendError(ErrorTypeParse, "Invalid contract data format")
 		return
 	}
 
-	if err := c.validateContractData(&contractData); err != nil {
-		logging.DebugLog("Contract validation failed: %v", err)
+	if err := ValidateContractData(&contractData); err != nil {
+		logging.DebugLogContext(ctx, "Contract validation failed: %v", err)
 		c.sendError(ErrorTypeValidation, err.Error())
 		return
 	}
 
 	contractID := GenerateUniqueID()
-	logging.DebugLog("Creating new contract with ID: %s", contractID)
+	logging.DebugLogContext(ctx, "Creating new contract with ID: %s", contractID)
 
-	// Create contract parameters for Python service
-	var contractParams contracts.ContractParams
-	parameters := map[string]interface{}{
-		"duration": contractData.Duration,
-		"payoff":   contractData.Payoff,
+	c.mu.Lock()
+	firstPrice, err := c.startClientContract(ctx, contractID, contractData, newContractParams(contractData), nil)
+	if err != nil {
+		c.mu.Unlock()
+		if err == ErrTenantLimitExceeded {
+			logging.DebugLogContext(ctx, "Rejecting contract for tenant %q: %v", c.TenantID, err)
+			c.sendError(ErrorTypeRateLimit, "Tenant contract limit exceeded")
+			return
+		}
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Failed to create contract: %v", err))
+		return
 	}
+	c.recordContractCreated(contractID, contractData)
+	c.mu.Unlock()
 
-	switch contractData.ProductType {
-	case "LuckyLadder":
-		contractParams = contracts.ContractParams{
-			ContractType: "lucky_ladder",
-			Parameters:   parameters,
-		}
-		contractParams.Parameters["rungs"] = contractData.Rungs
-	case "MomentumCatcher":
-		contractParams = contracts.ContractParams{
-			ContractType: "momentum_catcher",
-			Parameters:   parameters,
+	// Confirm once the first ContractUpdate was sent, so the client does not
+	// query a contract that has no state yet. c.mu is not held while waiting,
+	// as the contract's updates take it once it reaches a terminal state.
+	if firstPrice != nil {
+		select {
+		case <-firstPrice:
+		case <-time.After(firstPriceTimeout):
+			logging.DebugLogContext(ctx, "Contract %s received no price within %s, accepting it anyway", contractID, firstPriceTimeout)
 		}
-		contractParams.Parameters["target_movement"] = contractData.TargetMovement
 	}
+	accepted := map[string]interface{}{
+		"type":       MessageTypeContractAccepted,
+		"contractID": contractID,
+	}
+	if contractData.Precondition != nil {
+		accepted["status"] = "pending"
+	}
+	c.sendMessage(accepted)
+}
 
-	// Create a proxy for the contract
-	proxy := contracts.NewContractProxy(contractID, nil, c.Hub.ContractService)
+// startClientContract reserves tenant capacity for a contract owned by c and
+// starts it. Updates are broadcast to the contract's subscribers and
+// onTerminal, when set, is called once the contract reaches a terminal state.
+// It returns ErrTenantLimitExceeded if the tenant has no capacity left, and
+// otherwise the channel of Hub.startContract. The caller must hold c.mu.
+func (c *Client) startClientContract(ctx context.Context, contractID string, data ContractData, params contracts.ContractParams, onTerminal func(state map[string]interface{})) (<-chan struct{}, error) {
+	if err := c.Hub.reserveTenantContract(c.TenantID, contractID, data.EffectivePayoff()); err != nil {
+		return nil, err
+	}
 
-	// Set up a callback to handle Python service responses
-	proxy.SetUpdateCallback(func(price float64, timestamp time.Time) {
-		state := proxy.GetState()
-		logging.DebugLog("Got state from proxy: %+v", state)
+	// Register ownership before subscribing so the first update is delivered
+	c.Contracts[contractID] = data.ProductType
+	c.Hub.subscribeClient(contractID, c)
 
+	firstPrice, err := c.Hub.startContract(ctx, c.TenantID, contractID, params, func(state map[string]interface{}) {
 		update := map[string]interface{}{
 			"type":       MessageTypeContractUpdate,
 			"contractID": contractID,
 			"data":       state,
 		}
+		c.Hub.ContractBroadcast(contractID, update)
 
-		if updateBytes, err := json.Marshal(update); err == nil {
-			c.Send <- updateBytes
-		} else {
-			logging.DebugLog("Failed to marshal contract update: %v", err)
-		}
-
-		if status, ok := state["status"].(string); ok && (status == "inactive" || status == "expired" || status == "target_hit") {
-			logging.DebugLog("Contract %s is no longer active (status: %s), unsubscribing", contractID, status)
-			c.Hub.SimulationEngine.Unsubscribe(contractID)
-			delete(c.Contracts, contractID)
+		if isTerminalState(state) {
+			c.forgetContract(contractID)
+			c.Hub.unsubscribeClient(contractID, c)
+			if onTerminal != nil {
+				onTerminal(state)
+			}
 		}
 	})
-
-	// Forward to Python service and subscribe to updates
-	if err := c.Hub.ContractService.AddContract(contractID, contractParams); err != nil {
-		logging.DebugLog("Failed to add contract to service: %v", err)
-		c.sendError(ErrorTypeValidation, fmt.Sprintf("Failed to create contract: %v", err))
-		return
+	if err != nil {
+		delete(c.Contracts, contractID)
+		c.Hub.unsubscribeClient(contractID, c)
+		c.Hub.forgetContractTenant(contractID)
+		return nil, err
 	}
+	return firstPrice, nil
+}
 
-	c.Hub.SimulationEngine.Subscribe(contractID, proxy)
-	proxy.Start()
+// forgetContract removes a contract that reached a terminal state from the
+// contracts of the client. The caller must not hold c.mu.
+func (c *Client) forgetContract(contractID string) {
+	c.mu.Lock()
+	delete(c.Contracts, contractID)
+	c.mu.Unlock()
+}
 
-	c.Contracts[contractID] = contractData.ProductType
+// contractsSnapshot returns a copy of the contracts of the client keyed by
+// contract ID with their product type. The caller must not hold c.mu.
+func (c *Client) contractsSnapshot() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	contracts := make(map[string]string, len(c.Contracts))
+	for contractID, productType := range c.Contracts {
+		contracts[contractID] = productType
+	}
+	return contracts
+}
 
-	// Send confirmation
-	c.sendMessage(map[string]interface{}{
-		"type":       MessageTypeContractAccepted,
-		"contractID": contractID,
+// recordContractCreated registers a started contract with the cluster, the
+// audit log and the client's activity log. The caller must hold c.mu.
+func (c *Client) recordContractCreated(contractID string, data ContractData) {
+	c.recordActivity(ActivityContractCreated, contractID, map[string]interface{}{"productType": data.ProductType})
+	c.Hub.registerContract(contractID, c)
+	c.Hub.recordAudit(audit.AuditEvent{
+		EventType:  audit.EventContractCreated,
+		ContractID: contractID,
+		ClientID:   c.ID,
+		UserID:     c.UserID,
+		IPAddress:  c.RemoteAddr,
+		Payload:    data,
 	})
 }
 
@@ -324,14 +753,61 @@ func (c *Client) sendError(errorType string, message string) {
 
 // sendMessage sends a message to the client
 func (c *Client) sendMessage(data interface{}) {
-	message, err := json.Marshal(data)
+	c.sendMessageUntil(data, nil)
+}
+
+// sendMessageUntil sends a message to the client, waiting for room in its
+// Send buffer until done is closed. It reports whether the message was
+// queued.
+func (c *Client) sendMessageUntil(data interface{}, done <-chan struct{}) bool {
+	message, err := c.codec().Marshal(data)
 	if err != nil {
 		logging.DebugLog("Failed to marshal message: %v", err)
-		return
+		return false
 	}
 
 	logging.DebugLog("Sending message: %s", string(message))
-	c.Send <- message
+	if !c.queue(c.frame(message), done) {
+		logging.DebugLog("Client %s disconnected, dropping message", c.ID)
+		return false
+	}
+	return true
+}
+
+// queue adds frame to the Send channel, waiting while it is full until
+// WritePump returns or done is closed. It reports whether the frame was
+// queued. Frames for a client whose Send channel was closed are dropped.
+func (c *Client) queue(frame []byte, done <-chan struct{}) bool {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+	if c.sendClosed {
+		return false
+	}
+	select {
+	case c.Send <- frame:
+		return true
+	case <-c.writeDone:
+		return false
+	case <-c.stopSend:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// closeSend closes the Send channel so WritePump sends a close frame once
+// the queued messages are written. The caller must hold the hub lock and
+// remove the client from the hub's clients.
+func (c *Client) closeSend() {
+	if c.stopSend != nil {
+		c.stopOnce.Do(func() { close(c.stopSend) })
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.Send)
+	}
 }
 
 // WritePump handles sending messages to the client
@@ -340,21 +816,35 @@ func (c *Client) WritePump() {
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
+		if c.writeDone != nil {
+			close(c.writeDone)
+		}
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.Send:
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeFrame := []byte{}
+				if c.Hub.draining.Load() {
+					closeFrame = websocket.FormatCloseMessage(websocket.CloseGoingAway, ShutdownCloseReason)
+				}
+				c.Conn.WriteMessage(websocket.CloseMessage, closeFrame)
 				return
 			}
 
+			// Messages to compressing clients already carry their content
+			// encoding byte
+			if c.binaryJSON() && !c.compresses() {
+				message = append([]byte{contentTypeJSON}, message...)
+			}
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			wsMessageSize.WithLabelValues("sent").Observe(float64(len(message)))
+			if err := c.Conn.WriteMessage(c.frameType(), message); err != nil {
 				logging.DebugLog("Error writing message: %v", err)
 				return
 			}
+			c.recordSent(len(message))
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
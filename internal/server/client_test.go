@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestContractQueryIsLimitedToClientNamespace(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	owner := newTenantTestClient(h, "owner", "")
+	other := newTenantTestClient(h, "other", "")
+
+	accepted := submitThroughClient(t, owner, prices, testLuckyLadder())
+	contractID, _ := accepted["contractID"].(string)
+	if accepted["type"] != MessageTypeContractAccepted || contractID == "" {
+		t.Fatalf("Submission answered %v, want ContractAccepted", accepted)
+	}
+
+	// Another client's contract and an unknown ID get the same answer
+	for _, queried := range []string{contractID, "unknown-contract"} {
+		other.handleContractQuery(context.Background(), queried)
+		reply := nextMessageOfType(other, MessageTypeError)
+		if reply == nil || reply["message"] != "Contract not found: "+queried {
+			t.Errorf("Query of %s from another client answered %v, want a contract not found error", queried, reply)
+		}
+	}
+
+	// Sharing the contract brings it into the other client's namespace
+	shareContractWith(t, owner, contractID, other)
+	other.handleContractQuery(context.Background(), contractID)
+	if update := nextMessageOfType(other, MessageTypeContractUpdate); update == nil || update["contractID"] != contractID {
+		t.Fatalf("Query of a shared contract answered %v, want the contract state", update)
+	}
+}
+
+// dialTestClient connects to a client of h with config over a real WebSocket.
+// h must be running, as the client is registered with it.
+func dialTestClient(t *testing.T, h *Hub, config ClientConfig) (*websocket.Conn, *Client) {
+	upgrader := websocket.Upgrader{}
+	clients := make(chan *Client, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client, err := NewClient(h, conn, config)
+		if err != nil {
+			t.Errorf("NewClient: %v", err)
+			conn.Close()
+			return
+		}
+		go client.WritePump()
+		h.Register <- client
+		go client.ReadPump()
+		clients <- client
+	}))
+	t.Cleanup(ts.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, <-clients
+}
+
+func TestClientsOnlyAcceptFramesOfTheirEncoding(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	go h.Run()
+	request, _ := json.Marshal(map[string]interface{}{"type": MessageTypeValidateContract, "data": testLuckyLadder()})
+	binaryRequest := append([]byte{contentTypeJSON}, request...)
+
+	for _, tc := range []struct {
+		encoding        string
+		frameType       int
+		accepted        []byte
+		rejectedType    int
+		rejected        []byte
+		contentTypeByte bool
+	}{
+		{MessageEncodingText, websocket.TextMessage, request, websocket.BinaryMessage, binaryRequest, false},
+		{MessageEncodingBinary, websocket.BinaryMessage, binaryRequest, websocket.TextMessage, request, true},
+	} {
+		conn, _ := dialTestClient(t, h, ClientConfig{MessageEncoding: tc.encoding})
+		reply := func() map[string]interface{} {
+			frameType, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("%s client sent no reply: %v", tc.encoding, err)
+			}
+			if frameType != tc.frameType {
+				t.Fatalf("%s client replied with frame type %d, want %d", tc.encoding, frameType, tc.frameType)
+			}
+			if tc.contentTypeByte {
+				if len(data) == 0 || data[0] != contentTypeJSON {
+					t.Fatalf("%s client reply %q has no content type byte", tc.encoding, data)
+				}
+				data = data[1:]
+			}
+			var message map[string]interface{}
+			if err := json.Unmarshal(data, &message); err != nil {
+				t.Fatalf("%s client reply %q is not JSON: %v", tc.encoding, data, err)
+			}
+			return message
+		}
+
+		conn.WriteMessage(tc.rejectedType, tc.rejected)
+		if message := reply(); message["type"] != MessageTypeError || message["message"] != "Only "+tc.encoding+" frames are accepted" {
+			t.Errorf("%s client answered a frame of the other type with %v, want a rejection", tc.encoding, message)
+		}
+		conn.WriteMessage(tc.frameType, tc.accepted)
+		if message := reply(); message["type"] != MessageTypeValidationResult {
+			t.Errorf("%s client answered a %s frame with %v, want a validation result", tc.encoding, tc.encoding, message)
+		}
+	}
+}
+
+func TestClientBandwidthCountsExchangedBytes(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	go h.Run()
+	conn, client := dialTestClient(t, h, ClientConfig{})
+	request, _ := json.Marshal(map[string]interface{}{"type": MessageTypeValidateContract, "data": testLuckyLadder()})
+
+	var sent, received int64
+	for i := 0; i < 100; i++ {
+		conn.WriteMessage(websocket.TextMessage, request)
+		sent += int64(len(request))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("Client sent no reply to message %d: %v", i, err)
+			}
+			received += int64(len(data))
+			var message map[string]interface{}
+			json.Unmarshal(data, &message)
+			if message["type"] == MessageTypeValidationResult {
+				break
+			}
+		}
+	}
+
+	// Sent bytes are counted once each write returns
+	withinOnePercent := func(count, expected int64) bool {
+		return float64(count) >= 0.99*float64(expected) && float64(count) <= 1.01*float64(expected)
+	}
+	waitFor(t, "the bandwidth counters", func() bool {
+		bandwidth, err := h.GetClientBandwidth(client.ID)
+		return err == nil && withinOnePercent(bandwidth.BytesReceived, sent) && withinOnePercent(bandwidth.BytesSent, received)
+	})
+	if stats := h.ClientStatsReport()[client.ID]; !withinOnePercent(stats.BytesReceived, sent) || !withinOnePercent(stats.BytesSent, received) {
+		t.Errorf("Stats report %+v, want %d bytes received and %d bytes sent", stats, sent, received)
+	}
+	if _, err := h.GetClientBandwidth("client-unknown"); err != ErrClientNotFound {
+		t.Errorf("GetClientBandwidth of an unknown client returned %v, want ErrClientNotFound", err)
+	}
+}
+
+func TestStrikeAndRelativeTargetArePassedToTheContractsService(t *testing.T) {
+	data := ContractData{
+		ProductType:            "MomentumCatcher",
+		TargetMovement:         0.05,
+		TargetRelativeToStrike: true,
+		Strike:                 100,
+		Duration:               60000,
+		Payoff:                 10,
+	}
+	if err := ValidateContractData(&data); err != nil {
+		t.Fatalf("ValidateContractData: %v", err)
+	}
+	params := newContractParams(data).Parameters
+	// The contracts service resolves the target to 100 * 0.05 = 5 price units
+	if params["strike"] != 100.0 || params["target_movement"] != 0.05 || params["target_relative_to_strike"] != true {
+		t.Errorf("Parameters are %v, want strike 100 and the target as 0.05 of it", params)
+	}
+
+	data.Strike = -1
+	if err := ValidateContractData(&data); err == nil {
+		t.Error("ValidateContractData accepted a negative strike")
+	}
+}
+
+func TestNotionalScalesThePayoff(t *testing.T) {
+	data := ContractData{ProductType: "LuckyLadder", Payoff: 10, Notional: 50, PayoffPerUnit: 0.5}
+	single := data.EffectivePayoff()
+	data.Notional = 100
+	if doubled := data.EffectivePayoff(); single != 25 || doubled != 2*single {
+		t.Errorf("Notionals 50 and 100 pay %v and %v, want 25 and 50", single, doubled)
+	}
+	data.MaxPayoff = 80
+	if capped := data.EffectivePayoff(); capped != 40 {
+		t.Errorf("Notional 100 capped at 80 pays %v, want 40", capped)
+	}
+	params := newContractParams(data).Parameters
+	if params["notional"] != 100.0 || params["payoff_per_unit"] != 0.5 || params["max_payoff"] != 80.0 {
+		t.Errorf("Parameters are %v, want the notional, payoff per unit and cap", params)
+	}
+
+	// Contracts without a notional keep the flat payoff
+	flat := ContractData{ProductType: "LuckyLadder", Payoff: 10}
+	if payoff := flat.EffectivePayoff(); payoff != 10 {
+		t.Errorf("Contract without a notional pays %v, want the flat payoff 10", payoff)
+	}
+	if params := newContractParams(flat).Parameters; params["notional"] != nil {
+		t.Errorf("Parameters of a contract without a notional are %v, want no notional", params)
+	}
+}
+
+func TestContractAcceptedFollowsTheFirstContractUpdate(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	client := newTenantTestClient(h, "client-1", "")
+	raw, _ := json.Marshal(testLuckyLadder())
+	done := make(chan struct{})
+	go func() {
+		client.handleContractSubmission(context.Background(), raw)
+		close(done)
+	}()
+
+	// The submission waits for the first price of its contract
+	select {
+	case frame := <-client.Send:
+		t.Fatalf("Client received %s before the first price", frame)
+	case <-done:
+		t.Fatal("Submission returned before the first price")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	prices.tick(101.5)
+	<-done
+	var types []string
+	var updateContractID string
+	for len(client.Send) > 0 {
+		var message map[string]interface{}
+		json.Unmarshal(<-client.Send, &message)
+		msgType, _ := message["type"].(string)
+		types = append(types, msgType)
+		if msgType == MessageTypeContractUpdate && updateContractID == "" {
+			updateContractID, _ = message["contractID"].(string)
+		}
+		if msgType == MessageTypeContractAccepted {
+			if message["contractID"] != updateContractID || updateContractID == "" {
+				t.Fatalf("Client received %v, want ContractAccepted after the first ContractUpdate of its contract", types)
+			}
+			return
+		}
+	}
+	t.Fatalf("Client received %v, want ContractUpdate and then ContractAccepted", types)
+}
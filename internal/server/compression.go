@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"pricingserver/internal/common/logging"
+)
+
+// CompressionDeflate compresses large outgoing messages with DEFLATE
+// (RFC 1951). Clients request it with the X-Compression upgrade header.
+const CompressionDeflate = "deflate"
+
+// defaultCompressThresholdBytes is the encoded size above which broadcast
+// messages are compressed
+const defaultCompressThresholdBytes = 1024
+
+// Content encoding bytes that prefix every message sent to a client that
+// negotiated compression
+const (
+	contentEncodingIdentity byte = 0x00
+	contentEncodingDeflate  byte = 0x01
+)
+
+// ValidateCompression checks the compression requested at upgrade time.
+// Compressed messages need binary frames, so text encoding is rejected.
+func ValidateCompression(compression, encoding string) error {
+	switch compression {
+	case "":
+		return nil
+	case CompressionDeflate:
+		if encoding == MessageEncodingText {
+			return fmt.Errorf("deflate compression requires binary message encoding")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression: %s", compression)
+	}
+}
+
+// compressThresholdFromEnv reads COMPRESS_THRESHOLD_BYTES; 0 disables
+// compression
+func compressThresholdFromEnv() int {
+	threshold := defaultCompressThresholdBytes
+	if value := os.Getenv("COMPRESS_THRESHOLD_BYTES"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			threshold = parsed
+		} else {
+			logging.DebugLog("Invalid COMPRESS_THRESHOLD_BYTES %q, using %d", value, threshold)
+		}
+	}
+	return threshold
+}
+
+// compresses reports whether the client negotiated compression
+func (c *Client) compresses() bool {
+	return c.Config.Compression == CompressionDeflate
+}
+
+// frame prepares an encoded message for c.Send. Messages to clients that
+// negotiated compression are prefixed with the identity content encoding.
+func (c *Client) frame(data []byte) []byte {
+	if !c.compresses() {
+		return data
+	}
+	return append([]byte{contentEncodingIdentity}, data...)
+}
+
+// CompressionStats reports the effect of broadcast compression
+type CompressionStats struct {
+	MessagesCompressed      int64 `json:"messagesCompressed"`
+	BytesSavedByCompression int64 `json:"bytesSavedByCompression"`
+}
+
+// CompressionStats returns the compression counters of the hub
+func (h *Hub) CompressionStats() CompressionStats {
+	return CompressionStats{
+		MessagesCompressed:      atomic.LoadInt64(&h.MessagesCompressed),
+		BytesSavedByCompression: atomic.LoadInt64(&h.BytesSavedByCompression),
+	}
+}
+
+// broadcastMessage encodes a message sent to many clients once per
+// serialization format, and compresses each encoding at most once
+type broadcastMessage struct {
+	hub        *Hub
+	message    interface{}
+	encoded    map[Serializer][]byte
+	compressed map[Serializer][]byte
+}
+
+func newBroadcastMessage(hub *Hub, message interface{}) *broadcastMessage {
+	return &broadcastMessage{
+		hub:        hub,
+		message:    message,
+		encoded:    make(map[Serializer][]byte),
+		compressed: make(map[Serializer][]byte),
+	}
+}
+
+// encode returns the message encoded with serializer. Every client using
+// the same serializer shares the returned slice.
+func (b *broadcastMessage) encode(serializer Serializer) ([]byte, error) {
+	if data, ok := b.encoded[serializer]; ok {
+		return data, nil
+	}
+	data, err := serializer.Marshal(b.message)
+	if err != nil {
+		return nil, err
+	}
+	b.encoded[serializer] = data
+	return data, nil
+}
+
+// frameFor returns the bytes to queue for client, given the message encoded
+// with the client's serializer. Clients that negotiated compression receive
+// the compressed message when it exceeds the hub's threshold.
+func (b *broadcastMessage) frameFor(client *Client, data []byte) []byte {
+	if !client.compresses() {
+		return data
+	}
+	threshold := b.hub.CompressThresholdBytes
+	if threshold <= 0 || len(data) <= threshold {
+		return client.frame(data)
+	}
+
+	serializer := client.codec()
+	frame, ok := b.compressed[serializer]
+	if !ok {
+		compressed, err := deflate(data)
+		if err != nil || len(compressed) >= len(data) {
+			if err != nil {
+				logging.DebugLog("Failed to compress broadcast message: %v", err)
+			}
+			frame = client.frame(data)
+		} else {
+			frame = append([]byte{contentEncodingDeflate}, compressed...)
+			atomic.AddInt64(&b.hub.MessagesCompressed, 1)
+		}
+		b.compressed[serializer] = frame
+	}
+	if frame[0] == contentEncodingDeflate {
+		atomic.AddInt64(&b.hub.BytesSavedByCompression, int64(len(data)-len(frame)+1))
+	}
+	return frame
+}
+
+// deflate compresses data with DEFLATE
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"pricingserver/internal/common/logging"
+)
+
+// maxUserAgentLabelLength caps the user_agent label of
+// pricing_ws_connections_by_user_agent
+const maxUserAgentLabelLength = 32
+
+// ClientAddr returns the address a request came from: the first address of
+// its X-Forwarded-For header when it went through a proxy, or its RemoteAddr
+func ClientAddr(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if addr := strings.TrimSpace(first); addr != "" {
+			return addr
+		}
+	}
+	return r.RemoteAddr
+}
+
+// SetConnectionMetadata records the address and User-Agent of the WebSocket
+// handshake r on the client, adds them to the client's log lines and counts
+// the connection in pricing_ws_connections_by_user_agent
+func (c *Client) SetConnectionMetadata(r *http.Request) {
+	c.RemoteAddr = ClientAddr(r)
+	c.UserAgent = r.UserAgent()
+	c.Logger = logging.New(map[string]interface{}{
+		"clientID":   c.ID,
+		"remoteAddr": c.RemoteAddr,
+		"userAgent":  c.UserAgent,
+	})
+	wsConnectionsByUserAgent.WithLabelValues(sanitizeUserAgent(c.UserAgent)).Inc()
+}
+
+// sanitizeUserAgent reduces a User-Agent header to a low-cardinality metric
+// label: its lowercased first product name, e.g. "mozilla" for
+// "Mozilla/5.0 (X11; Linux x86_64)", keeping only letters, digits, '-', '_'
+// and '.', or "unknown"
+func sanitizeUserAgent(userAgent string) string {
+	product, _, _ := strings.Cut(strings.TrimSpace(userAgent), "/")
+	product, _, _ = strings.Cut(product, " ")
+	var label strings.Builder
+	for _, r := range strings.ToLower(product) {
+		if label.Len() >= maxUserAgentLabelLength {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			label.WriteRune(r)
+		}
+	}
+	if label.Len() == 0 {
+		return "unknown"
+	}
+	return label.String()
+}
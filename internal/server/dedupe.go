@@ -0,0 +1,57 @@
+package server
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// dedupeWindow is how long a sent message suppresses identical messages for
+// the same contract
+const dedupeWindow = 5 * time.Second
+
+// sentHash is the hash of the last message sent to a client for a contract
+type sentHash struct {
+	hash   uint64
+	sentAt time.Time
+}
+
+// dedupeExemptTypes are always delivered, even when repeated
+var dedupeExemptTypes = map[string]bool{
+	MessageTypeContractAccepted:   true,
+	MessageTypeContractSettlement: true,
+	MessageTypeError:              true,
+}
+
+// dedupeExempt reports whether message bypasses deduplication
+func dedupeExempt(message interface{}) bool {
+	msg, ok := message.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	msgType, _ := msg["type"].(string)
+	return dedupeExemptTypes[msgType]
+}
+
+// isDuplicate reports whether data repeats the last message sent to the
+// client for contractID within dedupeWindow, and records data otherwise.
+// Callers must hold the hub lock.
+func (c *Client) isDuplicate(contractID string, data []byte) bool {
+	h := fnv.New64a()
+	h.Write(data)
+	hash := h.Sum64()
+	now := time.Now()
+
+	if c.lastSentHashes == nil {
+		c.lastSentHashes = make(map[string]sentHash)
+	}
+	for id, sent := range c.lastSentHashes {
+		if now.Sub(sent.sentAt) > dedupeWindow {
+			delete(c.lastSentHashes, id)
+		}
+	}
+	if sent, ok := c.lastSentHashes[contractID]; ok && sent.hash == hash {
+		return true
+	}
+	c.lastSentHashes[contractID] = sentHash{hash: hash, sentAt: now}
+	return false
+}
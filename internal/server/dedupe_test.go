@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// newDedupeTestClient returns a hub with one connected client subscribed to
+// contract-1
+func newDedupeTestClient() (*Hub, *Client) {
+	h := NewHub()
+	client := &Client{ID: "client-1", Hub: h, Send: make(chan []byte, 10)}
+	h.Clients[client] = true
+	h.subscribeClient("contract-1", client)
+	return h, client
+}
+
+func contractUpdateMessage(price float64) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       MessageTypeContractUpdate,
+		"contractID": "contract-1",
+		"data":       map[string]interface{}{"status": "active", "price": price},
+	}
+}
+
+func TestContractBroadcastSkipsDuplicateState(t *testing.T) {
+	h, client := newDedupeTestClient()
+
+	h.ContractBroadcast("contract-1", contractUpdateMessage(100))
+	h.ContractBroadcast("contract-1", contractUpdateMessage(100))
+
+	if got := len(client.Send); got != 1 {
+		t.Fatalf("Client received %d messages for two identical states, want 1", got)
+	}
+}
+
+func TestContractBroadcastDeliversChangedState(t *testing.T) {
+	h, client := newDedupeTestClient()
+
+	h.ContractBroadcast("contract-1", contractUpdateMessage(100))
+	h.ContractBroadcast("contract-1", contractUpdateMessage(101))
+
+	if got := len(client.Send); got != 2 {
+		t.Fatalf("Client received %d messages for two different states, want 2", got)
+	}
+}
+
+func TestContractBroadcastDeliversRepeatedStateAfterWindow(t *testing.T) {
+	h, client := newDedupeTestClient()
+
+	h.ContractBroadcast("contract-1", contractUpdateMessage(100))
+	h.mu.Lock()
+	sent := client.lastSentHashes["contract-1"]
+	sent.sentAt = sent.sentAt.Add(-dedupeWindow - time.Second)
+	client.lastSentHashes["contract-1"] = sent
+	h.mu.Unlock()
+	h.ContractBroadcast("contract-1", contractUpdateMessage(100))
+
+	if got := len(client.Send); got != 2 {
+		t.Fatalf("Client received %d messages for a state repeated after the window, want 2", got)
+	}
+}
+
+func TestContractBroadcastDoesNotDeduplicateExemptTypes(t *testing.T) {
+	for _, msgType := range []string{MessageTypeContractAccepted, MessageTypeContractSettlement, MessageTypeError} {
+		h, client := newDedupeTestClient()
+		message := map[string]interface{}{"type": msgType, "contractID": "contract-1"}
+
+		h.ContractBroadcast("contract-1", message)
+		h.ContractBroadcast("contract-1", message)
+
+		if got := len(client.Send); got != 2 {
+			t.Errorf("Client received %d %s messages, want 2", got, msgType)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/simulation"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys shared by all hub instances
+const (
+	redisUpdatesChannel = "channel:pricing:updates"
+	redisClientsKey     = "pricing:clients"   // clientID -> instanceID
+	redisContractsKey   = "pricing:contracts" // contractID -> clientID
+)
+
+// DistributedHub wraps a local Hub and uses Redis pub/sub to share contract
+// messages with hubs running in other pricing server instances. When the hub
+// uses the simulation engine, the instances elect a leader that runs it and
+// publishes its ticks to the others.
+type DistributedHub struct {
+	*Hub
+	InstanceID string
+	redis      *redis.Client
+	prices     *clusterPriceEmitter
+}
+
+// relayedMessage is the payload published on the updates channel
+type relayedMessage struct {
+	Origin     string          `json:"origin"`
+	ContractID string          `json:"contractID"`
+	Message    json.RawMessage `json:"message"`
+}
+
+// NewDistributedHub connects to Redis at redisURL and attaches itself to hub
+func NewDistributedHub(hub *Hub, redisURL string) (*DistributedHub, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	d := &DistributedHub{
+		Hub:        hub,
+		InstanceID: GenerateUniqueID(),
+		redis:      client,
+	}
+	hub.relay = d
+
+	if engine, ok := hub.SimulationEngine.(*simulation.SimulationEngine); ok {
+		d.prices = newClusterPriceEmitter(engine, client)
+		hub.SimulationEngine = d.prices
+		hub.election = NewLeaderElection(client, d.InstanceID, d.prices.setLeader)
+	}
+	return d, nil
+}
+
+// Run subscribes to the updates and ticks channels, takes part in leader
+// election and starts the local hub
+func (d *DistributedHub) Run() {
+	channels := []string{redisUpdatesChannel}
+	if d.prices != nil {
+		channels = append(channels, redisTicksChannel)
+	}
+	pubsub := d.redis.Subscribe(context.Background(), channels...)
+	go func() {
+		defer pubsub.Close()
+		for msg := range pubsub.Channel() {
+			if msg.Channel == redisTicksChannel {
+				d.prices.handleTick(msg.Payload)
+				continue
+			}
+			d.handleRelayedMessage(msg.Payload)
+		}
+	}()
+	if d.election != nil {
+		go d.election.Run(context.Background())
+	}
+	d.Hub.Run()
+}
+
+func (d *DistributedHub) handleRelayedMessage(payload string) {
+	var relayed relayedMessage
+	if err := json.Unmarshal([]byte(payload), &relayed); err != nil {
+		logging.DebugLog("Failed to decode relayed message: %v", err)
+		return
+	}
+	// Messages from this instance were already delivered locally
+	if relayed.Origin == d.InstanceID {
+		return
+	}
+
+	var message map[string]interface{}
+	if err := json.Unmarshal(relayed.Message, &message); err != nil {
+		logging.DebugLog("Failed to decode relayed contract message: %v", err)
+		return
+	}
+	d.Hub.deliverContractMessage(relayed.ContractID, message)
+}
+
+// Publish implements ClusterRelay
+func (d *DistributedHub) Publish(contractID string, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(relayedMessage{
+		Origin:     d.InstanceID,
+		ContractID: contractID,
+		Message:    data,
+	})
+	if err != nil {
+		return err
+	}
+	return d.redis.Publish(context.Background(), redisUpdatesChannel, payload).Err()
+}
+
+// ClientRegistered implements ClusterRelay
+func (d *DistributedHub) ClientRegistered(client *Client) {
+	if err := d.redis.HSet(context.Background(), redisClientsKey, client.ID, d.InstanceID).Err(); err != nil {
+		logging.DebugLog("Failed to register client %s in Redis: %v", client.ID, err)
+	}
+}
+
+// ClientUnregistered implements ClusterRelay
+func (d *DistributedHub) ClientUnregistered(client *Client) {
+	ctx := context.Background()
+	if err := d.redis.HDel(ctx, redisClientsKey, client.ID).Err(); err != nil {
+		logging.DebugLog("Failed to unregister client %s in Redis: %v", client.ID, err)
+	}
+	for contractID := range client.contractsSnapshot() {
+		d.redis.HDel(ctx, redisContractsKey, contractID)
+	}
+}
+
+// ContractRegistered implements ClusterRelay
+func (d *DistributedHub) ContractRegistered(contractID string, client *Client) {
+	if err := d.redis.HSet(context.Background(), redisContractsKey, contractID, client.ID).Err(); err != nil {
+		logging.DebugLog("Failed to register contract %s in Redis: %v", contractID, err)
+	}
+}
+
+// LookupClientInstance returns the instance a client is connected to, or ""
+// if the client is not connected to any instance
+func (d *DistributedHub) LookupClientInstance(clientID string) (string, error) {
+	instanceID, err := d.redis.HGet(context.Background(), redisClientsKey, clientID).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return instanceID, err
+}
+
+// LookupContractOwner returns the ID of the client that owns a contract, or ""
+// if the contract is unknown
+func (d *DistributedHub) LookupContractOwner(contractID string) (string, error) {
+	clientID, err := d.redis.HGet(context.Background(), redisContractsKey, contractID).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return clientID, err
+}
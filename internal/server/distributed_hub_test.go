@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pricingserver/internal/simulation"
+)
+
+// attachTestRedis attaches h to the Redis instance of PRICING_TEST_REDIS_URL.
+// Tests using it are skipped when PRICING_TEST_REDIS_URL is unset.
+func attachTestRedis(t *testing.T, h *Hub) *DistributedHub {
+	redisURL := os.Getenv("PRICING_TEST_REDIS_URL")
+	if redisURL == "" {
+		t.Skip("PRICING_TEST_REDIS_URL is not set")
+	}
+	d, err := NewDistributedHub(h, redisURL)
+	if err != nil {
+		t.Fatalf("Failed to connect to test Redis: %v", err)
+	}
+	t.Cleanup(func() { d.redis.Close() })
+	return d
+}
+
+// newTestDistributedHub attaches a hub backed by a mock contracts service to
+// the test Redis instance and runs it
+func newTestDistributedHub(t *testing.T) *DistributedHub {
+	h, _, _ := newServiceTestHub(t)
+	d := attachTestRedis(t, h)
+	go d.Run()
+	return d
+}
+
+func TestDistributedHubRelaysContractMessagesToOtherInstances(t *testing.T) {
+	instanceA := newTestDistributedHub(t)
+	instanceB := newTestDistributedHub(t)
+	client := newTenantTestClient(instanceB.Hub, "client-b", "")
+	instanceB.subscribeClient("contract-1", client)
+
+	// Subscriptions to the updates channel are set up in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		instanceA.ContractBroadcast("contract-1", map[string]interface{}{
+			"type":       MessageTypeContractUpdate,
+			"contractID": "contract-1",
+			"data":       map[string]interface{}{"status": "active", "sent": time.Now().UnixNano()},
+		})
+		if update := nextMessageOfType(client, MessageTypeContractUpdate); update != nil {
+			if update["contractID"] != "contract-1" {
+				t.Fatalf("Client on instance B received %v, want the update of contract-1", update)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Client on instance B received no update published by instance A")
+		}
+	}
+}
+
+func TestLeaderElectionSharesTheLeadersTicks(t *testing.T) {
+	instances := make([]*DistributedHub, 2)
+	counters := make([]*tickCounter, 2)
+	for i := range instances {
+		h, _, _ := newServiceTestHub(t)
+		engine := simulation.NewSimulationEngine()
+		engine.TickInterval = 20 * time.Millisecond
+		h.SimulationEngine = engine
+		instances[i] = attachTestRedis(t, h)
+		counters[i] = &tickCounter{}
+		instances[i].prices.Subscribe("contract-1", counters[i])
+		t.Cleanup(instances[i].prices.Stop)
+	}
+	// A lease left by an earlier run would keep both instances followers
+	instances[0].redis.Del(context.Background(), redisLeaderKey)
+	for _, instance := range instances {
+		go instance.Run()
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		leaders := 0
+		for _, instance := range instances {
+			if instance.IsLeader() {
+				leaders++
+			}
+		}
+		if leaders > 1 {
+			t.Fatal("Both instances are leader")
+		}
+		if leaders == 1 && atomic.LoadInt64(&counters[0].ticks) > 0 && atomic.LoadInt64(&counters[1].ticks) > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d instances are leader and the instances received %d and %d ticks, want one leader and ticks on both",
+				leaders, atomic.LoadInt64(&counters[0].ticks), atomic.LoadInt64(&counters[1].ticks))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// ShutdownSettlementReason is the reason of the ContractSettlement messages
+// sent for running contracts when the server shuts down
+const ShutdownSettlementReason = "server_shutdown"
+
+// ShutdownCloseReason is the close reason sent to clients when the server
+// shuts down
+const ShutdownCloseReason = "Server shutting down"
+
+// ErrServerDraining is returned for contracts submitted once Drain started
+var ErrServerDraining = errors.New("server is shutting down")
+
+// Drain disconnects every client for a graceful shutdown. It stops accepting
+// connections and contracts, sends each client a ContractSettlement with
+// reason server_shutdown for each of its contracts, waiting for room in its
+// Send buffer, and closes the client's Send channel so its WritePump delivers
+// the queued messages before a going away close frame. Messages the client's
+// handlers send afterwards are dropped. Drain waits for every WritePump to
+// return, or until ctx is done, when it closes the remaining connections and
+// returns ctx.Err(). The contracts keep running so their state can be saved.
+func (h *Hub) Drain(ctx context.Context) error {
+	h.draining.Store(true)
+	h.mu.Lock()
+	clients := make([]*Client, 0, len(h.Clients))
+	for client := range h.Clients {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+	logging.DebugLog("Draining %d clients", len(clients))
+
+	for _, client := range clients {
+		for contractID := range client.contractsSnapshot() {
+			// Settlements wait for room in a full Send buffer, or the client
+			// would not learn that its contract stopped
+			client.sendMessageUntil(map[string]interface{}{
+				"type":       MessageTypeContractSettlement,
+				"contractID": contractID,
+				"data": map[string]interface{}{
+					"contractID": contractID,
+					"reason":     ShutdownSettlementReason,
+					"timestamp":  time.Now().Format(time.RFC3339),
+				},
+			}, ctx.Done())
+		}
+
+		// Messages are only sent to registered clients, so the channel can
+		// be closed once the client is removed
+		h.mu.Lock()
+		if h.Clients[client] {
+			delete(h.Clients, client)
+			client.closeSend()
+		}
+		h.mu.Unlock()
+	}
+
+	for _, client := range clients {
+		if client.writeDone == nil {
+			continue
+		}
+		select {
+		case <-client.writeDone:
+		case <-ctx.Done():
+			logging.DebugLog("Drain deadline exceeded, closing the remaining connections")
+			for _, remaining := range clients {
+				remaining.Conn.Close()
+			}
+			return ctx.Err()
+		}
+	}
+	logging.DebugLog("Drained %d clients", len(clients))
+	return nil
+}
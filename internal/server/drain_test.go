@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newDrainTestClient connects a WebSocket client to a test server and
+// registers the server side of the connection with h as a client owning
+// contractIDs. WritePump is not started.
+func newDrainTestClient(t *testing.T, h *Hub, contractIDs ...string) (*Client, *websocket.Conn) {
+	t.Helper()
+	clients := make(chan *Client, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		client, err := NewClient(h, conn, ClientConfig{})
+		if err != nil {
+			t.Errorf("NewClient: %v", err)
+			return
+		}
+		clients <- client
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := <-clients
+	for _, contractID := range contractIDs {
+		client.Contracts[contractID] = "LuckyLadder"
+	}
+	h.mu.Lock()
+	h.Clients[client] = true
+	h.mu.Unlock()
+	return client, conn
+}
+
+// readUntilClose returns the messages read from conn before its close frame,
+// and the close frame
+func readUntilClose(t *testing.T, conn *websocket.Conn) ([]map[string]interface{}, *websocket.CloseError) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var messages []map[string]interface{}
+	for {
+		_, data, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return messages, closeErr
+		}
+		if err != nil {
+			t.Fatalf("Connection failed before its close frame: %v", err)
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("Invalid message %q: %v", data, err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+// countMessages returns the number of messages of type msgType
+func countMessages(messages []map[string]interface{}, msgType string) int {
+	n := 0
+	for _, message := range messages {
+		if message["type"] == msgType {
+			n++
+		}
+	}
+	return n
+}
+
+func drainContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestDrainDeliversQueuedMessagesBeforeClose(t *testing.T) {
+	h := NewHub()
+	type connected struct {
+		client *Client
+		conn   *websocket.Conn
+	}
+	var clients []connected
+	for i := 0; i < 3; i++ {
+		client, conn := newDrainTestClient(t, h, fmt.Sprintf("contract-%d", i))
+		go client.WritePump()
+		for j := 0; j < 50; j++ {
+			client.sendMessage(map[string]interface{}{"type": MessageTypeContractUpdate, "seq": j})
+		}
+		clients = append(clients, connected{client, conn})
+	}
+
+	if err := h.Drain(drainContext(t)); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	for i, c := range clients {
+		messages, closeErr := readUntilClose(t, c.conn)
+		if got := countMessages(messages, MessageTypeContractUpdate); got < 48 {
+			t.Errorf("Client %d received %d of 50 queued messages before the close frame, want at least 48", i, got)
+		}
+		if got := countMessages(messages, MessageTypeContractSettlement); got != 1 {
+			t.Errorf("Client %d received %d settlements, want 1", i, got)
+		}
+		if closeErr.Code != websocket.CloseGoingAway || closeErr.Text != ShutdownCloseReason {
+			t.Errorf("Client %d was closed with %d %q, want %d %q", i, closeErr.Code, closeErr.Text, websocket.CloseGoingAway, ShutdownCloseReason)
+		}
+	}
+}
+
+func TestDrainDeliversSettlementsWhenSendBufferIsFull(t *testing.T) {
+	h := NewHub()
+	contractIDs := []string{"contract-1", "contract-2", "contract-3"}
+	client, conn := newDrainTestClient(t, h, contractIDs...)
+	for len(client.Send) < cap(client.Send) {
+		client.sendMessage(map[string]interface{}{"type": MessageTypeContractUpdate})
+	}
+	go client.WritePump()
+
+	if err := h.Drain(drainContext(t)); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	messages, _ := readUntilClose(t, conn)
+	if got := countMessages(messages, MessageTypeContractSettlement); got != len(contractIDs) {
+		t.Errorf("Client received %d settlements after a full Send buffer, want %d", got, len(contractIDs))
+	}
+}
+
+func TestSendAfterDrainDropsMessages(t *testing.T) {
+	h := NewHub()
+	client, _ := newDrainTestClient(t, h)
+	// A handler is waiting for room in the full Send buffer when the
+	// server shuts down, and WritePump never makes room
+	for len(client.Send) < cap(client.Send) {
+		client.sendMessage(map[string]interface{}{"type": MessageTypeContractUpdate})
+	}
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		client.sendMessage(map[string]interface{}{"type": MessageTypeContractAccepted})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := h.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain returned %v without a running WritePump, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("Sender waiting for a full Send buffer was not released by Drain")
+	}
+
+	// Handlers still running after Drain must not panic
+	client.sendError(ErrorTypeValidation, ErrServerDraining.Error())
+}
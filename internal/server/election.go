@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/simulation"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys used for leader election and tick distribution
+const (
+	redisLeaderKey    = "pricing:leader" // instanceID of the current leader
+	redisTicksChannel = "channel:pricing:ticks"
+)
+
+// leaderTTL is how long a leader keeps its lease without renewing it
+const leaderTTL = 10 * time.Second
+
+// renewLeaderScript extends the lease only if it is still held by ARGV[1]
+var renewLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// resignLeaderScript releases the lease only if it is still held by ARGV[1]
+var resignLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// LeaderElection elects a single hub instance as leader using a Redis key
+// acquired with SET NX and renewed in the background while it is held
+type LeaderElection struct {
+	redis      *redis.Client
+	instanceID string
+	onChange   func(leader bool)
+	leader     bool
+	mu         sync.Mutex
+}
+
+// NewLeaderElection creates an election for instanceID. onChange is called
+// whenever this instance gains or loses leadership.
+func NewLeaderElection(client *redis.Client, instanceID string, onChange func(leader bool)) *LeaderElection {
+	return &LeaderElection{redis: client, instanceID: instanceID, onChange: onChange}
+}
+
+// Run campaigns for leadership and renews the lease until ctx is done, then
+// resigns if this instance is the leader
+func (e *LeaderElection) Run(ctx context.Context) {
+	ticker := time.NewTicker(leaderTTL / 3)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			e.resign()
+			return
+		}
+	}
+}
+
+// IsLeader reports whether this instance currently holds the lease
+func (e *LeaderElection) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+func (e *LeaderElection) campaign(ctx context.Context) {
+	var held bool
+	if e.IsLeader() {
+		renewed, err := renewLeaderScript.Run(ctx, e.redis, []string{redisLeaderKey}, e.instanceID, leaderTTL.Milliseconds()).Int()
+		if err != nil {
+			logging.DebugLog("Failed to renew leader lease: %v", err)
+		}
+		held = err == nil && renewed == 1
+	} else {
+		acquired, err := e.redis.SetNX(ctx, redisLeaderKey, e.instanceID, leaderTTL).Result()
+		if err != nil {
+			logging.DebugLog("Failed to campaign for leadership: %v", err)
+		}
+		held = err == nil && acquired
+	}
+	e.setLeader(held)
+}
+
+func (e *LeaderElection) resign() {
+	if !e.IsLeader() {
+		return
+	}
+	if err := resignLeaderScript.Run(context.Background(), e.redis, []string{redisLeaderKey}, e.instanceID).Err(); err != nil {
+		logging.DebugLog("Failed to resign leadership: %v", err)
+	}
+	e.setLeader(false)
+}
+
+func (e *LeaderElection) setLeader(leader bool) {
+	e.mu.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	e.mu.Unlock()
+	if !changed {
+		return
+	}
+	if leader {
+		logging.DebugLog("Instance %s elected leader", e.instanceID)
+	} else {
+		logging.DebugLog("Instance %s is no longer leader", e.instanceID)
+	}
+	if e.onChange != nil {
+		e.onChange(leader)
+	}
+}
+
+// clusterTick is the payload published on the ticks channel
+type clusterTick struct {
+	Mid       float64 `json:"mid"`
+	Bid       float64 `json:"bid"`
+	Ask       float64 `json:"ask"`
+	Timestamp int64   `json:"timestamp"` // milliseconds
+}
+
+// clusterPriceEmitter shares the prices generated by the leader's simulation
+// engine with every hub instance. The engine only runs on the leader, which
+// publishes each tick to Redis; every instance, the leader included, delivers
+// the ticks it receives to its local subscribers.
+type clusterPriceEmitter struct {
+	engine      *simulation.SimulationEngine
+	redis       *redis.Client
+	running     bool
+	subscribers map[string]simulation.PriceHandler
+	lastQuote   *simulation.PriceQuote
+	mu          sync.Mutex
+}
+
+// clusterPublisherID is the engine subscription used to publish ticks
+const clusterPublisherID = "cluster-publisher"
+
+func newClusterPriceEmitter(engine *simulation.SimulationEngine, client *redis.Client) *clusterPriceEmitter {
+	return &clusterPriceEmitter{
+		engine:      engine,
+		redis:       client,
+		subscribers: make(map[string]simulation.PriceHandler),
+	}
+}
+
+// Start implements simulation.PriceEmitter. The engine is started when this
+// instance is elected leader.
+func (c *clusterPriceEmitter) Start() {}
+
+// Stop implements simulation.PriceEmitter
+func (c *clusterPriceEmitter) Stop() {
+	c.setLeader(false)
+}
+
+// Subscribe implements simulation.PriceEmitter
+func (c *clusterPriceEmitter) Subscribe(contractID string, handler simulation.PriceHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribers[contractID] = handler
+
+	// Send the last known price immediately, if there is one
+	if c.lastQuote != nil {
+		go deliverQuote(handler, *c.lastQuote, time.Now())
+	}
+}
+
+// Unsubscribe implements simulation.PriceEmitter
+func (c *clusterPriceEmitter) Unsubscribe(contractID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subscribers, contractID)
+}
+
+// Snapshot returns a copy of the local subscribers keyed by contract ID
+func (c *clusterPriceEmitter) Snapshot() map[string]simulation.PriceHandler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]simulation.PriceHandler, len(c.subscribers))
+	for contractID, handler := range c.subscribers {
+		snapshot[contractID] = handler
+	}
+	return snapshot
+}
+
+// setLeader starts the engine when this instance becomes leader and stops it
+// when it loses leadership. A new leader continues from the last price the
+// previous leader published.
+func (c *clusterPriceEmitter) setLeader(leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if leader == c.running {
+		return
+	}
+	c.running = leader
+	if leader {
+		if c.lastQuote != nil {
+			c.engine.BasePrice = c.lastQuote.Mid
+		}
+		c.engine.Subscribe(clusterPublisherID, c)
+		c.engine.Start()
+	} else {
+		c.engine.Unsubscribe(clusterPublisherID)
+		c.engine.Stop()
+	}
+}
+
+// HandlePriceUpdate receives engine prices on the leader
+func (c *clusterPriceEmitter) HandlePriceUpdate(price float64, timestamp time.Time) {
+	c.HandlePriceQuote(simulation.PriceQuote{Mid: price, Bid: price, Ask: price}, timestamp)
+}
+
+// HandlePriceQuote publishes an engine quote to every instance
+func (c *clusterPriceEmitter) HandlePriceQuote(quote simulation.PriceQuote, timestamp time.Time) {
+	payload, err := json.Marshal(clusterTick{
+		Mid:       quote.Mid,
+		Bid:       quote.Bid,
+		Ask:       quote.Ask,
+		Timestamp: timestamp.UnixMilli(),
+	})
+	if err != nil {
+		return
+	}
+	if err := c.redis.Publish(context.Background(), redisTicksChannel, payload).Err(); err != nil {
+		logging.DebugLog("Failed to publish tick: %v", err)
+	}
+}
+
+// handleTick delivers a tick received from Redis to the local subscribers
+func (c *clusterPriceEmitter) handleTick(payload string) {
+	var tick clusterTick
+	if err := json.Unmarshal([]byte(payload), &tick); err != nil {
+		logging.DebugLog("Failed to decode tick: %v", err)
+		return
+	}
+	quote := simulation.PriceQuote{Mid: tick.Mid, Bid: tick.Bid, Ask: tick.Ask}
+	timestamp := time.UnixMilli(tick.Timestamp)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastQuote = &quote
+	for _, handler := range c.subscribers {
+		go deliverQuote(handler, quote, timestamp)
+	}
+}
+
+// deliverQuote sends the full quote to handlers that accept one and the mid
+// price to the others
+func deliverQuote(handler simulation.PriceHandler, quote simulation.PriceQuote, timestamp time.Time) {
+	if v2, ok := handler.(simulation.PriceHandlerV2); ok {
+		v2.HandlePriceQuote(quote, timestamp)
+		return
+	}
+	handler.HandlePriceUpdate(quote.Mid, timestamp)
+}
+
+// IsLeader reports whether this hub runs the simulation engine for the
+// cluster. A hub without leader election is always the leader.
+func (h *Hub) IsLeader() bool {
+	if h.election == nil {
+		return true
+	}
+	return h.election.IsLeader()
+}
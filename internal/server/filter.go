@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"pricingserver/internal/common/logging"
+)
+
+// Filter operators
+const (
+	FilterOperatorGt       = "gt"
+	FilterOperatorLt       = "lt"
+	FilterOperatorEq       = "eq"
+	FilterOperatorNe       = "ne"
+	FilterOperatorContains = "contains"
+)
+
+// maxFiltersPerContract caps the filters a client sets on one contract
+const maxFiltersPerContract = 10
+
+// FilterPredicate is a condition on a field of the state carried by a
+// ContractUpdate, e.g. {"field": "unrealised_pnl", "operator": "gt",
+// "value": 50}
+type FilterPredicate struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+}
+
+// SetFilterData is the data of a SetFilter message. A null filter removes
+// every filter of the contract.
+type SetFilterData struct {
+	ContractID string           `json:"contractID"`
+	Filter     *FilterPredicate `json:"filter"`
+}
+
+// validateFilter checks a filter of a SetFilter message
+func validateFilter(predicate FilterPredicate) error {
+	if predicate.Field == "" {
+		return fmt.Errorf("filter field is required")
+	}
+	switch predicate.Operator {
+	case FilterOperatorGt, FilterOperatorLt:
+		if _, ok := filterNumber(predicate.Value); !ok {
+			return fmt.Errorf("filter operator %s requires a number", predicate.Operator)
+		}
+	case FilterOperatorEq, FilterOperatorNe, FilterOperatorContains:
+	default:
+		return fmt.Errorf("unsupported filter operator: %s", predicate.Operator)
+	}
+	return nil
+}
+
+// matches reports whether state satisfies the predicate. A missing field
+// satisfies none.
+func (predicate FilterPredicate) matches(state map[string]interface{}) bool {
+	value, ok := state[predicate.Field]
+	if !ok {
+		return false
+	}
+	switch predicate.Operator {
+	case FilterOperatorGt, FilterOperatorLt:
+		number, ok := filterNumber(value)
+		threshold, _ := filterNumber(predicate.Value)
+		if !ok {
+			return false
+		}
+		if predicate.Operator == FilterOperatorGt {
+			return number > threshold
+		}
+		return number < threshold
+	case FilterOperatorEq:
+		return filterValuesEqual(value, predicate.Value)
+	case FilterOperatorNe:
+		return !filterValuesEqual(value, predicate.Value)
+	case FilterOperatorContains:
+		// Strings contain substrings and lists contain elements
+		switch value := value.(type) {
+		case string:
+			substring, ok := predicate.Value.(string)
+			return ok && strings.Contains(value, substring)
+		case []interface{}:
+			for _, element := range value {
+				if filterValuesEqual(element, predicate.Value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// filterNumber returns value as a float64 if it is a number
+func filterNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// filterValuesEqual compares a state value with a filter value, comparing
+// numbers by value whatever their type
+func filterValuesEqual(a, b interface{}) bool {
+	x, aNumber := filterNumber(a)
+	y, bNumber := filterNumber(b)
+	if aNumber && bNumber {
+		return x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// passesFilters reports whether a contract message is delivered to the
+// client: ContractUpdate messages must satisfy every filter the client set
+// on the contract, other messages always are. Callers must hold the hub lock.
+func (c *Client) passesFilters(contractID string, message interface{}) bool {
+	predicates := c.SubscriptionFilters[contractID]
+	if len(predicates) == 0 {
+		return true
+	}
+	msg, ok := message.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	if msgType, _ := msg["type"].(string); msgType != MessageTypeContractUpdate {
+		return true
+	}
+	state, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, predicate := range predicates {
+		if !predicate.matches(state) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleSetFilter adds a filter on the ContractUpdate messages of a contract
+// the client owns or follows, or removes its filters, and replies with a
+// FilterSet message listing the contract's filters
+func (c *Client) handleSetFilter(ctx context.Context, data json.RawMessage) {
+	var request SetFilterData
+	if err := json.Unmarshal(data, &request); err != nil || request.ContractID == "" {
+		logging.DebugLogContext(ctx, "Invalid set filter data: %s", string(data))
+		c.sendError(ErrorTypeValidation, "contractID is required")
+		return
+	}
+	if request.Filter != nil {
+		if err := validateFilter(*request.Filter); err != nil {
+			c.sendError(ErrorTypeValidation, err.Error())
+			return
+		}
+	}
+
+	contractID := request.ContractID
+	c.mu.Lock()
+	_, ok := c.Contracts[contractID]
+	c.mu.Unlock()
+	if !ok || !c.Hub.contractVisibleTo(c.TenantID, contractID) {
+		logging.DebugLogContext(ctx, "Contract %s is not in the namespace of client %s", contractID, c.ID)
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Contract not found: %s", contractID))
+		return
+	}
+
+	c.Hub.mu.Lock()
+	predicates := c.SubscriptionFilters[contractID]
+	if request.Filter == nil {
+		delete(c.SubscriptionFilters, contractID)
+		predicates = nil
+	} else if len(predicates) >= maxFiltersPerContract {
+		c.Hub.mu.Unlock()
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("At most %d filters can be set on a contract", maxFiltersPerContract))
+		return
+	} else {
+		if c.SubscriptionFilters == nil {
+			c.SubscriptionFilters = make(map[string][]FilterPredicate)
+		}
+		predicates = append(predicates, *request.Filter)
+		c.SubscriptionFilters[contractID] = predicates
+	}
+	filters := append([]FilterPredicate{}, predicates...)
+	c.Hub.mu.Unlock()
+
+	logging.DebugLogContext(ctx, "Contract %s has %d filters for client %s", contractID, len(filters), c.ID)
+	c.sendMessage(map[string]interface{}{
+		"type":       MessageTypeFilterSet,
+		"contractID": contractID,
+		"data":       map[string]interface{}{"filters": filters},
+	})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestSetFilterDeliversOnlyMatchingUpdates(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	client := newTenantTestClient(h, "client", "")
+	accepted := submitThroughClient(t, client, prices, testLuckyLadder())
+	contractID, _ := accepted["contractID"].(string)
+	if accepted["type"] != MessageTypeContractAccepted || contractID == "" {
+		t.Fatalf("Submission answered %v, want ContractAccepted", accepted)
+	}
+
+	raw, _ := json.Marshal(SetFilterData{
+		ContractID: contractID,
+		Filter:     &FilterPredicate{Field: "price", Operator: FilterOperatorGt, Value: 100.0},
+	})
+	client.handleSetFilter(context.Background(), raw)
+	if set := nextMessageOfType(client, MessageTypeFilterSet); set == nil {
+		t.Fatal("SetFilter was not answered with FilterSet")
+	}
+
+	// Ticks below the threshold are filtered out, ticks above it delivered
+	for _, price := range []float64{99, 99.5, 100.5, 98, 100.8} {
+		prices.tick(price)
+	}
+	var delivered []float64
+	for len(delivered) == 0 || delivered[len(delivered)-1] != 100.8 {
+		update := nextMessageOfType(client, MessageTypeContractUpdate)
+		if update == nil {
+			t.Fatalf("Received updates at %v, want the update at 100.8", delivered)
+		}
+		state, _ := update["data"].(map[string]interface{})
+		price, _ := state["price"].(float64)
+		delivered = append(delivered, price)
+	}
+	if len(delivered) != 2 || delivered[0] != 100.5 {
+		t.Fatalf("Received updates at %v, want only those at 100.5 and 100.8", delivered)
+	}
+}
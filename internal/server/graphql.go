@@ -0,0 +1,380 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"pricingserver/internal/common/logging"
+
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+const graphQLSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+	subscription: Subscription
+}
+
+type Query {
+	contract(id: ID!): ContractState
+}
+
+type Mutation {
+	submitContract(input: ContractInput!): ContractAccepted!
+}
+
+type Subscription {
+	contractUpdates(id: ID!): ContractUpdate!
+}
+
+input ContractInput {
+	productType: String!
+	rungs: [Float!]
+	targetMovement: Float
+	duration: Int!
+	payoff: Float!
+}
+
+type ContractAccepted {
+	contractID: ID!
+}
+
+type ContractState {
+	contractID: ID!
+	status: String!
+	price: Float
+	elapsedMs: Int
+	duration: Int
+	productType: String
+	# Full state as returned by the contracts service, JSON encoded
+	data: String!
+}
+
+type ContractUpdate {
+	contractID: ID!
+	status: String!
+	price: Float
+	timestamp: String
+	# Full state as returned by the contracts service, JSON encoded
+	data: String!
+}
+`
+
+// graphQLSubprotocol is the WebSocket subprotocol used for subscriptions
+const graphQLSubprotocol = "graphql-transport-ws"
+
+// NewGraphQLSchema builds the GraphQL schema backed by hub
+func NewGraphQLSchema(hub *Hub) (*graphql.Schema, error) {
+	return graphql.ParseSchema(graphQLSchema, &graphQLResolver{hub: hub}, graphql.UseFieldResolvers())
+}
+
+// NewGraphQLHandler serves queries and mutations on POST /graphql. Requests
+// are authenticated like WebSocket connections and act for their tenant.
+func NewGraphQLHandler(schema *graphql.Schema) http.Handler {
+	handler := &relay.Handler{Schema: schema}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID, err := AuthenticateTenant(r)
+		if err != nil {
+			logging.DebugLog("Rejecting GraphQL request: %v", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenantID)))
+	})
+}
+
+// tenantKey is the context key of the tenant a GraphQL operation acts for
+type tenantKey struct{}
+
+func withTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// tenantFromContext returns the tenant set by withTenant, or the default
+// tenant
+func tenantFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantKey{}).(string)
+	return tenantID
+}
+
+type graphQLResolver struct {
+	hub *Hub
+}
+
+func (r *graphQLResolver) Contract(ctx context.Context, args struct{ ID graphql.ID }) (*contractStateResolver, error) {
+	state, err := r.hub.GetContractState(tenantFromContext(ctx), string(args.ID))
+	if err == ErrContractNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &contractStateResolver{id: string(args.ID), state: state}, nil
+}
+
+type contractInput struct {
+	ProductType    string
+	Rungs          *[]float64
+	TargetMovement *float64
+	Duration       int32
+	Payoff         float64
+}
+
+func (r *graphQLResolver) SubmitContract(ctx context.Context, args struct{ Input contractInput }) (*contractAcceptedResolver, error) {
+	data := ContractData{
+		ProductType: args.Input.ProductType,
+		Duration:    int64(args.Input.Duration),
+		Payoff:      args.Input.Payoff,
+	}
+	if args.Input.Rungs != nil {
+		data.Rungs = *args.Input.Rungs
+	}
+	if args.Input.TargetMovement != nil {
+		data.TargetMovement = *args.Input.TargetMovement
+	}
+
+	contractID, err := r.hub.SubmitContract(tenantFromContext(ctx), data)
+	if err != nil {
+		return nil, err
+	}
+	return &contractAcceptedResolver{ContractID: graphql.ID(contractID)}, nil
+}
+
+func (r *graphQLResolver) ContractUpdates(ctx context.Context, args struct{ ID graphql.ID }) (<-chan *contractStateResolver, error) {
+	contractID := string(args.ID)
+	if !r.hub.contractVisibleTo(tenantFromContext(ctx), contractID) {
+		return nil, ErrContractNotFound
+	}
+	updates := make(chan *contractStateResolver, 16)
+	// Terminal states are never dropped, so the subscription always ends
+	final := make(chan *contractStateResolver, 1)
+
+	removeListener := r.hub.AddContractListener(contractID, func(state map[string]interface{}) {
+		update := &contractStateResolver{id: contractID, state: state}
+		if isTerminalState(state) {
+			select {
+			case final <- update:
+			default:
+			}
+			return
+		}
+		select {
+		case updates <- update:
+		default:
+			logging.DebugLog("GraphQL subscription for contract %s is falling behind, dropping update", contractID)
+		}
+	})
+
+	out := make(chan *contractStateResolver)
+	go func() {
+		defer close(out)
+		defer removeListener()
+		for {
+			select {
+			case update := <-updates:
+				select {
+				case out <- update:
+				case <-ctx.Done():
+					return
+				}
+			case update := <-final:
+				select {
+				case out <- update:
+				case <-ctx.Done():
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+type contractAcceptedResolver struct {
+	ContractID graphql.ID
+}
+
+// contractStateResolver resolves both ContractState and ContractUpdate
+type contractStateResolver struct {
+	id    string
+	state map[string]interface{}
+}
+
+func (r *contractStateResolver) ContractID() graphql.ID {
+	if id, ok := r.state["contractID"].(string); ok && id != "" {
+		return graphql.ID(id)
+	}
+	return graphql.ID(r.id)
+}
+
+func (r *contractStateResolver) Status() string {
+	status, _ := r.state["status"].(string)
+	return status
+}
+
+func (r *contractStateResolver) Price() *float64 {
+	if price, ok := r.state["price"].(float64); ok {
+		return &price
+	}
+	return nil
+}
+
+func (r *contractStateResolver) ElapsedMs() *int32 {
+	return optionalInt32(r.state["elapsed_ms"])
+}
+
+func (r *contractStateResolver) Duration() *int32 {
+	return optionalInt32(r.state["duration"])
+}
+
+func (r *contractStateResolver) ProductType() *string {
+	if productType, ok := r.state["product_type"].(string); ok {
+		return &productType
+	}
+	return nil
+}
+
+func (r *contractStateResolver) Timestamp() *string {
+	if timestamp, ok := r.state["timestamp"].(string); ok {
+		return &timestamp
+	}
+	return nil
+}
+
+func (r *contractStateResolver) Data() (string, error) {
+	data, err := json.Marshal(r.state)
+	return string(data), err
+}
+
+func optionalInt32(v interface{}) *int32 {
+	if n, ok := v.(float64); ok {
+		i := int32(n)
+		return &i
+	}
+	return nil
+}
+
+// graphQLWSMessage is a graphql-transport-ws protocol message
+type graphQLWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+var graphQLUpgrader = websocket.Upgrader{
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{graphQLSubprotocol},
+}
+
+// NewGraphQLSubscriptionHandler serves subscriptions over WebSocket on
+// GET /graphql/subscriptions using the graphql-transport-ws protocol.
+// Connections are authenticated like those of NewGraphQLHandler.
+func NewGraphQLSubscriptionHandler(schema *graphql.Schema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID, err := AuthenticateTenant(r)
+		if err != nil {
+			logging.DebugLog("Rejecting GraphQL subscription connection: %v", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := graphQLUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logging.DebugLog("GraphQL upgrade error: %v", err)
+			return
+		}
+		serveGraphQLSubscriptions(schema, conn, tenantID)
+	})
+}
+
+func serveGraphQLSubscriptions(schema *graphql.Schema, conn *websocket.Conn, tenantID string) {
+	ctx, cancel := context.WithCancel(withTenant(context.Background(), tenantID))
+	var writeMu sync.Mutex
+	operations := make(map[string]context.CancelFunc)
+	var opsMu sync.Mutex
+
+	defer func() {
+		cancel()
+		conn.Close()
+	}()
+
+	send := func(msg graphQLWSMessage) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.WriteJSON(msg); err != nil {
+			logging.DebugLog("GraphQL write error: %v", err)
+		}
+	}
+
+	for {
+		var msg graphQLWSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			logging.DebugLog("GraphQL read error: %v", err)
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			send(graphQLWSMessage{Type: "connection_ack"})
+		case "ping":
+			send(graphQLWSMessage{Type: "pong"})
+		case "subscribe":
+			var req graphQLRequest
+			if err := json.Unmarshal(msg.Payload, &req); err != nil {
+				errPayload, _ := json.Marshal([]map[string]string{{"message": "invalid subscribe payload"}})
+				send(graphQLWSMessage{ID: msg.ID, Type: "error", Payload: errPayload})
+				continue
+			}
+
+			opCtx, opCancel := context.WithCancel(ctx)
+			opsMu.Lock()
+			operations[msg.ID] = opCancel
+			opsMu.Unlock()
+
+			responses, err := schema.Subscribe(opCtx, req.Query, req.OperationName, req.Variables)
+			if err != nil {
+				opCancel()
+				errPayload, _ := json.Marshal([]map[string]string{{"message": err.Error()}})
+				send(graphQLWSMessage{ID: msg.ID, Type: "error", Payload: errPayload})
+				continue
+			}
+
+			go func(id string) {
+				for response := range responses {
+					payload, err := json.Marshal(response)
+					if err != nil {
+						continue
+					}
+					send(graphQLWSMessage{ID: id, Type: "next", Payload: payload})
+				}
+				send(graphQLWSMessage{ID: id, Type: "complete"})
+				opsMu.Lock()
+				if opCancel, ok := operations[id]; ok {
+					opCancel()
+					delete(operations, id)
+				}
+				opsMu.Unlock()
+			}(msg.ID)
+		case "complete":
+			opsMu.Lock()
+			if opCancel, ok := operations[msg.ID]; ok {
+				opCancel()
+				delete(operations, msg.ID)
+			}
+			opsMu.Unlock()
+		default:
+			logging.DebugLog("Unknown GraphQL message type: %s", msg.Type)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newGraphQLTestServer serves the GraphQL endpoints of h
+func newGraphQLTestServer(t *testing.T, h *Hub) *httptest.Server {
+	schema, err := NewGraphQLSchema(h)
+	if err != nil {
+		t.Fatalf("NewGraphQLSchema: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", NewGraphQLHandler(schema))
+	mux.Handle("/graphql/subscriptions", NewGraphQLSubscriptionHandler(schema))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestGraphQLSubscriptionStreamsUpdatesOfSubmittedContract(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	ts := newGraphQLTestServer(t, h)
+
+	mutation, _ := json.Marshal(graphQLRequest{
+		Query: `mutation { submitContract(input: {productType: "LuckyLadder", rungs: [101, 102, 103], duration: 60000, payoff: 10}) { contractID } }`,
+	})
+	resp, err := http.Post(ts.URL+"/graphql", "application/json", bytes.NewReader(mutation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var submitted struct {
+		Data struct {
+			SubmitContract struct{ ContractID string }
+		}
+		Errors []interface{}
+	}
+	json.NewDecoder(resp.Body).Decode(&submitted)
+	resp.Body.Close()
+	contractID := submitted.Data.SubmitContract.ContractID
+	if contractID == "" {
+		t.Fatalf("submitContract returned errors %v, want a contract ID", submitted.Errors)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphQLSubprotocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/graphql/subscriptions", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.WriteJSON(graphQLWSMessage{Type: "connection_init"})
+	var ack graphQLWSMessage
+	if err := conn.ReadJSON(&ack); err != nil || ack.Type != "connection_ack" {
+		t.Fatalf("connection_init answered %+v (%v), want connection_ack", ack, err)
+	}
+	subscribe, _ := json.Marshal(graphQLRequest{
+		Query:     `subscription($id: ID!) { contractUpdates(id: $id) { contractID status price } }`,
+		Variables: map[string]interface{}{"id": contractID},
+	})
+	conn.WriteJSON(graphQLWSMessage{ID: "1", Type: "subscribe", Payload: subscribe})
+
+	messages := make(chan graphQLWSMessage)
+	go func() {
+		defer close(messages)
+		for {
+			var msg graphQLWSMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			messages <- msg
+		}
+	}()
+	nextUpdate := func() (map[string]interface{}, bool) {
+		msg, ok := <-messages
+		if !ok || msg.Type != "next" {
+			return nil, false
+		}
+		var payload struct {
+			Data struct{ ContractUpdates map[string]interface{} }
+		}
+		json.Unmarshal(msg.Payload, &payload)
+		return payload.Data.ContractUpdates, true
+	}
+
+	// Ticks sent before the subscription is set up are not streamed
+	stopTicking := make(chan struct{})
+	ticked := make(chan struct{})
+	go func() {
+		defer close(ticked)
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				prices.tick(101.5)
+			case <-stopTicking:
+				return
+			}
+		}
+	}()
+	update, ok := nextUpdate()
+	close(stopTicking)
+	<-ticked
+	if !ok || update["contractID"] != contractID || update["price"] != 101.5 {
+		t.Fatalf("Subscription sent %v, want the update of %s at 101.5", update, contractID)
+	}
+
+	h.contractStateHandler(context.Background(), contractID, func(map[string]interface{}) {})(map[string]interface{}{
+		"contractID": contractID,
+		"status":     "expired",
+	})
+	for {
+		update, ok := nextUpdate()
+		if !ok {
+			t.Fatal("Subscription ended without the terminal state")
+		}
+		if update["status"] == "expired" {
+			break
+		}
+	}
+	if msg := <-messages; msg.Type != "complete" || msg.ID != "1" {
+		t.Fatalf("Subscription sent %+v after the terminal state, want complete", msg)
+	}
+}
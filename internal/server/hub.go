@@ -1,13 +1,24 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"pricingserver/internal/audit"
 	"pricingserver/internal/common/logging"
 	"pricingserver/internal/contracts"
 	"pricingserver/internal/simulation"
 )
 
+// contractExpiryScanInterval is how often contracts that are no longer active
+// are removed from the contract manager
+const contractExpiryScanInterval = 10 * time.Second
+
 // Hub maintains active clients and coordinates communication
 type Hub struct {
 	Clients          map[*Client]bool
@@ -16,82 +27,640 @@ type Hub struct {
 	Broadcast        chan []byte
 	mu               sync.Mutex
 	ContractService  *contracts.ContractServiceClient
-	SimulationEngine *simulation.SimulationEngine
+	StorageService   *contracts.StorageServiceClient
+	Contracts        *contracts.PersistentContractManager
+	SimulationEngine simulation.PriceEmitter
+	relay            ClusterRelay
+	election         *LeaderElection
+	apiContracts     map[string]*apiContract
+	apiMu            sync.Mutex
+	listeners        map[string]map[int]func(state map[string]interface{})
+	nextListenerID   int
+	listenersMu      sync.Mutex
+	TenantLimits     map[string]TenantConfig
+	contractTenants  map[string]string
+	tenantMetrics    map[string]*TenantMetrics
+	contractPayoffs  map[string]float64
+	tenantsMu        sync.RWMutex
+	// TenantSimulationEngine holds the simulation engine of each tenant
+	TenantSimulationEngine map[string]*simulation.SimulationEngine
+	engineIdleSince        map[string]time.Time
+	// ProductSimulationOverrides configures the simulation engine of the
+	// contracts of a type, e.g. "lucky_ladder", instead of the shared or
+	// tenant engine. The configurations are complete, e.g. built from
+	// SimulationConfigFromEnv.
+	ProductSimulationOverrides map[string]simulation.SimulationConfig
+	productEngines             map[string]*simulation.SimulationEngine
+	enginesMu                  sync.Mutex
+	metricsReport              map[string]TenantMetricsSummary
+	metricsReportAt            time.Time
+	reportMu                   sync.Mutex
+	// OnEngineRestart is called with the contracts that were subscribed to
+	// the shared simulation engine when it is started again after a stop. It
+	// defaults to subscribing the contracts that are still active again.
+	OnEngineRestart func(contractIDs []string)
+	// PriceRecorder, when set, records every contract state after each price
+	PriceRecorder *simulation.ContractResultRecorder
+	// AuditLog, when set, records contract creations and terminations
+	AuditLog audit.AuditLog
+
+	// subscriptionIndex lists the local clients subscribed to each contract
+	subscriptionIndex map[string][]*Client
+	subscriptionsMu   sync.RWMutex
+
+	// activeContractTypes holds the contract type of every contract counted
+	// in pricing_contracts_active
+	activeContractTypes map[string]string
+	activeMu            sync.Mutex
+
+	// updateLogs keeps the latest updates of each contract for NDJSON
+	// streams
+	updateLogs   map[string]*contractUpdateLog
+	updateLogsMu sync.Mutex
+
+	// ReplayBufferSize is how many of the latest updates of a contract a
+	// ContractQuery replays before the current state
+	ReplayBufferSize int
+
+	// CompressThresholdBytes is the encoded size above which contract
+	// broadcasts are compressed for clients that negotiated compression, 0
+	// to disable compression
+	CompressThresholdBytes int
+	// MessagesCompressed and BytesSavedByCompression count the compressed
+	// broadcasts and the bytes they saved across recipients. They are updated
+	// atomically.
+	MessagesCompressed      int64
+	BytesSavedByCompression int64
+
+	// Subprotocols lists the WebSocket subprotocols accepted by the
+	// WebSocket endpoint in order of preference
+	Subprotocols []string
+
+	// sharedContracts lists the participants of each shared contract, owner
+	// first
+	sharedContracts map[string][]string
+	sharedMu        sync.Mutex
+
+	// priorityQueue holds the messages sent with PriorityBroadcast until the
+	// main loop delivers them
+	priorityQueue priorityQueue
+	prioritySeq   uint64
+	priorityMu    sync.Mutex
+	priorityReady chan struct{}
+
+	// SessionResumeTimeout is how long the contracts of a disconnected
+	// client keep running for it to reconnect, 0 to remove them at once
+	SessionResumeTimeout time.Duration
+	sessions             map[string]*sessionSnapshot
+	sessionsMu           sync.Mutex
+
+	// migrated is set once Migrate handed the clients over to another
+	// server, after which no new clients are accepted
+	migrated atomic.Bool
+	// draining is set once Drain started, after which no new clients or
+	// contracts are accepted
+	draining atomic.Bool
+}
+
+// ClusterRelay shares contract messages and client registrations with other
+// hub instances
+type ClusterRelay interface {
+	Publish(contractID string, message interface{}) error
+	ClientRegistered(client *Client)
+	ClientUnregistered(client *Client)
+	ContractRegistered(contractID string, client *Client)
 }
 
 // NewHub creates a new Hub
 func NewHub() *Hub {
-	return &Hub{
+	contractService := contracts.NewContractServiceClient()
+	storageService := contracts.NewStorageServiceClient()
+	h := &Hub{
 		Clients:          make(map[*Client]bool),
 		Register:         make(chan *Client),
 		Unregister:       make(chan *Client),
 		Broadcast:        make(chan []byte),
-		ContractService:  contracts.NewContractServiceClient(),
-		SimulationEngine: simulation.NewSimulationEngine(),
+		priorityReady:    make(chan struct{}, 1),
+		ContractService:  contractService,
+		StorageService:   storageService,
+		Contracts:        contracts.NewPersistentContractManager(contracts.NewContractManager(contractService), storageService),
+		SimulationEngine: newPriceEmitter(),
+		apiContracts:     make(map[string]*apiContract),
+		listeners:        make(map[string]map[int]func(state map[string]interface{})),
+		updateLogs:       make(map[string]*contractUpdateLog),
+		contractTenants:  make(map[string]string),
+		tenantMetrics:    make(map[string]*TenantMetrics),
+		contractPayoffs:  make(map[string]float64),
+
+		TenantSimulationEngine: make(map[string]*simulation.SimulationEngine),
+		engineIdleSince:        make(map[string]time.Time),
+		subscriptionIndex:      make(map[string][]*Client),
+		activeContractTypes:    make(map[string]string),
+		sharedContracts:        make(map[string][]string),
+		sessions:               make(map[string]*sessionSnapshot),
+		SessionResumeTimeout:   sessionResumeTimeoutFromEnv(),
+		CompressThresholdBytes: compressThresholdFromEnv(),
+		ReplayBufferSize:       replayBufferSizeFromEnv(),
+		Subprotocols:           SubprotocolsFromEnv(),
+	}
+	h.OnEngineRestart = h.resubscribeContracts
+	return h
+}
+
+// newPriceEmitter uses a WebSocket market data feed when WS_FEED_URL is set
+// or a NATS price feed when NATS_URL is set, falling back to the simulation
+// engine otherwise
+func newPriceEmitter() simulation.PriceEmitter {
+	if wsURL := os.Getenv("WS_FEED_URL"); wsURL != "" {
+		feed, err := simulation.NewWSFeed(wsURL, os.Getenv("WS_FEED_SYMBOL"))
+		if err != nil {
+			logging.DebugLog("Failed to connect to WebSocket price feed, using simulation engine: %v", err)
+			return simulation.NewSimulationEngineWithConfig(simulation.SimulationConfigFromEnv())
+		}
+		return feed
+	}
+
+	natsURL := os.Getenv("NATS_URL")
+	if natsURL == "" {
+		return simulation.NewSimulationEngineWithConfig(simulation.SimulationConfigFromEnv())
 	}
+	feed, err := simulation.NewNATSPriceFeed(natsURL, os.Getenv("NATS_PRICE_SUBJECT"))
+	if err != nil {
+		logging.DebugLog("Failed to connect to NATS, using simulation engine: %v", err)
+		return simulation.NewSimulationEngineWithConfig(simulation.SimulationConfigFromEnv())
+	}
+	return feed
 }
 
 // Run starts the hub's main loop
 func (h *Hub) Run() {
 	// Start the simulation engine
+	if engine, ok := h.SimulationEngine.(*simulation.SimulationEngine); ok {
+		h.watchEngineRestart(engine)
+	}
 	h.SimulationEngine.Start()
+	go h.reapIdleEngines()
 
-	// Create a proxy for each active contract
-	if activeContracts, err := h.ContractService.GetActiveContracts(); err == nil {
-		for _, contractID := range activeContracts {
-			logging.DebugLog("Restoring contract: %s", contractID)
-			state, err := h.ContractService.GetContractState(contractID)
-			if err != nil {
-				logging.DebugLog("Failed to get contract state: %v", err)
-				continue
-			}
-			if state == nil {
-				logging.DebugLog("Contract state not found: %s", contractID)
-				continue
-			}
-			if status, ok := state["status"].(string); !ok || status != "active" {
-				logging.DebugLog("Contract is not active: %s, status: %s", contractID, status)
-				continue
-			}
-
-			proxy := contracts.NewContractProxy(contractID, nil, h.ContractService)
-			h.SimulationEngine.Subscribe(contractID, proxy)
-			proxy.Start()
-			logging.DebugLog("Restored active contract: %s", contractID)
-		}
-	} else {
-		logging.DebugLog("Failed to get active contracts: %v", err)
+	h.Contracts.OnExpire = func(contractID string) {
+		h.untrackActiveContract(contractID)
+		h.unsubscribePrices(contractID)
+		h.forgetContractTenant(contractID)
+	}
+	h.restoreContracts()
+	if _, err := h.RestoreMigratedSessions(); err != nil {
+		logging.DebugLog("Failed to restore migrated sessions: %v", err)
 	}
+	h.Contracts.StartExpiryScanner(contractExpiryScanInterval)
+	go h.persistSnapshots()
 
 	for {
+		h.drainPriorityBroadcasts()
 		select {
+		case <-h.priorityReady:
 		case client := <-h.Register:
 			h.mu.Lock()
 			h.Clients[client] = true
 			h.mu.Unlock()
+			if h.relay != nil {
+				h.relay.ClientRegistered(client)
+			}
 		case client := <-h.Unregister:
+			if h.relay != nil {
+				h.relay.ClientUnregistered(client)
+			}
+			// c.mu is taken before h.mu, so the contracts are read first
+			contracts := client.contractsSnapshot()
+			client.mu.Lock()
+			migrated := client.migrated
+			// Multi-leg settlements have no other subscriber to deliver to
+			for parentID := range client.multiLegParents {
+				client.untrackMultiLegParent(parentID)
+			}
+			client.mu.Unlock()
 			h.mu.Lock()
 			if _, ok := h.Clients[client]; ok {
 				delete(h.Clients, client)
-				close(client.Send)
+				client.closeSend()
+				clientBytesSent.DeleteLabelValues(client.ID)
+				// Contracts of resumable sessions keep running until the
+				// client reconnects or the session expires
+				var kept map[string]string
+				if h.resumable(client) {
+					kept = make(map[string]string)
+				}
 				// Unsubscribe client's products from the simulation engine
-				for contractID := range client.Contracts {
-					h.SimulationEngine.Unsubscribe(contractID)
-					h.ContractService.RemoveContract(contractID)
+				for contractID, productType := range contracts {
+					// Contracts of migrated clients run on the new server
+					if migrated {
+						h.unsubscribeClient(contractID, client)
+						continue
+					}
+					// Shared contracts keep running for their owner
+					if h.leaveSharedContract(contractID, client.ID) {
+						h.unsubscribeClient(contractID, client)
+						continue
+					}
+					if kept != nil {
+						kept[contractID] = productType
+						h.unsubscribeClient(contractID, client)
+						continue
+					}
+					h.removeClientContract(client, contractID)
+				}
+				if len(kept) > 0 {
+					h.saveSession(client, kept, h.SessionResumeTimeout)
 				}
 			}
 			h.mu.Unlock()
 		case message := <-h.Broadcast:
-			h.mu.Lock()
-			for client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client)
-				}
+			h.broadcastToAll(message)
+		}
+	}
+}
+
+// removeClientContract stops a contract owned by client when the client
+// leaves
+func (h *Hub) removeClientContract(client *Client, contractID string) {
+	h.recordAudit(audit.AuditEvent{
+		EventType:  audit.EventContractRemoved,
+		ContractID: contractID,
+		ClientID:   client.ID,
+		UserID:     client.UserID,
+		IPAddress:  client.RemoteAddr,
+	})
+	h.unsubscribeClient(contractID, client)
+	h.untrackActiveContract(contractID)
+	h.unsubscribePrices(contractID)
+	h.Contracts.RemoveContract(contractID)
+	h.forgetContractTenant(contractID)
+}
+
+// broadcastToAll sends an encoded message to every connected client, dropping
+// clients that cannot keep up
+func (h *Hub) broadcastToAll(message []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.Clients {
+		select {
+		case client.Send <- client.frame(message):
+		default:
+			client.closeSend()
+			delete(h.Clients, client)
+		}
+	}
+}
+
+// ContractBroadcast delivers a contract message to every client subscribed to
+// contractID, and to other instances when a cluster relay is configured
+func (h *Hub) ContractBroadcast(contractID string, message interface{}) {
+	h.deliverContractMessage(contractID, message)
+	if h.relay != nil {
+		if err := h.relay.Publish(contractID, message); err != nil {
+			logging.DebugLog("Failed to publish contract %s message: %v", contractID, err)
+		}
+	}
+}
+
+// deliverContractMessage sends a message to locally connected clients
+// subscribed to contractID. The message is encoded once per serialization
+// format and compressed at most once per format. Messages are dropped for
+// clients whose Send buffer is full, except terminal updates and
+// settlements, see sendLate.
+func (h *Hub) deliverContractMessage(contractID string, message interface{}) {
+	h.subscriptionsMu.RLock()
+	subscribers := append([]*Client(nil), h.subscriptionIndex[contractID]...)
+	h.subscriptionsMu.RUnlock()
+	if len(subscribers) == 0 {
+		return
+	}
+
+	exempt := dedupeExempt(message)
+	reliable := mustDeliver(message)
+	broadcast := newBroadcastMessage(h, message)
+	var late []lateFrame
+	h.mu.Lock()
+	defer func() {
+		h.mu.Unlock()
+		h.sendLate(late)
+	}()
+	for _, client := range subscribers {
+		// Skip clients that disconnected after the index was read
+		if !h.Clients[client] {
+			continue
+		}
+		if !client.passesFilters(contractID, message) {
+			continue
+		}
+		data, err := broadcast.encode(client.codec())
+		if err != nil {
+			logging.DebugLog("Failed to marshal contract message for client %s: %v", client.ID, err)
+			continue
+		}
+		if !exempt && client.isDuplicate(contractID, data) {
+			logging.DebugLog("Skipping duplicate contract %s message for client %s", contractID, client.ID)
+			continue
+		}
+		frame := broadcast.frameFor(client, data)
+		select {
+		case client.Send <- frame:
+		default:
+			if reliable {
+				late = append(late, lateFrame{client: client, frame: frame})
+				continue
 			}
-			h.mu.Unlock()
+			logging.DebugLog("Send buffer full for client %s, dropping contract %s message", client.ID, contractID)
+		}
+	}
+}
+
+// BroadcastToContract sends an encoded message to the locally connected
+// clients subscribed to contractID. It is safe to call from any goroutine.
+// Like deliverContractMessage, it only drops updates that are not terminal.
+func (h *Hub) BroadcastToContract(contractID string, msg []byte) {
+	h.subscriptionsMu.RLock()
+	subscribers := append([]*Client(nil), h.subscriptionIndex[contractID]...)
+	h.subscriptionsMu.RUnlock()
+
+	var decoded interface{}
+	json.Unmarshal(msg, &decoded)
+	reliable := mustDeliver(decoded)
+	var late []lateFrame
+	h.mu.Lock()
+	defer func() {
+		h.mu.Unlock()
+		h.sendLate(late)
+	}()
+	for _, client := range subscribers {
+		if !h.Clients[client] {
+			continue
 		}
+		frame := client.frame(msg)
+		select {
+		case client.Send <- frame:
+		default:
+			if reliable {
+				late = append(late, lateFrame{client: client, frame: frame})
+				continue
+			}
+			logging.DebugLog("Send buffer full for client %s, dropping contract %s broadcast", client.ID, contractID)
+		}
+	}
+}
+
+// lateSendTimeout bounds how long a message that must not be dropped waits
+// for room in the Send buffer of a slow client, which is disconnected when
+// the buffer stays full
+var lateSendTimeout = 5 * time.Second
+
+// lateFrame is a frame that did not fit in the Send buffer of its client
+type lateFrame struct {
+	client *Client
+	frame  []byte
+}
+
+// mustDeliver reports whether message ends a contract for its subscribers,
+// i.e. it is a settlement or an update with a terminal state. Subscribers
+// are unsubscribed afterwards, so such messages are never dropped.
+func mustDeliver(message interface{}) bool {
+	msg, ok := message.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	msgType, _ := msg["type"].(string)
+	switch msgType {
+	case MessageTypeContractSettlement:
+		return true
+	case MessageTypeContractUpdate:
+		state, _ := msg["data"].(map[string]interface{})
+		return isTerminalState(state)
+	}
+	return false
+}
+
+// sendLate queues frames that did not fit in the Send buffer of their
+// clients without holding the hub lock, waiting up to lateSendTimeout for
+// each. A client whose buffer stays full is disconnected, so it learns the
+// final state of its contracts by querying them once it reconnects.
+func (h *Hub) sendLate(late []lateFrame) {
+	timeout := lateSendTimeout
+	for _, l := range late {
+		go func(l lateFrame) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if l.client.queue(l.frame, ctx.Done()) || ctx.Err() == nil {
+				return
+			}
+			logging.DebugLog("Send buffer of client %s stayed full, disconnecting it", l.client.ID)
+			if l.client.Conn != nil {
+				l.client.Conn.Close()
+			}
+		}(l)
+	}
+}
+
+// BroadcastToClient sends an encoded message to the locally connected client
+// with the given ID. It is safe to call from any goroutine.
+func (h *Hub) BroadcastToClient(clientID string, msg []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client := h.findClient(clientID)
+	if client == nil {
+		return ErrClientNotFound
+	}
+	select {
+	case client.Send <- client.frame(msg):
+		return nil
+	default:
+		return fmt.Errorf("send buffer full for client %s", clientID)
+	}
+}
+
+// findClient returns the connected client with the given ID, or nil. The
+// caller must hold h.mu.
+func (h *Hub) findClient(clientID string) *Client {
+	for client := range h.Clients {
+		if client.ID == clientID {
+			return client
+		}
+	}
+	return nil
+}
+
+// subscribeClient adds client to the clients receiving contractID messages
+func (h *Hub) subscribeClient(contractID string, client *Client) {
+	h.subscriptionsMu.Lock()
+	defer h.subscriptionsMu.Unlock()
+	for _, subscriber := range h.subscriptionIndex[contractID] {
+		if subscriber == client {
+			return
+		}
+	}
+	h.subscriptionIndex[contractID] = append(h.subscriptionIndex[contractID], client)
+}
+
+// unsubscribeClient stops delivering contractID messages to client
+func (h *Hub) unsubscribeClient(contractID string, client *Client) {
+	h.subscriptionsMu.Lock()
+	defer h.subscriptionsMu.Unlock()
+	subscribers := h.subscriptionIndex[contractID]
+	for i, subscriber := range subscribers {
+		if subscriber != client {
+			continue
+		}
+		subscribers = append(subscribers[:i:i], subscribers[i+1:]...)
+		break
+	}
+	if len(subscribers) == 0 {
+		delete(h.subscriptionIndex, contractID)
+		return
+	}
+	h.subscriptionIndex[contractID] = subscribers
+}
+
+// startContract creates a contract for tenantID in the contracts service and
+// subscribes it to price updates. onUpdate is called with the contract state
+// after every price update; the contract is unsubscribed once it reaches a
+// terminal state. The returned channel is closed once the contract handled
+// its first price update, and is nil for pending contracts, which wait for
+// their precondition.
+func (h *Hub) startContract(ctx context.Context, tenantID, contractID string, params contracts.ContractParams, onUpdate func(state map[string]interface{})) (<-chan struct{}, error) {
+	if h.draining.Load() {
+		return nil, ErrServerDraining
+	}
+	// Forward to Python service and subscribe to updates
+	proxy, err := h.Contracts.AddContract(ctx, contractID, params)
+	if err != nil {
+		logging.DebugLogContext(ctx, "Failed to add contract to service: %v", err)
+		return nil, err
+	}
+	onUpdate = withSettledPayoff(params, onUpdate)
+	proxy.SetUpdateCallback(h.contractUpdateCallback(ctx, contractID, proxy, onUpdate))
+
+	h.tenantsMu.Lock()
+	h.contractTenants[contractID] = tenantID
+	h.tenantsMu.Unlock()
+
+	var handler simulation.PriceHandler = proxy
+	if h.PriceRecorder != nil {
+		payoff, _ := params.Parameters["payoff"].(float64)
+		notional, _ := params.Parameters["notional"].(float64)
+		payoffPerUnit, _ := params.Parameters["payoff_per_unit"].(float64)
+		maxPayoff, _ := params.Parameters["max_payoff"].(float64)
+		payoff = scaledPayoff(payoff, notional, payoffPerUnit, maxPayoff)
+		handler = h.PriceRecorder.Wrap(contractID, payoff, proxy)
+	}
+	var firstPrice <-chan struct{}
+	if precondition, ok := params.Parameters["precondition"].(*PreconditionSpec); ok {
+		handleState := h.contractStateHandler(ctx, contractID, onUpdate)
+		handler = NewPendingContractProxy(contractID, *precondition, handler, func() {
+			h.expirePendingContract(ctx, contractID, *precondition, handleState)
+		})
+	} else {
+		handler, firstPrice = simulation.AcknowledgeFirstPrice(handler)
+	}
+	instrument, _ := params.Parameters["instrument"].(string)
+	strike := toFloat(params.Parameters["strike"])
+	maturityDays := toFloat(params.Parameters["duration"]) / float64(24*time.Hour/time.Millisecond)
+	// The proxy is started first as prices may arrive as soon as it is
+	// subscribed
+	proxy.Start()
+	h.subscribePrices(tenantID, contractID, params.ContractType, instrument, strike, maturityDays, handler)
+	h.trackActiveContract(contractID, params.ContractType)
+	return firstPrice, nil
+}
+
+// contractUpdateCallback returns the price update callback of a contract
+// proxy, which handles Python service responses by calling onUpdate with the
+// new state and unsubscribes the contract once it reaches a terminal state
+func (h *Hub) contractUpdateCallback(ctx context.Context, contractID string, proxy *contracts.ContractProxy, onUpdate func(state map[string]interface{})) func(price float64, timestamp time.Time) {
+	handleState := h.contractStateHandler(ctx, contractID, onUpdate)
+	return func(price float64, timestamp time.Time) {
+		state := proxy.GetState()
+		logging.DebugLogContext(ctx, "Got state from proxy: %+v", state)
+		handleState(state)
+	}
+}
+
+// contractStateHandler returns a function calling onUpdate with a new state
+// of a contract, notifying its listeners and unsubscribing the contract once
+// the state is terminal
+func (h *Hub) contractStateHandler(ctx context.Context, contractID string, onUpdate func(state map[string]interface{})) func(state map[string]interface{}) {
+	startedAt := time.Now()
+	return func(state map[string]interface{}) {
+		onUpdate(state)
+		h.notifyListeners(contractID, state)
+
+		if isTerminalState(state) {
+			logging.DebugLogContext(ctx, "Contract %s is no longer active (status: %v), unsubscribing", contractID, state["status"])
+			h.untrackActiveContract(contractID)
+			h.unsubscribePrices(contractID)
+			h.releaseTenantContract(contractID)
+			h.settleSharedContract(contractID, state)
+			go h.recordSettlement(contractID, state, time.Since(startedAt))
+		}
+	}
+}
+
+// resumeContract makes sure a contract that is active in the contracts
+// service is subscribed to the prices of instrument for tenantID. Contracts
+// without a running proxy, e.g. after a restart, are restored first. onUpdate is set as
+// the state callback unless the proxy already has one, which is reported by
+// the returned value.
+func (h *Hub) resumeContract(ctx context.Context, tenantID, contractID, productType, instrument string, onUpdate func(state map[string]interface{})) bool {
+	proxy, ok := h.Contracts.GetContract(contractID)
+	if !ok {
+		logging.DebugLogContext(ctx, "Restoring contract %s", contractID)
+		proxy = h.Contracts.RestoreContract(contractID)
+		h.tenantsMu.Lock()
+		h.contractTenants[contractID] = tenantID
+		h.tenantsMu.Unlock()
+		proxy.Start()
+		h.subscribePrices(tenantID, contractID, contracts.ContractTypeOf(productType), instrument, 0, 0, proxy)
+	}
+	h.trackActiveContract(contractID, contracts.ContractTypeOf(productType))
+
+	if proxy.HasUpdateCallback() {
+		return false
+	}
+	proxy.SetUpdateCallback(h.contractUpdateCallback(ctx, contractID, proxy, onUpdate))
+	return true
+}
+
+// AddContractListener registers fn to be called with every new state of a
+// contract, regardless of which client owns it. The returned function removes
+// the listener. fn must not block.
+func (h *Hub) AddContractListener(contractID string, fn func(state map[string]interface{})) func() {
+	h.listenersMu.Lock()
+	defer h.listenersMu.Unlock()
+	id := h.nextListenerID
+	h.nextListenerID++
+	if h.listeners[contractID] == nil {
+		h.listeners[contractID] = make(map[int]func(state map[string]interface{}))
+	}
+	h.listeners[contractID][id] = fn
+
+	return func() {
+		h.listenersMu.Lock()
+		defer h.listenersMu.Unlock()
+		delete(h.listeners[contractID], id)
+		if len(h.listeners[contractID]) == 0 {
+			delete(h.listeners, contractID)
+		}
+	}
+}
+
+func (h *Hub) notifyListeners(contractID string, state map[string]interface{}) {
+	h.recordContractUpdate(contractID, state)
+	h.listenersMu.Lock()
+	defer h.listenersMu.Unlock()
+	for _, fn := range h.listeners[contractID] {
+		fn(state)
+	}
+}
+
+// registerContract records that client owns contractID
+func (h *Hub) registerContract(contractID string, client *Client) {
+	if h.relay != nil {
+		h.relay.ContractRegistered(contractID, client)
 	}
 }
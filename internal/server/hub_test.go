@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBroadcastBenchmarkHub returns a hub with clients connected clients, each
+// subscribed to one of contracts contracts, and the contract IDs
+func newBroadcastBenchmarkHub(clients, contracts int) (*Hub, []string) {
+	h := NewHub()
+	contractIDs := make([]string, contracts)
+	for i := range contractIDs {
+		contractIDs[i] = fmt.Sprintf("contract-%d", i)
+	}
+	for i := 0; i < clients; i++ {
+		client := &Client{ID: fmt.Sprintf("client-%d", i), Hub: h, Send: make(chan []byte, 1)}
+		h.Clients[client] = true
+		h.subscribeClient(contractIDs[i%contracts], client)
+	}
+	return h, contractIDs
+}
+
+func benchmarkContractBroadcast(b *testing.B, clients, contracts int) {
+	h, contractIDs := newBroadcastBenchmarkHub(clients, contracts)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		contractID := contractIDs[i%contracts]
+		// The price changes so updates are not skipped as duplicates
+		h.ContractBroadcast(contractID, map[string]interface{}{
+			"type":       MessageTypeContractUpdate,
+			"contractID": contractID,
+			"data":       map[string]interface{}{"price": float64(i)},
+		})
+		for _, client := range h.subscriptionIndex[contractID] {
+			<-client.Send
+		}
+	}
+}
+
+// BenchmarkContractBroadcast delivers updates to 10 subscribers per contract
+// with a growing number of connected clients
+func BenchmarkContractBroadcast(b *testing.B) {
+	for _, clients := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			benchmarkContractBroadcast(b, clients, clients/10)
+		})
+	}
+}
+
+func TestContractBroadcastScalesWithSubscribers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test skipped in short mode")
+	}
+	few := testing.Benchmark(func(b *testing.B) { benchmarkContractBroadcast(b, 1000, 100) })
+	many := testing.Benchmark(func(b *testing.B) { benchmarkContractBroadcast(b, 10000, 1000) })
+
+	// Ten times as many clients with the same subscribers per contract
+	// should not make a broadcast noticeably slower
+	t.Logf("1000 clients: %d ns/op, 10000 clients: %d ns/op", few.NsPerOp(), many.NsPerOp())
+	if many.NsPerOp() > 3*few.NsPerOp() {
+		t.Errorf("Broadcasting with 10000 clients took %d ns/op, more than 3x the %d ns/op with 1000 clients", many.NsPerOp(), few.NsPerOp())
+	}
+}
+
+// newFullBufferTestClient returns a hub with one client subscribed to
+// contract-1 whose Send buffer is full
+func newFullBufferTestClient() (*Hub, *Client) {
+	h := NewHub()
+	client := &Client{ID: "client-1", Hub: h, Send: make(chan []byte, 1)}
+	h.Clients[client] = true
+	h.subscribeClient("contract-1", client)
+	client.Send <- []byte("queued")
+	return h, client
+}
+
+// receiveAfterQueued makes room in the Send buffer of client and returns the
+// next message queued after the one filling it, or nil if none arrives
+func receiveAfterQueued(client *Client) map[string]interface{} {
+	<-client.Send
+	select {
+	case frame := <-client.Send:
+		var message map[string]interface{}
+		json.Unmarshal(frame, &message)
+		return message
+	case <-time.After(200 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestContractBroadcastDeliversTerminalUpdateToFullBuffer(t *testing.T) {
+	h, client := newFullBufferTestClient()
+	h.ContractBroadcast("contract-1", map[string]interface{}{
+		"type":       MessageTypeContractUpdate,
+		"contractID": "contract-1",
+		"data":       map[string]interface{}{"status": "target_hit"},
+	})
+
+	message := receiveAfterQueued(client)
+	data, _ := message["data"].(map[string]interface{})
+	if data["status"] != "target_hit" {
+		t.Fatalf("Client received %v after its buffer had room, want the terminal update", message)
+	}
+}
+
+func TestContractBroadcastDropsActiveUpdateForFullBuffer(t *testing.T) {
+	h, client := newFullBufferTestClient()
+	h.ContractBroadcast("contract-1", contractUpdateMessage(100))
+
+	if message := receiveAfterQueued(client); message != nil {
+		t.Fatalf("Client received %v, want the update dropped", message)
+	}
+}
+
+func TestBroadcastToContractDeliversSettlementToFullBuffer(t *testing.T) {
+	h, client := newFullBufferTestClient()
+	h.BroadcastToContract("contract-1", []byte(`{"type": "ContractSettlement", "contractID": "contract-1"}`))
+
+	if message := receiveAfterQueued(client); message["type"] != MessageTypeContractSettlement {
+		t.Fatalf("Client received %v after its buffer had room, want the settlement", message)
+	}
+}
+
+func TestContractBroadcastDisconnectsClientWhoseBufferStaysFull(t *testing.T) {
+	defer func(timeout time.Duration) { lateSendTimeout = timeout }(lateSendTimeout)
+	lateSendTimeout = 10 * time.Millisecond
+
+	h := NewHub()
+	client, conn := newDrainTestClient(t, h)
+	h.subscribeClient("contract-1", client)
+	for len(client.Send) < cap(client.Send) {
+		client.sendMessage(contractUpdateMessage(100))
+	}
+	h.ContractBroadcast("contract-1", map[string]interface{}{
+		"type":       MessageTypeContractUpdate,
+		"contractID": "contract-1",
+		"data":       map[string]interface{}{"status": "expired"},
+	})
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read returned %v, want the connection closed by the server", err)
+	}
+}
+
+func TestTargetedBroadcastsOnlyReachTheirTarget(t *testing.T) {
+	h := NewHub()
+	clients := make([]*Client, 3)
+	for i := range clients {
+		clients[i] = newTenantTestClient(h, fmt.Sprintf("client-%d", i), "")
+		h.subscribeClient(fmt.Sprintf("contract-%d", i), clients[i])
+	}
+
+	h.BroadcastToContract("contract-1", []byte(`{"type": "ContractUpdate", "contractID": "contract-1", "data": {"status": "active"}}`))
+	if err := h.BroadcastToClient("client-2", []byte(`{"type": "Heartbeat"}`)); err != nil {
+		t.Fatalf("BroadcastToClient: %v", err)
+	}
+	for i, expected := range []string{"", MessageTypeContractUpdate, "Heartbeat"} {
+		var received []string
+		for len(clients[i].Send) > 0 {
+			var message map[string]interface{}
+			json.Unmarshal(<-clients[i].Send, &message)
+			received = append(received, fmt.Sprint(message["type"]))
+		}
+		if expected == "" && len(received) != 0 || expected != "" && (len(received) != 1 || received[0] != expected) {
+			t.Errorf("Client %d received %v, want %q", i, received, expected)
+		}
+	}
+
+	if err := h.BroadcastToClient("client-unknown", []byte(`{}`)); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("BroadcastToClient to an unknown client returned %v, want ErrClientNotFound", err)
+	}
+}
+
+func TestContractBroadcastCompressesOnceForAllClients(t *testing.T) {
+	h := NewHub()
+	h.CompressThresholdBytes = 1024
+	clients := make([]*Client, 100)
+	for i := range clients {
+		clients[i] = &Client{
+			ID:     fmt.Sprintf("client-%d", i),
+			Hub:    h,
+			Send:   make(chan []byte, 1),
+			Config: ClientConfig{Compression: CompressionDeflate},
+		}
+		h.Clients[clients[i]] = true
+		h.subscribeClient("contract-1", clients[i])
+	}
+	update := contractUpdateMessage(100)
+	update["data"].(map[string]interface{})["history"] = strings.Repeat("101.25,", 5*1024/7)
+	encoded, _ := json.Marshal(update)
+
+	h.ContractBroadcast("contract-1", update)
+
+	frames := make([][]byte, len(clients))
+	for i, client := range clients {
+		frames[i] = <-client.Send
+		if &frames[i][0] != &frames[0][0] {
+			t.Fatalf("Client %d received its own copy of the compressed message", i)
+		}
+	}
+	if frames[0][0] != contentEncodingDeflate {
+		t.Fatalf("Clients received content encoding %#x, want deflate", frames[0][0])
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(frames[0][1:])))
+	if err != nil || !bytes.Equal(inflated, encoded) {
+		t.Fatalf("Compressed message inflates to %d bytes (%v), want the %d bytes of the update", len(inflated), err, len(encoded))
+	}
+	stats := h.CompressionStats()
+	if stats.MessagesCompressed != 1 {
+		t.Errorf("Hub compressed %d messages, want 1", stats.MessagesCompressed)
+	}
+	if saved := int64(len(clients) * (len(encoded) - len(frames[0]) + 1)); stats.BytesSavedByCompression != saved {
+		t.Errorf("Hub saved %d bytes by compression, want %d", stats.BytesSavedByCompression, saved)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientBytesSent counts the bytes written to each connected WebSocket client
+var clientBytesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "pricing_ws_client_bytes_sent_total",
+	Help: "Bytes sent to a WebSocket client.",
+}, []string{"client_id"})
+
+// contractsActive counts the contracts running on this server by product type
+var contractsActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "pricing_contracts_active",
+	Help: "Contracts running on this server.",
+}, []string{"product_type"})
+
+// wsMessageSize measures the WebSocket messages exchanged with clients, e.g.
+// to tune COMPRESS_THRESHOLD_BYTES
+var wsMessageSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "pricing_ws_message_size_bytes",
+	Help:    "Size of WebSocket messages sent to or received from clients.",
+	Buckets: []float64{64, 256, 1024, 4096, 16384, 65536},
+}, []string{"direction"})
+
+// wsConnectionsByUserAgent counts the WebSocket connections by the client
+// product of their User-Agent header, see sanitizeUserAgent
+var wsConnectionsByUserAgent = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "pricing_ws_connections_by_user_agent",
+	Help: "WebSocket connections by client User-Agent.",
+}, []string{"user_agent"})
+
+func init() {
+	prometheus.MustRegister(clientBytesSent, contractsActive, wsMessageSize, wsConnectionsByUserAgent)
+}
+
+// trackActiveContract counts a started contract of contractType, e.g.
+// "lucky_ladder", in pricing_contracts_active
+func (h *Hub) trackActiveContract(contractID, contractType string) {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+	if _, ok := h.activeContractTypes[contractID]; ok {
+		return
+	}
+	h.activeContractTypes[contractID] = contractType
+	contractsActive.WithLabelValues(contractType).Inc()
+}
+
+// untrackActiveContract stops counting a contract in pricing_contracts_active.
+// It does nothing for contracts that are not counted.
+func (h *Hub) untrackActiveContract(contractID string) {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+	contractType, ok := h.activeContractTypes[contractID]
+	if !ok {
+		return
+	}
+	delete(h.activeContractTypes, contractID)
+	contractsActive.WithLabelValues(contractType).Dec()
+}
+
+// ClientBandwidth is the traffic exchanged with a client since it connected
+type ClientBandwidth struct {
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// bandwidth returns the client's traffic counters
+func (c *Client) bandwidth() ClientBandwidth {
+	return ClientBandwidth{
+		BytesSent:     atomic.LoadInt64(&c.BytesSent),
+		BytesReceived: atomic.LoadInt64(&c.BytesReceived),
+	}
+}
+
+// recordSent adds n bytes to the client's sent traffic
+func (c *Client) recordSent(n int) {
+	atomic.AddInt64(&c.BytesSent, int64(n))
+	clientBytesSent.WithLabelValues(c.ID).Add(float64(n))
+}
+
+// ClientStats is the traffic of a connected client with its connection
+// metadata
+type ClientStats struct {
+	ClientBandwidth
+	UserAgent   string    `json:"userAgent"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// ClientStatsReport returns the stats of every connected client keyed by
+// client ID
+func (h *Hub) ClientStatsReport() map[string]ClientStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	report := make(map[string]ClientStats, len(h.Clients))
+	for client := range h.Clients {
+		report[client.ID] = ClientStats{
+			ClientBandwidth: client.bandwidth(),
+			UserAgent:       client.UserAgent,
+			RemoteAddr:      client.RemoteAddr,
+			ConnectedAt:     client.ConnectedAt,
+		}
+	}
+	return report
+}
+
+// GetClientBandwidth returns the traffic of a connected client
+func (h *Hub) GetClientBandwidth(clientID string) (ClientBandwidth, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client := h.findClient(clientID)
+	if client == nil {
+		return ClientBandwidth{}, ErrClientNotFound
+	}
+	return client.bandwidth(), nil
+}
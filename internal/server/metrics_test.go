@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// activeContractsMetric returns pricing_contracts_active of productType in
+// the metrics output
+func activeContractsMetric(t *testing.T, productType string) float64 {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	prefix := fmt.Sprintf("pricing_contracts_active{product_type=%q} ", productType)
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), prefix); ok {
+			count, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("Metric %q is not a number", scanner.Text())
+			}
+			return count
+		}
+	}
+	return 0
+}
+
+func TestActiveContractsMetricIsLabelledByProductType(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	// Other tests of the package leave contracts in the metric
+	ladders := activeContractsMetric(t, "lucky_ladder")
+	catchers := activeContractsMetric(t, "momentum_catcher")
+
+	submissions := []ContractData{
+		testLuckyLadder(),
+		testLuckyLadder(),
+		testLuckyLadder(),
+		{ProductType: "MomentumCatcher", TargetMovement: 5, Duration: 60000, Payoff: 10},
+		{ProductType: "MomentumCatcher", TargetMovement: 5, Duration: 60000, Payoff: 10},
+	}
+	for _, data := range submissions {
+		if _, err := h.SubmitContract("", data); err != nil {
+			t.Fatalf("SubmitContract %s: %v", data.ProductType, err)
+		}
+	}
+
+	if count := activeContractsMetric(t, "lucky_ladder") - ladders; count != 3 {
+		t.Errorf("pricing_contracts_active of lucky_ladder grew by %v, want 3", count)
+	}
+	if count := activeContractsMetric(t, "momentum_catcher") - catchers; count != 2 {
+		t.Errorf("pricing_contracts_active of momentum_catcher grew by %v, want 2", count)
+	}
+}
+
+// messageSizeBuckets returns the count of each bucket of
+// pricing_ws_message_size_bytes in direction, keyed by upper bound, from a
+// registry of its own
+func messageSizeBuckets(t *testing.T, direction string) map[float64]uint64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(wsMessageSize)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets := make(map[float64]uint64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() != direction {
+				continue
+			}
+			// Bucket counts are cumulative
+			var below uint64
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount() - below
+				below = bucket.GetCumulativeCount()
+			}
+		}
+	}
+	return buckets
+}
+
+// sizeBucket returns the upper bound of the pricing_ws_message_size_bytes
+// bucket of a message of size bytes
+func sizeBucket(size int) float64 {
+	for _, bound := range []float64{64, 256, 1024, 4096, 16384, 65536} {
+		if float64(size) <= bound {
+			return bound
+		}
+	}
+	return math.Inf(1)
+}
+
+func TestMessageSizeHistogramCountsEachMessageOnce(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	go h.Run()
+	conn, _ := dialTestClient(t, h, ClientConfig{})
+	request, _ := json.Marshal(map[string]interface{}{"type": MessageTypeValidateContract, "data": testLuckyLadder()})
+	received, sent := messageSizeBuckets(t, "received"), messageSizeBuckets(t, "sent")
+
+	conn.WriteMessage(websocket.TextMessage, request)
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Client sent no reply: %v", err)
+	}
+
+	for _, tc := range []struct {
+		direction string
+		before    map[float64]uint64
+		size      int
+	}{{"received", received, len(request)}, {"sent", sent, len(reply)}} {
+		after := messageSizeBuckets(t, tc.direction)
+		for bound, count := range after {
+			expected := uint64(0)
+			if bound == sizeBucket(tc.size) {
+				expected = 1
+			}
+			if count-tc.before[bound] != expected {
+				t.Errorf("Bucket %v of %s messages grew by %d after a %d byte message, want %d", bound, tc.direction, count-tc.before[bound], tc.size, expected)
+			}
+		}
+	}
+}
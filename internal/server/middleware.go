@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/simulation"
+)
+
+// PanicCount is the number of handler panics recovered by RecoveryMiddleware.
+// Access it with sync/atomic.
+var PanicCount int64
+
+// RecoveryMiddleware recovers from panics in next, logging the panic with its
+// stack trace and responding with 500, so one failing request cannot take the
+// server and every connected client down with it. The request context
+// carries a Logger with a requestID field.
+func RecoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(logging.WithFields(r.Context(), map[string]interface{}{"requestID": GenerateUniqueID()}))
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers deliberately abort a response
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			atomic.AddInt64(&PanicCount, 1)
+			logging.DebugLogContext(r.Context(), "Recovered panic in %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewHealthHandler returns the handler of GET /health, which reports the
+// number of recovered panics and, when hub prices contracts with the
+// simulation engine, the engine's tick telemetry
+func NewHealthHandler(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		health := map[string]interface{}{
+			"status":     "healthy",
+			"panicCount": atomic.LoadInt64(&PanicCount),
+		}
+		if engine, ok := hub.SimulationEngine.(*simulation.SimulationEngine); ok {
+			telemetry := engine.Telemetry()
+			health["engine_ticks"] = telemetry.TickCount
+			health["missed_ticks"] = telemetry.MissedTicks
+			health["last_tick_latency_ms"] = telemetry.LastTickLatencyMs
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health)
+	}
+}
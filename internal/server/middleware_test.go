@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRecoveryMiddlewareAnswers500AndKeepsServing(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	mux := http.NewServeMux()
+	mux.Handle("/panic", RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler bug")
+	})))
+	mux.Handle("/health", RecoveryMiddleware(NewHealthHandler(h)))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	before := atomic.LoadInt64(&PanicCount)
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(ts.URL + "/panic")
+		if err != nil {
+			t.Fatalf("Request %d after a panic failed: %v", i+1, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("Panicking handler answered %d, want 500", resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("Health check after the panics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var health struct {
+		Status     string `json:"status"`
+		PanicCount int64  `json:"panicCount"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	if resp.StatusCode != http.StatusOK || health.Status != "healthy" {
+		t.Fatalf("Health check answered %d with %+v, want 200 healthy", resp.StatusCode, health)
+	}
+	if health.PanicCount != before+2 {
+		t.Errorf("Health reports %d panics, want %d", health.PanicCount, before+2)
+	}
+}
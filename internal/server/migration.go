@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// migrationTimeout is how long Migrate waits for clients to reconnect to the
+// new server, and how long the new server keeps their sessions
+const migrationTimeout = 30 * time.Second
+
+// migrationPollInterval is how often Migrate checks which sessions were
+// taken over by the new server
+const migrationPollInterval = time.Second
+
+// migratedSession is a WebSocket session handed over to another pricing
+// server through the storage service
+type migratedSession struct {
+	ClientID  string             `json:"clientID"`
+	TenantID  string             `json:"tenantID"`
+	UserID    string             `json:"userID"`
+	Contracts []migratedContract `json:"contracts"`
+}
+
+// migratedContract is a contract of a migrated session with the state of its
+// proxy
+type migratedContract struct {
+	ContractID  string          `json:"contractID"`
+	ProductType string          `json:"productType"`
+	Snapshot    json.RawMessage `json:"snapshot,omitempty"`
+}
+
+// AcceptingConnections reports whether new WebSocket clients may connect,
+// which stops once the hub migrated its clients to another server or started
+// draining them
+func (h *Hub) AcceptingConnections() bool {
+	return !h.migrated.Load() && !h.draining.Load()
+}
+
+// Migrate hands the sessions of every connected client over to the pricing
+// server at targetAddr for a zero-downtime deploy. The sessions are saved to
+// the storage service and their contracts stop receiving prices here, then
+// every client receives a ServerMigrate message with targetAddr and its
+// session ID to reconnect with as X-Last-Session-ID. Migrate waits up to
+// migrationTimeout for the new server to take the sessions over and then
+// stops accepting connections. It returns an error if the sessions could not
+// be saved or some of them were not taken over in time.
+func (h *Hub) Migrate(targetAddr string) error {
+	h.mu.Lock()
+	clients := make([]*Client, 0, len(h.Clients))
+	for client := range h.Clients {
+		if client.SessionID != "" {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.Unlock()
+
+	sessions := make(map[string][]byte, len(clients))
+	sessionIDs := make([]string, 0, len(clients))
+	var contractIDs []string
+	for _, client := range clients {
+		session := migratedSession{ClientID: client.ID, TenantID: client.TenantID, UserID: client.UserID}
+		client.mu.Lock()
+		for contractID, productType := range client.Contracts {
+			// Shared contracts move with their owner
+			if !h.ownsSharedContract(contractID, client.ID) {
+				continue
+			}
+			contract := migratedContract{ContractID: contractID, ProductType: productType}
+			if proxy, ok := h.Contracts.GetContract(contractID); ok {
+				if snapshot, err := proxy.Snapshot(); err == nil {
+					contract.Snapshot = snapshot
+				}
+			}
+			session.Contracts = append(session.Contracts, contract)
+			contractIDs = append(contractIDs, contractID)
+		}
+		client.mu.Unlock()
+
+		data, err := json.Marshal(session)
+		if err != nil {
+			return fmt.Errorf("failed to encode session %s: %v", client.SessionID, err)
+		}
+		sessions[client.SessionID] = data
+		sessionIDs = append(sessionIDs, client.SessionID)
+	}
+	if err := h.StorageService.SaveMigratedSessions(sessions); err != nil {
+		return fmt.Errorf("failed to save sessions: %v", err)
+	}
+
+	// The new server prices the contracts from now on
+	for _, client := range clients {
+		client.mu.Lock()
+		client.migrated = true
+		client.mu.Unlock()
+	}
+	for _, contractID := range contractIDs {
+		h.untrackActiveContract(contractID)
+		h.unsubscribePrices(contractID)
+	}
+
+	logging.DebugLog("Migrating %d sessions with %d contracts to %s", len(sessionIDs), len(contractIDs), targetAddr)
+	for _, client := range clients {
+		h.sendToClients([]string{client.ID}, map[string]interface{}{
+			"type": MessageTypeServerMigrate,
+			"data": map[string]interface{}{
+				"address":   targetAddr,
+				"sessionID": client.SessionID,
+			},
+		})
+	}
+
+	pending, err := h.waitForMigratedSessions(sessionIDs)
+	h.migrated.Store(true)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d of %d sessions were not taken over by %s", len(pending), len(sessionIDs), targetAddr)
+	}
+	logging.DebugLog("Migrated %d sessions to %s", len(sessionIDs), targetAddr)
+	return nil
+}
+
+// waitForMigratedSessions waits up to migrationTimeout for every session in
+// sessionIDs to be taken over and returns the sessions that were not
+func (h *Hub) waitForMigratedSessions(sessionIDs []string) ([]string, error) {
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+	deadline := time.Now().Add(migrationTimeout)
+	for {
+		pending, err := h.StorageService.PendingMigratedSessions(sessionIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check migrated sessions: %v", err)
+		}
+		if len(pending) == 0 || time.Now().After(deadline) {
+			return pending, nil
+		}
+		time.Sleep(migrationPollInterval)
+	}
+}
+
+// RestoreMigratedSessions takes over every session that other pricing
+// servers handed over with Migrate. Their contracts are subscribed to this
+// hub's price sources and kept for the clients to reconnect with the session
+// ID. It returns the number of sessions restored.
+func (h *Hub) RestoreMigratedSessions() (int, error) {
+	return h.restoreMigratedSessions(nil)
+}
+
+// restoreMigratedSessions takes over the migrated sessions with the given
+// IDs, or every migrated session when sessionIDs is empty
+func (h *Hub) restoreMigratedSessions(sessionIDs []string) (int, error) {
+	if h.StorageService == nil {
+		return 0, nil
+	}
+	sessions, err := h.StorageService.TakeMigratedSessions(sessionIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for sessionID, data := range sessions {
+		var session migratedSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			logging.DebugLog("Skipping invalid migrated session %s: %v", sessionID, err)
+			continue
+		}
+		contracts := make(map[string]string, len(session.Contracts))
+		for _, contract := range session.Contracts {
+			if h.restoreMigratedContract(session.TenantID, contract) {
+				contracts[contract.ContractID] = contract.ProductType
+			}
+		}
+		client := &Client{ID: session.ClientID, SessionID: sessionID, TenantID: session.TenantID, UserID: session.UserID}
+		h.saveSession(client, contracts, h.migratedSessionTimeout())
+		restored++
+	}
+	if restored > 0 {
+		logging.DebugLog("Restored %d migrated sessions", restored)
+	}
+	return restored, nil
+}
+
+// restoreMigratedContract subscribes a contract of a migrated session to
+// this hub's prices and reports whether it is still active
+func (h *Hub) restoreMigratedContract(tenantID string, contract migratedContract) bool {
+	ctx := logging.WithFields(context.Background(), map[string]interface{}{"contractID": contract.ContractID})
+	state, err := h.ContractService.GetContractState(ctx, contract.ContractID)
+	if err != nil || state == nil || isTerminalState(state) {
+		logging.DebugLogContext(ctx, "Skipping migrated contract %s that is no longer active", contract.ContractID)
+		return false
+	}
+	instrument, _ := state["instrument"].(string)
+
+	contractID := contract.ContractID
+	h.resumeContract(ctx, tenantID, contractID, contract.ProductType, instrument, func(state map[string]interface{}) {
+		h.ContractBroadcast(contractID, map[string]interface{}{
+			"type":       MessageTypeContractUpdate,
+			"contractID": contractID,
+			"data":       state,
+		})
+	})
+	if proxy, ok := h.Contracts.GetContract(contractID); ok && len(contract.Snapshot) > 0 {
+		if err := proxy.Restore(contract.Snapshot); err != nil {
+			logging.DebugLogContext(ctx, "Failed to restore state of migrated contract %s: %v", contractID, err)
+		}
+	}
+	return true
+}
+
+// migratedSessionTimeout is how long migrated sessions are kept for their
+// clients to reconnect
+func (h *Hub) migratedSessionTimeout() time.Duration {
+	if h.SessionResumeTimeout > migrationTimeout {
+		return h.SessionResumeTimeout
+	}
+	return migrationTimeout
+}
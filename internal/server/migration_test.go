@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"pricingserver/internal/contracts"
+)
+
+// newSessionStorageServer serves the migrated sessions endpoints of the
+// storage service from memory and accepts every other request
+func newSessionStorageServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	sessions := make(map[string]json.RawMessage)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/simulation/sessions" {
+			w.Write([]byte("{}"))
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		ids := r.URL.Query()["id"]
+		if len(ids) == 0 {
+			for id := range sessions {
+				ids = append(ids, id)
+			}
+		}
+		switch r.Method {
+		case http.MethodPost:
+			var body struct {
+				Sessions map[string]json.RawMessage `json:"sessions"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			for id, session := range body.Sessions {
+				sessions[id] = session
+			}
+			w.Write([]byte("{}"))
+		case http.MethodGet:
+			pending := make([]string, 0)
+			for _, id := range ids {
+				if _, ok := sessions[id]; ok {
+					pending = append(pending, id)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"session_ids": pending})
+		case http.MethodDelete:
+			taken := make(map[string]json.RawMessage)
+			for _, id := range ids {
+				if session, ok := sessions[id]; ok {
+					taken[id] = session
+					delete(sessions, id)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"sessions": taken})
+		}
+	}))
+	t.Cleanup(storage.Close)
+	return storage
+}
+
+func TestMigrateHandsClientOverToAnotherHub(t *testing.T) {
+	mock := contracts.NewMockContractServer()
+	t.Cleanup(mock.Close)
+	t.Setenv("CONTRACTS_SERVICE_URL", mock.URL)
+	t.Setenv("STORAGE_SERVICE_URL", newSessionStorageServer(t).URL)
+
+	previous, previousPrices := NewHub(), newManualEmitter()
+	previous.SimulationEngine = previousPrices
+	next, nextPrices := NewHub(), newManualEmitter()
+	next.SimulationEngine = nextPrices
+
+	client := newTenantTestClient(previous, "client", "tenant-a")
+	client.SessionID = "session-1"
+	client.UserID = "user-1"
+	accepted := submitThroughClient(t, client, previousPrices, testLuckyLadder())
+	contractID, _ := accepted["contractID"].(string)
+	if accepted["type"] != MessageTypeContractAccepted || contractID == "" {
+		t.Fatalf("Submission answered %v, want ContractAccepted", accepted)
+	}
+
+	migrated := make(chan error, 1)
+	go func() { migrated <- previous.Migrate("next:8080") }()
+
+	migrate := nextMessageOfType(client, MessageTypeServerMigrate)
+	data, _ := migrate["data"].(map[string]interface{})
+	if data["address"] != "next:8080" || data["sessionID"] != "session-1" {
+		t.Fatalf("Client was sent %v, want a ServerMigrate to next:8080 with its session", migrate)
+	}
+
+	// The client reconnects to the new hub with its session ID
+	reconnected := &Client{
+		ID:        "client",
+		Hub:       next,
+		Send:      make(chan []byte, 10),
+		Contracts: make(map[string]string),
+		TenantID:  "tenant-a",
+		UserID:    "user-1",
+		SessionID: "session-2",
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("X-Last-Session-ID", data["sessionID"].(string))
+	if restored := NewReconnectHandler(next).Restore(req, reconnected); len(restored) != 1 || restored[0] != contractID {
+		t.Fatalf("The new hub restored %v, want %s", restored, contractID)
+	}
+	next.mu.Lock()
+	next.Clients[reconnected] = true
+	next.mu.Unlock()
+
+	if err := <-migrated; err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if previous.AcceptingConnections() {
+		t.Error("The previous hub still accepts connections after migrating")
+	}
+	if previousPrices.subscribed(contractID) || !nextPrices.subscribed(contractID) {
+		t.Fatalf("Contract %s is priced by the previous hub, want the new one", contractID)
+	}
+
+	nextPrices.tick(100.5)
+	for {
+		update := nextMessageOfType(reconnected, MessageTypeContractUpdate)
+		if update == nil {
+			t.Fatal("Migrated client received no price update from the new hub")
+		}
+		if state, _ := update["data"].(map[string]interface{}); state["price"] == 100.5 {
+			break
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"pricingserver/internal/common/logging"
+)
+
+// Spread types of multi-leg contracts
+const (
+	// SpreadTypeCalendar combines legs with different durations
+	SpreadTypeCalendar = "calendar"
+)
+
+// MultiLegData represents data required to create a multi-leg contract
+type MultiLegData struct {
+	Legs       []ContractData `json:"legs"`
+	SpreadType string         `json:"spreadType"`
+}
+
+// ValidateMultiLegData validates a multi-leg contract and each of its legs
+func ValidateMultiLegData(data *MultiLegData) error {
+	if len(data.Legs) < 2 {
+		return fmt.Errorf("at least two legs are required")
+	}
+	for i := range data.Legs {
+		if err := ValidateContractData(&data.Legs[i]); err != nil {
+			return fmt.Errorf("leg %d: %v", i, err)
+		}
+	}
+
+	switch data.SpreadType {
+	case "":
+		return fmt.Errorf("spreadType is required")
+	case SpreadTypeCalendar:
+		durations := make(map[int64]bool)
+		for _, leg := range data.Legs {
+			if durations[leg.Duration] {
+				return fmt.Errorf("calendar spread legs must have different durations")
+			}
+			durations[leg.Duration] = true
+		}
+	default:
+		return fmt.Errorf("unsupported spread type: %s", data.SpreadType)
+	}
+	return nil
+}
+
+// multiLegSettlement collects the final states of the legs of a multi-leg
+// contract until every leg has settled
+type multiLegSettlement struct {
+	mu      sync.Mutex
+	legIDs  []string
+	settled map[string]map[string]interface{}
+	done    bool
+}
+
+func newMultiLegSettlement(legIDs []string) *multiLegSettlement {
+	return &multiLegSettlement{
+		legIDs:  legIDs,
+		settled: make(map[string]map[string]interface{}),
+	}
+}
+
+// settle records the final state of a leg. Once every leg has settled it
+// returns the aggregated settlement, with the P&L summed across the legs.
+func (s *multiLegSettlement) settle(legID string, state map[string]interface{}) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil, false
+	}
+	s.settled[legID] = state
+	if len(s.settled) < len(s.legIDs) {
+		return nil, false
+	}
+	s.done = true
+
+	var pnl float64
+	legs := make([]map[string]interface{}, 0, len(s.legIDs))
+	for _, id := range s.legIDs {
+		legState := s.settled[id]
+		if legPnL, ok := legState["current_pnl"].(float64); ok {
+			pnl += legPnL
+		}
+		legs = append(legs, map[string]interface{}{
+			"contractID": id,
+			"data":       legState,
+		})
+	}
+	return map[string]interface{}{
+		"status": "settled",
+		"pnl":    pnl,
+		"legs":   legs,
+	}, true
+}
+
+// handleMultiLegSubmission processes multi-leg contract submissions. The legs
+// are created atomically: if any leg fails, the legs created before it are
+// cancelled. Once every leg has settled a ContractSettlement message with the
+// aggregated P&L is sent for the parent contract.
+func (c *Client) handleMultiLegSubmission(ctx context.Context, data json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var multiLeg MultiLegData
+	if err := json.Unmarshal(data, &multiLeg); err != nil {
+		logging.DebugLogContext(ctx, "Failed to unmarshal multi-leg data: %v", err)
+		c.sendError(ErrorTypeParse, "Invalid multi-leg data format")
+		return
+	}
+
+	if err := ValidateMultiLegData(&multiLeg); err != nil {
+		logging.DebugLogContext(ctx, "Multi-leg validation failed: %v", err)
+		c.sendError(ErrorTypeValidation, err.Error())
+		return
+	}
+
+	parentID := GenerateUniqueID()
+	legIDs := make([]string, len(multiLeg.Legs))
+	for i := range legIDs {
+		legIDs[i] = GenerateUniqueID()
+	}
+	logging.DebugLogContext(ctx, "Creating new %s multi-leg contract %s with legs %v", multiLeg.SpreadType, parentID, legIDs)
+
+	settlement := newMultiLegSettlement(legIDs)
+	c.trackMultiLegParent(parentID)
+
+	for i, leg := range multiLeg.Legs {
+		legID := legIDs[i]
+		params := newContractParams(leg)
+		params.Parameters["parent_contract_id"] = parentID
+
+		_, err := c.startClientContract(ctx, legID, leg, params, func(state map[string]interface{}) {
+			result, ok := settlement.settle(legID, state)
+			if !ok {
+				return
+			}
+			logging.DebugLog("Multi-leg contract %s settled with P&L %v", parentID, result["pnl"])
+			c.Hub.ContractBroadcast(parentID, map[string]interface{}{
+				"type":       MessageTypeContractSettlement,
+				"contractID": parentID,
+				"data":       result,
+			})
+			c.mu.Lock()
+			c.untrackMultiLegParent(parentID)
+			c.mu.Unlock()
+		})
+		if err != nil {
+			logging.DebugLogContext(ctx, "Failed to create leg %d of multi-leg contract %s, rolling back: %v", i, parentID, err)
+			for _, created := range legIDs[:i] {
+				c.cancelClientContract(ctx, created)
+			}
+			c.untrackMultiLegParent(parentID)
+			if err == ErrTenantLimitExceeded {
+				c.sendError(ErrorTypeRateLimit, "Tenant contract limit exceeded")
+				return
+			}
+			c.sendError(ErrorTypeValidation, fmt.Sprintf("Failed to create leg %d: %v", i, err))
+			return
+		}
+	}
+
+	for i, legID := range legIDs {
+		c.recordContractCreated(legID, multiLeg.Legs[i])
+	}
+
+	c.sendMessage(map[string]interface{}{
+		"type":       MessageTypeMultiLegAccepted,
+		"contractID": parentID,
+		"data": map[string]interface{}{
+			"contractIDs": legIDs,
+			"spreadType":  multiLeg.SpreadType,
+		},
+	})
+}
+
+// trackMultiLegParent subscribes c to the settlement of the multi-leg contract
+// parentID. The caller must hold c.mu.
+func (c *Client) trackMultiLegParent(parentID string) {
+	if c.multiLegParents == nil {
+		c.multiLegParents = make(map[string]bool)
+	}
+	c.multiLegParents[parentID] = true
+	c.Hub.subscribeClient(parentID, c)
+}
+
+// untrackMultiLegParent unsubscribes c from the settlement of the multi-leg
+// contract parentID. The caller must hold c.mu.
+func (c *Client) untrackMultiLegParent(parentID string) {
+	delete(c.multiLegParents, parentID)
+	c.Hub.unsubscribeClient(parentID, c)
+}
+
+// cancelClientContract stops a started contract owned by c and removes it
+// from the contracts service. The caller must hold c.mu.
+func (c *Client) cancelClientContract(ctx context.Context, contractID string) {
+	logging.DebugLogContext(ctx, "Cancelling contract %s", contractID)
+	c.Hub.untrackActiveContract(contractID)
+	c.Hub.unsubscribePrices(contractID)
+	delete(c.Contracts, contractID)
+	c.Hub.unsubscribeClient(contractID, c)
+	c.Hub.forgetContractTenant(contractID)
+	if err := c.Hub.Contracts.RemoveContract(contractID); err != nil {
+		logging.DebugLogContext(ctx, "Failed to remove contract %s: %v", contractID, err)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"pricingserver/internal/contracts"
+)
+
+// testCalendarSpread returns a calendar spread of two Lucky Ladder legs
+func testCalendarSpread() MultiLegData {
+	near, far := testLuckyLadder(), testLuckyLadder()
+	far.Duration *= 2
+	return MultiLegData{Legs: []ContractData{near, far}, SpreadType: SpreadTypeCalendar}
+}
+
+// submitMultiLeg submits data as client and returns the next message of
+// msgType
+func submitMultiLeg(t *testing.T, client *Client, data MultiLegData, msgType string) map[string]interface{} {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.handleMultiLegSubmission(context.Background(), raw)
+	return nextMessageOfType(client, msgType)
+}
+
+func TestMultiLegSubmissionLinksLegsToParent(t *testing.T) {
+	h, mock, _ := newServiceTestHub(t)
+	client := newTenantTestClient(h, "client", "")
+
+	accepted := submitMultiLeg(t, client, testCalendarSpread(), MessageTypeMultiLegAccepted)
+	if accepted == nil {
+		t.Fatal("Submission was not accepted")
+	}
+	parentID, _ := accepted["contractID"].(string)
+	legIDs, _ := accepted["data"].(map[string]interface{})["contractIDs"].([]interface{})
+	if parentID == "" || len(legIDs) != 2 {
+		t.Fatalf("Submission answered %v, want a parent ID and two leg IDs", accepted)
+	}
+
+	for _, request := range mock.RecordedRequests() {
+		if request.Method != http.MethodPost || request.Path != "/contracts" {
+			continue
+		}
+		var params contracts.ContractParams
+		json.Unmarshal(request.Body, &params)
+		if params.Parameters["parent_contract_id"] != parentID {
+			t.Errorf("Leg %v was created with parent %v, want %s", params.Parameters["contract_id"], params.Parameters["parent_contract_id"], parentID)
+		}
+	}
+}
+
+func TestMultiLegSecondLegFailureCancelsFirst(t *testing.T) {
+	h, mock, prices := newServiceTestHub(t)
+	client := newTenantTestClient(h, "client", "")
+
+	var added int32
+	mock.OnAddContract = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&added, 1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"detail":"second leg rejected"}`))
+			return
+		}
+		var params contracts.ContractParams
+		json.NewDecoder(r.Body).Decode(&params)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "contract_id": params.Parameters["contract_id"]})
+	}
+
+	rejected := submitMultiLeg(t, client, testCalendarSpread(), MessageTypeError)
+	if rejected == nil || !strings.HasPrefix(rejected["message"].(string), "Failed to create leg 1") {
+		t.Fatalf("Submission answered %v, want an error for the second leg", rejected)
+	}
+
+	var firstLeg string
+	removed := make(map[string]bool)
+	for _, request := range mock.RecordedRequests() {
+		switch {
+		case request.Method == http.MethodPost && request.Path == "/contracts" && firstLeg == "":
+			var params contracts.ContractParams
+			json.Unmarshal(request.Body, &params)
+			firstLeg, _ = params.Parameters["contract_id"].(string)
+		case request.Method == http.MethodDelete:
+			removed[strings.TrimPrefix(request.Path, "/contracts/")] = true
+		}
+	}
+	if firstLeg == "" {
+		t.Fatal("The first leg was never created")
+	}
+	if !removed[firstLeg] {
+		t.Errorf("The first leg %s was not removed from the contracts service", firstLeg)
+	}
+	if prices.subscribed(firstLeg) {
+		t.Errorf("The first leg %s is still subscribed to prices", firstLeg)
+	}
+	if len(client.Contracts) != 0 || len(client.multiLegParents) != 0 {
+		t.Errorf("Client still tracks contracts %v and parents %v", client.Contracts, client.multiLegParents)
+	}
+	if metrics := h.GetTenantMetrics(""); metrics.ActiveContracts != 0 {
+		t.Errorf("%d contracts are still active, want 0", metrics.ActiveContracts)
+	}
+}
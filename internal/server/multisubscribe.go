@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"pricingserver/internal/common/logging"
+)
+
+const (
+	// maxMultiSubscribeContracts caps the contracts of one MultiSubscribe
+	maxMultiSubscribeContracts = 100
+	// multiSubscribeConcurrency is the number of contract states fetched
+	// from the contracts service at the same time
+	multiSubscribeConcurrency = 10
+)
+
+// MultiSubscribeData represents the contracts of a MultiSubscribe message
+type MultiSubscribeData struct {
+	ContractIDs []string `json:"contractIDs"`
+}
+
+// multiSubscribeResult is the state lookup of one contract
+type multiSubscribeResult struct {
+	state map[string]interface{}
+	err   error
+}
+
+// fetchContractStates gets the state of every contract from the contracts
+// service, at most multiSubscribeConcurrency at a time
+func (c *Client) fetchContractStates(ctx context.Context, contractIDs []string) []multiSubscribeResult {
+	results := make([]multiSubscribeResult, len(contractIDs))
+	sem := make(chan struct{}, multiSubscribeConcurrency)
+	var wg sync.WaitGroup
+	for i, contractID := range contractIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, contractID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			state, err := c.Hub.ContractService.GetContractState(ctx, contractID)
+			results[i] = multiSubscribeResult{state: state, err: err}
+		}(i, contractID)
+	}
+	wg.Wait()
+	return results
+}
+
+// handleMultiSubscribe subscribes the client to several existing contracts
+// and replies with their initial states in a single MultiSubscribeResponse.
+// Contracts that cannot be subscribed are listed in its errors.
+func (c *Client) handleMultiSubscribe(ctx context.Context, data json.RawMessage) {
+	var subscribe MultiSubscribeData
+	if err := json.Unmarshal(data, &subscribe); err != nil {
+		logging.DebugLogContext(ctx, "Failed to parse multi-subscribe data: %v", err)
+		c.sendError(ErrorTypeParse, "Invalid multi-subscribe data format")
+		return
+	}
+	if len(subscribe.ContractIDs) == 0 {
+		c.sendError(ErrorTypeValidation, "contractIDs are required")
+		return
+	}
+	if len(subscribe.ContractIDs) > maxMultiSubscribeContracts {
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("At most %d contracts can be subscribed at once", maxMultiSubscribeContracts))
+		return
+	}
+
+	states := make(map[string]interface{})
+	errs := make(map[string]string)
+	seen := make(map[string]bool)
+	var visible []string
+	for _, contractID := range subscribe.ContractIDs {
+		if seen[contractID] {
+			continue
+		}
+		seen[contractID] = true
+		// Contracts of other tenants are reported as missing
+		if !c.Hub.contractVisibleTo(c.TenantID, contractID) {
+			errs[contractID] = "Contract not found"
+			continue
+		}
+		visible = append(visible, contractID)
+	}
+
+	results := c.fetchContractStates(ctx, visible)
+
+	c.mu.Lock()
+	for i, contractID := range visible {
+		result := results[i]
+		switch {
+		case result.err != nil:
+			logging.DebugLogContext(ctx, "Failed to get state of contract %s: %v", contractID, result.err)
+			errs[contractID] = fmt.Sprintf("Failed to get contract state: %v", result.err)
+			continue
+		case result.state == nil:
+			errs[contractID] = "Contract not found"
+			continue
+		}
+		states[contractID] = result.state
+		if status, _ := result.state["status"].(string); status != "active" {
+			continue
+		}
+		c.subscribeExistingContract(ctx, contractID, result.state)
+	}
+	c.mu.Unlock()
+
+	logging.DebugLogContext(ctx, "Client %s subscribed to %d contracts", c.ID, len(states))
+	c.sendMessage(map[string]interface{}{
+		"type": MessageTypeMultiSubscribeResponse,
+		"data": map[string]interface{}{
+			"contracts": states,
+			"errors":    errs,
+		},
+	})
+}
+
+// subscribeExistingContract delivers the updates of an active contract to
+// c. The caller must hold c.mu.
+func (c *Client) subscribeExistingContract(ctx context.Context, contractID string, state map[string]interface{}) {
+	productType, _ := state["product_type"].(string)
+	instrument, _ := state["instrument"].(string)
+	c.Contracts[contractID] = productType
+	c.Hub.subscribeClient(contractID, c)
+
+	resumed := c.Hub.resumeContract(ctx, c.TenantID, contractID, productType, instrument, func(state map[string]interface{}) {
+		c.Hub.ContractBroadcast(contractID, map[string]interface{}{
+			"type":       MessageTypeContractUpdate,
+			"contractID": contractID,
+			"data":       state,
+		})
+		if isTerminalState(state) {
+			c.forgetContract(contractID)
+			c.Hub.unsubscribeClient(contractID, c)
+		}
+	})
+	if resumed {
+		c.Hub.registerContract(contractID, c)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestMultiSubscribeSubscribesEveryContract(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	contractIDs := make([]string, 5)
+	for i := range contractIDs {
+		contractID, err := h.SubmitContract("", testLuckyLadder())
+		if err != nil {
+			t.Fatalf("SubmitContract: %v", err)
+		}
+		contractIDs[i] = contractID
+	}
+	client := newTenantTestClient(h, "client-1", "")
+
+	data, _ := json.Marshal(MultiSubscribeData{ContractIDs: append(contractIDs, "contract-unknown")})
+	client.handleMultiSubscribe(context.Background(), data)
+
+	response := nextMessageOfType(client, MessageTypeMultiSubscribeResponse)
+	if response == nil {
+		t.Fatal("Client received no MultiSubscribeResponse")
+	}
+	body, _ := response["data"].(map[string]interface{})
+	states, _ := body["contracts"].(map[string]interface{})
+	errs, _ := body["errors"].(map[string]interface{})
+	if len(states) != len(contractIDs) || errs["contract-unknown"] == nil {
+		t.Fatalf("Response lists states %v and errors %v, want the 5 contracts and an error for contract-unknown", states, errs)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, contractID := range contractIDs {
+		if states[contractID] == nil {
+			t.Errorf("Response has no state of %s", contractID)
+		}
+		if client.Contracts[contractID] != "lucky_ladder" {
+			t.Errorf("Client contracts are %v, want %s as a lucky_ladder", client.Contracts, contractID)
+		}
+	}
+	if len(client.Contracts) != len(contractIDs) {
+		t.Errorf("Client is subscribed to %d contracts, want %d", len(client.Contracts), len(contractIDs))
+	}
+}
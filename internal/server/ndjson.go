@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// contractUpdateHistorySize is how many recent updates of each contract are
+// kept for NDJSON streams resuming with Last-Event-ID
+const contractUpdateHistorySize = 100
+
+// contractUpdateEntry is a line of an NDJSON contract stream
+type contractUpdateEntry struct {
+	ID   int64                  `json:"id"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// contractUpdateLog is a ring buffer of the latest updates of a contract,
+// numbered from 1
+type contractUpdateLog struct {
+	mu      sync.Mutex
+	entries []contractUpdateEntry
+	next    int
+	lastID  int64
+}
+
+func (l *contractUpdateLog) append(state map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	entry := contractUpdateEntry{ID: l.lastID, Data: state}
+	if len(l.entries) < contractUpdateHistorySize {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % contractUpdateHistorySize
+}
+
+// since returns the kept updates with an ID above id, oldest first
+func (l *contractUpdateLog) since(id int64) []contractUpdateEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []contractUpdateEntry
+	for i := range l.entries {
+		entry := l.entries[(l.next+i)%len(l.entries)]
+		if entry.ID > id {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (l *contractUpdateLog) last() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID
+}
+
+// contractUpdateLog returns the update log of contractID, creating it if
+// needed
+func (h *Hub) contractUpdateLog(contractID string) *contractUpdateLog {
+	h.updateLogsMu.Lock()
+	defer h.updateLogsMu.Unlock()
+	log, ok := h.updateLogs[contractID]
+	if !ok {
+		log = &contractUpdateLog{}
+		h.updateLogs[contractID] = log
+	}
+	return log
+}
+
+// recordContractUpdate adds a state to the update log of a contract. Logs
+// of settled contracts are dropped after apiContractRetention.
+func (h *Hub) recordContractUpdate(contractID string, state map[string]interface{}) {
+	log := h.contractUpdateLog(contractID)
+	log.append(state)
+	if isTerminalState(state) {
+		time.AfterFunc(apiContractRetention, func() {
+			h.updateLogsMu.Lock()
+			if h.updateLogs[contractID] == log {
+				delete(h.updateLogs, contractID)
+			}
+			h.updateLogsMu.Unlock()
+		})
+	}
+}
+
+// ServeContractStream streams the updates of a contract as newline-delimited
+// JSON on GET /api/contracts/{id}/stream, one {"id": n, "data": state} line
+// per update, starting with the current state. Clients resuming with a
+// Last-Event-ID header receive the kept updates after that ID instead. The
+// stream ends once the contract reaches a terminal state. Contracts not
+// visible to tenantID are not found.
+func (h *Hub) ServeContractStream(w http.ResponseWriter, r *http.Request, tenantID, contractID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, resume := int64(0), false
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID, resume = id, true
+	}
+
+	// Listen before fetching the initial state so no update is missed
+	wake := make(chan struct{}, 1)
+	removeListener := h.AddContractListener(contractID, func(state map[string]interface{}) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+	defer removeListener()
+
+	updates := h.contractUpdateLog(contractID)
+	currentID := updates.last()
+	state, err := h.GetContractState(tenantID, contractID)
+	if err == ErrContractNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	if !resume {
+		lastID = currentID
+		if err := encoder.Encode(contractUpdateEntry{ID: lastID, Data: state}); err != nil {
+			logging.DebugLog("Failed to write NDJSON update for contract %s: %v", contractID, err)
+			return
+		}
+		flusher.Flush()
+		if isTerminalState(state) {
+			return
+		}
+	}
+
+	for {
+		for _, entry := range updates.since(lastID) {
+			if err := encoder.Encode(entry); err != nil {
+				logging.DebugLog("Failed to write NDJSON update for contract %s: %v", contractID, err)
+				return
+			}
+			lastID = entry.ID
+			if isTerminalState(entry.Data) {
+				flusher.Flush()
+				logging.DebugLog("Contract %s reached a terminal state, closing NDJSON stream", contractID)
+				return
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-wake:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
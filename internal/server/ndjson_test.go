@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrentNDJSONStreamsReceiveIdenticalUpdates(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeContractStream(w, r, "", strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	t.Cleanup(ts.Close)
+	contractID, err := h.SubmitContract("", testLuckyLadder())
+	if err != nil {
+		t.Fatalf("SubmitContract: %v", err)
+	}
+
+	// The timeout ends a stream if the terminal state never comes
+	client := &http.Client{Timeout: 5 * time.Second}
+	open := func(lastEventID int64) *bufio.Reader {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/"+contractID, nil)
+		if lastEventID > 0 {
+			req.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventID, 10))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("Stream answered %d with Content-Type %q, want 200 and application/x-ndjson", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body)
+	}
+	readLines := func(stream *bufio.Reader) []contractUpdateEntry {
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("Stream ended with %v, want the terminal state", err)
+		}
+		var entries []contractUpdateEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var entry contractUpdateEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Line %q is not JSON: %v", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	streams := []*bufio.Reader{open(0), open(0)}
+	// Both streams start with the current state before prices move
+	for _, stream := range streams {
+		if _, err := stream.Peek(1); err != nil {
+			t.Fatalf("Stream sent no initial state: %v", err)
+		}
+	}
+	prices.tick(101.5)
+	prices.tick(102.5)
+	h.contractStateHandler(context.Background(), contractID, func(map[string]interface{}) {})(map[string]interface{}{
+		"contractID": contractID,
+		"status":     "expired",
+	})
+
+	first, second := readLines(streams[0]), readLines(streams[1])
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("Streams received %v and %v, want identical updates", first, second)
+	}
+	if len(first) != 4 || first[1].Data["price"] != 101.5 || first[2].Data["price"] != 102.5 || first[3].Data["status"] != "expired" {
+		t.Fatalf("Streams received %v, want the initial state, both prices and the expired state", first)
+	}
+
+	// Resuming streams receive the kept updates after Last-Event-ID
+	if resumed := readLines(open(first[1].ID)); !reflect.DeepEqual(resumed, first[2:]) {
+		t.Errorf("Stream resumed after %d received %v, want %v", first[1].ID, resumed, first[2:])
+	}
+}
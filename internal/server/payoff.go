@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"math"
+
+	"pricingserver/internal/contracts"
+)
+
+// PayoffFunc computes the payoff of a contract from its final state. Besides
+// the fields reported by the contracts service, the state holds the
+// contract's effective "payoff" and, depending on the product,
+// "target_movement".
+type PayoffFunc func(state map[string]interface{}) float64
+
+// Payoff function names
+const (
+	PayoffFixed                  = "fixed"
+	PayoffProportionalToMovement = "proportional_to_movement"
+	PayoffPerRung                = "per_rung"
+	PayoffLinearPnL              = "linear_pnl"
+)
+
+// PayoffRegistry holds the payoff functions contracts can settle with
+var PayoffRegistry = map[string]PayoffFunc{
+	// The flat payoff, paid when the target is hit or any rung is hit
+	PayoffFixed: func(state map[string]interface{}) float64 {
+		status, _ := state["status"].(string)
+		if status == "target_hit" || len(toFloatSlice(state["all_rungs_hit"])) > 0 {
+			return toFloat(state["payoff"])
+		}
+		return 0
+	},
+	// A share of the payoff proportional to the largest movement relative to
+	// the target, capped at the full payoff
+	PayoffProportionalToMovement: func(state map[string]interface{}) float64 {
+		target := math.Abs(toFloat(state["target_movement"]))
+		if target == 0 {
+			return 0
+		}
+		return toFloat(state["payoff"]) * math.Min(toFloat(state["max_movement"])/target, 1)
+	},
+	// The payoff once for every rung hit
+	PayoffPerRung: func(state map[string]interface{}) float64 {
+		return toFloat(state["payoff"]) * float64(len(toFloatSlice(state["all_rungs_hit"])))
+	},
+	// The mark-to-market P&L of the position
+	PayoffLinearPnL: func(state map[string]interface{}) float64 {
+		return toFloat(state["current_pnl"])
+	},
+}
+
+// payoffProductTypes restricts payoff functions to the product types they
+// apply to. Functions that are not listed apply to every product.
+var payoffProductTypes = map[string]string{
+	PayoffProportionalToMovement: "MomentumCatcher",
+	PayoffPerRung:                "LuckyLadder",
+}
+
+// validatePayoffFunction checks that a contract's payoff function exists and
+// applies to its product type
+func validatePayoffFunction(data *ContractData) error {
+	if data.PayoffFunction == "" {
+		return nil
+	}
+	if _, ok := PayoffRegistry[data.PayoffFunction]; !ok {
+		return fmt.Errorf("unsupported payoff function: %s", data.PayoffFunction)
+	}
+	if productType, ok := payoffProductTypes[data.PayoffFunction]; ok && productType != data.ProductType {
+		return fmt.Errorf("payoff function %s requires %s", data.PayoffFunction, productType)
+	}
+	return nil
+}
+
+// withSettledPayoff wraps onUpdate so terminal states carry "settled_payoff",
+// the payoff of the contract computed by its payoff function
+func withSettledPayoff(params contracts.ContractParams, onUpdate func(state map[string]interface{})) func(state map[string]interface{}) {
+	name, _ := params.Parameters["payoff_function"].(string)
+	if name == "" {
+		name = PayoffFixed
+	}
+	payoffFunc := PayoffRegistry[name]
+	if payoffFunc == nil {
+		return onUpdate
+	}
+
+	payoff, _ := params.Parameters["payoff"].(float64)
+	notional, _ := params.Parameters["notional"].(float64)
+	payoffPerUnit, _ := params.Parameters["payoff_per_unit"].(float64)
+	maxPayoff, _ := params.Parameters["max_payoff"].(float64)
+	defaults := map[string]interface{}{
+		"payoff": scaledPayoff(payoff, notional, payoffPerUnit, maxPayoff),
+	}
+	if target, ok := params.Parameters["target_movement"]; ok {
+		defaults["target_movement"] = target
+	}
+
+	return func(state map[string]interface{}) {
+		if isTerminalState(state) {
+			input := make(map[string]interface{}, len(state)+len(defaults))
+			for key, value := range defaults {
+				input[key] = value
+			}
+			for key, value := range state {
+				input[key] = value
+			}
+			state["settled_payoff"] = payoffFunc(input)
+		}
+		onUpdate(state)
+	}
+}
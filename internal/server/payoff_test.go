@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+
+	"pricingserver/internal/contracts"
+)
+
+func TestPayoffFunctionsOfKnownStates(t *testing.T) {
+	for _, tc := range []struct {
+		function string
+		state    map[string]interface{}
+		payoff   float64
+	}{
+		{PayoffFixed, map[string]interface{}{"status": "target_hit", "payoff": 10.0}, 10},
+		{PayoffFixed, map[string]interface{}{"status": "expired", "payoff": 10.0, "all_rungs_hit": []interface{}{101.0}}, 10},
+		{PayoffFixed, map[string]interface{}{"status": "expired", "payoff": 10.0}, 0},
+		{PayoffProportionalToMovement, map[string]interface{}{"payoff": 10.0, "target_movement": 4.0, "max_movement": 1.0}, 2.5},
+		{PayoffProportionalToMovement, map[string]interface{}{"payoff": 10.0, "target_movement": -4.0, "max_movement": 6.0}, 10},
+		{PayoffPerRung, map[string]interface{}{"payoff": 10.0, "all_rungs_hit": []interface{}{101.0, 102.0, 103.0}}, 30},
+		{PayoffPerRung, map[string]interface{}{"payoff": 10.0}, 0},
+		{PayoffLinearPnL, map[string]interface{}{"payoff": 10.0, "current_pnl": -3.5}, -3.5},
+	} {
+		if payoff := PayoffRegistry[tc.function](tc.state); payoff != tc.payoff {
+			t.Errorf("%s payoff of %v is %v, want %v", tc.function, tc.state, payoff, tc.payoff)
+		}
+	}
+}
+
+func TestTerminalStatesCarryTheSettledPayoff(t *testing.T) {
+	params := contracts.ContractParams{
+		ContractType: "lucky_ladder",
+		Parameters:   map[string]interface{}{"payoff": 10.0, "payoff_function": PayoffPerRung},
+	}
+	var updates []map[string]interface{}
+	onUpdate := withSettledPayoff(params, func(state map[string]interface{}) { updates = append(updates, state) })
+
+	onUpdate(map[string]interface{}{"status": "active", "all_rungs_hit": []interface{}{101.0}})
+	onUpdate(map[string]interface{}{"status": "expired", "all_rungs_hit": []interface{}{101.0, 102.0}})
+	if _, ok := updates[0]["settled_payoff"]; ok {
+		t.Errorf("Active state %v carries a settled payoff", updates[0])
+	}
+	if payoff := updates[1]["settled_payoff"]; payoff != 20.0 {
+		t.Errorf("Expired state settled at %v, want 20 for two rungs", payoff)
+	}
+
+	data := ContractData{ProductType: "MomentumCatcher", PayoffFunction: PayoffPerRung}
+	if err := validatePayoffFunction(&data); err == nil {
+		t.Errorf("validatePayoffFunction accepted %s for a MomentumCatcher", PayoffPerRung)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/simulation"
+)
+
+// Precondition types
+const (
+	PreconditionPriceBelow = "price_below"
+	PreconditionPriceAbove = "price_above"
+)
+
+// statusPreconditionTimeout is the terminal status of a pending contract
+// whose precondition was not met in time
+const statusPreconditionTimeout = "precondition_timeout"
+
+// PreconditionSpec makes a submitted contract wait for a price condition
+// before it starts, e.g. {"type": "price_below", "threshold": 95,
+// "timeoutMs": 60000}
+type PreconditionSpec struct {
+	Type      string  `json:"type"`
+	Threshold float64 `json:"threshold"`
+	// TimeoutMs is how long the contract waits for the condition before it
+	// settles with status precondition_timeout
+	TimeoutMs int64 `json:"timeoutMs"`
+}
+
+// validatePrecondition checks the precondition of a contract submission
+func validatePrecondition(spec *PreconditionSpec) error {
+	if spec.Type != PreconditionPriceBelow && spec.Type != PreconditionPriceAbove {
+		return fmt.Errorf("unsupported precondition type: %s", spec.Type)
+	}
+	if spec.Threshold <= 0 {
+		return fmt.Errorf("precondition threshold must be positive")
+	}
+	if spec.TimeoutMs <= 0 {
+		return fmt.Errorf("precondition timeoutMs must be positive")
+	}
+	return nil
+}
+
+// met reports whether price satisfies the precondition
+func (spec PreconditionSpec) met(price float64) bool {
+	if spec.Type == PreconditionPriceBelow {
+		return price < spec.Threshold
+	}
+	return price > spec.Threshold
+}
+
+// PendingContractProxy holds the prices of a pending contract back until its
+// precondition is met, then forwards them to the contract, which starts with
+// the first price it receives. If the precondition is not met within its
+// timeout, onTimeout is called instead and no price is ever forwarded.
+type PendingContractProxy struct {
+	contractID   string
+	precondition PreconditionSpec
+	handler      simulation.PriceHandler
+	onTimeout    func()
+
+	mu        sync.Mutex
+	activated bool
+	expired   bool
+	timer     *time.Timer
+}
+
+// NewPendingContractProxy creates a PendingContractProxy forwarding prices
+// to handler and starts its timeout
+func NewPendingContractProxy(contractID string, precondition PreconditionSpec, handler simulation.PriceHandler, onTimeout func()) *PendingContractProxy {
+	p := &PendingContractProxy{
+		contractID:   contractID,
+		precondition: precondition,
+		handler:      handler,
+		onTimeout:    onTimeout,
+	}
+	p.timer = time.AfterFunc(time.Duration(precondition.TimeoutMs)*time.Millisecond, p.expire)
+	return p
+}
+
+// HandlePriceUpdate implements simulation.PriceHandler
+func (p *PendingContractProxy) HandlePriceUpdate(price float64, timestamp time.Time) {
+	p.mu.Lock()
+	if !p.activated {
+		if p.expired || !p.precondition.met(price) {
+			p.mu.Unlock()
+			return
+		}
+		p.activated = true
+		p.timer.Stop()
+		logging.DebugLog("Precondition %s %f of contract %s met at %f, activating", p.precondition.Type, p.precondition.Threshold, p.contractID, price)
+	}
+	p.mu.Unlock()
+	p.handler.HandlePriceUpdate(price, timestamp)
+}
+
+// Activated reports whether the precondition was met
+func (p *PendingContractProxy) Activated() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.activated
+}
+
+func (p *PendingContractProxy) expire() {
+	p.mu.Lock()
+	if p.activated {
+		p.mu.Unlock()
+		return
+	}
+	p.expired = true
+	p.mu.Unlock()
+	p.onTimeout()
+}
+
+// expirePendingContract settles a pending contract whose precondition timed
+// out through handleState and removes it from the contracts service
+func (h *Hub) expirePendingContract(ctx context.Context, contractID string, precondition PreconditionSpec, handleState func(state map[string]interface{})) {
+	logging.DebugLogContext(ctx, "Precondition of contract %s not met within %dms", contractID, precondition.TimeoutMs)
+	handleState(map[string]interface{}{
+		"contractID":   contractID,
+		"status":       statusPreconditionTimeout,
+		"precondition": precondition,
+		"timestamp":    time.Now().Format(time.RFC3339),
+	})
+	if err := h.Contracts.RemoveContract(contractID); err != nil {
+		logging.DebugLogContext(ctx, "Failed to remove expired pending contract %s: %v", contractID, err)
+	}
+}
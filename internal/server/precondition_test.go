@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"pricingserver/internal/contracts"
+)
+
+// priceUpdatesSent counts the prices forwarded to the contracts service for
+// contractID
+func priceUpdatesSent(mock *contracts.MockContractServer, contractID string) int {
+	sent := 0
+	for _, request := range mock.RecordedRequests() {
+		if request.Method == http.MethodPost && request.Path == "/contracts/"+contractID+"/price-update" {
+			sent++
+		}
+	}
+	return sent
+}
+
+func TestPendingContractActivatesWhenPriceDropsBelowThreshold(t *testing.T) {
+	h, mock, prices := newServiceTestHub(t)
+	client := newTenantTestClient(h, "client", "")
+	data := testLuckyLadder()
+	data.Precondition = &PreconditionSpec{Type: PreconditionPriceBelow, Threshold: 95, TimeoutMs: 60000}
+
+	// Submission ticks at 100, above the threshold
+	accepted := submitThroughClient(t, client, prices, data)
+	contractID, _ := accepted["contractID"].(string)
+	if accepted["type"] != MessageTypeContractAccepted || accepted["status"] != "pending" {
+		t.Fatalf("Submission answered %v, want a pending ContractAccepted", accepted)
+	}
+	for _, request := range mock.RecordedRequests() {
+		if request.Method == http.MethodPost && request.Path == "/contracts" {
+			var params contracts.ContractParams
+			json.Unmarshal(request.Body, &params)
+			if params.Parameters["pending"] != true {
+				t.Errorf("Contract was created with %v, want it pending", params.Parameters)
+			}
+		}
+	}
+	prices.tick(96)
+	if sent := priceUpdatesSent(mock, contractID); sent != 0 {
+		t.Fatalf("%d prices above the threshold reached the pending contract", sent)
+	}
+
+	prices.tick(94)
+	update := nextUpdateAt(t, client, 94)
+	if state, _ := update["data"].(map[string]interface{}); state["status"] != "active" {
+		t.Fatalf("Contract was updated with %v at 94, want it active", update["data"])
+	}
+	if sent := priceUpdatesSent(mock, contractID); sent != 1 {
+		t.Errorf("%d prices reached the contract, want the one at 94", sent)
+	}
+}
+
+func TestPendingContractSettlesWhenPreconditionTimesOut(t *testing.T) {
+	h, mock, prices := newServiceTestHub(t)
+	client := newTenantTestClient(h, "client", "")
+	data := testLuckyLadder()
+	data.Precondition = &PreconditionSpec{Type: PreconditionPriceBelow, Threshold: 95, TimeoutMs: 100}
+
+	accepted := submitThroughClient(t, client, prices, data)
+	contractID, _ := accepted["contractID"].(string)
+	if accepted["type"] != MessageTypeContractAccepted || contractID == "" {
+		t.Fatalf("Submission answered %v, want ContractAccepted", accepted)
+	}
+
+	update := nextMessageOfType(client, MessageTypeContractUpdate)
+	if state, _ := update["data"].(map[string]interface{}); update == nil || state["status"] != statusPreconditionTimeout {
+		t.Fatalf("Contract was updated with %v, want status %s", update, statusPreconditionTimeout)
+	}
+	if prices.subscribed(contractID) {
+		t.Error("Expired pending contract is still subscribed to prices")
+	}
+	waitFor(t, "the contract to be removed", func() bool {
+		for _, request := range mock.RecordedRequests() {
+			if request.Method == http.MethodDelete && strings.HasSuffix(request.Path, "/"+contractID) {
+				return true
+			}
+		}
+		return false
+	})
+}
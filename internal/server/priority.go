@@ -0,0 +1,82 @@
+package server
+
+import (
+	"container/heap"
+)
+
+// Broadcast priorities. Lower priorities are delivered first.
+const (
+	PriorityAdmin          = 0
+	PriorityContractUpdate = 10
+)
+
+// priorityMessage is a message waiting in the hub's priority queue
+type priorityMessage struct {
+	priority int
+	seq      uint64
+	msg      []byte
+}
+
+// priorityQueue is a min-heap of messages ordered by priority, then by the
+// order they were queued in
+type priorityQueue []priorityMessage
+
+func (q priorityQueue) Len() int { return len(q) }
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *priorityQueue) Push(x interface{}) { *q = append(*q, x.(priorityMessage)) }
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = priorityMessage{}
+	*q = old[:n-1]
+	return item
+}
+
+// PriorityBroadcast queues an encoded message for every connected client.
+// The hub delivers queued messages lowest priority first, before any message
+// sent on Broadcast, so system-wide messages sent with PriorityAdmin are not
+// held up by contract updates.
+func (h *Hub) PriorityBroadcast(priority int, msg []byte) {
+	h.priorityMu.Lock()
+	h.prioritySeq++
+	heap.Push(&h.priorityQueue, priorityMessage{priority: priority, seq: h.prioritySeq, msg: msg})
+	h.priorityMu.Unlock()
+
+	select {
+	case h.priorityReady <- struct{}{}:
+	default:
+	}
+}
+
+// popPriorityMessage removes the next message from the priority queue
+func (h *Hub) popPriorityMessage() ([]byte, bool) {
+	h.priorityMu.Lock()
+	defer h.priorityMu.Unlock()
+	if h.priorityQueue.Len() == 0 {
+		return nil, false
+	}
+	return heap.Pop(&h.priorityQueue).(priorityMessage).msg, true
+}
+
+// drainPriorityBroadcasts delivers every queued priority message. Messages
+// queued while draining are delivered in priority order too.
+func (h *Hub) drainPriorityBroadcasts() {
+	for {
+		msg, ok := h.popPriorityMessage()
+		if !ok {
+			return
+		}
+		h.broadcastToAll(msg)
+	}
+}
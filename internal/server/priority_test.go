@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPriorityBroadcastDeliversAdminMessageBeforeQueuedUpdates(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	const updates = 1000
+	client := &Client{ID: "client", Hub: h, Send: make(chan []byte, updates+1), Contracts: make(map[string]string)}
+	h.Clients[client] = true
+
+	for i := 0; i < updates; i++ {
+		h.PriorityBroadcast(PriorityContractUpdate, []byte(fmt.Sprintf(`{"type":"ContractUpdate","seq":%d}`, i)))
+	}
+	admin := `{"type":"ServerShutdown"}`
+	h.PriorityBroadcast(PriorityAdmin, []byte(admin))
+	go h.Run()
+
+	timeout := time.After(5 * time.Second)
+	for received := 0; received <= updates; received++ {
+		select {
+		case frame := <-client.Send:
+			if string(frame) != admin {
+				continue
+			}
+			if received > updates/10 {
+				t.Fatalf("Admin message was delivered after %d of %d updates, want before at least 90%% of them", received, updates)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("Admin message was not delivered after %d messages", received)
+		}
+	}
+	t.Fatal("Admin message was never delivered")
+}
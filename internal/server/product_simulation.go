@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/simulation"
+)
+
+// productSimulationSection prefixes the config file sections that configure
+// the simulation of a contract type, e.g. [product_simulation.lucky_ladder]
+const productSimulationSection = "product_simulation."
+
+// LoadProductSimulationOverrides reads the [product_simulation.<type>]
+// sections of a TOML-style config file, where <type> is a contracts service
+// type such as lucky_ladder. Each section holds key = value lines overriding
+// the environment configuration, e.g. volatility = 0.02. Other sections are
+// ignored.
+func LoadProductSimulationOverrides(path string) (map[string]simulation.SimulationConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	overrides := make(map[string]simulation.SimulationConfig)
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if contractType := strings.TrimPrefix(section, productSimulationSection); contractType != section {
+				if _, ok := overrides[contractType]; !ok {
+					overrides[contractType] = simulation.SimulationConfigFromEnv()
+				}
+			}
+			continue
+		}
+		contractType := strings.TrimPrefix(section, productSimulationSection)
+		if contractType == section {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNumber)
+		}
+		config := overrides[contractType]
+		if err := setSimulationOption(&config, strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"`)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		overrides[contractType] = config
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// setSimulationOption sets the option of config named key
+func setSimulationOption(config *simulation.SimulationConfig, key, value string) error {
+	if key == "process" {
+		process := simulation.ProcessType(value)
+		if process != simulation.ProcessGBM && process != simulation.ProcessHeston {
+			return fmt.Errorf("unsupported process %q", value)
+		}
+		config.Process = process
+		return nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q", key, value)
+	}
+	switch key {
+	case "tick_interval_ms":
+		config.TickInterval = time.Duration(number * float64(time.Millisecond))
+	case "base_price":
+		config.BasePrice = number
+	case "drift":
+		config.Drift = number
+	case "volatility":
+		config.Volatility = number
+	case "spread":
+		config.Spread = number
+	case "heston_kappa":
+		config.HestonKappa = number
+	case "heston_theta":
+		config.HestonTheta = number
+	case "heston_xi":
+		config.HestonXi = number
+	case "heston_rho":
+		config.HestonRho = number
+	case "warmup_ticks":
+		config.WarmupTicks = int(number)
+	default:
+		return fmt.Errorf("unknown option %s", key)
+	}
+	return nil
+}
+
+// productEngine returns the engine pricing the contracts of contractType when
+// ProductSimulationOverrides configures one, creating it on first use.
+// External price feeds price every contract. Callers must hold enginesMu.
+func (h *Hub) productEngine(contractType string) (*simulation.SimulationEngine, bool) {
+	if _, simulated := h.SimulationEngine.(*simulation.SimulationEngine); !simulated {
+		return nil, false
+	}
+	if engine, ok := h.productEngines[contractType]; ok {
+		return engine, true
+	}
+	config, ok := h.ProductSimulationOverrides[contractType]
+	if !ok {
+		return nil, false
+	}
+	engine := simulation.NewSimulationEngineWithConfig(config)
+	logging.DebugLog("Starting simulation engine for %s contracts with volatility %f", contractType, engine.Volatility)
+	engine.Start()
+	if h.productEngines == nil {
+		h.productEngines = make(map[string]*simulation.SimulationEngine)
+	}
+	h.productEngines[contractType] = engine
+	return engine, true
+}
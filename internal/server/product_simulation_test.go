@@ -0,0 +1,81 @@
+package server
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pricingserver/internal/simulation"
+)
+
+// priceCollector records the prices a contract receives
+type priceCollector struct {
+	prices chan float64
+}
+
+func (c priceCollector) HandlePriceUpdate(price float64, timestamp time.Time) {
+	select {
+	case c.prices <- price:
+	default:
+	}
+}
+
+func TestProductTypesArePricedWithTheirOwnVolatility(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.toml")
+	os.WriteFile(path, []byte(`
+[server]
+port = 8080
+
+[product_simulation.lucky_ladder]
+volatility = 0.05
+tick_interval_ms = 1
+
+[product_simulation.momentum_catcher]
+volatility = 0.005
+tick_interval_ms = 1
+`), 0600)
+	overrides, err := LoadProductSimulationOverrides(path)
+	if err != nil {
+		t.Fatalf("LoadProductSimulationOverrides: %v", err)
+	}
+	if len(overrides) != 2 || overrides["lucky_ladder"].Volatility != 0.05 || overrides["momentum_catcher"].TickInterval != time.Millisecond {
+		t.Fatalf("Loaded overrides %+v, want lucky_ladder and momentum_catcher", overrides)
+	}
+
+	h, _, _ := newServiceTestHub(t)
+	// Overrides only apply to simulated prices
+	h.SimulationEngine = simulation.NewSimulationEngine()
+	h.ProductSimulationOverrides = overrides
+	volatilities := make(map[string]float64)
+	for _, contractType := range []string{"lucky_ladder", "momentum_catcher"} {
+		collector := priceCollector{prices: make(chan float64, 101)}
+		h.subscribePrices("", "contract-"+contractType, contractType, "", 0, 0, collector)
+		prices := make([]float64, cap(collector.prices))
+		for i := range prices {
+			select {
+			case prices[i] = <-collector.prices:
+			case <-time.After(time.Second):
+				t.Fatalf("%s contract received %d prices, want %d", contractType, i, len(prices))
+			}
+		}
+		var squares float64
+		for i := 1; i < len(prices); i++ {
+			r := math.Log(prices[i] / prices[i-1])
+			squares += r * r
+		}
+		volatilities[contractType] = math.Sqrt(squares / float64(len(prices)-1))
+	}
+	h.enginesMu.Lock()
+	for _, engine := range h.productEngines {
+		engine.Stop()
+	}
+	h.enginesMu.Unlock()
+
+	// Larger movements reach the targets of contracts sooner
+	if volatilities["lucky_ladder"] < 5*volatilities["momentum_catcher"] {
+		t.Errorf("lucky_ladder prices move by %g and momentum_catcher prices by %g per tick, want lucky_ladder 10 times as volatile",
+			volatilities["lucky_ladder"], volatilities["momentum_catcher"])
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	pricingpb "pricingserver/internal/proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtobufSerializer encodes messages as pricing.v1.Envelope protobuf
+// messages. Outgoing protocol messages are converted to their typed
+// counterparts and incoming envelopes are converted back to a Message with
+// JSON data, so the existing handlers can process them unchanged.
+type ProtobufSerializer struct{}
+
+// Marshal implements Serializer
+func (ProtobufSerializer) Marshal(v interface{}) ([]byte, error) {
+	switch msg := v.(type) {
+	case proto.Message:
+		return proto.Marshal(msg)
+	case ErrorResponse:
+		return proto.Marshal(&pricingpb.Envelope{Payload: &pricingpb.Envelope_Error{
+			Error: &pricingpb.ErrorResponse{ErrorType: msg.ErrorType, Message: msg.Message},
+		}})
+	case map[string]interface{}:
+		envelope, err := envelopeFromMap(msg)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(envelope)
+	default:
+		return nil, fmt.Errorf("protobuf serializer cannot encode %T", v)
+	}
+}
+
+// Unmarshal implements Serializer
+func (ProtobufSerializer) Unmarshal(data []byte, v interface{}) error {
+	switch target := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, target)
+	case *Message:
+		var envelope pricingpb.Envelope
+		if err := proto.Unmarshal(data, &envelope); err != nil {
+			return err
+		}
+		return messageFromEnvelope(&envelope, target)
+	default:
+		return fmt.Errorf("protobuf serializer cannot decode into %T", v)
+	}
+}
+
+func envelopeFromMap(msg map[string]interface{}) (*pricingpb.Envelope, error) {
+	msgType, _ := msg["type"].(string)
+	contractID, _ := msg["contractID"].(string)
+
+	switch msgType {
+	case MessageTypeContractAccepted:
+		return &pricingpb.Envelope{Payload: &pricingpb.Envelope_ContractAccepted{
+			ContractAccepted: &pricingpb.ContractAccepted{ContractId: contractID},
+		}}, nil
+	case MessageTypeContractUpdate:
+		state, _ := msg["data"].(map[string]interface{})
+		update := contractUpdateFromState(state)
+		if update.ContractId == "" {
+			update.ContractId = contractID
+		}
+		return &pricingpb.Envelope{Payload: &pricingpb.Envelope_ContractUpdate{
+			ContractUpdate: update,
+		}}, nil
+	default:
+		return nil, fmt.Errorf("protobuf serializer does not support message type: %s", msgType)
+	}
+}
+
+// contractUpdateFromState maps a contract state returned by the contracts
+// service onto the typed ContractUpdate message
+func contractUpdateFromState(state map[string]interface{}) *pricingpb.ContractUpdate {
+	update := &pricingpb.ContractUpdate{}
+	if state == nil {
+		return update
+	}
+
+	update.ContractId, _ = state["contractID"].(string)
+	update.Status, _ = state["status"].(string)
+	update.Timestamp, _ = state["timestamp"].(string)
+	update.ProductType, _ = state["product_type"].(string)
+	update.Price = toFloat(state["price"])
+	update.ElapsedMs = int64(toFloat(state["elapsed_ms"]))
+	update.Duration = int64(toFloat(state["duration"]))
+	update.Movement = toFloat(state["movement"])
+	update.MaxMovement = toFloat(state["max_movement"])
+	update.TargetMovement = toFloat(state["target_movement"])
+	update.TargetHit, _ = state["target_hit"].(bool)
+	update.RungsHit = toFloatSlice(state["rungs_hit"])
+	update.AllRungsHit = toFloatSlice(state["all_rungs_hit"])
+	update.RemainingRungs = toFloatSlice(state["remaining_rungs"])
+	return update
+}
+
+func messageFromEnvelope(envelope *pricingpb.Envelope, msg *Message) error {
+	switch payload := envelope.Payload.(type) {
+	case *pricingpb.Envelope_ContractSubmission:
+		submission := payload.ContractSubmission
+		data, err := json.Marshal(ContractData{
+			ProductType:    submission.GetProductType(),
+			Rungs:          submission.GetRungs(),
+			TargetMovement: submission.GetTargetMovement(),
+			Duration:       submission.GetDuration(),
+			Payoff:         submission.GetPayoff(),
+		})
+		if err != nil {
+			return err
+		}
+		*msg = Message{Type: MessageTypeContractSubmission, Data: data}
+	case *pricingpb.Envelope_ContractQuery:
+		*msg = Message{Type: MessageTypeContractQuery, ContractID: payload.ContractQuery.GetContractId()}
+	default:
+		return fmt.Errorf("unsupported protobuf payload: %T", envelope.Payload)
+	}
+	return nil
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	}
+	return 0
+}
+
+func toFloatSlice(v interface{}) []float64 {
+	switch values := v.(type) {
+	case []float64:
+		return values
+	case []interface{}:
+		result := make([]float64, 0, len(values))
+		for _, value := range values {
+			result = append(result, toFloat(value))
+		}
+		return result
+	}
+	return nil
+}
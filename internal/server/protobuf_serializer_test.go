@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	pricingpb "pricingserver/internal/proto"
+)
+
+func TestProtobufContractUpdateIsSmallerThanJSON(t *testing.T) {
+	update := map[string]interface{}{
+		"type":       MessageTypeContractUpdate,
+		"contractID": "contract-1",
+		"data": map[string]interface{}{
+			"contractID":      "contract-1",
+			"status":          "active",
+			"timestamp":       "2024-01-02T03:04:05Z",
+			"product_type":    "lucky_ladder",
+			"price":           101.25,
+			"elapsed_ms":      float64(1500),
+			"duration":        float64(60000),
+			"rungs_hit":       []interface{}{101.0},
+			"all_rungs_hit":   []interface{}{101.0},
+			"remaining_rungs": []interface{}{102.0, 103.0},
+		},
+	}
+	encoded, err := ProtobufSerializer{}.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonEncoded, _ := json.Marshal(update)
+	if len(encoded) >= len(jsonEncoded) {
+		t.Errorf("Protobuf update is %d bytes, want fewer than the %d bytes of JSON", len(encoded), len(jsonEncoded))
+	}
+
+	var envelope pricingpb.Envelope
+	if err := (ProtobufSerializer{}).Unmarshal(encoded, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	decoded := envelope.GetContractUpdate()
+	if decoded == nil {
+		t.Fatalf("Decoded %v, want a ContractUpdate", envelope.Payload)
+	}
+	if decoded.GetContractId() != "contract-1" || decoded.GetStatus() != "active" || decoded.GetProductType() != "lucky_ladder" ||
+		decoded.GetTimestamp() != "2024-01-02T03:04:05Z" || decoded.GetPrice() != 101.25 ||
+		decoded.GetElapsedMs() != 1500 || decoded.GetDuration() != 60000 {
+		t.Errorf("Decoded %v, want the fields of the JSON update", decoded)
+	}
+	if !reflect.DeepEqual(decoded.GetRungsHit(), []float64{101}) || !reflect.DeepEqual(decoded.GetRemainingRungs(), []float64{102, 103}) {
+		t.Errorf("Decoded rungs hit %v and remaining %v, want [101] and [102 103]", decoded.GetRungsHit(), decoded.GetRemainingRungs())
+	}
+}
+
+func TestProtobufSerializerDecodesSubmissionEnvelope(t *testing.T) {
+	encoded, err := ProtobufSerializer{}.Marshal(&pricingpb.Envelope{Payload: &pricingpb.Envelope_ContractSubmission{
+		ContractSubmission: &pricingpb.ContractSubmission{
+			ProductType: "LuckyLadder",
+			Rungs:       []float64{101, 102, 103},
+			Duration:    60000,
+			Payoff:      10,
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if err := (ProtobufSerializer{}).Unmarshal(encoded, &msg); err != nil {
+		t.Fatal(err)
+	}
+	var data ContractData
+	json.Unmarshal(msg.Data, &data)
+	if msg.Type != MessageTypeContractSubmission || !reflect.DeepEqual(data, testLuckyLadder()) {
+		t.Fatalf("Decoded %s with %+v, want the submitted contract", msg.Type, data)
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// defaultSessionResumeTimeout is how long the contracts of a disconnected
+// client keep running for it to reconnect
+const defaultSessionResumeTimeout = 30 * time.Second
+
+// sessionSnapshot holds the contracts of a disconnected client until it
+// reconnects or the snapshot expires
+type sessionSnapshot struct {
+	client *Client
+	// contracts maps contract IDs to product types
+	contracts map[string]string
+	expiry    *time.Timer
+}
+
+func sessionResumeTimeoutFromEnv() time.Duration {
+	timeout := defaultSessionResumeTimeout
+	if value := os.Getenv("SESSION_RESUME_TIMEOUT"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed >= 0 {
+			timeout = parsed
+		} else {
+			logging.DebugLog("Invalid SESSION_RESUME_TIMEOUT %q, using %s", value, timeout)
+		}
+	}
+	return timeout
+}
+
+// resumable reports whether the contracts of client should keep running
+// after it disconnects
+func (h *Hub) resumable(client *Client) bool {
+	return h.SessionResumeTimeout > 0 && client.SessionID != "" && !client.kicked && !client.migrated
+}
+
+// saveSession keeps the contracts of a disconnected client for timeout,
+// after which they are removed
+func (h *Hub) saveSession(client *Client, contracts map[string]string, timeout time.Duration) {
+	sessionID := client.SessionID
+	logging.DebugLog("Keeping %d contracts of session %s for %s", len(contracts), sessionID, timeout)
+	snapshot := &sessionSnapshot{client: client, contracts: contracts}
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	h.sessions[sessionID] = snapshot
+	snapshot.expiry = time.AfterFunc(timeout, func() {
+		h.expireSession(sessionID, snapshot)
+	})
+}
+
+// takeSession removes and returns the snapshot of sessionID if it belongs to
+// the tenant and user of client, or returns nil. Clients without an
+// authenticated user cannot take sessions over, as a session ID alone does
+// not prove who they are.
+func (h *Hub) takeSession(sessionID string, client *Client) *sessionSnapshot {
+	if client.UserID == "" {
+		logging.DebugLogContext(client.logContext(), "Refusing to resume session %s for a client without an authenticated user", sessionID)
+		return nil
+	}
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	snapshot, ok := h.sessions[sessionID]
+	if !ok || snapshot.client.TenantID != client.TenantID || snapshot.client.UserID != client.UserID {
+		return nil
+	}
+	delete(h.sessions, sessionID)
+	snapshot.expiry.Stop()
+	return snapshot
+}
+
+// expireSession removes the contracts of a session that was not resumed
+func (h *Hub) expireSession(sessionID string, snapshot *sessionSnapshot) {
+	h.sessionsMu.Lock()
+	if h.sessions[sessionID] != snapshot {
+		h.sessionsMu.Unlock()
+		return
+	}
+	delete(h.sessions, sessionID)
+	h.sessionsMu.Unlock()
+
+	logging.DebugLog("Session %s expired, removing %d contracts", sessionID, len(snapshot.contracts))
+	for contractID := range snapshot.contracts {
+		// Settled contracts were already cleaned up
+		if h.isActiveContract(contractID) {
+			h.removeClientContract(snapshot.client, contractID)
+		}
+	}
+}
+
+// isActiveContract reports whether contractID has not reached a terminal
+// state yet
+func (h *Hub) isActiveContract(contractID string) bool {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+	_, ok := h.activeContractTypes[contractID]
+	return ok
+}
+
+// followResumedContract subscribes client to a contract it took over from
+// its previous session and forgets the contract once it settles
+func (h *Hub) followResumedContract(contractID, productType string, client *Client) {
+	client.mu.Lock()
+	client.Contracts[contractID] = productType
+	client.mu.Unlock()
+	h.subscribeClient(contractID, client)
+
+	var remove func()
+	remove = h.AddContractListener(contractID, func(state map[string]interface{}) {
+		if !isTerminalState(state) {
+			return
+		}
+		client.forgetContract(contractID)
+		h.unsubscribeClient(contractID, client)
+		// Listeners cannot remove themselves while being notified
+		go remove()
+	})
+}
+
+// ReconnectHandler restores the contracts of a client reconnecting with the
+// ID of its previous session in the X-Last-Session-ID header, including
+// sessions migrated from another server. Every new connection is told its
+// session ID in the X-Session-ID response header.
+type ReconnectHandler struct {
+	hub *Hub
+}
+
+// NewReconnectHandler creates a ReconnectHandler for the clients of hub
+func NewReconnectHandler(hub *Hub) *ReconnectHandler {
+	return &ReconnectHandler{hub: hub}
+}
+
+// Restore hands the still active contracts of the previous session over to
+// client, sends their current state as if the client had sent a
+// ContractQuery for each, then sends a Reconnected message listing them. It
+// must be called before the client is registered with the hub, and returns
+// the restored contract IDs.
+func (rh *ReconnectHandler) Restore(r *http.Request, client *Client) []string {
+	lastSessionID := r.Header.Get("X-Last-Session-ID")
+	if lastSessionID == "" {
+		return nil
+	}
+
+	snapshot := rh.hub.takeSession(lastSessionID, client)
+	if snapshot == nil {
+		// The session may have been migrated from another server
+		if n, err := rh.hub.restoreMigratedSessions([]string{lastSessionID}); err != nil {
+			logging.DebugLogContext(client.logContext(), "Failed to restore migrated session %s: %v", lastSessionID, err)
+		} else if n > 0 {
+			snapshot = rh.hub.takeSession(lastSessionID, client)
+		}
+	}
+
+	restored := make([]string, 0)
+	if snapshot != nil {
+		for contractID := range snapshot.contracts {
+			if rh.hub.isActiveContract(contractID) {
+				restored = append(restored, contractID)
+			}
+		}
+		sort.Strings(restored)
+		for _, contractID := range restored {
+			rh.hub.followResumedContract(contractID, snapshot.contracts[contractID], client)
+		}
+	}
+	logging.DebugLogContext(client.logContext(), "Session %s resumed with contracts %v", lastSessionID, restored)
+
+	for _, contractID := range restored {
+		client.handleContractQuery(client.logContext(), contractID)
+	}
+	client.sendMessage(map[string]interface{}{
+		"type": MessageTypeReconnected,
+		"data": map[string]interface{}{
+			"sessionID":   client.SessionID,
+			"contractIDs": restored,
+		},
+	})
+	return restored
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// waitFor polls condition until it holds or a second has passed
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnectRestoresPriceUpdatesOfSessionContracts(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	h.SessionResumeTimeout = time.Minute
+	go h.Run()
+
+	previous := newTenantTestClient(h, "previous", "tenant-a")
+	previous.SessionID = "session-1"
+	previous.UserID = "user-1"
+	var contractIDs []string
+	for i := 0; i < 2; i++ {
+		accepted := submitThroughClient(t, previous, prices, testLuckyLadder())
+		if accepted["type"] != MessageTypeContractAccepted {
+			t.Fatalf("Submission %d answered %v, want ContractAccepted", i+1, accepted)
+		}
+		contractIDs = append(contractIDs, accepted["contractID"].(string))
+	}
+	sort.Strings(contractIDs)
+
+	// Disconnect
+	h.Unregister <- previous
+	waitFor(t, "the session to be saved", func() bool {
+		h.sessionsMu.Lock()
+		defer h.sessionsMu.Unlock()
+		return h.sessions["session-1"] != nil
+	})
+
+	// Reconnect the way serveWs does
+	client := &Client{
+		ID:        "reconnected",
+		Hub:       h,
+		Send:      make(chan []byte, 64),
+		Contracts: make(map[string]string),
+		TenantID:  "tenant-a",
+		UserID:    "user-1",
+		SessionID: "session-2",
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("X-Last-Session-ID", "session-1")
+	restored := NewReconnectHandler(h).Restore(req, client)
+	if len(restored) != 2 || restored[0] != contractIDs[0] || restored[1] != contractIDs[1] {
+		t.Fatalf("Restore returned %v, want %v", restored, contractIDs)
+	}
+	h.Register <- client
+	waitFor(t, "the client to be registered", func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.Clients[client]
+	})
+
+	reconnected := nextMessageOfType(client, MessageTypeReconnected)
+	if reconnected == nil {
+		t.Fatal("Client was not sent a Reconnected message")
+	}
+	if listed, _ := reconnected["data"].(map[string]interface{})["contractIDs"].([]interface{}); len(listed) != 2 {
+		t.Fatalf("Reconnected listed %v, want both contracts", reconnected["data"])
+	}
+
+	// Both contracts are updated within three ticks of reconnecting
+	updated := make(map[string]bool)
+	for tick := 1; tick <= 3 && len(updated) < 2; tick++ {
+		price := 100 + float64(tick)/10
+		prices.tick(price)
+		timeout := time.After(200 * time.Millisecond)
+	collect:
+		for len(updated) < 2 {
+			select {
+			case frame := <-client.Send:
+				var message map[string]interface{}
+				json.Unmarshal(frame, &message)
+				data, _ := message["data"].(map[string]interface{})
+				if message["type"] == MessageTypeContractUpdate && data["price"] == price {
+					updated[message["contractID"].(string)] = true
+				}
+			case <-timeout:
+				break collect
+			}
+		}
+	}
+	for _, contractID := range contractIDs {
+		if !updated[contractID] {
+			t.Errorf("Contract %s received no price update within three ticks of reconnecting", contractID)
+		}
+	}
+}
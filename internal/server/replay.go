@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"pricingserver/internal/common/logging"
+)
+
+// defaultReplayBufferSize is how many of the latest updates of a contract are
+// replayed to a client querying it
+const defaultReplayBufferSize = 10
+
+func replayBufferSizeFromEnv() int {
+	size := defaultReplayBufferSize
+	if value := os.Getenv("REPLAY_BUFFER_SIZE"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 && parsed <= contractUpdateHistorySize {
+			size = parsed
+		} else {
+			logging.DebugLog("Invalid REPLAY_BUFFER_SIZE %q, using %d", value, size)
+		}
+	}
+	return size
+}
+
+// latest returns the n most recent kept updates, oldest first
+func (l *contractUpdateLog) latest(n int) []contractUpdateEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n > len(l.entries) {
+		n = len(l.entries)
+	}
+	entries := make([]contractUpdateEntry, 0, n)
+	for i := len(l.entries) - n; i < len(l.entries); i++ {
+		entries = append(entries, l.entries[(l.next+i)%len(l.entries)])
+	}
+	return entries
+}
+
+// replayContractUpdates sends c the last ReplayBufferSize updates of a
+// contract, oldest first, as ContractUpdate messages marked "replayed": true
+// so a client that joined late can tell them from live updates. The caller
+// must hold c.mu.
+func (c *Client) replayContractUpdates(ctx context.Context, contractID string) {
+	if c.Hub.ReplayBufferSize <= 0 {
+		return
+	}
+	c.Hub.updateLogsMu.Lock()
+	log, ok := c.Hub.updateLogs[contractID]
+	c.Hub.updateLogsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	entries := log.latest(c.Hub.ReplayBufferSize)
+	logging.DebugLogContext(ctx, "Replaying %d updates of contract %s", len(entries), contractID)
+	for _, entry := range entries {
+		c.sendMessage(map[string]interface{}{
+			"type":       MessageTypeContractUpdate,
+			"contractID": contractID,
+			"data":       entry.Data,
+			"replayed":   true,
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"testing"
+)
+
+// nextUpdateAt returns the next ContractUpdate of client at price, skipping
+// earlier updates
+func nextUpdateAt(t *testing.T, client *Client, price float64) map[string]interface{} {
+	t.Helper()
+	for {
+		update := nextMessageOfType(client, MessageTypeContractUpdate)
+		if update == nil {
+			t.Fatalf("Client %s received no update at %v", client.ID, price)
+		}
+		if state, _ := update["data"].(map[string]interface{}); state["price"] == price {
+			return update
+		}
+	}
+}
+
+func TestContractQueryReplaysBufferedUpdatesBeforeLiveOnes(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	h.ReplayBufferSize = 5
+	owner := newTenantTestClient(h, "owner", "")
+	late := newTenantTestClient(h, "late", "")
+
+	accepted := submitThroughClient(t, owner, prices, testLuckyLadder())
+	contractID, _ := accepted["contractID"].(string)
+	if accepted["type"] != MessageTypeContractAccepted || contractID == "" {
+		t.Fatalf("Submission answered %v, want ContractAccepted", accepted)
+	}
+	buffered := []float64{100.1, 100.2, 100.3, 100.4, 100.5}
+	for _, price := range buffered {
+		prices.tick(price)
+		nextUpdateAt(t, owner, price)
+	}
+
+	// A second client joins and queries the contract
+	shareContractWith(t, owner, contractID, late)
+	if nextMessageOfType(late, MessageTypeContractShared) == nil {
+		t.Fatal("The second client was not told the contract is shared")
+	}
+	late.handleContractQuery(context.Background(), contractID)
+	prices.tick(100.9)
+
+	var replayed []float64
+	for {
+		message := nextMessageOfType(late, MessageTypeContractUpdate)
+		if message == nil {
+			t.Fatalf("Received replayed updates at %v but no live update", replayed)
+		}
+		state, _ := message["data"].(map[string]interface{})
+		if message["replayed"] == true {
+			replayed = append(replayed, state["price"].(float64))
+			continue
+		}
+		if state["price"] == 100.9 {
+			break
+		}
+	}
+	if len(replayed) != len(buffered) {
+		t.Fatalf("Replayed updates at %v before the live update, want %v", replayed, buffered)
+	}
+	for i, price := range buffered {
+		if replayed[i] != price {
+			t.Fatalf("Replayed updates at %v before the live update, want %v", replayed, buffered)
+		}
+	}
+}
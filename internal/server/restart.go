@@ -0,0 +1,45 @@
+package server
+
+import (
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/contracts"
+	"pricingserver/internal/simulation"
+)
+
+// watchEngineRestart makes engine call OnEngineRestart when it is started
+// again after a stop
+func (h *Hub) watchEngineRestart(engine *simulation.SimulationEngine) {
+	engine.OnRestart = func(contractIDs []string) {
+		if h.OnEngineRestart != nil {
+			h.OnEngineRestart(contractIDs)
+		}
+	}
+}
+
+// resubscribeContracts is the default OnEngineRestart. It subscribes every
+// contract among contractIDs that is still active but no longer subscribed
+// to a price source again.
+func (h *Hub) resubscribeContracts(contractIDs []string) {
+	subscribed := make(map[string]bool)
+	for _, contractID := range h.subscribedContracts() {
+		subscribed[contractID] = true
+	}
+
+	resubscribed := 0
+	for _, contractID := range contractIDs {
+		if subscribed[contractID] || !h.isActiveContract(contractID) {
+			continue
+		}
+		proxy, ok := h.Contracts.GetContract(contractID)
+		if !ok {
+			continue
+		}
+		h.tenantsMu.RLock()
+		tenantID := h.contractTenants[contractID]
+		h.tenantsMu.RUnlock()
+		instrument, _ := proxy.GetState()["instrument"].(string)
+		h.subscribePrices(tenantID, contractID, contracts.ContractTypeOf(proxy.ProductType()), instrument, 0, 0, proxy)
+		resubscribed++
+	}
+	logging.DebugLog("Resubscribed %d of %d contracts after a simulation engine restart", resubscribed, len(contractIDs))
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pricingserver/internal/simulation"
+)
+
+func TestContractsKeepReceivingPricesAfterEngineRestart(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	engine := simulation.NewSimulationEngine()
+	engine.TickInterval = 20 * time.Millisecond
+	h.SimulationEngine = engine
+	restarted := make(chan []string, 1)
+	h.OnEngineRestart = func(contractIDs []string) {
+		h.resubscribeContracts(contractIDs)
+		restarted <- contractIDs
+	}
+	go h.Run()
+	t.Cleanup(engine.Stop)
+
+	contractIDs := make([]string, 5)
+	updates := make([]int64, len(contractIDs))
+	for i := range contractIDs {
+		contractID, err := h.SubmitContract("", testLuckyLadder())
+		if err != nil {
+			t.Fatalf("SubmitContract: %v", err)
+		}
+		contractIDs[i] = contractID
+		i := i
+		t.Cleanup(h.AddContractListener(contractID, func(map[string]interface{}) { atomic.AddInt64(&updates[i], 1) }))
+	}
+	// Stop reports the subscribed contracts in order
+	expected := append([]string(nil), contractIDs...)
+	sort.Strings(expected)
+
+	engine.Stop()
+	engine.Start()
+	select {
+	case subscribed := <-restarted:
+		if !reflect.DeepEqual(subscribed, expected) {
+			t.Fatalf("OnEngineRestart was called with %v, want %v", subscribed, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnEngineRestart was not called")
+	}
+	before := make([]int64, len(updates))
+	for i := range updates {
+		before[i] = atomic.LoadInt64(&updates[i])
+	}
+
+	// Two ticks, with time for the contracts service to answer
+	time.Sleep(2*engine.TickInterval + 50*time.Millisecond)
+	for i, contractID := range contractIDs {
+		if atomic.LoadInt64(&updates[i]) == before[i] {
+			t.Errorf("Contract %s received no update within two ticks of the restart", contractID)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rung generators of LuckyLadder contracts submitted without rungs
+const (
+	RungGeneratorManual     = "manual"
+	RungGeneratorFibonacci  = "fibonacci"
+	RungGeneratorArithmetic = "arithmetic"
+	RungGeneratorGeometric  = "geometric"
+)
+
+// maxGeneratedRungs caps the number of rungs a generator may produce
+const maxGeneratedRungs = 100
+
+// GenerateFibonacciRungs returns count rungs at Fibonacci multiples of
+// stepSize above startPrice, e.g. 101, 102, 103, 105, 108, 113 for a start
+// price of 100 and a step of 1
+func GenerateFibonacciRungs(startPrice, stepSize float64, count int) []float64 {
+	rungs := make([]float64, 0, count)
+	previous, current := 1.0, 1.0
+	for len(rungs) < count {
+		rungs = append(rungs, startPrice+current*stepSize)
+		previous, current = current, previous+current
+	}
+	return rungs
+}
+
+// GenerateArithmeticRungs returns count rungs stepSize apart above
+// startPrice, e.g. 101, 102, 103 for a start price of 100 and a step of 1
+func GenerateArithmeticRungs(startPrice, stepSize float64, count int) []float64 {
+	rungs := make([]float64, count)
+	for i := range rungs {
+		rungs[i] = startPrice + float64(i+1)*stepSize
+	}
+	return rungs
+}
+
+// GenerateGeometricRungs returns count rungs each growthRate above the
+// previous one, starting from startPrice, e.g. 101, 102.01, 103.0301 for a
+// start price of 100 and a growth rate of 0.01
+func GenerateGeometricRungs(startPrice, growthRate float64, count int) []float64 {
+	rungs := make([]float64, count)
+	for i := range rungs {
+		rungs[i] = startPrice * math.Pow(1+growthRate, float64(i+1))
+	}
+	return rungs
+}
+
+// generateRungs returns the rungs of a LuckyLadder submitted without rungs
+// from its RungGenerator, or nil for manual rungs. The rungs start from
+// RungStart, or the strike when it is 0, or 1 for rungs relative to the
+// strike.
+func generateRungs(data *ContractData) ([]float64, error) {
+	var generate func(startPrice, stepSize float64, count int) []float64
+	switch data.RungGenerator {
+	case "", RungGeneratorManual:
+		return nil, nil
+	case RungGeneratorFibonacci:
+		generate = GenerateFibonacciRungs
+	case RungGeneratorArithmetic:
+		generate = GenerateArithmeticRungs
+	case RungGeneratorGeometric:
+		generate = GenerateGeometricRungs
+	default:
+		return nil, fmt.Errorf("unsupported rungGenerator: %s", data.RungGenerator)
+	}
+
+	if data.RungCount <= 0 || data.RungCount > maxGeneratedRungs {
+		return nil, fmt.Errorf("rungCount must be between 1 and %d", maxGeneratedRungs)
+	}
+	if data.RungStep <= 0 {
+		return nil, fmt.Errorf("rungStep must be positive")
+	}
+	start := data.RungStart
+	if start == 0 {
+		start = data.Strike
+	}
+	if start == 0 && data.RungsRelativeToStrike {
+		start = 1
+	}
+	if start <= 0 {
+		return nil, fmt.Errorf("rungStart or strike is required to generate rungs")
+	}
+	return generate(start, data.RungStep, data.RungCount), nil
+}
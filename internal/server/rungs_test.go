@@ -0,0 +1,38 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFibonacciRungsAscendFromTheStartPrice(t *testing.T) {
+	if rungs := GenerateFibonacciRungs(100, 1, 6); !reflect.DeepEqual(rungs, []float64{101, 102, 103, 105, 108, 113}) {
+		t.Errorf("6 Fibonacci rungs above 100 are %v, want [101 102 103 105 108 113]", rungs)
+	}
+	rungs := GenerateFibonacciRungs(100, 0.5, 20)
+	if len(rungs) != 20 {
+		t.Fatalf("Generated %d rungs, want 20", len(rungs))
+	}
+	for i := 1; i < len(rungs); i++ {
+		if rungs[i] <= rungs[i-1] {
+			t.Fatalf("Rung %d (%v) is not above rung %d (%v)", i, rungs[i], i-1, rungs[i-1])
+		}
+	}
+
+	// LuckyLadders submitted without rungs get the rungs of their generator
+	data := ContractData{
+		ProductType:   "LuckyLadder",
+		RungGenerator: RungGeneratorFibonacci,
+		Strike:        100,
+		RungStep:      1,
+		RungCount:     6,
+		Duration:      60000,
+		Payoff:        10,
+	}
+	if err := ValidateContractData(&data); err != nil {
+		t.Fatalf("ValidateContractData: %v", err)
+	}
+	if !reflect.DeepEqual(data.Rungs, []float64{101, 102, 103, 105, 108, 113}) {
+		t.Errorf("Submission got rungs %v, want the Fibonacci rungs above its strike", data.Rungs)
+	}
+}
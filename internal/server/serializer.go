@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"pricingserver/internal/common/logging"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Serialization formats
+const (
+	SerializationFormatJSON     = "json"
+	SerializationFormatMsgpack  = "msgpack"
+	SerializationFormatProtobuf = "protobuf"
+)
+
+// WebSocket subprotocols that select a serialization format
+const (
+	SubprotocolJSON     = "pricing.json"
+	SubprotocolMsgpack  = "pricing.msgpack"
+	SubprotocolProtobuf = "pricing.v1.proto"
+)
+
+// Subprotocols lists the supported WebSocket subprotocols in order of
+// preference
+var Subprotocols = []string{SubprotocolProtobuf, SubprotocolMsgpack, SubprotocolJSON}
+
+var subprotocolFormats = map[string]string{
+	SubprotocolJSON:     SerializationFormatJSON,
+	SubprotocolMsgpack:  SerializationFormatMsgpack,
+	SubprotocolProtobuf: SerializationFormatProtobuf,
+}
+
+// SubprotocolsFromEnv returns the subprotocols listed in WS_SUBPROTOCOLS as a
+// comma-separated list in order of preference, defaulting to Subprotocols.
+// Unknown subprotocols are ignored.
+func SubprotocolsFromEnv() []string {
+	value := os.Getenv("WS_SUBPROTOCOLS")
+	if value == "" {
+		return append([]string(nil), Subprotocols...)
+	}
+	var supported []string
+	for _, subprotocol := range strings.Split(value, ",") {
+		subprotocol = strings.TrimSpace(subprotocol)
+		if _, ok := subprotocolFormats[subprotocol]; !ok {
+			logging.DebugLog("Ignoring unknown WebSocket subprotocol %q", subprotocol)
+			continue
+		}
+		supported = append(supported, subprotocol)
+	}
+	return supported
+}
+
+// NegotiateSubprotocol returns the first of supported offered in the upgrade
+// request r, or "" if it offers none of them
+func NegotiateSubprotocol(r *http.Request, supported []string) string {
+	offered := websocket.Subprotocols(r)
+	for _, subprotocol := range supported {
+		for _, candidate := range offered {
+			if candidate == subprotocol {
+				return subprotocol
+			}
+		}
+	}
+	return ""
+}
+
+// SubprotocolSerializationFormat returns the serialization format selected by
+// a subprotocol, defaulting to JSON
+func SubprotocolSerializationFormat(subprotocol string) string {
+	if format, ok := subprotocolFormats[subprotocol]; ok {
+		return format
+	}
+	return SerializationFormatJSON
+}
+
+// Serializer encodes and decodes WebSocket messages
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// NewSerializer returns the serializer for the given format, defaulting to JSON
+func NewSerializer(format string) (Serializer, error) {
+	switch format {
+	case "", SerializationFormatJSON:
+		return JSONSerializer{}, nil
+	case SerializationFormatMsgpack:
+		return MsgpackSerializer{}, nil
+	case SerializationFormatProtobuf:
+		return ProtobufSerializer{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported serialization format: %s", format)
+	}
+}
+
+// JSONSerializer encodes messages as JSON
+type JSONSerializer struct{}
+
+// Marshal implements Serializer
+func (JSONSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Serializer
+func (JSONSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// MsgpackSerializer encodes messages as MessagePack using the json struct
+// tags, so field names on the wire match the JSON protocol
+type MsgpackSerializer struct{}
+
+// Marshal implements Serializer
+func (MsgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Serializer. The payload is decoded generically and
+// then applied through encoding/json so that json.RawMessage fields such as
+// Message.Data keep working with the existing handlers.
+func (MsgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	var decoded interface{}
+	if err := msgpack.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	intermediate, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(intermediate, v)
+}
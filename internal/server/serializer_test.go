@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSerializersRoundTripContractData(t *testing.T) {
+	data := ContractData{
+		ProductType:  "LuckyLadder",
+		Rungs:        []float64{101, 102.5, 104},
+		Duration:     60000,
+		Payoff:       10,
+		Notional:     2500,
+		Instrument:   "EUR/USD",
+		Precondition: &PreconditionSpec{Type: PreconditionPriceBelow, Threshold: 95, TimeoutMs: 30000},
+	}
+	for _, format := range []string{SerializationFormatJSON, SerializationFormatMsgpack} {
+		serializer, err := NewSerializer(format)
+		if err != nil {
+			t.Fatalf("NewSerializer(%s): %v", format, err)
+		}
+		encoded, err := serializer.Marshal(data)
+		if err != nil {
+			t.Fatalf("%s Marshal: %v", format, err)
+		}
+		var decoded ContractData
+		if err := serializer.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("%s Unmarshal: %v", format, err)
+		}
+		if !reflect.DeepEqual(decoded, data) {
+			t.Errorf("%s round trip returned %+v, want %+v", format, decoded, data)
+		}
+	}
+}
+
+func TestMsgpackSerializerDecodesSubmissionMessage(t *testing.T) {
+	encoded, err := MsgpackSerializer{}.Marshal(map[string]interface{}{
+		"type": MessageTypeContractSubmission,
+		"data": testLuckyLadder(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if err := (MsgpackSerializer{}).Unmarshal(encoded, &msg); err != nil {
+		t.Fatal(err)
+	}
+	var data ContractData
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		t.Fatalf("Message data %s is not a contract submission: %v", msg.Data, err)
+	}
+	if msg.Type != MessageTypeContractSubmission || !reflect.DeepEqual(data, testLuckyLadder()) {
+		t.Fatalf("Decoded %s with %+v, want the submitted contract", msg.Type, data)
+	}
+}
+
+func TestNewSerializerRejectsUnknownFormat(t *testing.T) {
+	if _, err := NewSerializer("xml"); err == nil {
+		t.Fatal("NewSerializer accepted an unknown format")
+	}
+}
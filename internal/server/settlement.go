@@ -0,0 +1,42 @@
+package server
+
+import (
+	"time"
+
+	"pricingserver/internal/audit"
+	"pricingserver/internal/common/logging"
+)
+
+// recordAudit writes event to the audit log, if one is configured
+func (h *Hub) recordAudit(event audit.AuditEvent) {
+	if h.AuditLog == nil {
+		return
+	}
+	if err := h.AuditLog.Record(event); err != nil {
+		logging.DebugLog("Failed to record %s audit event for contract %s: %v", event.EventType, event.ContractID, err)
+	}
+}
+
+// recordSettlement stores the outcome of a contract that reached a terminal
+// state after running for elapsed in the storage service
+func (h *Hub) recordSettlement(contractID string, state map[string]interface{}, elapsed time.Duration) {
+	h.recordAudit(audit.AuditEvent{EventType: audit.EventContractSettled, ContractID: contractID, Payload: state})
+	if h.StorageService == nil {
+		return
+	}
+	if price, ok := state["price"].(float64); ok {
+		if err := h.StorageService.UpdateFinalPrice(contractID, price); err != nil {
+			logging.DebugLog("Failed to record final price of contract %s: %v", contractID, err)
+		}
+	}
+	if hitRungs, ok := state["all_rungs_hit"]; ok {
+		if err := h.StorageService.UpdateHitRungs(contractID, toFloatSlice(hitRungs)); err != nil {
+			logging.DebugLog("Failed to record hit rungs of contract %s: %v", contractID, err)
+		}
+	}
+	if status, _ := state["status"].(string); status == "target_hit" {
+		if err := h.StorageService.UpdateTimeToTarget(contractID, elapsed); err != nil {
+			logging.DebugLog("Failed to record time to target of contract %s: %v", contractID, err)
+		}
+	}
+}
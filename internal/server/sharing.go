@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"pricingserver/internal/common/logging"
+)
+
+// contractShareData is the data of a ContractShare message
+type contractShareData struct {
+	ContractID      string   `json:"contractID"`
+	TargetClientIDs []string `json:"targetClientIDs"`
+}
+
+// handleContractShare lets the clients in targetClientIDs follow a contract
+// owned by c. Every participant receives the contract's updates and a
+// ContractSettlement once it reaches a terminal state. Targets must be
+// connected to this server and belong to the same tenant.
+func (c *Client) handleContractShare(ctx context.Context, data json.RawMessage) {
+	var share contractShareData
+	if err := json.Unmarshal(data, &share); err != nil {
+		logging.DebugLogContext(ctx, "Failed to parse contract share data: %v", err)
+		c.sendError(ErrorTypeParse, "Invalid contract share data format")
+		return
+	}
+	if share.ContractID == "" || len(share.TargetClientIDs) == 0 {
+		c.sendError(ErrorTypeValidation, "contractID and targetClientIDs are required")
+		return
+	}
+
+	c.mu.Lock()
+	productType, owned := c.Contracts[share.ContractID]
+	c.mu.Unlock()
+	if !owned || !c.Hub.ownsSharedContract(share.ContractID, c.ID) {
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Contract not found: %s", share.ContractID))
+		return
+	}
+
+	c.Hub.mu.Lock()
+	targets := make([]*Client, 0, len(share.TargetClientIDs))
+	for _, clientID := range share.TargetClientIDs {
+		target := c.Hub.findClient(clientID)
+		if target == nil || target.TenantID != c.TenantID {
+			c.Hub.mu.Unlock()
+			c.sendError(ErrorTypeValidation, fmt.Sprintf("Client not found: %s", clientID))
+			return
+		}
+		if target != c {
+			targets = append(targets, target)
+		}
+	}
+	c.Hub.mu.Unlock()
+
+	for _, target := range targets {
+		target.mu.Lock()
+		target.Contracts[share.ContractID] = productType
+		target.mu.Unlock()
+		c.Hub.subscribeClient(share.ContractID, target)
+	}
+	participants := c.Hub.shareContract(share.ContractID, c.ID, targets)
+	logging.DebugLogContext(ctx, "Contract %s shared by client %s with %v", share.ContractID, c.ID, participants)
+
+	c.Hub.sendToClients(participants, map[string]interface{}{
+		"type":       MessageTypeContractShared,
+		"contractID": share.ContractID,
+		"data": map[string]interface{}{
+			"sharedBy":  c.ID,
+			"clientIDs": participants,
+		},
+	})
+}
+
+// shareContract records targets as participants of a contract owned by
+// ownerID and returns every participant, owner first
+func (h *Hub) shareContract(contractID, ownerID string, targets []*Client) []string {
+	h.sharedMu.Lock()
+	defer h.sharedMu.Unlock()
+	participants := h.sharedContracts[contractID]
+	if len(participants) == 0 {
+		participants = []string{ownerID}
+	}
+	for _, target := range targets {
+		known := false
+		for _, clientID := range participants {
+			if clientID == target.ID {
+				known = true
+				break
+			}
+		}
+		if !known {
+			participants = append(participants, target.ID)
+		}
+	}
+	h.sharedContracts[contractID] = participants
+	return append([]string(nil), participants...)
+}
+
+// ownsSharedContract reports whether clientID may share contractID, i.e. it
+// is not shared yet or clientID is its owner
+func (h *Hub) ownsSharedContract(contractID, clientID string) bool {
+	h.sharedMu.Lock()
+	defer h.sharedMu.Unlock()
+	participants := h.sharedContracts[contractID]
+	return len(participants) == 0 || participants[0] == clientID
+}
+
+// leaveSharedContract removes clientID from the participants of a shared
+// contract. It returns true if the client was a participant other than the
+// owner, so the contract keeps running for the others. When the owner
+// leaves, the contract stops being shared.
+func (h *Hub) leaveSharedContract(contractID, clientID string) bool {
+	h.sharedMu.Lock()
+	defer h.sharedMu.Unlock()
+	participants := h.sharedContracts[contractID]
+	if len(participants) == 0 {
+		return false
+	}
+	if participants[0] == clientID {
+		delete(h.sharedContracts, contractID)
+		return false
+	}
+	for i, participant := range participants {
+		if participant == clientID {
+			h.sharedContracts[contractID] = append(participants[:i:i], participants[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// settleSharedContract sends a ContractSettlement with the final state of a
+// shared contract to every participant and removes the contract from the
+// participants other than the owner
+func (h *Hub) settleSharedContract(contractID string, state map[string]interface{}) {
+	h.sharedMu.Lock()
+	participants := h.sharedContracts[contractID]
+	delete(h.sharedContracts, contractID)
+	h.sharedMu.Unlock()
+	if len(participants) == 0 {
+		return
+	}
+
+	logging.DebugLog("Settling shared contract %s for %v", contractID, participants)
+	h.sendToClients(participants, map[string]interface{}{
+		"type":       MessageTypeContractSettlement,
+		"contractID": contractID,
+		"data":       state,
+	})
+
+	h.mu.Lock()
+	var others []*Client
+	for _, clientID := range participants[1:] {
+		if client := h.findClient(clientID); client != nil {
+			others = append(others, client)
+		}
+	}
+	h.mu.Unlock()
+	for _, client := range others {
+		client.mu.Lock()
+		delete(client.Contracts, contractID)
+		client.mu.Unlock()
+		h.unsubscribeClient(contractID, client)
+	}
+}
+
+// sendToClients sends a message to the locally connected clients with the
+// given IDs, encoding it once per serialization format. Like contract
+// broadcasts, settlements are never dropped for clients with a full Send
+// buffer, see sendLate.
+func (h *Hub) sendToClients(clientIDs []string, message interface{}) {
+	reliable := mustDeliver(message)
+	broadcast := newBroadcastMessage(h, message)
+	var late []lateFrame
+	h.mu.Lock()
+	defer func() {
+		h.mu.Unlock()
+		h.sendLate(late)
+	}()
+	for _, clientID := range clientIDs {
+		client := h.findClient(clientID)
+		if client == nil {
+			continue
+		}
+		data, err := broadcast.encode(client.codec())
+		if err != nil {
+			logging.DebugLog("Failed to marshal message for client %s: %v", client.ID, err)
+			continue
+		}
+		frame := broadcast.frameFor(client, data)
+		select {
+		case client.Send <- frame:
+		default:
+			if reliable {
+				late = append(late, lateFrame{client: client, frame: frame})
+				continue
+			}
+			logging.DebugLog("Send buffer full for client %s, dropping message", client.ID)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newSharingTestClients returns a hub with n connected clients, the first
+// owning contract-1
+func newSharingTestClients(n int) (*Hub, []*Client) {
+	h := NewHub()
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = &Client{
+			ID:        fmt.Sprintf("client-%d", i),
+			Hub:       h,
+			Send:      make(chan []byte, 10),
+			Contracts: make(map[string]string),
+		}
+		h.Clients[clients[i]] = true
+	}
+	clients[0].Contracts["contract-1"] = "LuckyLadder"
+	h.subscribeClient("contract-1", clients[0])
+	return h, clients
+}
+
+// nextMessageOfType returns the next message of type msgType queued for
+// client, skipping the others, or nil if none arrives within a second
+func nextMessageOfType(client *Client, msgType string) map[string]interface{} {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case frame := <-client.Send:
+			var message map[string]interface{}
+			json.Unmarshal(frame, &message)
+			if message["type"] == msgType {
+				return message
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func shareContract(t *testing.T, owner *Client, targets ...*Client) {
+	t.Helper()
+	shareContractWith(t, owner, "contract-1", targets...)
+}
+
+func shareContractWith(t *testing.T, owner *Client, contractID string, targets ...*Client) {
+	t.Helper()
+	share := contractShareData{ContractID: contractID}
+	for _, target := range targets {
+		share.TargetClientIDs = append(share.TargetClientIDs, target.ID)
+	}
+	data, _ := json.Marshal(share)
+	owner.handleContractShare(context.Background(), data)
+}
+
+func TestSharedContractSettlesForEveryParticipant(t *testing.T) {
+	h, clients := newSharingTestClients(3)
+	shareContract(t, clients[0], clients[1], clients[2])
+	for i, client := range clients {
+		if nextMessageOfType(client, MessageTypeContractShared) == nil {
+			t.Fatalf("Client %d was not told the contract is shared", i)
+		}
+	}
+	// The last participant is not keeping up with its messages
+	for len(clients[2].Send) < cap(clients[2].Send) {
+		clients[2].Send <- []byte(`{"type": "ContractUpdate"}`)
+	}
+
+	// The contract expires
+	h.contractStateHandler(context.Background(), "contract-1", func(map[string]interface{}) {})(map[string]interface{}{
+		"contractID": "contract-1",
+		"status":     "expired",
+	})
+
+	for i, client := range clients {
+		settlement := nextMessageOfType(client, MessageTypeContractSettlement)
+		if settlement == nil || settlement["contractID"] != "contract-1" {
+			t.Errorf("Client %d received settlement %v, want the settlement of contract-1", i, settlement)
+		}
+	}
+	for i, client := range clients[1:] {
+		client.mu.Lock()
+		_, ok := client.Contracts["contract-1"]
+		client.mu.Unlock()
+		if ok {
+			t.Errorf("Participant %d still has contract-1 after its settlement", i+1)
+		}
+	}
+}
+
+func TestContractShareRejectsContractOfAnotherClient(t *testing.T) {
+	_, clients := newSharingTestClients(2)
+	share, _ := json.Marshal(contractShareData{ContractID: "contract-1", TargetClientIDs: []string{clients[0].ID}})
+	clients[1].handleContractShare(context.Background(), share)
+
+	if message := nextMessageOfType(clients[1], MessageTypeError); message == nil {
+		t.Fatal("Sharing a contract the client does not own was not rejected")
+	}
+	if message := nextMessageOfType(clients[0], MessageTypeContractShared); message != nil {
+		t.Errorf("Owner was told %v for a share it did not make", message)
+	}
+}
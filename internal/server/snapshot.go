@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/contracts"
+	"pricingserver/internal/simulation"
+)
+
+// snapshotInterval is how often the subscribed contracts are persisted
+const snapshotInterval = 30 * time.Second
+
+// restoreContracts resubscribes the contracts that were running before a
+// restart to the shared emitter
+func (h *Hub) restoreContracts() {
+	restored := h.Contracts.Restore(h.SimulationEngine)
+	logging.DebugLog("Restored %d active contracts", len(restored))
+	for _, contractID := range restored {
+		if proxy, ok := h.Contracts.GetContract(contractID); ok {
+			h.trackActiveContract(contractID, contracts.ContractTypeOf(proxy.ProductType()))
+		}
+		h.storeRestoredContract(contractID)
+	}
+
+	snapshots, err := h.StorageService.GetProductSnapshots()
+	if err != nil {
+		logging.DebugLog("Failed to load product snapshots: %v", err)
+		return
+	}
+	if err := h.RestoreProducts(snapshots); err != nil {
+		logging.DebugLog("Failed to restore product snapshots: %v", err)
+	}
+}
+
+// storeRestoredContract records a contract restored from the contracts
+// service in the storage service if it is missing there, without replacing
+// a stored record that may be newer
+func (h *Hub) storeRestoredContract(contractID string) {
+	state, err := h.ContractService.GetContractState(context.Background(), contractID)
+	if err != nil || state == nil {
+		logging.DebugLog("Failed to get state of restored contract %s: %v", contractID, err)
+		return
+	}
+	parameters, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	productType, _ := state["product_type"].(string)
+	created, err := h.StorageService.SaveContractIfNotExists(contracts.StoredContract{
+		ID:         contractID,
+		Type:       productType,
+		Parameters: parameters,
+		CreatedAt:  time.Now().UnixMilli(),
+		IsActive:   true,
+		Duration:   int(toFloat(state["duration"])),
+	})
+	if err != nil {
+		logging.DebugLog("Failed to store restored contract %s: %v", contractID, err)
+	} else if created {
+		logging.DebugLog("Stored restored contract %s that was missing from the storage service", contractID)
+	}
+}
+
+// SnapshotAllProducts returns the state of every managed contract proxy keyed
+// by contract ID
+func (h *Hub) SnapshotAllProducts() (map[string][]byte, error) {
+	snapshots := make(map[string][]byte)
+	for _, contractID := range h.Contracts.ContractIDs() {
+		proxy, ok := h.Contracts.GetContract(contractID)
+		if !ok {
+			continue
+		}
+		snapshot, err := proxy.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot contract %s: %v", contractID, err)
+		}
+		snapshots[contractID] = snapshot
+	}
+	return snapshots, nil
+}
+
+// RestoreProducts applies snapshots taken by SnapshotAllProducts to the
+// managed contract proxies. Snapshots of contracts that were not restored,
+// e.g. because they expired during the restart, are skipped.
+func (h *Hub) RestoreProducts(snapshots map[string][]byte) error {
+	var errs []error
+	restored := 0
+	for contractID, snapshot := range snapshots {
+		proxy, ok := h.Contracts.GetContract(contractID)
+		if !ok {
+			logging.DebugLog("Contract %s is no longer managed, skipping its snapshot", contractID)
+			continue
+		}
+		if err := proxy.Restore(snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("contract %s: %v", contractID, err))
+			continue
+		}
+		restored++
+	}
+	logging.DebugLog("Restored state of %d products", restored)
+	return errors.Join(errs...)
+}
+
+// SaveProductSnapshots persists the state of every managed contract proxy to
+// the storage service. It is called during graceful shutdown.
+func (h *Hub) SaveProductSnapshots() error {
+	snapshots, err := h.SnapshotAllProducts()
+	if err != nil {
+		return err
+	}
+	if err := h.StorageService.SaveProductSnapshots(snapshots); err != nil {
+		return err
+	}
+	logging.DebugLog("Saved snapshots of %d products", len(snapshots))
+	return nil
+}
+
+// subscribedContracts returns the IDs of every contract subscribed to the
+// shared emitter or a tenant simulation engine
+func (h *Hub) subscribedContracts() []string {
+	var ids []string
+	if snapshotter, ok := h.SimulationEngine.(interface {
+		Snapshot() map[string]simulation.PriceHandler
+	}); ok {
+		for contractID := range snapshotter.Snapshot() {
+			ids = append(ids, contractID)
+		}
+	}
+
+	h.enginesMu.Lock()
+	for _, engine := range h.TenantSimulationEngine {
+		for contractID := range engine.Snapshot() {
+			ids = append(ids, contractID)
+		}
+	}
+	for _, engine := range h.productEngines {
+		for contractID := range engine.Snapshot() {
+			ids = append(ids, contractID)
+		}
+	}
+	h.enginesMu.Unlock()
+	return ids
+}
+
+// persistSnapshots periodically saves the subscribed contracts to the storage
+// service so they can be restored after a restart
+func (h *Hub) persistSnapshots() {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids := h.subscribedContracts()
+		if err := h.StorageService.SaveSimulationSnapshot(ids); err != nil {
+			logging.DebugLog("Failed to save simulation snapshot: %v", err)
+			continue
+		}
+		logging.DebugLog("Saved simulation snapshot of %d contracts", len(ids))
+	}
+}
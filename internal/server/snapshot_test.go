@@ -0,0 +1,48 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"pricingserver/internal/contracts"
+)
+
+func TestRestoredProductsContinuePricingAfterRestart(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	contractID, err := h.SubmitContract("", testLuckyLadder())
+	if err != nil {
+		t.Fatalf("SubmitContract: %v", err)
+	}
+	prices.tick(101.5)
+	before, _ := h.Contracts.GetContract(contractID)
+
+	snapshots, err := h.SnapshotAllProducts()
+	if err != nil {
+		t.Fatalf("SnapshotAllProducts: %v", err)
+	}
+	if _, ok := snapshots[contractID]; !ok || len(snapshots) != 1 {
+		t.Fatalf("Snapshots are of %d contracts, want only %s", len(snapshots), contractID)
+	}
+
+	// The restarted hub gets the contract back from the contracts service
+	// and its state from the snapshot
+	restarted := NewHub()
+	restarted.Contracts.RestoreContract(contractID)
+	if err := restarted.RestoreProducts(snapshots); err != nil {
+		t.Fatalf("RestoreProducts: %v", err)
+	}
+	after, _ := restarted.Contracts.GetContract(contractID)
+	if after.ProductType() != before.ProductType() || !reflect.DeepEqual(after.GetState(), before.GetState()) {
+		t.Fatalf("Restored %s contract with state %v, want %s with %v", after.ProductType(), after.GetState(), before.ProductType(), before.GetState())
+	}
+
+	// Both contracts handle the next price the same way
+	for _, proxy := range []*contracts.ContractProxy{before, after} {
+		proxy.HandlePriceUpdate(100.8, time.Now())
+		data, _ := proxy.GetState()["data"].(map[string]interface{})
+		if data["price"] != 100.8 || data["status"] != "active" {
+			t.Errorf("Contract state after a price update is %v, want active at 100.8", data)
+		}
+	}
+}
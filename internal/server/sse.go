@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"pricingserver/internal/common/logging"
+)
+
+// ServeSSE streams contract updates as Server-Sent Events on
+// GET /sse/contracts/{id}?token=...
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ValidToken(r.URL.Query().Get("token")) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	tenantID, err := AuthenticateTenant(r)
+	if err != nil {
+		logging.DebugLog("Rejecting SSE request: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	contractID := strings.TrimPrefix(r.URL.Path, "/sse/contracts/")
+	if contractID == "" || strings.Contains(contractID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Listen before fetching the initial state so no update is missed.
+	// Terminal states have their own channel so they are never dropped and
+	// the stream always ends.
+	updates := make(chan map[string]interface{}, 16)
+	final := make(chan map[string]interface{}, 1)
+	removeListener := h.AddContractListener(contractID, func(state map[string]interface{}) {
+		if isTerminalState(state) {
+			select {
+			case final <- state:
+			default:
+			}
+			return
+		}
+		select {
+		case updates <- state:
+		default:
+			logging.DebugLog("SSE stream for contract %s is falling behind, dropping update", contractID)
+		}
+	})
+	defer removeListener()
+
+	state, err := h.GetContractState(tenantID, contractID)
+	if err == ErrContractNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		if err := writeSSEEvent(w, state); err != nil {
+			logging.DebugLog("Failed to write SSE event for contract %s: %v", contractID, err)
+			return
+		}
+		flusher.Flush()
+
+		if isTerminalState(state) {
+			logging.DebugLog("Contract %s reached a terminal state, closing SSE stream", contractID)
+			return
+		}
+
+		select {
+		case state = <-updates:
+		case state = <-final:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, state map[string]interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextSSEEvent returns the data of the next event of an SSE stream
+func nextSSEEvent(t *testing.T, stream *bufio.Reader) map[string]interface{} {
+	t.Helper()
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Stream ended before the next event: %v", err)
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			var event map[string]interface{}
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("Event %q is not JSON: %v", data, err)
+			}
+			return event
+		}
+	}
+}
+
+func TestSSEStreamSendsContractEvents(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	ts := httptest.NewServer(http.HandlerFunc(h.ServeSSE))
+	t.Cleanup(ts.Close)
+
+	contractID, err := h.SubmitContract("", testLuckyLadder())
+	if err != nil {
+		t.Fatalf("SubmitContract: %v", err)
+	}
+	// The timeout ends the stream if an expected event never comes
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(ts.URL + "/sse/contracts/" + contractID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("SSE stream answered %d with Content-Type %q, want 200 and text/event-stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	// The stream starts with the current state
+	if event := nextSSEEvent(t, stream); event["product_type"] != "lucky_ladder" || event["status"] != "active" {
+		t.Fatalf("First event is %v, want the active state of the contract", event)
+	}
+
+	prices.tick(101.5)
+	for {
+		if event := nextSSEEvent(t, stream); event["price"] == 101.5 {
+			break
+		}
+	}
+
+	// A terminal state is the last event
+	h.contractStateHandler(context.Background(), contractID, func(map[string]interface{}) {})(map[string]interface{}{
+		"contractID": contractID,
+		"status":     "expired",
+	})
+	for {
+		if event := nextSSEEvent(t, stream); event["status"] == "expired" {
+			break
+		}
+	}
+	if rest, _ := io.ReadAll(stream); len(strings.TrimSpace(string(rest))) != 0 {
+		t.Errorf("Stream sent %q after the terminal state", rest)
+	}
+}
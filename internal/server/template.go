@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"pricingserver/internal/common/logging"
+)
+
+// createFromTemplateData is the data of a CreateFromTemplate message
+type createFromTemplateData struct {
+	TemplateID string                 `json:"templateID"`
+	Overrides  map[string]interface{} `json:"overrides"`
+}
+
+// handleCreateFromTemplate submits a contract with the parameters of a
+// template saved in the storage service. Overrides replace template
+// parameters of the same name, e.g. {"payoff": 50}; the result is validated
+// and accepted like a ContractSubmission.
+func (c *Client) handleCreateFromTemplate(ctx context.Context, data json.RawMessage) {
+	var request createFromTemplateData
+	if err := json.Unmarshal(data, &request); err != nil {
+		logging.DebugLogContext(ctx, "Failed to parse create from template data: %v", err)
+		c.sendError(ErrorTypeParse, "Invalid create from template data format")
+		return
+	}
+	if request.TemplateID == "" {
+		c.sendError(ErrorTypeValidation, "templateID is required")
+		return
+	}
+	if c.Hub.StorageService == nil {
+		c.sendError(ErrorTypeValidation, "Contract templates are not available")
+		return
+	}
+
+	template, err := c.Hub.StorageService.GetContractTemplate(request.TemplateID)
+	if err != nil {
+		logging.DebugLogContext(ctx, "Failed to fetch template %s: %v", request.TemplateID, err)
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Failed to fetch template: %s", request.TemplateID))
+		return
+	}
+	if template == nil {
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Template not found: %s", request.TemplateID))
+		return
+	}
+
+	parameters := make(map[string]interface{})
+	if err := json.Unmarshal(template.Parameters, &parameters); err != nil {
+		logging.DebugLogContext(ctx, "Template %s has invalid parameters: %v", template.ID, err)
+		c.sendError(ErrorTypeValidation, fmt.Sprintf("Invalid template: %s", template.ID))
+		return
+	}
+	parameters["productType"] = template.Type
+	for name, value := range request.Overrides {
+		parameters[name] = value
+	}
+
+	submission, err := json.Marshal(parameters)
+	if err != nil {
+		c.sendError(ErrorTypeValidation, "Invalid template overrides")
+		return
+	}
+	logging.DebugLogContext(ctx, "Creating contract from template %s", template.ID)
+	c.handleContractSubmission(ctx, submission)
+}
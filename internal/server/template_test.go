@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pricingserver/internal/contracts"
+)
+
+func TestCreateFromTemplateAppliesOverrides(t *testing.T) {
+	h, mock, prices := newServiceTestHub(t)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/contract/template/template-1" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(contracts.ContractTemplate{
+			ID:         "template-1",
+			Name:       "Three rungs",
+			Type:       "LuckyLadder",
+			Parameters: json.RawMessage(`{"rungs": [101, 102, 103], "duration": 60000, "payoff": 10}`),
+		})
+	}))
+	t.Cleanup(storage.Close)
+	t.Setenv("STORAGE_SERVICE_URL", storage.URL)
+	h.StorageService = contracts.NewStorageServiceClient()
+	client := newTenantTestClient(h, "client-1", "")
+
+	create := func(templateID string) map[string]interface{} {
+		data, _ := json.Marshal(createFromTemplateData{TemplateID: templateID, Overrides: map[string]interface{}{"payoff": 50}})
+		return answerWhileTicking(t, client, prices, func() {
+			client.handleCreateFromTemplate(context.Background(), data)
+		})
+	}
+	if accepted := create("template-1"); accepted["type"] != MessageTypeContractAccepted {
+		t.Fatalf("CreateFromTemplate answered %v, want ContractAccepted", accepted)
+	}
+	var added *contracts.ContractParams
+	for _, request := range mock.RecordedRequests() {
+		if request.Method == http.MethodPost && request.Path == "/contracts" {
+			added = &contracts.ContractParams{}
+			json.Unmarshal(request.Body, added)
+		}
+	}
+	if added == nil {
+		t.Fatal("Contracts service was sent no contract")
+	}
+	if rungs, _ := added.Parameters["rungs"].([]interface{}); added.ContractType != "lucky_ladder" || added.Parameters["payoff"] != 50.0 || len(rungs) != 3 {
+		t.Fatalf("Contracts service was sent %+v, want the template rungs with the payoff overridden to 50", added)
+	}
+
+	if rejected := create("template-unknown"); rejected["type"] != MessageTypeError || rejected["message"] != "Template not found: template-unknown" {
+		t.Errorf("CreateFromTemplate of an unknown template answered %v, want Template not found", rejected)
+	}
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"pricingserver/internal/common/logging"
+	"pricingserver/internal/simulation"
+)
+
+// tenantMetricsCacheTTL is how long an aggregated tenant metrics report is reused
+const tenantMetricsCacheTTL = 5 * time.Second
+
+// tenantEngineIdleTimeout is how long a tenant simulation engine may run
+// without subscribers before it is stopped
+const tenantEngineIdleTimeout = 5 * time.Minute
+
+// ErrTenantLimitExceeded is returned when a tenant has reached one of its limits
+var ErrTenantLimitExceeded = errors.New("tenant limit exceeded")
+
+// TenantConfig holds the limits applied to a single tenant. A zero value
+// means the limit is not enforced.
+type TenantConfig struct {
+	MaxContracts   int     `json:"maxContracts"`
+	MaxPayoffTotal float64 `json:"maxPayoffTotal"`
+	// TickIntervalMs is the tick interval of the tenant's simulation engine
+	TickIntervalMs int `json:"tickIntervalMs"`
+}
+
+// TenantMetrics tracks the active contracts of a tenant
+type TenantMetrics struct {
+	ActiveContracts int
+	PayoffTotal     float64
+}
+
+// TenantMetricsSummary is the resource usage of a tenant reported to admins
+type TenantMetricsSummary struct {
+	ActiveContracts        int     `json:"activeContracts"`
+	TicksPerSec            float64 `json:"ticksPerSec"`
+	TotalPayoffOutstanding float64 `json:"totalPayoffOutstanding"`
+	ConnectedClients       int     `json:"connectedClients"`
+}
+
+// LoadTenantLimits reads per-tenant limits from the TENANT_LIMITS environment
+// variable, a JSON object keyed by tenant ID
+func LoadTenantLimits() map[string]TenantConfig {
+	raw := os.Getenv("TENANT_LIMITS")
+	if raw == "" {
+		return nil
+	}
+	var limits map[string]TenantConfig
+	if err := json.Unmarshal([]byte(raw), &limits); err != nil {
+		logging.DebugLog("Ignoring invalid TENANT_LIMITS: %v", err)
+		return nil
+	}
+	return limits
+}
+
+// reserveTenantContract checks the limits of tenantID and, if the contract
+// fits, counts it against the tenant until releaseTenantContract is called
+func (h *Hub) reserveTenantContract(tenantID, contractID string, payoff float64) error {
+	h.tenantsMu.Lock()
+	defer h.tenantsMu.Unlock()
+
+	metrics, ok := h.tenantMetrics[tenantID]
+	if !ok {
+		metrics = &TenantMetrics{}
+		h.tenantMetrics[tenantID] = metrics
+	}
+
+	if limits, ok := h.TenantLimits[tenantID]; ok {
+		if limits.MaxContracts > 0 && metrics.ActiveContracts >= limits.MaxContracts {
+			return ErrTenantLimitExceeded
+		}
+		if limits.MaxPayoffTotal > 0 && metrics.PayoffTotal+payoff > limits.MaxPayoffTotal {
+			return ErrTenantLimitExceeded
+		}
+	}
+
+	metrics.ActiveContracts++
+	metrics.PayoffTotal += payoff
+	h.contractTenants[contractID] = tenantID
+	h.contractPayoffs[contractID] = payoff
+	return nil
+}
+
+// releaseTenantContract stops counting a settled or removed contract against
+// its tenant. It is a no-op for contracts that were never reserved.
+func (h *Hub) releaseTenantContract(contractID string) {
+	h.tenantsMu.Lock()
+	defer h.tenantsMu.Unlock()
+
+	payoff, ok := h.contractPayoffs[contractID]
+	if !ok {
+		return
+	}
+	delete(h.contractPayoffs, contractID)
+	if metrics, ok := h.tenantMetrics[h.contractTenants[contractID]]; ok {
+		metrics.ActiveContracts--
+		metrics.PayoffTotal -= payoff
+	}
+}
+
+// GetTenantMetrics returns a copy of the current metrics of tenantID
+func (h *Hub) GetTenantMetrics(tenantID string) TenantMetrics {
+	h.tenantsMu.RLock()
+	defer h.tenantsMu.RUnlock()
+	if metrics, ok := h.tenantMetrics[tenantID]; ok {
+		return *metrics
+	}
+	return TenantMetrics{}
+}
+
+// contractVisibleTo reports whether a contract may be accessed by tenantID.
+// Contracts the hub did not create, such as those restored on startup, belong
+// to the default (empty) tenant.
+func (h *Hub) contractVisibleTo(tenantID, contractID string) bool {
+	h.tenantsMu.RLock()
+	defer h.tenantsMu.RUnlock()
+	owner, ok := h.contractTenants[contractID]
+	if !ok {
+		return tenantID == ""
+	}
+	return owner == tenantID
+}
+
+func (h *Hub) forgetContractTenant(contractID string) {
+	h.releaseTenantContract(contractID)
+	h.tenantsMu.Lock()
+	delete(h.contractTenants, contractID)
+	h.tenantsMu.Unlock()
+}
+
+// subscribePrices subscribes a contract to the prices of instrument, or to
+// the default prices when it is empty, from the price source of tenantID.
+// Contracts with a strike are priced with the implied volatility of their
+// strike and maturity when the price source supports it. Contracts of a type
+// with ProductSimulationOverrides are priced by the engine of their type.
+// Tenants get their own simulation engine, created on first use, so a busy
+// tenant cannot slow down price generation for the others. The default tenant
+// and external price feeds use the shared emitter.
+func (h *Hub) subscribePrices(tenantID, contractID, contractType, instrument string, strike, maturityDays float64, handler simulation.PriceHandler) {
+	h.enginesMu.Lock()
+	defer h.enginesMu.Unlock()
+	var emitter simulation.PriceEmitter
+	if engine, ok := h.productEngine(contractType); ok {
+		emitter = engine
+	} else {
+		emitter = h.tenantEmitter(tenantID, true)
+	}
+	if subscriber, ok := emitter.(simulation.StrikeSubscriber); ok && strike > 0 {
+		subscriber.SubscribeStrike(instrument, contractID, strike, maturityDays, handler)
+		return
+	}
+	if subscriber, ok := emitter.(simulation.InstrumentSubscriber); ok && instrument != "" {
+		subscriber.SubscribeInstrument(instrument, contractID, handler)
+		return
+	}
+	emitter.Subscribe(contractID, handler)
+}
+
+// unsubscribePrices removes a contract from the price source it was subscribed to
+func (h *Hub) unsubscribePrices(contractID string) {
+	h.tenantsMu.RLock()
+	tenantID := h.contractTenants[contractID]
+	h.tenantsMu.RUnlock()
+
+	h.enginesMu.Lock()
+	defer h.enginesMu.Unlock()
+	h.tenantEmitter(tenantID, false).Unsubscribe(contractID)
+	for _, engine := range h.productEngines {
+		engine.Unsubscribe(contractID)
+	}
+}
+
+// tenantEmitter returns the price source of tenantID, creating the tenant's
+// engine if create is set. Callers must hold enginesMu.
+func (h *Hub) tenantEmitter(tenantID string, create bool) simulation.PriceEmitter {
+	if tenantID == "" {
+		return h.SimulationEngine
+	}
+	if _, simulated := h.SimulationEngine.(*simulation.SimulationEngine); !simulated {
+		return h.SimulationEngine
+	}
+
+	engine, ok := h.TenantSimulationEngine[tenantID]
+	if !ok {
+		if !create {
+			return h.SimulationEngine
+		}
+		engine = simulation.NewSimulationEngineWithConfig(simulation.SimulationConfigFromEnv())
+		if interval := h.TenantLimits[tenantID].TickIntervalMs; interval > 0 {
+			engine.TickInterval = time.Duration(interval) * time.Millisecond
+		}
+		logging.DebugLog("Starting simulation engine for tenant %q with tick interval %v", tenantID, engine.TickInterval)
+		engine.Start()
+		h.TenantSimulationEngine[tenantID] = engine
+	}
+	delete(h.engineIdleSince, tenantID)
+	return engine
+}
+
+// reapIdleEngines periodically stops tenant engines that have had no
+// subscribers for longer than tenantEngineIdleTimeout
+func (h *Hub) reapIdleEngines() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		h.enginesMu.Lock()
+		for tenantID, engine := range h.TenantSimulationEngine {
+			if engine.SubscriberCount() > 0 {
+				delete(h.engineIdleSince, tenantID)
+				continue
+			}
+			idleSince, ok := h.engineIdleSince[tenantID]
+			if !ok {
+				h.engineIdleSince[tenantID] = now
+				continue
+			}
+			if now.Sub(idleSince) > tenantEngineIdleTimeout {
+				logging.DebugLog("Stopping idle simulation engine for tenant %q", tenantID)
+				engine.Stop()
+				delete(h.TenantSimulationEngine, tenantID)
+				delete(h.engineIdleSince, tenantID)
+			}
+		}
+		h.enginesMu.Unlock()
+	}
+}
+
+// TenantMetricsReport aggregates the usage of every known tenant. Reports are
+// cached for tenantMetricsCacheTTL.
+func (h *Hub) TenantMetricsReport() map[string]TenantMetricsSummary {
+	h.reportMu.Lock()
+	defer h.reportMu.Unlock()
+	if h.metricsReport != nil && time.Since(h.metricsReportAt) < tenantMetricsCacheTTL {
+		return h.metricsReport
+	}
+
+	report := make(map[string]TenantMetricsSummary)
+
+	h.tenantsMu.RLock()
+	for tenantID, metrics := range h.tenantMetrics {
+		report[tenantID] = TenantMetricsSummary{
+			ActiveContracts:        metrics.ActiveContracts,
+			TotalPayoffOutstanding: metrics.PayoffTotal,
+		}
+	}
+	h.tenantsMu.RUnlock()
+
+	h.mu.Lock()
+	for client := range h.Clients {
+		summary := report[client.TenantID]
+		summary.ConnectedClients++
+		report[client.TenantID] = summary
+	}
+	h.mu.Unlock()
+
+	h.enginesMu.Lock()
+	for tenantID, summary := range report {
+		interval := simulation.DefaultTickInterval
+		if engine, ok := h.TenantSimulationEngine[tenantID]; ok {
+			interval = engine.TickInterval
+		}
+		if summary.ActiveContracts > 0 {
+			summary.TicksPerSec = float64(time.Second) / float64(interval)
+		}
+		report[tenantID] = summary
+	}
+	h.enginesMu.Unlock()
+
+	h.metricsReport = report
+	h.metricsReportAt = time.Now()
+	return report
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pricingserver/internal/simulation"
+)
+
+// newTenantTestClient returns a client of tenantID connected to h
+func newTenantTestClient(h *Hub, clientID, tenantID string) *Client {
+	client := &Client{
+		ID:        clientID,
+		Hub:       h,
+		Send:      make(chan []byte, 10),
+		Contracts: make(map[string]string),
+		TenantID:  tenantID,
+	}
+	h.mu.Lock()
+	h.Clients[client] = true
+	h.mu.Unlock()
+	return client
+}
+
+// submitThroughClient submits data as client, ticking prices until the
+// submission is answered, and returns the ContractAccepted or Error message
+func submitThroughClient(t *testing.T, client *Client, prices *manualEmitter, data ContractData) map[string]interface{} {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return answerWhileTicking(t, client, prices, func() {
+		client.handleContractSubmission(context.Background(), raw)
+	})
+}
+
+// answerWhileTicking runs submit, ticking prices until it returns, and
+// returns the ContractAccepted or Error message it sent to client
+func answerWhileTicking(t *testing.T, client *Client, prices *manualEmitter, submit func()) map[string]interface{} {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		submit()
+		close(done)
+	}()
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			for {
+				select {
+				case frame := <-client.Send:
+					var message map[string]interface{}
+					json.Unmarshal(frame, &message)
+					if message["type"] == MessageTypeContractAccepted || message["type"] == MessageTypeError {
+						return message
+					}
+				default:
+					t.Fatal("Submission was answered with neither ContractAccepted nor Error")
+				}
+			}
+		case <-ticker.C:
+			prices.tick(100)
+		}
+	}
+}
+
+// signTestJWT returns an HS256 JWT with claims signed with secret
+func signTestJWT(claims Claims, secret string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// withJWTSecret enables bearer token authentication with secret for the
+// duration of the test
+func withJWTSecret(t *testing.T, secret string) {
+	previous := jwtSecret
+	jwtSecret = secret
+	t.Cleanup(func() { jwtSecret = previous })
+}
+
+func TestClientCannotQueryContractOfAnotherTenant(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	owner := newTenantTestClient(h, "owner", "tenant-a")
+	other := newTenantTestClient(h, "other", "tenant-b")
+
+	accepted := submitThroughClient(t, owner, prices, testLuckyLadder())
+	contractID, _ := accepted["contractID"].(string)
+	if accepted["type"] != MessageTypeContractAccepted || contractID == "" {
+		t.Fatalf("Submission answered %v, want ContractAccepted", accepted)
+	}
+	// Even a client that learnt the ID cannot see across tenants
+	other.Contracts[contractID] = "LuckyLadder"
+
+	other.handleContractQuery(context.Background(), contractID)
+	reply := nextMessageOfType(other, MessageTypeError)
+	if reply == nil || reply["message"] != "Contract not found: "+contractID {
+		t.Fatalf("Query from tenant B answered %v, want a contract not found error", reply)
+	}
+	if update := nextMessageOfType(other, MessageTypeContractUpdate); update != nil {
+		t.Fatalf("Tenant B received %v", update)
+	}
+
+	owner.handleContractQuery(context.Background(), contractID)
+	if update := nextMessageOfType(owner, MessageTypeContractUpdate); update == nil || update["contractID"] != contractID {
+		t.Fatalf("Query from the owner answered %v, want the contract state", update)
+	}
+}
+
+func TestSSEStreamRejectsTokenOfAnotherTenant(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	withJWTSecret(t, "test-secret")
+
+	contractID, err := h.SubmitContract("tenant-a", testLuckyLadder())
+	if err != nil {
+		t.Fatalf("SubmitContract: %v", err)
+	}
+
+	for _, tenantID := range []string{"tenant-b", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/sse/contracts/"+contractID, nil)
+		req.Header.Set("Authorization", "Bearer "+signTestJWT(Claims{Subject: "user", Tenant: tenantID}, "test-secret"))
+		rec := httptest.NewRecorder()
+		h.ServeSSE(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("SSE stream as tenant %q answered %d, want 404", tenantID, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/sse/contracts/"+contractID, nil)
+	rec := httptest.NewRecorder()
+	h.ServeSSE(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("SSE stream without a token answered %d, want 401", rec.Code)
+	}
+}
+
+func TestTenantContractLimitRejectsThirdContract(t *testing.T) {
+	h, _, prices := newServiceTestHub(t)
+	h.TenantLimits = map[string]TenantConfig{"tenant-a": {MaxContracts: 2}}
+	client := newTenantTestClient(h, "client", "tenant-a")
+
+	var contractIDs []string
+	for i := 0; i < 2; i++ {
+		accepted := submitThroughClient(t, client, prices, testLuckyLadder())
+		if accepted["type"] != MessageTypeContractAccepted {
+			t.Fatalf("Submission %d answered %v, want ContractAccepted", i+1, accepted)
+		}
+		contractIDs = append(contractIDs, accepted["contractID"].(string))
+	}
+
+	rejected := submitThroughClient(t, client, prices, testLuckyLadder())
+	if rejected["type"] != MessageTypeError || rejected["errorType"] != ErrorTypeRateLimit {
+		t.Fatalf("Third submission answered %v, want a rate limit error", rejected)
+	}
+	if _, err := h.SubmitContract("tenant-a", testLuckyLadder()); err != ErrTenantLimitExceeded {
+		t.Fatalf("Third submission through the API: %v, want ErrTenantLimitExceeded", err)
+	}
+	if metrics := h.GetTenantMetrics("tenant-a"); metrics.ActiveContracts != 2 {
+		t.Errorf("Tenant A has %d active contracts, want 2", metrics.ActiveContracts)
+	}
+
+	// Other tenants have their own limits
+	if _, err := h.SubmitContract("tenant-b", testLuckyLadder()); err != nil {
+		t.Errorf("Submission of tenant B: %v", err)
+	}
+
+	// A settled contract frees its slot
+	h.contractStateHandler(context.Background(), contractIDs[0], func(map[string]interface{}) {})(map[string]interface{}{"status": "expired"})
+	if _, err := h.SubmitContract("tenant-a", testLuckyLadder()); err != nil {
+		t.Errorf("Submission after a contract settled: %v", err)
+	}
+}
+
+// tickCounter counts the prices it handles
+type tickCounter struct {
+	ticks int64
+}
+
+func (c *tickCounter) HandlePriceUpdate(price float64, timestamp time.Time) {
+	atomic.AddInt64(&c.ticks, 1)
+}
+
+func TestTenantEnginesTickAtTheirOwnInterval(t *testing.T) {
+	h, _, _ := newServiceTestHub(t)
+	h.SimulationEngine = simulation.NewSimulationEngine()
+	h.TenantLimits = map[string]TenantConfig{
+		"tenant-a": {TickIntervalMs: 20},
+		"tenant-b": {TickIntervalMs: 100},
+	}
+	t.Cleanup(func() {
+		h.enginesMu.Lock()
+		defer h.enginesMu.Unlock()
+		for _, engine := range h.TenantSimulationEngine {
+			engine.Stop()
+		}
+	})
+
+	fast, slow := &tickCounter{}, &tickCounter{}
+	h.subscribePrices("tenant-a", "contract-a", "lucky_ladder", "", 0, 0, fast)
+	h.subscribePrices("tenant-b", "contract-b", "lucky_ladder", "", 0, 0, slow)
+	time.Sleep(600 * time.Millisecond)
+
+	fastTicks, slowTicks := atomic.LoadInt64(&fast.ticks), atomic.LoadInt64(&slow.ticks)
+	if slowTicks == 0 || fastTicks < 2*slowTicks {
+		t.Fatalf("Tenant A received %d ticks and tenant B %d, want tenant A ticking several times faster", fastTicks, slowTicks)
+	}
+	h.enginesMu.Lock()
+	engines := len(h.TenantSimulationEngine)
+	h.enginesMu.Unlock()
+	if engines != 2 {
+		t.Errorf("Hub runs %d tenant engines, want one per tenant", engines)
+	}
+}
@@ -2,15 +2,128 @@ package server
 
 import (
     "crypto/rand"
+    "encoding/binary"
     "encoding/hex"
+    "fmt"
+    mathrand "math/rand"
+    "os"
+    "strings"
+    "sync"
+    "time"
 )
 
-// GenerateUniqueID generates a cryptographically secure random ID.
+// ID formats selected with the ID_FORMAT environment variable
+const (
+    IDFormatHex  = "hex"
+    IDFormatULID = "ulid"
+)
+
+// IDFormat is the format of the IDs returned by GenerateUniqueID
+var IDFormat = os.Getenv("ID_FORMAT")
+
+// GenerateUniqueID generates a cryptographically secure random ID, or a ULID
+// when IDFormat is "ulid".
 func GenerateUniqueID() string {
+    if IDFormat == IDFormatULID {
+        return GenerateULID()
+    }
     b := make([]byte, 16)
     _, err := rand.Read(b)
     if err != nil {
         return ""
     }
     return hex.EncodeToString(b)
-}
\ No newline at end of file
+}
+
+// crockfordAlphabet is the Crockford Base32 alphabet used by ULIDs
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+    ulidMu      sync.Mutex
+    ulidEntropy = newULIDEntropy()
+    ulidLastMs  uint64
+    ulidLast    [10]byte
+)
+
+// newULIDEntropy returns the entropy source for ULIDs, seeded once
+func newULIDEntropy() *mathrand.Rand {
+    var seed [8]byte
+    if _, err := rand.Read(seed[:]); err != nil {
+        return mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+    }
+    return mathrand.New(mathrand.NewSource(int64(binary.BigEndian.Uint64(seed[:]))))
+}
+
+// GenerateULID generates a ULID. IDs generated within the same millisecond
+// increment the random part of the previous one, so IDs from this process are
+// strictly increasing when compared as strings.
+func GenerateULID() string {
+    ulidMu.Lock()
+    defer ulidMu.Unlock()
+
+    ms := uint64(time.Now().UnixMilli())
+    if ms <= ulidLastMs {
+        ms = ulidLastMs
+        if !incrementEntropy(&ulidLast) {
+            // The random part overflowed, move on to the next millisecond
+            ms++
+        }
+    } else {
+        ulidEntropy.Read(ulidLast[:])
+    }
+    ulidLastMs = ms
+
+    var id [16]byte
+    id[0] = byte(ms >> 40)
+    id[1] = byte(ms >> 32)
+    id[2] = byte(ms >> 24)
+    id[3] = byte(ms >> 16)
+    id[4] = byte(ms >> 8)
+    id[5] = byte(ms)
+    copy(id[6:], ulidLast[:])
+    return encodeULID(id)
+}
+
+// incrementEntropy adds one to b, reporting false if it wrapped around
+func incrementEntropy(b *[10]byte) bool {
+    for i := len(b) - 1; i >= 0; i-- {
+        b[i]++
+        if b[i] != 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// encodeULID encodes a 128-bit ULID as 26 Crockford Base32 characters
+func encodeULID(id [16]byte) string {
+    hi := binary.BigEndian.Uint64(id[:8])
+    lo := binary.BigEndian.Uint64(id[8:])
+    var out [26]byte
+    for i := len(out) - 1; i >= 0; i-- {
+        out[i] = crockfordAlphabet[lo&31]
+        lo = lo>>5 | hi<<59
+        hi >>= 5
+    }
+    return string(out[:])
+}
+
+// ULIDToTime returns the creation time embedded in a ULID
+func ULIDToTime(id string) (time.Time, error) {
+    if len(id) != 26 {
+        return time.Time{}, fmt.Errorf("invalid ULID length: %d", len(id))
+    }
+    if id[0] > '7' {
+        return time.Time{}, fmt.Errorf("invalid ULID: %s", id)
+    }
+    var ms uint64
+    for _, c := range strings.ToUpper(id) {
+        if strings.IndexRune(crockfordAlphabet, c) < 0 {
+            return time.Time{}, fmt.Errorf("invalid ULID character: %q", c)
+        }
+    }
+    for _, c := range strings.ToUpper(id[:10]) {
+        ms = ms<<5 | uint64(strings.IndexRune(crockfordAlphabet, c))
+    }
+    return time.UnixMilli(int64(ms)), nil
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateULIDIsMonotonic(t *testing.T) {
+	previous := GenerateULID()
+	for i := 0; i < 10000; i++ {
+		id := GenerateULID()
+		if len(id) != 26 {
+			t.Fatalf("ULID %q has %d characters, want 26", id, len(id))
+		}
+		if id <= previous {
+			t.Fatalf("ULID %q generated after %q is not greater", id, previous)
+		}
+		previous = id
+	}
+}
+
+func TestULIDToTime(t *testing.T) {
+	before := time.Now().Truncate(time.Millisecond)
+	id := GenerateULID()
+	after := time.Now()
+
+	created, err := ULIDToTime(id)
+	if err != nil {
+		t.Fatalf("ULIDToTime(%q): %v", id, err)
+	}
+	if created.Before(before) || created.After(after) {
+		t.Errorf("ULIDToTime(%q) = %s, want between %s and %s", id, created, before, after)
+	}
+}
+
+func TestULIDToTimeRejectsInvalidIDs(t *testing.T) {
+	for _, id := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FA", "81ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAU"} {
+		if _, err := ULIDToTime(id); err == nil {
+			t.Errorf("ULIDToTime(%q) returned no error", id)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"pricingserver/internal/common/logging"
+)
+
+// ValidationResult reports whether contract data would be accepted by a
+// ContractSubmission
+type ValidationResult struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ValidateContract checks contract data without creating the contract,
+// calling the contracts service or reserving tenant capacity
+func ValidateContract(data ContractData) ValidationResult {
+	if err := ValidateContractData(&data); err != nil {
+		return ValidationResult{Valid: false, Errors: []string{err.Error()}}
+	}
+	return ValidationResult{Valid: true}
+}
+
+// handleValidateContract answers a ValidateContract message with a
+// ValidationResult
+func (c *Client) handleValidateContract(ctx context.Context, data json.RawMessage) {
+	var contractData ContractData
+	if err := json.Unmarshal(data, &contractData); err != nil {
+		logging.DebugLogContext(ctx, "Failed to unmarshal contract data: %v", err)
+		c.sendError(ErrorTypeParse, "Invalid contract data format")
+		return
+	}
+
+	result := ValidateContract(contractData)
+	logging.DebugLogContext(ctx, "Validated contract data: %+v", result)
+	c.sendMessage(map[string]interface{}{
+		"type": MessageTypeValidationResult,
+		"data": result,
+	})
+}
@@ -0,0 +1,34 @@
+package simulation
+
+import (
+	"sync"
+	"time"
+)
+
+// ackHandler forwards prices to a handler and closes delivered once the
+// handler returned from the first one
+type ackHandler struct {
+	handler   PriceHandler
+	once      sync.Once
+	delivered chan struct{}
+}
+
+// AcknowledgeFirstPrice wraps handler so that the returned channel is closed
+// once handler has handled its first price update
+func AcknowledgeFirstPrice(handler PriceHandler) (PriceHandler, <-chan struct{}) {
+	ack := &ackHandler{handler: handler, delivered: make(chan struct{})}
+	return ack, ack.delivered
+}
+
+// HandlePriceUpdate implements PriceHandler
+func (a *ackHandler) HandlePriceUpdate(price float64, timestamp time.Time) {
+	a.handler.HandlePriceUpdate(price, timestamp)
+	a.once.Do(func() { close(a.delivered) })
+}
+
+// HandlePriceQuote implements PriceHandlerV2, keeping bid and ask for
+// handlers that price against them
+func (a *ackHandler) HandlePriceQuote(quote PriceQuote, timestamp time.Time) {
+	quoteHandler(a.handler).HandlePriceQuote(quote, timestamp)
+	a.once.Do(func() { close(a.delivered) })
+}
@@ -0,0 +1,178 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// csvTick is a single row of a CSV price file
+type csvTick struct {
+	timestamp int64 // milliseconds
+	price     float64
+}
+
+// CSVFeed replays historical prices read from a CSV file for backtesting
+type CSVFeed struct {
+	// Speed is the playback speed multiplier; 2 replays twice as fast as the
+	// recorded timestamps. Values <= 0 replay without sleeping.
+	Speed float64
+
+	ticks       []csvTick
+	subscribers map[string]PriceHandler
+	mu          sync.Mutex
+	started     bool
+	playing     bool
+	stopChan    chan struct{}
+	done        chan struct{}
+}
+
+// NewCSVFeed reads a two column CSV file of timestamp_ms,price rows. A header
+// row is skipped if present.
+func NewCSVFeed(path string) (*CSVFeed, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var ticks []csvTick
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		timestamp, tsErr := strconv.ParseInt(strings.TrimSpace(record[0]), 10, 64)
+		price, priceErr := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if tsErr != nil || priceErr != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("invalid price row at line %d: %v", line, record)
+		}
+		ticks = append(ticks, csvTick{timestamp: timestamp, price: price})
+	}
+	if len(ticks) == 0 {
+		return nil, fmt.Errorf("no prices found in %s", path)
+	}
+
+	sort.SliceStable(ticks, func(i, j int) bool { return ticks[i].timestamp < ticks[j].timestamp })
+	logging.DebugLog("Loaded %d prices from %s", len(ticks), path)
+
+	return &CSVFeed{
+		Speed:       1,
+		ticks:       ticks,
+		subscribers: make(map[string]PriceHandler),
+		stopChan:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// Start arms the feed. Playback begins when the first contract subscribes so
+// that it sees the whole recording.
+func (f *CSVFeed) Start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logging.DebugLog("Starting CSV price feed")
+	f.started = true
+	if len(f.subscribers) > 0 {
+		f.play()
+	}
+}
+
+// Stop ends playback
+func (f *CSVFeed) Stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.stopChan:
+		return
+	default:
+		close(f.stopChan)
+	}
+	if !f.playing {
+		f.playing = true
+		close(f.done)
+	}
+}
+
+// Done is closed once every price has been replayed or the feed is stopped
+func (f *CSVFeed) Done() <-chan struct{} {
+	return f.done
+}
+
+// Subscribe adds a handler to receive price updates
+func (f *CSVFeed) Subscribe(contractID string, handler PriceHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logging.DebugLog("Adding CSV feed subscription for contract %s", contractID)
+	f.subscribers[contractID] = handler
+	if f.started {
+		f.play()
+	}
+}
+
+// Unsubscribe removes a handler from receiving price updates
+func (f *CSVFeed) Unsubscribe(contractID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logging.DebugLog("Removing CSV feed subscription for contract %s", contractID)
+	delete(f.subscribers, contractID)
+}
+
+// play starts the replay goroutine once. Callers must hold f.mu.
+func (f *CSVFeed) play() {
+	if f.playing {
+		return
+	}
+	f.playing = true
+	go f.replay()
+}
+
+func (f *CSVFeed) replay() {
+	defer close(f.done)
+	for i, tick := range f.ticks {
+		if i > 0 && f.Speed > 0 {
+			gap := time.Duration(float64(tick.timestamp-f.ticks[i-1].timestamp) / f.Speed * float64(time.Millisecond))
+			select {
+			case <-time.After(gap):
+			case <-f.stopChan:
+				return
+			}
+		}
+		select {
+		case <-f.stopChan:
+			return
+		default:
+		}
+
+		timestamp := time.UnixMilli(tick.timestamp)
+		f.mu.Lock()
+		handlers := make([]PriceHandler, 0, len(f.subscribers))
+		for _, handler := range f.subscribers {
+			handlers = append(handlers, handler)
+		}
+		f.mu.Unlock()
+
+		// Deliver synchronously so every contract sees prices in file order
+		for _, handler := range handlers {
+			handler.HandlePriceUpdate(tick.price, timestamp)
+		}
+	}
+	logging.DebugLog("CSV price feed finished after %d prices", len(f.ticks))
+}
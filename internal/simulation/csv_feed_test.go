@@ -0,0 +1,47 @@
+package simulation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVFeedReplaysEveryPriceInTimestampOrder(t *testing.T) {
+	// Rows are out of order; the feed sorts them by timestamp
+	var rows strings.Builder
+	rows.WriteString("timestamp_ms,price\n")
+	for _, i := range []int{3, 0, 1, 2, 4, 9, 5, 6, 8, 7} {
+		fmt.Fprintf(&rows, "%d,%v\n", 1700000000000+int64(i)*10, 100+float64(i)/10)
+	}
+	path := filepath.Join(t.TempDir(), "prices.csv")
+	if err := os.WriteFile(path, []byte(rows.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := NewCSVFeed(path)
+	if err != nil {
+		t.Fatalf("NewCSVFeed: %v", err)
+	}
+	feed.Speed = 10
+	recorder := priceRecorder{prices: make(chan float64, 20)}
+	feed.Start()
+	defer feed.Stop()
+	feed.Subscribe("contract-1", recorder)
+
+	select {
+	case <-feed.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Feed did not finish replaying")
+	}
+	if received := len(recorder.prices); received != 10 {
+		t.Fatalf("Contract received %d prices, want 10", received)
+	}
+	for i := 0; i < 10; i++ {
+		if price, want := <-recorder.prices, 100+float64(i)/10; price != want {
+			t.Errorf("Price %d is %v, want %v", i+1, price, want)
+		}
+	}
+}
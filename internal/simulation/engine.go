@@ -1,9 +1,13 @@
 package simulation
 
 import (
+	"fmt"
 	"math"
-	"math/rand"
+	"os"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"pricingserver/internal/common/logging"
@@ -14,68 +18,479 @@ type PriceHandler interface {
 	HandlePriceUpdate(price float64, timestamp time.Time)
 }
 
+// PriceQuote is a simulated mid price with the bid and ask around it
+type PriceQuote struct {
+	Mid float64
+	Bid float64
+	Ask float64
+}
+
+// PriceHandlerV2 is implemented by handlers that price against bid or ask
+// rather than the mid price
+type PriceHandlerV2 interface {
+	HandlePriceQuote(quote PriceQuote, timestamp time.Time)
+}
+
+// PriceHandlerAdapter lets a mid-price PriceHandler receive price quotes
+type PriceHandlerAdapter struct {
+	PriceHandler
+}
+
+// HandlePriceQuote implements PriceHandlerV2 by forwarding the mid price
+func (a PriceHandlerAdapter) HandlePriceQuote(quote PriceQuote, timestamp time.Time) {
+	a.HandlePriceUpdate(quote.Mid, timestamp)
+}
+
+// quoteHandler returns handler as a PriceHandlerV2, wrapping handlers that
+// only understand mid prices
+func quoteHandler(handler PriceHandler) PriceHandlerV2 {
+	if v2, ok := handler.(PriceHandlerV2); ok {
+		return v2
+	}
+	return PriceHandlerAdapter{handler}
+}
+
+// PriceEmitter represents any source of price updates that contracts can subscribe to
+type PriceEmitter interface {
+	Start()
+	Stop()
+	Subscribe(contractID string, handler PriceHandler)
+	Unsubscribe(contractID string)
+}
+
+// InstrumentSubscriber is implemented by price emitters that generate
+// separate prices for several instruments, e.g. "EUR/USD"
+type InstrumentSubscriber interface {
+	SubscribeInstrument(instrument, contractID string, handler PriceHandler)
+}
+
+// SimulationConfig configures a simulation engine
+type SimulationConfig struct {
+	TickInterval time.Duration
+	BasePrice    float64
+	// Drift and Volatility are the GBM parameters of the price
+	Drift      float64
+	Volatility float64
+	// Spread is the distance between bid and ask, centred on the mid price
+	Spread float64
+	// Rand generates the price shocks, defaulting to the global source
+	Rand RandSource
+	// Process is the stochastic process prices follow, defaulting to GBM
+	Process ProcessType
+	// HestonKappa is the rate at which the variance reverts to HestonTheta
+	HestonKappa float64
+	// HestonTheta is the long-run variance
+	HestonTheta float64
+	// HestonXi is the volatility of the variance
+	HestonXi float64
+	// HestonRho is the correlation between price and variance shocks
+	HestonRho float64
+	// InstrumentConfig configures an engine per instrument, e.g. "BTC/USD",
+	// for contracts subscribed with SubscribeInstrument. The configurations
+	// are complete, e.g. built from DefaultSimulationConfig, except that
+	// their TickInterval defaults to the engine's.
+	InstrumentConfig map[string]SimulationConfig
+	// VolatilitySurface holds the implied volatility by strike and maturity
+	// in days, e.g. VolatilitySurface[100][30], used for contracts subscribed
+	// with SubscribeStrike. Volatilities are in the units of Volatility.
+	VolatilitySurface map[float64]map[float64]float64
+	// WarmupTicks is the number of prices generated without notifying
+	// subscribers when the engine first starts, so the first price they see
+	// is not the configured BasePrice
+	WarmupTicks int
+}
+
+// DefaultSimulationConfig returns the configuration of a new simulation engine
+func DefaultSimulationConfig() SimulationConfig {
+	return SimulationConfig{
+		TickInterval: DefaultTickInterval,
+		BasePrice:    100.0,
+		Drift:        DefaultDrift,
+		Volatility:   DefaultVolatility,
+		Rand:         GlobalRandSource(),
+		Process:      ProcessGBM,
+		HestonKappa:  DefaultHestonKappa,
+		HestonTheta:  DefaultHestonTheta,
+		HestonXi:     DefaultHestonXi,
+		HestonRho:    DefaultHestonRho,
+	}
+}
+
+// SimulationConfigFromEnv reads SIMULATION_TICK_INTERVAL_MS,
+// SIMULATION_BASE_PRICE, SIMULATION_SPREAD, SIMULATION_PROCESS, the
+// SIMULATION_HESTON_* parameters, SIMULATION_WARMUP_TICKS and
+// SIMULATION_VOLATILITY_SURFACE, keeping defaults for unset or invalid values
+func SimulationConfigFromEnv() SimulationConfig {
+	config := DefaultSimulationConfig()
+	if ms, err := strconv.Atoi(os.Getenv("SIMULATION_TICK_INTERVAL_MS")); err == nil && ms > 0 {
+		config.TickInterval = time.Duration(ms) * time.Millisecond
+	}
+	if price, err := strconv.ParseFloat(os.Getenv("SIMULATION_BASE_PRICE"), 64); err == nil && price > 0 {
+		config.BasePrice = price
+	}
+	if spread, err := strconv.ParseFloat(os.Getenv("SIMULATION_SPREAD"), 64); err == nil && spread >= 0 {
+		config.Spread = spread
+	}
+	if process := ProcessType(os.Getenv("SIMULATION_PROCESS")); process == ProcessGBM || process == ProcessHeston {
+		config.Process = process
+	}
+	if kappa, err := strconv.ParseFloat(os.Getenv("SIMULATION_HESTON_KAPPA"), 64); err == nil && kappa > 0 {
+		config.HestonKappa = kappa
+	}
+	if theta, err := strconv.ParseFloat(os.Getenv("SIMULATION_HESTON_THETA"), 64); err == nil && theta > 0 {
+		config.HestonTheta = theta
+	}
+	if xi, err := strconv.ParseFloat(os.Getenv("SIMULATION_HESTON_XI"), 64); err == nil && xi >= 0 {
+		config.HestonXi = xi
+	}
+	if rho, err := strconv.ParseFloat(os.Getenv("SIMULATION_HESTON_RHO"), 64); err == nil && rho >= -1 && rho <= 1 {
+		config.HestonRho = rho
+	}
+	if ticks, err := strconv.Atoi(os.Getenv("SIMULATION_WARMUP_TICKS")); err == nil && ticks >= 0 {
+		config.WarmupTicks = ticks
+	}
+	if surface, err := parseVolatilitySurface(os.Getenv("SIMULATION_VOLATILITY_SURFACE")); err == nil {
+		config.VolatilitySurface = surface
+	} else {
+		logging.DebugLog("Ignoring SIMULATION_VOLATILITY_SURFACE: %v", err)
+	}
+	return config
+}
+
 // SimulationEngine generates simulated price data
 type SimulationEngine struct {
 	subscribers map[string]PriceHandler // Maps contract IDs to price handlers
 	mu          sync.Mutex
-	ticker      *time.Ticker
-	stopChan    chan bool
+	tickPolicy  TickPolicy
+	// wake interrupts the wait for the next tick when the tick policy
+	// changes or the first contract subscribes
+	wake     chan struct{}
+	stopChan chan bool
+	// instruments holds the engine of each configured instrument, guarded by
+	// mu
+	instruments map[string]*SimulationEngine
+	// running is set between Start and Stop
+	running bool
+	// contractPaths holds the prices of contracts simulated with the implied
+	// volatility of their strike
+	contractPaths map[string]*contractPath
+	// lastShock is the standard normal price shock of the last tick
+	lastShock float64
 	// BasePrice allows products to set a starting price if needed
 	BasePrice float64
+	// Drift and Volatility are the GBM parameters of the price
+	Drift      float64
+	Volatility float64
+	// VolatilitySurface holds the implied volatility by strike and maturity
+	// in days
+	VolatilitySurface map[float64]map[float64]float64
+	// TickInterval is the time between generated prices when no tick policy
+	// is set
+	TickInterval time.Duration
+	// Spread is the distance between the generated bid and ask
+	Spread float64
+	// Rand generates the price shocks
+	Rand RandSource
+	// Process is the stochastic process prices follow
+	Process ProcessType
+	// CurrentVariance is the instantaneous variance of the Heston process
+	CurrentVariance float64
+	// Heston process parameters, see SimulationConfig
+	HestonKappa float64
+	HestonTheta float64
+	HestonXi    float64
+	HestonRho   float64
+	// WarmupTicks is the number of prices generated before the first start
+	// notifies subscribers
+	WarmupTicks int
+	warmedUp    bool
+	// OnRestart, when set, is called by Start after a Stop with the contracts
+	// that were subscribed when the engine stopped
+	OnRestart func(contractIDs []string)
+	// stopped is set by Stop until the engine is started again, with the
+	// contracts subscribed at the time in stoppedContracts
+	stopped          bool
+	stoppedContracts []string
+
+	// TickCount and MissedTicks count the ticks that generated a price and
+	// those that took more than twice TickInterval to dispatch. They are
+	// updated atomically.
+	TickCount   int64
+	MissedTicks int64
+	// LastTickLatency is the time from the last tick firing to its price
+	// being dispatched to every subscriber, guarded by mu
+	LastTickLatency time.Duration
+}
+
+// EngineTelemetry reports whether a simulation engine keeps up with its
+// tick interval
+type EngineTelemetry struct {
+	TickCount       int64         `json:"engine_ticks"`
+	MissedTicks     int64         `json:"missed_ticks"`
+	LastTickLatency time.Duration `json:"-"`
+	// LastTickLatencyMs is LastTickLatency in milliseconds
+	LastTickLatencyMs float64 `json:"last_tick_latency_ms"`
 }
 
+// DefaultTickInterval is the tick interval of a new simulation engine
+const DefaultTickInterval = 100 * time.Millisecond
+
 // NewSimulationEngine creates a new simulation engine
 func NewSimulationEngine() *SimulationEngine {
-	return &SimulationEngine{
-		subscribers: make(map[string]PriceHandler),
-		stopChan:    make(chan bool),
-		BasePrice:   100.0, // Set a default base price
+	return NewSimulationEngineWithConfig(DefaultSimulationConfig())
+}
+
+// NewSimulationEngineWithConfig creates a new simulation engine from config
+func NewSimulationEngineWithConfig(config SimulationConfig) *SimulationEngine {
+	engine := &SimulationEngine{
+		subscribers:       make(map[string]PriceHandler),
+		contractPaths:     make(map[string]*contractPath),
+		wake:              make(chan struct{}, 1),
+		stopChan:          make(chan bool),
+		BasePrice:         config.BasePrice,
+		Drift:             config.Drift,
+		Volatility:        config.Volatility,
+		VolatilitySurface: config.VolatilitySurface,
+		TickInterval:      config.TickInterval,
+		Spread:            config.Spread,
+		Rand:              config.Rand,
+		Process:           config.Process,
+		HestonKappa:       config.HestonKappa,
+		HestonTheta:       config.HestonTheta,
+		HestonXi:          config.HestonXi,
+		HestonRho:         config.HestonRho,
+		WarmupTicks:       config.WarmupTicks,
+		// The variance starts at its long-run level
+		CurrentVariance: config.HestonTheta,
 	}
+	if engine.Rand == nil {
+		engine.Rand = GlobalRandSource()
+	}
+	if len(config.InstrumentConfig) > 0 {
+		engine.instruments = make(map[string]*SimulationEngine, len(config.InstrumentConfig))
+		for instrument, instrumentConfig := range config.InstrumentConfig {
+			if instrumentConfig.TickInterval <= 0 {
+				instrumentConfig.TickInterval = config.TickInterval
+			}
+			instrumentConfig.InstrumentConfig = nil
+			engine.instruments[instrument] = NewSimulationEngineWithConfig(instrumentConfig)
+		}
+	}
+	return engine
+}
+
+// Instrument returns the engine generating the prices of instrument, or nil
+// if the instrument is not configured
+func (se *SimulationEngine) Instrument(instrument string) *SimulationEngine {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.instruments[instrument]
+}
+
+// NewPriceSeries adds an instrument whose prices are generated independently
+// of the engine's and of the other instruments' from config, like an entry of
+// InstrumentConfig, and returns its engine. The series uses the engine's tick
+// policy and starts right away if the engine is running.
+func (se *SimulationEngine) NewPriceSeries(instrument string, config SimulationConfig) (*SimulationEngine, error) {
+	se.mu.Lock()
+	if _, ok := se.instruments[instrument]; ok {
+		se.mu.Unlock()
+		return nil, fmt.Errorf("instrument %s already has a price series", instrument)
+	}
+	if config.TickInterval <= 0 {
+		config.TickInterval = se.TickInterval
+	}
+	config.InstrumentConfig = nil
+	engine := NewSimulationEngineWithConfig(config)
+	engine.tickPolicy = se.tickPolicy
+	if se.instruments == nil {
+		se.instruments = make(map[string]*SimulationEngine)
+	}
+	se.instruments[instrument] = engine
+	running := se.running
+	se.mu.Unlock()
+
+	logging.DebugLog("Added price series for instrument %s with base price %f and volatility %f", instrument, engine.BasePrice, engine.Volatility)
+	if running {
+		engine.Start()
+	}
+	return engine, nil
+}
+
+// instrumentEngines returns the engines of every instrument. Callers must
+// hold mu.
+func (se *SimulationEngine) instrumentEngines() []*SimulationEngine {
+	engines := make([]*SimulationEngine, 0, len(se.instruments))
+	for _, engine := range se.instruments {
+		engines = append(engines, engine)
+	}
+	return engines
 }
 
 // Start begins the simulation
 func (se *SimulationEngine) Start() {
 	logging.DebugLog("Starting simulation engine")
-	se.ticker = time.NewTicker(100 * time.Millisecond) // Adjust the interval as needed
+	se.mu.Lock()
+	se.running = true
+	engines := se.instrumentEngines()
+	se.warmUp()
+	restarted, contractIDs, onRestart := se.stopped, se.stoppedContracts, se.OnRestart
+	se.stopped = false
+	se.stoppedContracts = nil
+	se.mu.Unlock()
+	for _, engine := range engines {
+		engine.Start()
+	}
 
 	go func() {
 		for {
 			select {
-			case <-se.ticker.C:
+			case <-se.wake:
+			case fired := <-se.nextTick():
 				se.mu.Lock()
 				subscriberCount := len(se.subscribers)
 				if subscriberCount > 0 {
-					price := se.generatePrice()
+					quote := se.generatePrice()
 					timestamp := time.Now()
-					logging.DebugLog("Generated new price: %f at %v with %d subscribers", price, timestamp, subscriberCount)
+					logging.DebugLog("Generated new price: %f at %v with %d subscribers", quote.Mid, timestamp, subscriberCount)
 					// Notify each subscriber independently
 					for contractID, handler := range se.subscribers {
-						go func(id string, h PriceHandler, p float64, t time.Time) {
-							logging.DebugLog("Notifying contract %s of price update: %f at %v", id, p, t)
-							h.HandlePriceUpdate(p, t)
-						}(contractID, handler, price, timestamp)
+						go func(id string, h PriceHandlerV2, q PriceQuote, t time.Time) {
+							logging.DebugLog("Notifying contract %s of price update: %f at %v", id, q.Mid, t)
+							h.HandlePriceQuote(q, t)
+						}(contractID, quoteHandler(handler), se.contractQuote(contractID, quote), timestamp)
 					}
+					se.recordTick(time.Since(fired))
 				}
 				se.mu.Unlock()
 			case <-se.stopChan:
 				logging.DebugLog("Stopping simulation engine")
-				se.ticker.Stop()
 				return
 			}
 		}
 	}()
+
+	if restarted && onRestart != nil {
+		logging.DebugLog("Simulation engine restarted with %d contracts subscribed before it stopped", len(contractIDs))
+		onRestart(contractIDs)
+	}
+}
+
+// recordTick counts a tick whose price was dispatched latency after it
+// fired. Callers must hold mu.
+func (se *SimulationEngine) recordTick(latency time.Duration) {
+	atomic.AddInt64(&se.TickCount, 1)
+	se.LastTickLatency = latency
+	if latency > 2*se.TickInterval {
+		atomic.AddInt64(&se.MissedTicks, 1)
+		logging.DebugLog("Simulation engine tick took %s, more than twice its %s interval", latency, se.TickInterval)
+	}
 }
 
-// Stop ends the simulation
+// Telemetry returns the tick counters of the engine
+func (se *SimulationEngine) Telemetry() EngineTelemetry {
+	se.mu.Lock()
+	latency := se.LastTickLatency
+	se.mu.Unlock()
+	return EngineTelemetry{
+		TickCount:         atomic.LoadInt64(&se.TickCount),
+		MissedTicks:       atomic.LoadInt64(&se.MissedTicks),
+		LastTickLatency:   latency,
+		LastTickLatencyMs: float64(latency) / float64(time.Millisecond),
+	}
+}
+
+// warmUp generates WarmupTicks prices without notifying subscribers the
+// first time the engine starts. Callers must hold mu.
+func (se *SimulationEngine) warmUp() {
+	if se.warmedUp {
+		return
+	}
+	se.warmedUp = true
+	if se.WarmupTicks <= 0 {
+		return
+	}
+	for i := 0; i < se.WarmupTicks; i++ {
+		se.generatePrice()
+	}
+	logging.DebugLog("Simulation engine warmed up over %d ticks, price is %f", se.WarmupTicks, se.BasePrice)
+}
+
+// Stop ends the simulation. The contracts subscribed at the time are passed
+// to OnRestart when the engine is started again.
 func (se *SimulationEngine) Stop() {
+	contractIDs := make([]string, 0)
+	for contractID := range se.Snapshot() {
+		contractIDs = append(contractIDs, contractID)
+	}
+	sort.Strings(contractIDs)
+	se.mu.Lock()
+	se.running = false
+	engines := se.instrumentEngines()
+	se.mu.Unlock()
+	for _, engine := range engines {
+		engine.Stop()
+	}
 	se.stopChan <- true
+	se.mu.Lock()
+	se.stopped = true
+	se.stoppedContracts = contractIDs
+	se.mu.Unlock()
+}
+
+// SetTickPolicy changes when prices are generated. Without a policy the
+// engine ticks every TickInterval.
+func (se *SimulationEngine) SetTickPolicy(policy TickPolicy) {
+	se.mu.Lock()
+	se.tickPolicy = policy
+	engines := se.instrumentEngines()
+	se.mu.Unlock()
+	for _, engine := range engines {
+		engine.SetTickPolicy(policy)
+	}
+	se.wakeUp()
+}
+
+// nextTick returns a channel that receives when the next price is due
+func (se *SimulationEngine) nextTick() <-chan time.Time {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	policy := se.tickPolicy
+	if policy == nil {
+		policy = FixedIntervalPolicy(se.TickInterval)
+	}
+	return policy.NextTick(len(se.subscribers))
+}
+
+// wakeUp makes the engine ask its tick policy for a new next tick
+func (se *SimulationEngine) wakeUp() {
+	select {
+	case se.wake <- struct{}{}:
+	default:
+	}
 }
 
-// Subscribe adds a handler to receive price updates
+// Subscribe adds a handler to receive price updates, see SubscribeAsync
 func (se *SimulationEngine) Subscribe(contractID string, handler PriceHandler) {
+	se.SubscribeAsync(contractID, handler)
+}
+
+// SubscribeAck subscribes handler like SubscribeAsync and returns a channel
+// that is closed once handler has handled its first price update
+func (se *SimulationEngine) SubscribeAck(contractID string, handler PriceHandler) <-chan struct{} {
+	acknowledged, delivered := AcknowledgeFirstPrice(handler)
+	se.SubscribeAsync(contractID, acknowledged)
+	return delivered
+}
+
+// SubscribeAsync adds a handler to receive price updates. The handler is
+// sent the current price from a new goroutine without waiting for the next
+// tick, so it may receive it after SubscribeAsync returned.
+func (se *SimulationEngine) SubscribeAsync(contractID string, handler PriceHandler) {
 	se.mu.Lock()
 	defer se.mu.Unlock()
 	logging.DebugLog("Adding subscription for contract %s", contractID)
+	idle := len(se.subscribers) == 0
 	se.subscribers[contractID] = handler
 	logging.DebugLog("Current number of subscribers: %d", len(se.subscribers))
 
@@ -83,31 +498,109 @@ func (se *SimulationEngine) Subscribe(contractID string, handler PriceHandler) {
 	timestamp := time.Now()
 	logging.DebugLog("Sending initial price update to contract %s: %f at %v", contractID, se.BasePrice, timestamp)
 	go handler.HandlePriceUpdate(se.BasePrice, timestamp)
+	if idle {
+		se.wakeUp()
+	}
+}
+
+// SubscribeInstrument adds a handler to receive the price updates of
+// instrument. Contracts of instruments that are not configured receive the
+// engine's own prices.
+func (se *SimulationEngine) SubscribeInstrument(instrument, contractID string, handler PriceHandler) {
+	engine := se.Instrument(instrument)
+	if engine == nil {
+		logging.DebugLog("Instrument %q is not configured, subscribing contract %s to the default prices", instrument, contractID)
+		se.Subscribe(contractID, handler)
+		return
+	}
+	logging.DebugLog("Subscribing contract %s to instrument %s", contractID, instrument)
+	engine.Subscribe(contractID, handler)
 }
 
 // Unsubscribe removes a handler from receiving price updates
 func (se *SimulationEngine) Unsubscribe(contractID string) {
+	se.mu.Lock()
+	engines := se.instrumentEngines()
+	se.mu.Unlock()
+	for _, engine := range engines {
+		engine.Unsubscribe(contractID)
+	}
 	se.mu.Lock()
 	defer se.mu.Unlock()
 	logging.DebugLog("Removing subscription for contract %s", contractID)
 	delete(se.subscribers, contractID)
+	delete(se.contractPaths, contractID)
 	logging.DebugLog("Current number of subscribers: %d", len(se.subscribers))
 }
 
-// generatePrice generates a simulated price
-func (se *SimulationEngine) generatePrice() float64 {
-	// Implement a stochastic process, e.g., Geometric Brownian Motion
+// Snapshot returns a copy of the current subscribers keyed by contract ID
+func (se *SimulationEngine) Snapshot() map[string]PriceHandler {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	snapshot := make(map[string]PriceHandler, len(se.subscribers))
+	for contractID, handler := range se.subscribers {
+		snapshot[contractID] = handler
+	}
+	for _, engine := range se.instrumentEngines() {
+		for contractID, handler := range engine.Snapshot() {
+			snapshot[contractID] = handler
+		}
+	}
+	return snapshot
+}
+
+// SubscriberCount returns the number of subscribed contracts across every
+// instrument
+func (se *SimulationEngine) SubscriberCount() int {
+	se.mu.Lock()
+	count := len(se.subscribers)
+	engines := se.instrumentEngines()
+	se.mu.Unlock()
+	for _, engine := range engines {
+		count += engine.SubscriberCount()
+	}
+	return count
+}
+
+// Default GBM parameters
+const (
+	DefaultDrift      = 0.0002
+	DefaultVolatility = 0.01
+)
+
+// priceTimeStep is the time step of the stochastic processes
+const priceTimeStep = 0.1
 
-	// Parameters for Geometric Brownian Motion
-	mu := 0.0002  // Drift coefficient
-	sigma := 0.01 // Volatility coefficient
-	dt := 0.1     // Time step
+// generatePrice generates a simulated price quote
+func (se *SimulationEngine) generatePrice() PriceQuote {
+	if se.Process == ProcessHeston {
+		se.generateHestonPrice()
+	} else {
+		se.generateGBMPrice()
+	}
+	se.advanceContractPaths(se.lastShock)
+	return se.quote()
+}
+
+// generateGBMPrice advances the base price along a Geometric Brownian Motion
+func (se *SimulationEngine) generateGBMPrice() {
+	mu := se.Drift
+	sigma := se.Volatility
+	dt := priceTimeStep
 
 	// Generate a random number from standard normal distribution
-	epsilon := rand.NormFloat64()
+	epsilon := se.Rand.NormFloat64()
+	se.lastShock = epsilon
 
 	// Update the base price
 	se.BasePrice = se.BasePrice * math.Exp((mu-(0.5*math.Pow(sigma, 2)))*dt+sigma*epsilon*math.Sqrt(dt))
+}
 
-	return se.BasePrice
+// quote returns the current base price with the bid and ask around it
+func (se *SimulationEngine) quote() PriceQuote {
+	return PriceQuote{
+		Mid: se.BasePrice,
+		Bid: se.BasePrice - se.Spread/2,
+		Ask: se.BasePrice + se.Spread/2,
+	}
 }
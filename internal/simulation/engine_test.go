@@ -0,0 +1,356 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// manualTickPolicy ticks whenever the test sends on ticks
+type manualTickPolicy struct {
+	ticks chan time.Time
+}
+
+func (p manualTickPolicy) NextTick(subscriberCount int) <-chan time.Time {
+	return p.ticks
+}
+
+// priceRecorder forwards every price it handles to prices
+type priceRecorder struct {
+	prices chan float64
+}
+
+func (r priceRecorder) HandlePriceUpdate(price float64, timestamp time.Time) {
+	r.prices <- price
+}
+
+func TestTelemetryCountsTicks(t *testing.T) {
+	engine := NewSimulationEngine()
+	policy := manualTickPolicy{ticks: make(chan time.Time)}
+	engine.SetTickPolicy(policy)
+	recorder := priceRecorder{prices: make(chan float64, 1)}
+	engine.Subscribe("contract-1", recorder)
+	// Subscribers receive the current price without waiting for a tick
+	<-recorder.prices
+	engine.Start()
+	defer engine.Stop()
+
+	for i := 0; i < 100; i++ {
+		policy.ticks <- time.Now()
+		select {
+		case <-recorder.prices:
+		case <-time.After(time.Second):
+			t.Fatalf("No price was delivered for tick %d", i+1)
+		}
+	}
+
+	telemetry := engine.Telemetry()
+	if telemetry.TickCount != 100 {
+		t.Errorf("TickCount is %d, want 100", telemetry.TickCount)
+	}
+	if telemetry.LastTickLatency <= 0 {
+		t.Errorf("LastTickLatency is %s, want more than 0", telemetry.LastTickLatency)
+	}
+	if telemetry.LastTickLatencyMs != float64(telemetry.LastTickLatency)/float64(time.Millisecond) {
+		t.Errorf("LastTickLatencyMs is %f, want %s in milliseconds", telemetry.LastTickLatencyMs, telemetry.LastTickLatency)
+	}
+}
+
+func TestTelemetryCountsMissedTicks(t *testing.T) {
+	engine := NewSimulationEngine()
+	engine.TickInterval = time.Millisecond
+	policy := manualTickPolicy{ticks: make(chan time.Time)}
+	engine.SetTickPolicy(policy)
+	recorder := priceRecorder{prices: make(chan float64, 1)}
+	engine.Subscribe("contract-1", recorder)
+	<-recorder.prices
+	engine.Start()
+	defer engine.Stop()
+
+	// A tick dispatched a second after it fired is late
+	policy.ticks <- time.Now().Add(-time.Second)
+	<-recorder.prices
+
+	if missed := engine.Telemetry().MissedTicks; missed != 1 {
+		t.Errorf("MissedTicks is %d, want 1", missed)
+	}
+}
+
+func TestSnapshotMatchesSubscribedContracts(t *testing.T) {
+	engine := NewSimulationEngine()
+	handlers := map[string]priceRecorder{}
+	for _, contractID := range []string{"contract-1", "contract-2", "contract-3"} {
+		handlers[contractID] = priceRecorder{prices: make(chan float64, 1)}
+		engine.Subscribe(contractID, handlers[contractID])
+	}
+	engine.Unsubscribe("contract-2")
+	delete(handlers, "contract-2")
+
+	snapshot := engine.Snapshot()
+	if len(snapshot) != len(handlers) {
+		t.Fatalf("Snapshot has %d contracts, want %d", len(snapshot), len(handlers))
+	}
+	for contractID, handler := range handlers {
+		if snapshot[contractID] != handler {
+			t.Errorf("Snapshot has %v for %s, want its subscribed handler", snapshot[contractID], contractID)
+		}
+	}
+
+	// The snapshot is a copy
+	delete(snapshot, "contract-1")
+	if _, ok := engine.Snapshot()["contract-1"]; !ok {
+		t.Error("Deleting from a snapshot unsubscribed contract-1")
+	}
+}
+
+// quoteRecorder forwards every price quote it handles to quotes
+type quoteRecorder struct {
+	quotes chan PriceQuote
+}
+
+func (r quoteRecorder) HandlePriceUpdate(price float64, timestamp time.Time) {}
+
+func (r quoteRecorder) HandlePriceQuote(quote PriceQuote, timestamp time.Time) {
+	r.quotes <- quote
+}
+
+func TestQuotesSpreadBidAndAskAroundMid(t *testing.T) {
+	config := DefaultSimulationConfig()
+	config.Spread = 0.5
+	engine := NewSimulationEngineWithConfig(config)
+	policy := manualTickPolicy{ticks: make(chan time.Time)}
+	engine.SetTickPolicy(policy)
+	quotes := quoteRecorder{quotes: make(chan PriceQuote, 1)}
+	mids := priceRecorder{prices: make(chan float64, 1)}
+	engine.Subscribe("contract-quotes", quotes)
+	engine.Subscribe("contract-mids", mids)
+	<-mids.prices
+	engine.Start()
+	defer engine.Stop()
+
+	for i := 0; i < 10; i++ {
+		policy.ticks <- time.Now()
+		var quote PriceQuote
+		select {
+		case quote = <-quotes.quotes:
+		case <-time.After(time.Second):
+			t.Fatalf("No quote was delivered for tick %d", i+1)
+		}
+		if quote.Ask != quote.Mid+0.25 || quote.Bid != quote.Mid-0.25 {
+			t.Errorf("Quote %d is %+v, want bid and ask 0.25 either side of mid", i+1, quote)
+		}
+		// Mid-price handlers are adapted to receive the mid
+		select {
+		case mid := <-mids.prices:
+			if mid != quote.Mid {
+				t.Errorf("Mid-price handler received %v, want %v", mid, quote.Mid)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("No mid price was delivered for tick %d", i+1)
+		}
+	}
+}
+
+func TestAdaptivePolicyDoesNotTickWithoutSubscribers(t *testing.T) {
+	engine := NewSimulationEngine()
+	engine.SetTickPolicy(AdaptivePolicy(10*time.Millisecond, 2*time.Second))
+	engine.Start()
+	defer engine.Stop()
+
+	time.Sleep(time.Second)
+	if telemetry := engine.Telemetry(); telemetry.TickCount != 0 {
+		t.Fatalf("Engine ticked %d times without subscribers, want 0", telemetry.TickCount)
+	}
+
+	// Many subscribers tick every 2s/100 = 20ms, as soon as they subscribe
+	recorder := priceRecorder{prices: make(chan float64, 1000)}
+	for i := 0; i < 100; i++ {
+		engine.Subscribe(fmt.Sprintf("contract-%d", i), recorder)
+	}
+	deadline := time.Now().Add(time.Second)
+	for engine.Telemetry().TickCount < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Engine ticked %d times in a second with 100 subscribers, want at least 2", engine.Telemetry().TickCount)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInstrumentContractsReceiveThePricesOfTheirInstrument(t *testing.T) {
+	config := DefaultSimulationConfig()
+	config.TickInterval = time.Millisecond
+	// Instruments tick as often as the engine when TickInterval is zero
+	eur := DefaultSimulationConfig()
+	eur.TickInterval = 0
+	eur.BasePrice = 1.1
+	eur.Volatility = 0.001
+	btc := DefaultSimulationConfig()
+	btc.TickInterval = 0
+	btc.BasePrice = 45000
+	btc.Volatility = 0.05
+	config.InstrumentConfig = map[string]SimulationConfig{"EUR/USD": eur, "BTC/USD": btc}
+	engine := NewSimulationEngineWithConfig(config)
+
+	recorders := map[string]priceRecorder{}
+	for _, instrument := range []string{"EUR/USD", "BTC/USD", "XAU/USD"} {
+		recorders[instrument] = priceRecorder{prices: make(chan float64, 200)}
+		engine.SubscribeInstrument(instrument, "contract-"+instrument, recorders[instrument])
+	}
+	engine.Start()
+	defer engine.Stop()
+
+	// Unconfigured instruments receive the engine's own prices
+	volatilities := map[string]float64{}
+	for instrument, basePrice := range map[string]float64{"EUR/USD": 1.1, "BTC/USD": 45000, "XAU/USD": 100} {
+		prices := make([]float64, 101)
+		for i := range prices {
+			select {
+			case prices[i] = <-recorders[instrument].prices:
+			case <-time.After(time.Second):
+				t.Fatalf("Contract of %s received %d prices, want %d", instrument, i, len(prices))
+			}
+		}
+		var squares float64
+		for i := range prices {
+			if prices[i] < basePrice/2 || prices[i] > 2*basePrice {
+				t.Fatalf("Contract of %s received %v, want prices around %v", instrument, prices[i], basePrice)
+			}
+			if i == 0 {
+				continue
+			}
+			r := math.Log(prices[i] / prices[i-1])
+			squares += r * r
+		}
+		volatilities[instrument] = math.Sqrt(squares / float64(len(prices)-1))
+	}
+	if volatilities["BTC/USD"] < 10*volatilities["EUR/USD"] {
+		t.Errorf("BTC/USD prices move by %g and EUR/USD prices by %g per tick, want BTC/USD 50 times as volatile",
+			volatilities["BTC/USD"], volatilities["EUR/USD"])
+	}
+}
+
+func TestWarmupPricesAreNotSentToSubscribers(t *testing.T) {
+	config := DefaultSimulationConfig()
+	config.Rand = DeterministicRandSource(42)
+	config.WarmupTicks = 50
+	engine := NewSimulationEngineWithConfig(config)
+	policy := manualTickPolicy{ticks: make(chan time.Time)}
+	engine.SetTickPolicy(policy)
+	early := priceRecorder{prices: make(chan float64, 1)}
+	engine.Subscribe("contract-early", early)
+	if price := <-early.prices; price != config.BasePrice {
+		t.Fatalf("Contract subscribed before the start received %v, want the base price %v", price, config.BasePrice)
+	}
+
+	// The same seed generates the warmup prices again without an engine
+	reference := NewSimulationEngineWithConfig(DefaultSimulationConfig())
+	reference.Rand = DeterministicRandSource(42)
+	for i := 0; i < config.WarmupTicks; i++ {
+		reference.generatePrice()
+	}
+
+	engine.Start()
+	defer engine.Stop()
+	select {
+	case price := <-early.prices:
+		t.Fatalf("Contract received %v during the warmup", price)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if ticks := engine.Telemetry().TickCount; ticks != 0 {
+		t.Errorf("TickCount is %d after the warmup, want 0", ticks)
+	}
+	if engine.BasePrice != reference.BasePrice {
+		t.Fatalf("BasePrice is %v after the warmup, want %v", engine.BasePrice, reference.BasePrice)
+	}
+
+	late := priceRecorder{prices: make(chan float64, 1)}
+	engine.Subscribe("contract-late", late)
+	if price := <-late.prices; price != reference.BasePrice {
+		t.Errorf("Contract subscribed after the warmup received %v, want %v", price, reference.BasePrice)
+	}
+	policy.ticks <- time.Now()
+	expected := reference.generatePrice().Mid
+	for _, recorder := range []priceRecorder{early, late} {
+		select {
+		case price := <-recorder.prices:
+			if price != expected {
+				t.Errorf("First tick after the warmup sent %v, want %v", price, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("No price was delivered for the first tick after the warmup")
+		}
+	}
+}
+
+func TestPriceSeriesAddedAtRuntimeTickIndependently(t *testing.T) {
+	config := DefaultSimulationConfig()
+	config.TickInterval = time.Millisecond
+	engine := NewSimulationEngineWithConfig(config)
+	engine.Start()
+	defer engine.Stop()
+
+	eur := DefaultSimulationConfig()
+	eur.TickInterval = 0
+	eur.BasePrice = 1.1
+	eur.Volatility = 0.001
+	eur.Rand = DeterministicRandSource(1)
+	btc := DefaultSimulationConfig()
+	btc.TickInterval = 0
+	btc.BasePrice = 45000
+	btc.Volatility = 0.05
+	btc.Rand = DeterministicRandSource(2)
+	series := map[string]*SimulationEngine{}
+	for instrument, seriesConfig := range map[string]SimulationConfig{"EURUSD": eur, "BTCUSD": btc} {
+		s, err := engine.NewPriceSeries(instrument, seriesConfig)
+		if err != nil {
+			t.Fatalf("NewPriceSeries(%s): %v", instrument, err)
+		}
+		series[instrument] = s
+	}
+	if _, err := engine.NewPriceSeries("EURUSD", eur); err == nil {
+		t.Error("Adding a second EURUSD price series succeeded, want an error")
+	}
+
+	returns := map[string][]float64{}
+	for instrument, basePrice := range map[string]float64{"EURUSD": 1.1, "BTCUSD": 45000} {
+		recorder := priceRecorder{prices: make(chan float64, 100)}
+		engine.SubscribeInstrument(instrument, "contract-"+instrument, recorder)
+		prices := make([]float64, 51)
+		for i := range prices {
+			select {
+			case prices[i] = <-recorder.prices:
+			case <-time.After(time.Second):
+				t.Fatalf("Contract of %s received %d prices, want %d", instrument, i, len(prices))
+			}
+			if prices[i] < basePrice/2 || prices[i] > 2*basePrice {
+				t.Fatalf("Contract of %s received %v, want prices around %v", instrument, prices[i], basePrice)
+			}
+			if i > 0 {
+				returns[instrument] = append(returns[instrument], math.Log(prices[i]/prices[i-1]))
+			}
+		}
+		engine.Unsubscribe("contract-" + instrument)
+	}
+
+	// Each series moves with its own volatility
+	deviation := func(returns []float64) float64 {
+		var squares float64
+		for _, r := range returns {
+			squares += r * r
+		}
+		return math.Sqrt(squares / float64(len(returns)))
+	}
+	if deviation(returns["BTCUSD"]) < 10*deviation(returns["EURUSD"]) {
+		t.Errorf("BTCUSD prices move by %g and EURUSD prices by %g per tick, want BTCUSD 50 times as volatile",
+			deviation(returns["BTCUSD"]), deviation(returns["EURUSD"]))
+	}
+	if ticks := engine.Telemetry().TickCount; ticks != 0 {
+		t.Errorf("Engine without subscribers of its own ticked %d times, want 0", ticks)
+	}
+	for instrument, s := range series {
+		if s.Telemetry().TickCount == 0 {
+			t.Errorf("Price series of %s did not tick", instrument)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package simulation
+
+import "math"
+
+// ProcessType selects the stochastic process of a simulation engine
+type ProcessType string
+
+// Supported processes
+const (
+	// ProcessGBM is Geometric Brownian Motion with constant volatility
+	ProcessGBM ProcessType = "gbm"
+	// ProcessHeston is the Heston model, whose variance follows a
+	// mean-reverting square root process
+	ProcessHeston ProcessType = "heston"
+)
+
+// Default Heston parameters. The long-run variance matches the volatility of
+// the GBM process, and 2*kappa*theta > xi^2 keeps the variance positive.
+const (
+	DefaultHestonKappa = 2.0
+	DefaultHestonTheta = 0.0001
+	DefaultHestonXi    = 0.01
+	DefaultHestonRho   = -0.7
+)
+
+// generateHestonPrice advances the base price and variance by one
+// Euler-Maruyama step of the Heston model:
+//
+//	dS = mu*S*dt + sqrt(V)*S*dW1
+//	dV = kappa*(theta - V)*dt + xi*sqrt(V)*dW2
+//
+// The price is stepped in log space so it stays positive. The Brownian
+// motions are correlated by rho through the Cholesky factor of their
+// correlation matrix, and negative variances are truncated to zero.
+func (se *SimulationEngine) generateHestonPrice() {
+	dt := priceTimeStep
+
+	z1 := se.Rand.NormFloat64()
+	se.lastShock = z1
+	z2 := se.HestonRho*z1 + math.Sqrt(1-se.HestonRho*se.HestonRho)*se.Rand.NormFloat64()
+
+	variance := math.Max(se.CurrentVariance, 0)
+	se.BasePrice = se.BasePrice * math.Exp((se.Drift-0.5*variance)*dt+math.Sqrt(variance*dt)*z1)
+	se.CurrentVariance = se.CurrentVariance + se.HestonKappa*(se.HestonTheta-variance)*dt + se.HestonXi*math.Sqrt(variance*dt)*z2
+}
@@ -0,0 +1,45 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHestonVarianceRevertsToTheta(t *testing.T) {
+	config := DefaultSimulationConfig()
+	config.Process = ProcessHeston
+	config.Rand = DeterministicRandSource(42)
+	engine := NewSimulationEngineWithConfig(config)
+	// The variance starts far above its long-run level
+	engine.CurrentVariance = 10 * config.HestonTheta
+
+	const steps = 10000
+	var varianceSum float64
+	returns := make([]float64, 0, steps/2)
+	for i := 0; i < steps; i++ {
+		previous := engine.BasePrice
+		engine.generateHestonPrice()
+		// The first half lets the variance settle
+		if i >= steps/2 {
+			varianceSum += engine.CurrentVariance
+			returns = append(returns, math.Log(engine.BasePrice/previous))
+		}
+	}
+
+	if mean := varianceSum / float64(len(returns)); math.Abs(mean-config.HestonTheta) > 0.1*config.HestonTheta {
+		t.Errorf("Mean variance is %g, want within 10%% of theta %g", mean, config.HestonTheta)
+	}
+	var mean, squares float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+	for _, r := range returns {
+		squares += (r - mean) * (r - mean)
+	}
+	// Each step scales the variance by its time step
+	expected := config.HestonTheta * priceTimeStep
+	if sample := squares / float64(len(returns)-1); math.Abs(sample-expected) > 0.1*expected {
+		t.Errorf("Sample variance of log returns is %g, want within 10%% of theta*dt %g", sample, expected)
+	}
+}
@@ -0,0 +1,160 @@
+package simulation
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultNATSPriceSubject is the subject pattern used when none is configured.
+// The last token of each subject is the instrument symbol, e.g. "prices.BTCUSD".
+const DefaultNATSPriceSubject = "prices.>"
+
+// NATSPriceFeed consumes prices published on NATS and forwards them to subscribers
+type NATSPriceFeed struct {
+	// SymbolMap maps instrument symbols to contract IDs. A mapped contract only
+	// receives prices for its symbol; unmapped contracts receive every price.
+	// It must be populated before Start is called.
+	SymbolMap map[string]string
+
+	conn        *nats.Conn
+	subject     string
+	sub         *nats.Subscription
+	subscribers map[string]PriceHandler
+	lastPrices  map[string]float64
+	lastPrice   *float64
+	mu          sync.Mutex
+}
+
+// natsPriceMessage is the JSON form of a published price
+type natsPriceMessage struct {
+	Price     float64 `json:"price"`
+	Timestamp int64   `json:"timestamp,omitempty"` // milliseconds
+}
+
+// NewNATSPriceFeed connects to the NATS server at url
+func NewNATSPriceFeed(url, subject string) (*NATSPriceFeed, error) {
+	if subject == "" {
+		subject = DefaultNATSPriceSubject
+	}
+	logging.DebugLog("Connecting to NATS at %s", url)
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPriceFeed{
+		SymbolMap:   make(map[string]string),
+		conn:        conn,
+		subject:     subject,
+		subscribers: make(map[string]PriceHandler),
+		lastPrices:  make(map[string]float64),
+	}, nil
+}
+
+// Start subscribes to the configured NATS subject
+func (f *NATSPriceFeed) Start() {
+	logging.DebugLog("Starting NATS price feed on subject %s", f.subject)
+	sub, err := f.conn.Subscribe(f.subject, f.handleMessage)
+	if err != nil {
+		logging.DebugLog("Failed to subscribe to %s: %v", f.subject, err)
+		return
+	}
+	f.mu.Lock()
+	f.sub = sub
+	f.mu.Unlock()
+}
+
+// Stop unsubscribes from NATS and closes the connection
+func (f *NATSPriceFeed) Stop() {
+	logging.DebugLog("Stopping NATS price feed")
+	f.mu.Lock()
+	sub := f.sub
+	f.sub = nil
+	f.mu.Unlock()
+	if sub != nil {
+		sub.Unsubscribe()
+	}
+	f.conn.Close()
+}
+
+// Subscribe adds a handler to receive price updates
+func (f *NATSPriceFeed) Subscribe(contractID string, handler PriceHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logging.DebugLog("Adding NATS subscription for contract %s", contractID)
+	f.subscribers[contractID] = handler
+
+	// Send the last known price immediately, if there is one
+	if symbol := f.symbolFor(contractID); symbol != "" {
+		if price, ok := f.lastPrices[symbol]; ok {
+			go handler.HandlePriceUpdate(price, time.Now())
+		}
+	} else if f.lastPrice != nil {
+		go handler.HandlePriceUpdate(*f.lastPrice, time.Now())
+	}
+}
+
+// Unsubscribe removes a handler from receiving price updates
+func (f *NATSPriceFeed) Unsubscribe(contractID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logging.DebugLog("Removing NATS subscription for contract %s", contractID)
+	delete(f.subscribers, contractID)
+}
+
+func (f *NATSPriceFeed) handleMessage(msg *nats.Msg) {
+	symbol := msg.Subject[strings.LastIndex(msg.Subject, ".")+1:]
+	price, timestamp, err := parseNATSPrice(msg.Data)
+	if err != nil {
+		logging.DebugLog("Ignoring invalid price on %s: %v", msg.Subject, err)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastPrices[symbol] = price
+	f.lastPrice = &price
+
+	mapped := make(map[string]bool, len(f.SymbolMap))
+	for _, contractID := range f.SymbolMap {
+		mapped[contractID] = true
+	}
+	for contractID, handler := range f.subscribers {
+		if mapped[contractID] && f.SymbolMap[symbol] != contractID {
+			continue
+		}
+		go handler.HandlePriceUpdate(price, timestamp)
+	}
+}
+
+// symbolFor returns the symbol a contract is mapped to, or "" if unmapped
+func (f *NATSPriceFeed) symbolFor(contractID string) string {
+	for symbol, id := range f.SymbolMap {
+		if id == contractID {
+			return symbol
+		}
+	}
+	return ""
+}
+
+// parseNATSPrice accepts either a bare number or a JSON price message
+func parseNATSPrice(data []byte) (float64, time.Time, error) {
+	if price, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64); err == nil {
+		return price, time.Now(), nil
+	}
+	var msg natsPriceMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return 0, time.Time{}, err
+	}
+	timestamp := time.Now()
+	if msg.Timestamp > 0 {
+		timestamp = time.UnixMilli(msg.Timestamp)
+	}
+	return msg.Price, timestamp, nil
+}
@@ -0,0 +1,140 @@
+package simulation
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natsTestServer speaks enough of the NATS client protocol to accept one
+// connection, record its subscriptions and deliver published messages to
+// them
+type natsTestServer struct {
+	listener   net.Listener
+	subscribed chan string
+
+	mu   sync.Mutex
+	conn net.Conn
+	sids map[string]string
+}
+
+func newNATSTestServer(t *testing.T) *natsTestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsTestServer{listener: listener, subscribed: make(chan string, 10), sids: make(map[string]string)}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *natsTestServer) URL() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *natsTestServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			s.write("PONG\r\n")
+		case "SUB":
+			s.mu.Lock()
+			s.sids[fields[len(fields)-1]] = fields[1]
+			s.mu.Unlock()
+			s.subscribed <- fields[1]
+		}
+	}
+}
+
+func (s *natsTestServer) write(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Write([]byte(data))
+}
+
+// publish delivers payload on subject to every subscription
+func (s *natsTestServer) publish(subject, payload string) {
+	s.mu.Lock()
+	sids := make([]string, 0, len(s.sids))
+	for sid := range s.sids {
+		sids = append(sids, sid)
+	}
+	s.mu.Unlock()
+	for _, sid := range sids {
+		s.write(fmt.Sprintf("MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload))
+	}
+}
+
+func TestNATSPriceFeedForwardsPricesToSubscribedContracts(t *testing.T) {
+	server := newNATSTestServer(t)
+	feed, err := NewNATSPriceFeed(server.URL(), "")
+	if err != nil {
+		t.Fatalf("NewNATSPriceFeed: %v", err)
+	}
+	feed.SymbolMap["BTCUSD"] = "contract-btc"
+	feed.SymbolMap["ETHUSD"] = "contract-eth"
+	btc := priceRecorder{prices: make(chan float64, 10)}
+	eth := priceRecorder{prices: make(chan float64, 10)}
+	feed.Subscribe("contract-btc", btc)
+	feed.Subscribe("contract-eth", eth)
+	feed.Start()
+	defer feed.Stop()
+
+	select {
+	case subject := <-server.subscribed:
+		if subject != DefaultNATSPriceSubject {
+			t.Fatalf("Feed subscribed to %s, want %s", subject, DefaultNATSPriceSubject)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Feed did not subscribe")
+	}
+
+	server.publish("prices.BTCUSD", "101.5")
+	server.publish("prices.ETHUSD", `{"price": 2500.25, "timestamp": 1700000000000}`)
+
+	for _, expected := range []struct {
+		recorder priceRecorder
+		price    float64
+	}{{btc, 101.5}, {eth, 2500.25}} {
+		select {
+		case price := <-expected.recorder.prices:
+			if price != expected.price {
+				t.Errorf("Contract received %v, want %v", price, expected.price)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Contract received no price, want %v", expected.price)
+		}
+	}
+	// Mapped contracts only receive the prices of their symbol
+	select {
+	case price := <-btc.prices:
+		t.Errorf("BTCUSD contract received %v", price)
+	case price := <-eth.prices:
+		t.Errorf("ETHUSD contract received %v", price)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,26 @@
+package simulation
+
+import "math/rand"
+
+// RandSource provides the random numbers used to generate prices
+type RandSource interface {
+	NormFloat64() float64
+	Float64() float64
+}
+
+// globalRandSource draws from the global math/rand source
+type globalRandSource struct{}
+
+func (globalRandSource) NormFloat64() float64 { return rand.NormFloat64() }
+func (globalRandSource) Float64() float64     { return rand.Float64() }
+
+// GlobalRandSource returns a RandSource backed by the global math/rand source
+func GlobalRandSource() RandSource {
+	return globalRandSource{}
+}
+
+// DeterministicRandSource returns a RandSource that produces the same
+// sequence for the same seed. It is not safe for concurrent use.
+func DeterministicRandSource(seed int64) RandSource {
+	return rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,41 @@
+package simulation
+
+import "testing"
+
+// priceSeries returns n prices generated by a new engine using process and
+// the deterministic source of seed
+func priceSeries(process ProcessType, seed int64, n int) []float64 {
+	config := DefaultSimulationConfig()
+	config.Process = process
+	config.Rand = DeterministicRandSource(seed)
+	engine := NewSimulationEngineWithConfig(config)
+
+	prices := make([]float64, n)
+	for i := range prices {
+		prices[i] = engine.generatePrice().Mid
+	}
+	return prices
+}
+
+func TestDeterministicRandSourceReproducesPriceSeries(t *testing.T) {
+	for _, process := range []ProcessType{ProcessGBM, ProcessHeston} {
+		first := priceSeries(process, 42, 1000)
+		second := priceSeries(process, 42, 1000)
+		for i := range first {
+			if first[i] != second[i] {
+				t.Fatalf("%s price %d is %f in the first run and %f in the second", process, i, first[i], second[i])
+			}
+		}
+	}
+}
+
+func TestDeterministicRandSourceSeedsDiffer(t *testing.T) {
+	first := priceSeries(ProcessGBM, 1, 100)
+	second := priceSeries(ProcessGBM, 2, 100)
+	for i := range first {
+		if first[i] != second[i] {
+			return
+		}
+	}
+	t.Fatal("Different seeds produced the same price series")
+}
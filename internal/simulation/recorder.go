@@ -0,0 +1,160 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// StateProvider is implemented by price handlers that expose the contract
+// state after each update
+type StateProvider interface {
+	GetState() map[string]interface{}
+}
+
+// ContractResultRecorder writes the state of every contract after each price
+// update to a CSV file with contractID,price,timestamp,state rows. When closed
+// it appends one summary row per contract whose timestamp column is "summary"
+// and whose state holds finalStatus, finalPrice and payoffEarned.
+type ContractResultRecorder struct {
+	file    *os.File
+	writer  *csv.Writer
+	results map[string]*contractResult
+	order   []string
+	mu      sync.Mutex
+	closed  bool
+}
+
+// contractResult is the last recorded outcome of a contract
+type contractResult struct {
+	payoff float64
+	status string
+	price  float64
+	state  map[string]interface{}
+}
+
+// ContractSummary is written as the state of a contract's summary row
+type ContractSummary struct {
+	FinalStatus  string  `json:"finalStatus"`
+	FinalPrice   float64 `json:"finalPrice"`
+	PayoffEarned float64 `json:"payoffEarned"`
+}
+
+// NewContractResultRecorder creates the CSV file at path and writes its header
+func NewContractResultRecorder(path string) (*ContractResultRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"contractID", "price", "timestamp", "state"}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ContractResultRecorder{
+		file:    file,
+		writer:  writer,
+		results: make(map[string]*contractResult),
+	}, nil
+}
+
+// Wrap returns a handler that forwards price updates to handler and records
+// the resulting state. payoff is the contract's payoff, used for the summary.
+func (r *ContractResultRecorder) Wrap(contractID string, payoff float64, handler PriceHandler) PriceHandler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.results[contractID]; !ok {
+		r.results[contractID] = &contractResult{payoff: payoff, status: "active"}
+		r.order = append(r.order, contractID)
+	}
+	return &recordingHandler{recorder: r, contractID: contractID, handler: handler}
+}
+
+// Close writes the summary rows and flushes the file
+func (r *ContractResultRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	for _, contractID := range r.order {
+		result := r.results[contractID]
+		summary, _ := json.Marshal(ContractSummary{
+			FinalStatus:  result.status,
+			FinalPrice:   result.price,
+			PayoffEarned: payoffEarned(result.status, result.state, result.payoff),
+		})
+		r.writer.Write([]string{contractID, formatPrice(result.price), "summary", string(summary)})
+	}
+	r.writer.Flush()
+	if err := r.writer.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	logging.DebugLog("Recorded backtest results for %d contracts", len(r.order))
+	return r.file.Close()
+}
+
+func (r *ContractResultRecorder) record(contractID string, price float64, timestamp time.Time, state map[string]interface{}) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		logging.DebugLog("Failed to encode state of contract %s: %v", contractID, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	result := r.results[contractID]
+	result.price = price
+	result.state = state
+	if status, ok := state["status"].(string); ok {
+		result.status = status
+	}
+	if err := r.writer.Write([]string{contractID, formatPrice(price), strconv.FormatInt(timestamp.UnixMilli(), 10), string(data)}); err != nil {
+		logging.DebugLog("Failed to record state of contract %s: %v", contractID, err)
+	}
+}
+
+// recordingHandler records the state of the wrapped handler after each update
+type recordingHandler struct {
+	recorder   *ContractResultRecorder
+	contractID string
+	handler    PriceHandler
+}
+
+// HandlePriceUpdate implements PriceHandler
+func (h *recordingHandler) HandlePriceUpdate(price float64, timestamp time.Time) {
+	h.handler.HandlePriceUpdate(price, timestamp)
+
+	var state map[string]interface{}
+	if provider, ok := h.handler.(StateProvider); ok {
+		state = provider.GetState()
+	}
+	h.recorder.record(h.contractID, price, timestamp, state)
+}
+
+// payoffEarned pays a MomentumCatcher's payoff when its target was hit and a
+// LuckyLadder's payoff for every rung it hit
+func payoffEarned(status string, state map[string]interface{}, payoff float64) float64 {
+	if status == "target_hit" {
+		return payoff
+	}
+	if rungs, ok := state["all_rungs_hit"].([]interface{}); ok {
+		return payoff * float64(len(rungs))
+	}
+	return 0
+}
+
+func formatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
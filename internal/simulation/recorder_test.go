@@ -0,0 +1,116 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// targetProduct behaves like a MomentumCatcher: it hits its target once the
+// price has moved target away from the first price it saw and expires after
+// maxTicks prices otherwise
+type targetProduct struct {
+	target   float64
+	maxTicks int
+
+	ticks  int
+	start  float64
+	price  float64
+	status string
+}
+
+func newTargetProduct(target float64, maxTicks int) *targetProduct {
+	return &targetProduct{target: target, maxTicks: maxTicks, status: "active"}
+}
+
+func (p *targetProduct) HandlePriceUpdate(price float64, timestamp time.Time) {
+	if p.status != "active" {
+		return
+	}
+	if p.ticks == 0 {
+		p.start = price
+	}
+	p.ticks++
+	p.price = price
+	switch {
+	case price-p.start >= p.target || p.start-price >= p.target:
+		p.status = "target_hit"
+	case p.ticks >= p.maxTicks:
+		p.status = "expired"
+	}
+}
+
+func (p *targetProduct) GetState() map[string]interface{} {
+	return map[string]interface{}{"status": p.status, "price": p.price}
+}
+
+func TestContractResultRecorderWritesEveryTickAndSummary(t *testing.T) {
+	dir := t.TempDir()
+	var rows strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&rows, "%d,%v\n", 1700000000000+int64(i)*10, 100+float64(i)/2)
+	}
+	input := filepath.Join(dir, "prices.csv")
+	if err := os.WriteFile(input, []byte(rows.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := NewCSVFeed(input)
+	if err != nil {
+		t.Fatalf("NewCSVFeed: %v", err)
+	}
+	feed.Speed = 0
+	output := filepath.Join(dir, "results.csv")
+	recorder, err := NewContractResultRecorder(output)
+	if err != nil {
+		t.Fatalf("NewContractResultRecorder: %v", err)
+	}
+
+	// The target is hit at the fifth price, 102
+	feed.Subscribe("contract-1", recorder.Wrap("contract-1", 10, newTargetProduct(2, 100)))
+	feed.Start()
+	defer feed.Stop()
+	select {
+	case <-feed.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Feed did not finish replaying")
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A header, one row per price and the summary
+	if len(records) != 12 {
+		t.Fatalf("Output has %d rows, want 12", len(records))
+	}
+	for i, record := range records[1:11] {
+		if want := formatPrice(100 + float64(i)/2); record[0] != "contract-1" || record[1] != want {
+			t.Errorf("Row %d is %v, want contract-1 at %s", i+1, record, want)
+		}
+	}
+
+	summaryRow := records[11]
+	if summaryRow[0] != "contract-1" || summaryRow[2] != "summary" {
+		t.Fatalf("Last row is %v, want the summary of contract-1", summaryRow)
+	}
+	var summary ContractSummary
+	if err := json.Unmarshal([]byte(summaryRow[3]), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary != (ContractSummary{FinalStatus: "target_hit", FinalPrice: 104.5, PayoffEarned: 10}) {
+		t.Errorf("Summary is %+v, want target_hit at 104.5 earning 10", summary)
+	}
+}
@@ -0,0 +1,174 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// Scenario is a named, deterministic price path used for backtesting
+type Scenario struct {
+	Name         string
+	Prices       []float64
+	TickInterval time.Duration
+}
+
+// Pre-defined market scenarios
+var (
+	ScenarioBullRun    = Scenario{Name: "bull run", Prices: trendPrices(100, 0.5, 60), TickInterval: DefaultTickInterval}
+	ScenarioBearRun    = Scenario{Name: "bear run", Prices: trendPrices(100, -0.5, 60), TickInterval: DefaultTickInterval}
+	ScenarioRangeBound = Scenario{Name: "range-bound", Prices: rangePrices(100, 1, 60), TickInterval: DefaultTickInterval}
+	ScenarioFlashCrash = Scenario{Name: "flash crash", Prices: flashCrashPrices(100, 20, 60), TickInterval: DefaultTickInterval}
+)
+
+// DefaultScenarios returns every pre-defined scenario
+func DefaultScenarios() []Scenario {
+	return []Scenario{ScenarioBullRun, ScenarioBearRun, ScenarioRangeBound, ScenarioFlashCrash}
+}
+
+// ScenarioByName returns the pre-defined scenario called name
+func ScenarioByName(name string) (Scenario, bool) {
+	for _, scenario := range DefaultScenarios() {
+		if scenario.Name == name {
+			return scenario, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// ScenarioProduct is a contract that can be run against a scenario
+type ScenarioProduct interface {
+	PriceHandler
+	StateProvider
+}
+
+// ProductConstructor creates a fresh contract of a product for each run
+type ProductConstructor struct {
+	Name string
+	// Payoff is the contract payoff, used to compute the payoff earned
+	Payoff float64
+	New    func(contractID string) (ScenarioProduct, error)
+}
+
+// ScenarioResult is the outcome of one product run against one scenario
+type ScenarioResult struct {
+	Scenario    string                 `json:"scenario"`
+	Product     string                 `json:"product"`
+	FinalStatus string                 `json:"finalStatus"`
+	FinalPrice  float64                `json:"finalPrice"`
+	Payoff      float64                `json:"payoffEarned"`
+	Ticks       int                    `json:"ticks"`
+	Elapsed     time.Duration          `json:"elapsed"`
+	State       map[string]interface{} `json:"state,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// ScenarioReport holds the result of every scenario and product combination
+type ScenarioReport struct {
+	Results []ScenarioResult `json:"results"`
+}
+
+// ScenarioRunner runs every product against every scenario
+type ScenarioRunner struct {
+	Scenarios []Scenario
+	Products  []ProductConstructor
+}
+
+// NewScenarioRunner creates a runner for the given scenarios and products
+func NewScenarioRunner(scenarios []Scenario, products []ProductConstructor) *ScenarioRunner {
+	return &ScenarioRunner{Scenarios: scenarios, Products: products}
+}
+
+// Run runs each combination in turn and returns the report
+func (sr *ScenarioRunner) Run() *ScenarioReport {
+	report := &ScenarioReport{}
+	runID := time.Now().UnixNano()
+	for i, scenario := range sr.Scenarios {
+		for j, product := range sr.Products {
+			contractID := fmt.Sprintf("scenario-%d-%d-%d", runID, i, j)
+			report.Results = append(report.Results, RunScenario(scenario, product, contractID))
+		}
+	}
+	return report
+}
+
+// RunScenario feeds a scenario's prices to a new contract, one every
+// TickInterval, until the prices run out or the contract reaches a terminal
+// state. Contracts that implement Close are closed afterwards.
+func RunScenario(scenario Scenario, constructor ProductConstructor, contractID string) ScenarioResult {
+	result := ScenarioResult{Scenario: scenario.Name, Product: constructor.Name, FinalStatus: "active"}
+
+	product, err := constructor.New(contractID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if closer, ok := product.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+
+	logging.DebugLog("Running scenario %q against %s contract %s", scenario.Name, constructor.Name, contractID)
+	start := time.Now()
+	timestamp := start
+	for i, price := range scenario.Prices {
+		if i > 0 && scenario.TickInterval > 0 {
+			time.Sleep(scenario.TickInterval)
+			timestamp = timestamp.Add(scenario.TickInterval)
+		}
+		product.HandlePriceUpdate(price, timestamp)
+		result.Ticks++
+		result.FinalPrice = price
+
+		result.State = product.GetState()
+		if status, ok := result.State["status"].(string); ok {
+			result.FinalStatus = status
+		}
+		if isTerminalStatus(result.FinalStatus) {
+			break
+		}
+	}
+	result.Elapsed = time.Since(start)
+	result.Payoff = payoffEarned(result.FinalStatus, result.State, constructor.Payoff)
+	return result
+}
+
+func isTerminalStatus(status string) bool {
+	return status == "inactive" || status == "expired" || status == "target_hit"
+}
+
+// trendPrices moves steadily from start by step per tick
+func trendPrices(start, step float64, ticks int) []float64 {
+	prices := make([]float64, ticks)
+	for i := range prices {
+		prices[i] = start + step*float64(i)
+	}
+	return prices
+}
+
+// rangePrices oscillates around mid within +/- amplitude
+func rangePrices(mid, amplitude float64, ticks int) []float64 {
+	prices := make([]float64, ticks)
+	for i := range prices {
+		prices[i] = mid + amplitude*math.Sin(float64(i)*math.Pi/8)
+	}
+	return prices
+}
+
+// flashCrashPrices holds at start, drops by depth in a single tick a third of
+// the way through, then recovers linearly
+func flashCrashPrices(start, depth float64, ticks int) []float64 {
+	prices := make([]float64, ticks)
+	crash := ticks / 3
+	for i := range prices {
+		switch {
+		case i < crash:
+			prices[i] = start
+		default:
+			recovery := float64(i-crash) / float64(ticks-crash)
+			prices[i] = start - depth*(1-recovery)
+		}
+	}
+	return prices
+}
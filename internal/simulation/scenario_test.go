@@ -0,0 +1,43 @@
+package simulation
+
+import "testing"
+
+func TestScenarioRunnerSettlesEachScenario(t *testing.T) {
+	// Replay the pre-defined scenarios without sleeping between ticks
+	scenarios := DefaultScenarios()
+	for i := range scenarios {
+		scenarios[i].TickInterval = 0
+	}
+	product := ProductConstructor{
+		Name:   "momentum 5",
+		Payoff: 10,
+		New: func(contractID string) (ScenarioProduct, error) {
+			return newTargetProduct(5, 60), nil
+		},
+	}
+
+	report := NewScenarioRunner(scenarios, []ProductConstructor{product}).Run()
+	expected := map[string]ScenarioResult{
+		ScenarioBullRun.Name:    {FinalStatus: "target_hit", FinalPrice: 105, Payoff: 10, Ticks: 11},
+		ScenarioBearRun.Name:    {FinalStatus: "target_hit", FinalPrice: 95, Payoff: 10, Ticks: 11},
+		ScenarioRangeBound.Name: {FinalStatus: "expired", Ticks: 60},
+		ScenarioFlashCrash.Name: {FinalStatus: "target_hit", FinalPrice: 80, Payoff: 10, Ticks: 21},
+	}
+	if len(report.Results) != len(expected) {
+		t.Fatalf("Report has %d results, want %d", len(report.Results), len(expected))
+	}
+	for _, result := range report.Results {
+		want, ok := expected[result.Scenario]
+		if !ok || result.Product != product.Name {
+			t.Errorf("Unexpected result of %s against %q", result.Product, result.Scenario)
+			continue
+		}
+		if result.FinalStatus != want.FinalStatus || result.Payoff != want.Payoff || result.Ticks != want.Ticks {
+			t.Errorf("%q ended %s after %d ticks earning %v, want %s after %d earning %v",
+				result.Scenario, result.FinalStatus, result.Ticks, result.Payoff, want.FinalStatus, want.Ticks, want.Payoff)
+		}
+		if want.FinalPrice != 0 && result.FinalPrice != want.FinalPrice {
+			t.Errorf("%q ended at %v, want %v", result.Scenario, result.FinalPrice, want.FinalPrice)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package simulation
+
+import (
+	"fmt"
+	"time"
+
+	"pricingserver/internal/common/logging"
+)
+
+// SensitivityResult is the outcome of one parameter value in a sweep
+type SensitivityResult struct {
+	Value          float64       `json:"value"`
+	FinalStatus    string        `json:"finalStatus"`
+	Payoff         float64       `json:"payoffEarned"`
+	TimeToTerminal time.Duration `json:"timeToTerminal"`
+	Ticks          int           `json:"ticks"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// SensitivityAnalysis runs one contract per parameter value against scenario.
+// newProduct builds the product for a value of the swept parameter.
+func SensitivityAnalysis(paramName string, values []float64, scenario Scenario, newProduct func(value float64) (ProductConstructor, error)) []SensitivityResult {
+	results := make([]SensitivityResult, 0, len(values))
+	runID := time.Now().UnixNano()
+	logging.DebugLog("Running %s sensitivity sweep over %d values against scenario %q", paramName, len(values), scenario.Name)
+	for i, value := range values {
+		result := SensitivityResult{Value: value}
+		constructor, err := newProduct(value)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		run := RunScenario(scenario, constructor, fmt.Sprintf("sensitivity-%d-%d", runID, i))
+		result.FinalStatus = run.FinalStatus
+		result.Payoff = run.Payoff
+		result.Ticks = run.Ticks
+		result.Error = run.Error
+		if isTerminalStatus(run.FinalStatus) {
+			result.TimeToTerminal = run.Elapsed
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,38 @@
+package simulation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSensitivityAnalysisLargerTargetsTakeLonger(t *testing.T) {
+	scenario := ScenarioBullRun
+	scenario.TickInterval = time.Millisecond
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	results := SensitivityAnalysis("targetMovement", values, scenario, func(value float64) (ProductConstructor, error) {
+		return ProductConstructor{
+			Name:   "momentum",
+			Payoff: 10,
+			New: func(contractID string) (ScenarioProduct, error) {
+				return newTargetProduct(value, 60), nil
+			},
+		}, nil
+	})
+	if len(results) != len(values) {
+		t.Fatalf("Sweep returned %d results, want %d", len(results), len(values))
+	}
+	for i, result := range results {
+		if result.Value != values[i] || result.FinalStatus != "target_hit" || result.Payoff != 10 {
+			t.Fatalf("Result %d is %+v, want target %v hit earning 10", i, result, values[i])
+		}
+		if i == 0 {
+			continue
+		}
+		previous := results[i-1]
+		if result.Ticks <= previous.Ticks || result.TimeToTerminal <= previous.TimeToTerminal {
+			t.Errorf("Target %v was hit after %d ticks in %s, want longer than target %v's %d ticks in %s",
+				result.Value, result.Ticks, result.TimeToTerminal, previous.Value, previous.Ticks, previous.TimeToTerminal)
+		}
+	}
+}
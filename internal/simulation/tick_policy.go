@@ -0,0 +1,53 @@
+package simulation
+
+import (
+	"time"
+)
+
+// TickPolicy decides when a simulation engine generates its next price
+type TickPolicy interface {
+	// NextTick returns a channel that receives when the next tick is due,
+	// given the number of subscribed contracts
+	NextTick(subscriberCount int) <-chan time.Time
+}
+
+// fixedIntervalPolicy ticks every interval
+type fixedIntervalPolicy struct {
+	interval time.Duration
+}
+
+// FixedIntervalPolicy ticks every d regardless of the subscribers
+func FixedIntervalPolicy(d time.Duration) TickPolicy {
+	return fixedIntervalPolicy{interval: d}
+}
+
+func (p fixedIntervalPolicy) NextTick(subscriberCount int) <-chan time.Time {
+	return time.After(p.interval)
+}
+
+// adaptivePolicy slows ticking down as contracts unsubscribe
+type adaptivePolicy struct {
+	min time.Duration
+	max time.Duration
+}
+
+// AdaptivePolicy ticks every maxD without subscribers and every maxD divided
+// by the number of subscribers otherwise, but never more often than every
+// minD. An engine using it wakes up as soon as a contract subscribes.
+func AdaptivePolicy(minD, maxD time.Duration) TickPolicy {
+	if maxD < minD {
+		maxD = minD
+	}
+	return adaptivePolicy{min: minD, max: maxD}
+}
+
+func (p adaptivePolicy) NextTick(subscriberCount int) <-chan time.Time {
+	interval := p.max
+	if subscriberCount > 1 {
+		interval = p.max / time.Duration(subscriberCount)
+	}
+	if interval < p.min {
+		interval = p.min
+	}
+	return time.After(interval)
+}
@@ -0,0 +1,148 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"pricingserver/internal/common/logging"
+)
+
+// StrikeSubscriber is implemented by price emitters that price contracts
+// with the implied volatility of their strike and maturity
+type StrikeSubscriber interface {
+	SubscribeStrike(instrument, contractID string, strike, maturityDays float64, handler PriceHandler)
+}
+
+// contractPath is the price path of a contract simulated with its own
+// volatility
+type contractPath struct {
+	price      float64
+	volatility float64
+}
+
+// parseVolatilitySurface parses a JSON volatility surface such as
+// {"100": {"30": 0.2, "90": 0.25}}, keyed by strike and then maturity in
+// days. An empty string is an empty surface.
+func parseVolatilitySurface(value string) (map[float64]map[float64]float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var raw map[string]map[string]float64
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, err
+	}
+	surface := make(map[float64]map[float64]float64, len(raw))
+	for strikeKey, maturities := range raw {
+		strike, err := strconv.ParseFloat(strikeKey, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strike %q", strikeKey)
+		}
+		surface[strike] = make(map[float64]float64, len(maturities))
+		for maturityKey, volatility := range maturities {
+			maturity, err := strconv.ParseFloat(maturityKey, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid maturity %q", maturityKey)
+			}
+			if volatility < 0 {
+				return nil, fmt.Errorf("negative volatility for strike %s and maturity %s", strikeKey, maturityKey)
+			}
+			surface[strike][maturity] = volatility
+		}
+	}
+	return surface, nil
+}
+
+// LookupVolatility returns the implied volatility of strike and maturityDays
+// from the engine's VolatilitySurface, interpolating bilinearly between the
+// surrounding points. Points outside the surface take the value at its edge.
+// It returns the engine's Volatility when the surface is empty.
+func (se *SimulationEngine) LookupVolatility(strike, maturityDays float64) float64 {
+	if len(se.VolatilitySurface) == 0 {
+		return se.Volatility
+	}
+	strikes := sortedKeys(se.VolatilitySurface)
+	lower, upper, weight := bracket(strikes, strike)
+	lowerVol := interpolateMaturity(se.VolatilitySurface[lower], maturityDays)
+	upperVol := interpolateMaturity(se.VolatilitySurface[upper], maturityDays)
+	return lowerVol + (upperVol-lowerVol)*weight
+}
+
+// interpolateMaturity linearly interpolates the volatilities of one strike
+// by maturity
+func interpolateMaturity(vols map[float64]float64, maturityDays float64) float64 {
+	if len(vols) == 0 {
+		return 0
+	}
+	lower, upper, weight := bracket(sortedKeys(vols), maturityDays)
+	return vols[lower] + (vols[upper]-vols[lower])*weight
+}
+
+// bracket returns the keys surrounding x and the weight of the upper one,
+// clamping x to the range of keys, which must be sorted and non-empty
+func bracket(keys []float64, x float64) (float64, float64, float64) {
+	i := sort.SearchFloat64s(keys, x)
+	if i == 0 {
+		return keys[0], keys[0], 0
+	}
+	if i == len(keys) {
+		return keys[i-1], keys[i-1], 0
+	}
+	lower, upper := keys[i-1], keys[i]
+	return lower, upper, (x - lower) / (upper - lower)
+}
+
+func sortedKeys[V any](m map[float64]V) []float64 {
+	keys := make([]float64, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Float64s(keys)
+	return keys
+}
+
+// SubscribeStrike adds a handler to receive price updates of instrument
+// simulated with the implied volatility of strike and maturityDays. Every
+// tick moves the contract's price by the same shock as the engine's price,
+// scaled by its own volatility. Contracts without a strike, or of engines
+// without a volatility surface, receive the engine's prices.
+func (se *SimulationEngine) SubscribeStrike(instrument, contractID string, strike, maturityDays float64, handler PriceHandler) {
+	engine := se
+	if instrumentEngine := se.Instrument(instrument); instrumentEngine != nil {
+		engine = instrumentEngine
+	}
+	if strike > 0 && len(engine.VolatilitySurface) > 0 {
+		volatility := engine.LookupVolatility(strike, maturityDays)
+		logging.DebugLog("Contract %s uses implied volatility %f for strike %f and maturity %f days", contractID, volatility, strike, maturityDays)
+		engine.mu.Lock()
+		engine.contractPaths[contractID] = &contractPath{price: engine.BasePrice, volatility: volatility}
+		engine.mu.Unlock()
+	}
+	engine.Subscribe(contractID, handler)
+}
+
+// advanceContractPaths moves the price of every contract with its own
+// volatility by shock. Callers must hold se.mu.
+func (se *SimulationEngine) advanceContractPaths(shock float64) {
+	dt := priceTimeStep
+	for _, path := range se.contractPaths {
+		sigma := path.volatility
+		path.price = path.price * math.Exp((se.Drift-0.5*sigma*sigma)*dt+sigma*shock*math.Sqrt(dt))
+	}
+}
+
+// contractQuote returns the quote of a contract's own price path, or quote
+// when the contract follows the engine's price. Callers must hold se.mu.
+func (se *SimulationEngine) contractQuote(contractID string, quote PriceQuote) PriceQuote {
+	path, ok := se.contractPaths[contractID]
+	if !ok {
+		return quote
+	}
+	return PriceQuote{
+		Mid: path.price,
+		Bid: path.price - se.Spread/2,
+		Ask: path.price + se.Spread/2,
+	}
+}
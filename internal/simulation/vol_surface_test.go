@@ -0,0 +1,44 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLookupVolatilityInterpolatesTheSurface(t *testing.T) {
+	engine := NewSimulationEngine()
+	if vol := engine.LookupVolatility(100, 30); vol != engine.Volatility {
+		t.Errorf("Lookup without a surface is %v, want the engine volatility %v", vol, engine.Volatility)
+	}
+
+	flat, err := parseVolatilitySurface(`{"90": {"30": 0.2, "90": 0.2}, "110": {"30": 0.2, "90": 0.2}}`)
+	if err != nil {
+		t.Fatalf("parseVolatilitySurface: %v", err)
+	}
+	engine.VolatilitySurface = flat
+	for _, point := range [][2]float64{{90, 30}, {100, 60}, {110, 90}, {50, 1}, {200, 365}} {
+		if vol := engine.LookupVolatility(point[0], point[1]); math.Abs(vol-0.2) > 1e-12 {
+			t.Errorf("Flat surface lookup of strike %v and maturity %v is %v, want 0.2", point[0], point[1], vol)
+		}
+	}
+
+	engine.VolatilitySurface = map[float64]map[float64]float64{
+		90:  {30: 0.2, 90: 0.3},
+		110: {30: 0.4, 90: 0.5},
+	}
+	for _, tc := range []struct {
+		strike, maturityDays, volatility float64
+	}{
+		{90, 30, 0.2},
+		{100, 60, 0.35},
+		{90, 60, 0.25},
+		{105, 30, 0.35},
+		// Points outside the surface take the value at its edge
+		{200, 10, 0.4},
+		{50, 365, 0.3},
+	} {
+		if vol := engine.LookupVolatility(tc.strike, tc.maturityDays); math.Abs(vol-tc.volatility) > 1e-12 {
+			t.Errorf("Lookup of strike %v and maturity %v is %v, want %v", tc.strike, tc.maturityDays, vol, tc.volatility)
+		}
+	}
+}
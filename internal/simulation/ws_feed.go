@@ -0,0 +1,186 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"pricingserver/internal/common/logging"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reconnection backoff bounds for WSFeed
+const (
+	wsFeedInitialBackoff = time.Second
+	wsFeedMaxBackoff     = 30 * time.Second
+)
+
+// WSFeed consumes prices from an external WebSocket market data feed that
+// publishes Binance-style trade messages such as {"s": "BTCUSDT", "p": "45000.00"}
+type WSFeed struct {
+	// BasePrice is the last price received from the feed
+	BasePrice float64
+
+	url         string
+	symbol      string
+	conn        *websocket.Conn
+	subscribers map[string]PriceHandler
+	hasPrice    bool
+	mu          sync.Mutex
+	stopChan    chan struct{}
+	stopOnce    sync.Once
+}
+
+// wsPriceMessage is a price update published by the feed. The price may be
+// sent as a string or a number.
+type wsPriceMessage struct {
+	Symbol    string      `json:"s"`
+	Price     json.Number `json:"p"`
+	EventTime int64       `json:"E,omitempty"` // milliseconds
+}
+
+// NewWSFeed connects to the WebSocket feed at url. Only prices for symbol are
+// forwarded; an empty symbol forwards every price.
+func NewWSFeed(url, symbol string) (*WSFeed, error) {
+	logging.DebugLog("Connecting to WebSocket price feed at %s", url)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &WSFeed{
+		url:         url,
+		symbol:      symbol,
+		conn:        conn,
+		subscribers: make(map[string]PriceHandler),
+		stopChan:    make(chan struct{}),
+	}, nil
+}
+
+// Start reads prices from the feed, reconnecting with exponential backoff
+// whenever the connection drops
+func (f *WSFeed) Start() {
+	logging.DebugLog("Starting WebSocket price feed for symbol %q", f.symbol)
+	go f.run()
+}
+
+// Stop closes the connection and stops reconnecting
+func (f *WSFeed) Stop() {
+	logging.DebugLog("Stopping WebSocket price feed")
+	f.stopOnce.Do(func() {
+		close(f.stopChan)
+		f.mu.Lock()
+		if f.conn != nil {
+			f.conn.Close()
+		}
+		f.mu.Unlock()
+	})
+}
+
+// Subscribe adds a handler to receive price updates
+func (f *WSFeed) Subscribe(contractID string, handler PriceHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logging.DebugLog("Adding WebSocket feed subscription for contract %s", contractID)
+	f.subscribers[contractID] = handler
+
+	// Send the last known price immediately, if there is one
+	if f.hasPrice {
+		go handler.HandlePriceUpdate(f.BasePrice, time.Now())
+	}
+}
+
+// Unsubscribe removes a handler from receiving price updates
+func (f *WSFeed) Unsubscribe(contractID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logging.DebugLog("Removing WebSocket feed subscription for contract %s", contractID)
+	delete(f.subscribers, contractID)
+}
+
+func (f *WSFeed) run() {
+	backoff := wsFeedInitialBackoff
+	for {
+		f.mu.Lock()
+		conn := f.conn
+		f.mu.Unlock()
+
+		if conn != nil {
+			f.readPrices(conn)
+			backoff = wsFeedInitialBackoff
+		}
+
+		select {
+		case <-f.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+
+		logging.DebugLog("Reconnecting to WebSocket price feed at %s", f.url)
+		conn, _, err := websocket.DefaultDialer.Dial(f.url, nil)
+		if err != nil {
+			logging.DebugLog("WebSocket price feed reconnect failed: %v", err)
+			backoff *= 2
+			if backoff > wsFeedMaxBackoff {
+				backoff = wsFeedMaxBackoff
+			}
+			conn = nil
+		}
+		f.mu.Lock()
+		f.conn = conn
+		f.mu.Unlock()
+	}
+}
+
+// readPrices forwards prices until the connection fails
+func (f *WSFeed) readPrices(conn *websocket.Conn) {
+	defer conn.Close()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			logging.DebugLog("WebSocket price feed read error: %v", err)
+			return
+		}
+		price, timestamp, err := f.parsePrice(data)
+		if err != nil {
+			logging.DebugLog("Ignoring invalid WebSocket price message: %v", err)
+			continue
+		}
+		if price == nil {
+			continue
+		}
+		f.publish(*price, timestamp)
+	}
+}
+
+// parsePrice returns nil if the message is for another symbol
+func (f *WSFeed) parsePrice(data []byte) (*float64, time.Time, error) {
+	var msg wsPriceMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, time.Time{}, err
+	}
+	if f.symbol != "" && msg.Symbol != f.symbol {
+		return nil, time.Time{}, nil
+	}
+	price, err := strconv.ParseFloat(msg.Price.String(), 64)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid price %q: %v", msg.Price, err)
+	}
+	timestamp := time.Now()
+	if msg.EventTime > 0 {
+		timestamp = time.UnixMilli(msg.EventTime)
+	}
+	return &price, timestamp, nil
+}
+
+func (f *WSFeed) publish(price float64, timestamp time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.BasePrice = price
+	f.hasPrice = true
+	for _, handler := range f.subscribers {
+		go handler.HandlePriceUpdate(price, timestamp)
+	}
+}
@@ -0,0 +1,79 @@
+package simulation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWSFeedForwardsEveryPriceOfItsSymbol(t *testing.T) {
+	messages := []string{
+		`{"s": "BTCUSDT", "p": "45000.00"}`,
+		`{"s": "BTCUSDT", "p": "45010.50"}`,
+		`{"s": "ETHUSDT", "p": "2500.00"}`,
+		`{"s": "BTCUSDT", "p": 45020.25}`,
+		`{"s": "BTCUSDT", "p": "45005.75", "E": 1700000000000}`,
+		`{"s": "BTCUSDT", "p": "45030.00"}`,
+	}
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, msg := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+		// Hold the connection open until the feed closes it
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	feed, err := NewWSFeed("ws"+strings.TrimPrefix(ts.URL, "http"), "BTCUSDT")
+	if err != nil {
+		t.Fatalf("NewWSFeed: %v", err)
+	}
+	recorder := priceRecorder{prices: make(chan float64, 10)}
+	feed.Subscribe("contract-1", recorder)
+	feed.Start()
+	defer feed.Stop()
+
+	// Handlers are called concurrently, so prices may arrive in any order
+	var received []float64
+	for len(received) < 5 {
+		select {
+		case price := <-recorder.prices:
+			received = append(received, price)
+		case <-time.After(time.Second):
+			t.Fatalf("Contract received %v, want 5 prices", received)
+		}
+	}
+	sort.Float64s(received)
+	for i, want := range []float64{45000, 45005.75, 45010.5, 45020.25, 45030} {
+		if received[i] != want {
+			t.Fatalf("Contract received %v, want the 5 BTCUSDT prices", received)
+		}
+	}
+	select {
+	case price := <-recorder.prices:
+		t.Errorf("Contract received %v after the 5 BTCUSDT prices", price)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	feed.mu.Lock()
+	basePrice := feed.BasePrice
+	feed.mu.Unlock()
+	if basePrice != 45030 {
+		t.Errorf("BasePrice is %v, want the last price 45030", basePrice)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAsyncBatchSize     = 50
+	defaultAsyncFlushInterval = 100 * time.Millisecond
+)
+
+// asyncCloseTimeout bounds how long Close keeps retrying buffered writes the
+// database rejects before they are lost
+var asyncCloseTimeout = 10 * time.Second
+
+// batchWriter writes flushed saves and deletes, see PostgresStorage
+type batchWriter interface {
+	SaveBatchOptimized(contracts []*Contract) error
+	DeleteBatch(ids []string) error
+}
+
+// pendingWrite is a buffered mutation waiting to be flushed to the database.
+// A nil contract marks a pending delete.
+type pendingWrite struct {
+	id       string
+	contract *Contract
+}
+
+// AsyncPostgresStorage wraps PostgresStorage with a write-behind buffer.
+// Save and Delete are queued on a channel and flushed in batches by a
+// background goroutine, while reads are served from the pending writes
+// before falling back to the database. Writes the database rejects stay
+// pending and are retried with the next batch.
+type AsyncPostgresStorage struct {
+	storage       *PostgresStorage
+	batches       batchWriter
+	queue         chan pendingWrite
+	flushRequests chan chan struct{}
+	pending       map[string]*pendingWrite
+	mu            sync.RWMutex
+	batchSize     int
+	flushInterval time.Duration
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewAsyncPostgresStorage creates a write-behind wrapper around storage with a
+// queue of the given capacity and starts the background flusher.
+func NewAsyncPostgresStorage(storage *PostgresStorage, capacity int) *AsyncPostgresStorage {
+	return newAsyncPostgresStorage(storage, storage, capacity, defaultAsyncFlushInterval)
+}
+
+// newAsyncPostgresStorage is NewAsyncPostgresStorage with the writer of the
+// flushed batches and the interval at which buffered writes are flushed
+func newAsyncPostgresStorage(storage *PostgresStorage, batches batchWriter, capacity int, flushInterval time.Duration) *AsyncPostgresStorage {
+	s := &AsyncPostgresStorage{
+		storage:       storage,
+		batches:       batches,
+		queue:         make(chan pendingWrite, capacity),
+		flushRequests: make(chan chan struct{}),
+		pending:       make(map[string]*pendingWrite),
+		batchSize:     defaultAsyncBatchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *AsyncPostgresStorage) Save(id string, contract *Contract) error {
+	s.enqueue(pendingWrite{id: id, contract: contract})
+	return nil
+}
+
+func (s *AsyncPostgresStorage) Delete(id string) error {
+	s.enqueue(pendingWrite{id: id})
+	return nil
+}
+
+func (s *AsyncPostgresStorage) Get(id string) (*Contract, error) {
+	s.mu.RLock()
+	write, ok := s.pending[id]
+	s.mu.RUnlock()
+	if ok {
+		return write.contract, nil
+	}
+	return s.storage.Get(id)
+}
+
+func (s *AsyncPostgresStorage) GetAll() ([]*Contract, error) {
+	stored, err := s.storage.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.pending) == 0 {
+		return stored, nil
+	}
+
+	contracts := make([]*Contract, 0, len(stored)+len(s.pending))
+	for _, contract := range stored {
+		if _, ok := s.pending[contract.ID]; !ok {
+			contracts = append(contracts, contract)
+		}
+	}
+	for _, write := range s.pending {
+		if write.contract != nil {
+			contracts = append(contracts, write.contract)
+		}
+	}
+	return contracts, nil
+}
+
+func (s *AsyncPostgresStorage) Clean() error {
+	s.Flush()
+	return s.storage.Clean()
+}
+
+func (s *AsyncPostgresStorage) Ping() error {
+	return s.storage.Ping()
+}
+
+// UpdateFinalPrice flushes pending writes first so the contract row exists
+func (s *AsyncPostgresStorage) UpdateFinalPrice(id string, price float64) error {
+	s.Flush()
+	return s.storage.UpdateFinalPrice(id, price)
+}
+
+// UpdateHitRungs flushes pending writes first so the contract row exists
+func (s *AsyncPostgresStorage) UpdateHitRungs(id string, rungs []float64) error {
+	s.Flush()
+	return s.storage.UpdateHitRungs(id, rungs)
+}
+
+// RungHitFrequency flushes pending writes so the counts reflect every save
+func (s *AsyncPostgresStorage) RungHitFrequency() (map[string]int, error) {
+	s.Flush()
+	return s.storage.RungHitFrequency()
+}
+
+// UpdateTimeToTarget flushes pending writes first so the contract row exists
+func (s *AsyncPostgresStorage) UpdateTimeToTarget(id string, ms int64) error {
+	s.Flush()
+	return s.storage.UpdateTimeToTarget(id, ms)
+}
+
+// TimeToTargetHistogram flushes pending writes so the counts reflect every save
+func (s *AsyncPostgresStorage) TimeToTargetHistogram() (map[string]int, error) {
+	s.Flush()
+	return s.storage.TimeToTargetHistogram()
+}
+
+// PriceStats flushes pending writes so the statistics reflect every save
+func (s *AsyncPostgresStorage) PriceStats() (*PriceStats, error) {
+	s.Flush()
+	return s.storage.PriceStats()
+}
+
+// GetEvents flushes pending writes so the history includes every save
+func (s *AsyncPostgresStorage) GetEvents(contractID string) ([]ContractEvent, error) {
+	s.Flush()
+	return s.storage.GetEvents(contractID)
+}
+
+// ArchiveOlderThan flushes pending writes so buffered saves are archived
+// with the rest
+func (s *AsyncPostgresStorage) ArchiveOlderThan(age time.Duration) (int64, error) {
+	s.Flush()
+	return s.storage.ArchiveOlderThan(age)
+}
+
+// ArchiveContracts flushes pending writes so buffered saves are archived
+// with the rest
+func (s *AsyncPostgresStorage) ArchiveContracts(age time.Duration) ([]string, error) {
+	s.Flush()
+	return s.storage.ArchiveContracts(age)
+}
+
+// SaveIfNotExists counts a buffered save as existing, even one the database
+// has rejected so far, and flushes pending writes so a buffered delete is
+// applied first
+func (s *AsyncPostgresStorage) SaveIfNotExists(id string, contract *Contract) (bool, error) {
+	s.mu.RLock()
+	write, ok := s.pending[id]
+	s.mu.RUnlock()
+	if ok && write.contract != nil {
+		return false, nil
+	}
+	s.Flush()
+	return s.storage.SaveIfNotExists(id, contract)
+}
+
+// ImportContracts flushes pending writes so buffered saves count as existing
+func (s *AsyncPostgresStorage) ImportContracts(contracts []*Contract) ([]string, []string, error) {
+	s.Flush()
+	return s.storage.ImportContracts(contracts)
+}
+
+// Templates are written directly, so they need no flush
+func (s *AsyncPostgresStorage) SaveTemplate(template *ContractTemplate) error {
+	return s.storage.SaveTemplate(template)
+}
+
+func (s *AsyncPostgresStorage) ListTemplates() ([]*ContractTemplate, error) {
+	return s.storage.ListTemplates()
+}
+
+func (s *AsyncPostgresStorage) GetTemplate(id string) (*ContractTemplate, error) {
+	return s.storage.GetTemplate(id)
+}
+
+func (s *AsyncPostgresStorage) DeleteTemplate(id string) (bool, error) {
+	return s.storage.DeleteTemplate(id)
+}
+
+// Migrated sessions are written directly, so they need no flush
+func (s *AsyncPostgresStorage) SaveMigratedSessions(sessions map[string]json.RawMessage) error {
+	return s.storage.SaveMigratedSessions(sessions)
+}
+
+func (s *AsyncPostgresStorage) TakeMigratedSessions(sessionIDs []string) (map[string]json.RawMessage, error) {
+	return s.storage.TakeMigratedSessions(sessionIDs)
+}
+
+func (s *AsyncPostgresStorage) PendingMigratedSessions(sessionIDs []string) ([]string, error) {
+	return s.storage.PendingMigratedSessions(sessionIDs)
+}
+
+func (s *AsyncPostgresStorage) GetArchived() ([]*Contract, error) {
+	s.Flush()
+	return s.storage.GetArchived()
+}
+
+// PnLSummary flushes pending writes so the report reflects every save
+func (s *AsyncPostgresStorage) PnLSummary() (*PnLReport, error) {
+	s.Flush()
+	return s.storage.PnLSummary()
+}
+
+// Flush blocks until every write queued before the call has been written,
+// or the database rejected it and it stays pending.
+func (s *AsyncPostgresStorage) Flush() {
+	reply := make(chan struct{})
+	select {
+	case s.flushRequests <- reply:
+		<-reply
+	case <-s.done:
+	}
+}
+
+// Close stops accepting writes and waits for the buffer to drain, retrying
+// rejected writes for up to asyncCloseTimeout.
+func (s *AsyncPostgresStorage) Close() {
+	s.closeOnce.Do(func() {
+		close(s.queue)
+		<-s.done
+	})
+}
+
+func (s *AsyncPostgresStorage) enqueue(write pendingWrite) {
+	s.mu.Lock()
+	s.pending[write.id] = &write
+	s.mu.Unlock()
+	s.queue <- write
+}
+
+func (s *AsyncPostgresStorage) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pendingWrite, 0, s.batchSize)
+	for {
+		select {
+		case write, ok := <-s.queue:
+			if !ok {
+				s.drain(batch)
+				return
+			}
+			batch = append(batch, write)
+			if len(batch) >= s.batchSize {
+				batch = s.writeBatch(batch)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				batch = s.writeBatch(batch)
+			}
+		case reply := <-s.flushRequests:
+			for drained := false; !drained; {
+				select {
+				case write, ok := <-s.queue:
+					if !ok {
+						drained = true
+						break
+					}
+					batch = append(batch, write)
+				default:
+					drained = true
+				}
+			}
+			batch = s.writeBatch(batch)
+			close(reply)
+		}
+	}
+}
+
+// drain writes the last batch when the storage is closed, retrying the
+// writes the database rejects until asyncCloseTimeout elapses
+func (s *AsyncPostgresStorage) drain(batch []pendingWrite) {
+	deadline := time.Now().Add(asyncCloseTimeout)
+	for batch = s.writeBatch(batch); len(batch) > 0; batch = s.writeBatch(batch) {
+		if time.Now().After(deadline) {
+			log.Printf("Lost %d buffered writes the database rejected", len(batch))
+			return
+		}
+		time.Sleep(defaultAsyncFlushInterval)
+	}
+}
+
+// writeBatch applies the last write seen for each contract in the batch. It
+// returns the writes the database rejected, which stay pending, reusing the
+// batch's array.
+func (s *AsyncPostgresStorage) writeBatch(batch []pendingWrite) []pendingWrite {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	latest := make(map[string]pendingWrite, len(batch))
+	for _, write := range batch {
+		latest[write.id] = write
+	}
+
+	var saves []*Contract
+	var deletes []string
+	for id, write := range latest {
+		if write.contract != nil {
+			saves = append(saves, write.contract)
+		} else {
+			deletes = append(deletes, id)
+		}
+	}
+
+	failed := batch[:0]
+	if len(saves) > 0 {
+		if err := s.batches.SaveBatchOptimized(saves); err != nil {
+			log.Printf("Failed to flush %d buffered saves, retrying with the next batch: %v", len(saves), err)
+			for _, contract := range saves {
+				failed = append(failed, latest[contract.ID])
+				delete(latest, contract.ID)
+			}
+		}
+	}
+	if len(deletes) > 0 {
+		if err := s.batches.DeleteBatch(deletes); err != nil {
+			log.Printf("Failed to flush %d buffered deletes, retrying with the next batch: %v", len(deletes), err)
+			for _, id := range deletes {
+				failed = append(failed, latest[id])
+				delete(latest, id)
+			}
+		}
+	}
+
+	// Drop cache entries that were written and have not been superseded by
+	// a newer write
+	s.mu.Lock()
+	for id, write := range latest {
+		if current, ok := s.pending[id]; ok && current.contract == write.contract {
+			delete(s.pending, id)
+		}
+	}
+	s.mu.Unlock()
+	return failed
+}
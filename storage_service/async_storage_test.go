@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyBatchWriter records the batches written to it, or rejects them with
+// errDatabaseDown while failing is set
+type flakyBatchWriter struct {
+	mu      sync.Mutex
+	failing bool
+	saved   map[string]*Contract
+	deleted map[string]bool
+}
+
+func newFlakyBatchWriter(failing bool) *flakyBatchWriter {
+	return &flakyBatchWriter{
+		failing: failing,
+		saved:   make(map[string]*Contract),
+		deleted: make(map[string]bool),
+	}
+}
+
+func (w *flakyBatchWriter) SaveBatchOptimized(contracts []*Contract) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failing {
+		return errDatabaseDown
+	}
+	for _, contract := range contracts {
+		w.saved[contract.ID] = contract
+	}
+	return nil
+}
+
+func (w *flakyBatchWriter) DeleteBatch(ids []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failing {
+		return errDatabaseDown
+	}
+	for _, id := range ids {
+		w.deleted[id] = true
+	}
+	return nil
+}
+
+func (w *flakyBatchWriter) setFailing(failing bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failing = failing
+}
+
+func (w *flakyBatchWriter) written() (map[string]*Contract, map[string]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	saved := make(map[string]*Contract, len(w.saved))
+	for id, contract := range w.saved {
+		saved[id] = contract
+	}
+	deleted := make(map[string]bool, len(w.deleted))
+	for id := range w.deleted {
+		deleted[id] = true
+	}
+	return saved, deleted
+}
+
+// newTestAsyncStorage returns a write-behind storage that flushes to batches
+// only when asked to, and whose reads cannot reach the database, as nothing
+// listens on its port
+func newTestAsyncStorage(t *testing.T, batches batchWriter) *AsyncPostgresStorage {
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 user=test dbname=test sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := newAsyncPostgresStorage(&PostgresStorage{db: db}, batches, 10, time.Hour)
+	t.Cleanup(func() {
+		closeTimeout := asyncCloseTimeout
+		asyncCloseTimeout = 0
+		storage.Close()
+		asyncCloseTimeout = closeTimeout
+		db.Close()
+	})
+	return storage
+}
+
+// newUnflushedAsyncStorage returns a write-behind storage whose writes are
+// never flushed
+func newUnflushedAsyncStorage(t *testing.T) *AsyncPostgresStorage {
+	return newTestAsyncStorage(t, newFlakyBatchWriter(true))
+}
+
+func TestAsyncPostgresStorageGetReturnsBufferedSave(t *testing.T) {
+	storage := newUnflushedAsyncStorage(t)
+
+	contract := &Contract{
+		ID:         "buffered",
+		Type:       "LuckyLadder",
+		Parameters: json.RawMessage(`{"payoff": 10}`),
+		CreatedAt:  time.Now().UnixMilli(),
+		IsActive:   true,
+		Duration:   60000,
+	}
+	if err := storage.Save(contract.ID, contract); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := storage.Get(contract.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != contract {
+		t.Fatalf("Get returned %+v, want the buffered contract %+v", got, contract)
+	}
+}
+
+func TestAsyncPostgresStorageGetReturnsBufferedDelete(t *testing.T) {
+	storage := newUnflushedAsyncStorage(t)
+
+	if err := storage.Save("deleted", &Contract{ID: "deleted", Type: "LuckyLadder"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := storage.Delete("deleted"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	got, err := storage.Get("deleted")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("Get returned %+v after a buffered delete, want nil", got)
+	}
+}
+
+func TestAsyncPostgresStorageKeepsFailedWritesPending(t *testing.T) {
+	batches := newFlakyBatchWriter(true)
+	storage := newTestAsyncStorage(t, batches)
+
+	saved := &Contract{ID: "saved", Type: "LuckyLadder", IsActive: true}
+	if err := storage.Save(saved.ID, saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := storage.Save("deleted", &Contract{ID: "deleted", Type: "LuckyLadder"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := storage.Delete("deleted"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	storage.Flush()
+
+	got, err := storage.Get(saved.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != saved {
+		t.Fatalf("Get after a failed flush returned %+v, want the buffered contract", got)
+	}
+	got, err = storage.Get("deleted")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("Get after a failed flush returned %+v for a buffered delete, want nil", got)
+	}
+
+	// A write made while the database is down supersedes the failed one
+	updated := &Contract{ID: saved.ID, Type: "LuckyLadder", IsActive: false}
+	if err := storage.Save(updated.ID, updated); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	batches.setFailing(false)
+	storage.Flush()
+
+	written, deleted := batches.written()
+	if written[saved.ID] != updated {
+		t.Errorf("Retried flush wrote %+v, want the latest save %+v", written[saved.ID], updated)
+	}
+	if _, ok := written["deleted"]; ok || !deleted["deleted"] {
+		t.Errorf("Retried flush wrote %+v and deleted %v, want contract deleted removed", written, deleted)
+	}
+	storage.mu.RLock()
+	pending := len(storage.pending)
+	storage.mu.RUnlock()
+	if pending != 0 {
+		t.Errorf("%d writes still pending after a successful flush, want 0", pending)
+	}
+}
+
+func TestAsyncPostgresStorageCloseRetriesFailedWrites(t *testing.T) {
+	batches := newFlakyBatchWriter(true)
+	storage := newAsyncPostgresStorage(nil, batches, 10, time.Hour)
+
+	contract := &Contract{ID: "closing", Type: "LuckyLadder"}
+	if err := storage.Save(contract.ID, contract); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	storage.Flush()
+
+	go func() {
+		time.Sleep(3 * defaultAsyncFlushInterval)
+		batches.setFailing(false)
+	}()
+	storage.Close()
+
+	written, _ := batches.written()
+	if written[contract.ID] != contract {
+		t.Fatalf("Close wrote %+v, want the buffered contract once the database recovered", written[contract.ID])
+	}
+}
+
+func TestAsyncPostgresStorageSaveIfNotExistsCountsBufferedSave(t *testing.T) {
+	storage := newUnflushedAsyncStorage(t)
+
+	contract := &Contract{ID: "buffered", Type: "LuckyLadder"}
+	if err := storage.Save(contract.ID, contract); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	storage.Flush()
+
+	// The database rejected the buffered save, so only the buffer knows it
+	created, err := storage.SaveIfNotExists(contract.ID, &Contract{ID: contract.ID, Type: "MomentumCatcher"})
+	if err != nil {
+		t.Fatalf("SaveIfNotExists: %v", err)
+	}
+	if created {
+		t.Fatal("SaveIfNotExists created a contract whose save is buffered")
+	}
+	if got, _ := storage.Get(contract.ID); got != contract {
+		t.Fatalf("Get returned %+v, want the buffered contract", got)
+	}
+}
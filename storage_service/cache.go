@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const contractCacheTTL = 30 * time.Second
+
+// CachedStorage serves Get from a Redis cache before falling back to the
+// wrapped storage. Save and Delete invalidate the cached entry. Without a
+// Redis client every call goes straight to the wrapped storage.
+type CachedStorage struct {
+	storage Storage
+	redis   *redis.Client
+	// tenantID scopes the cache keys, so storage processes of different
+	// tenants can share a Redis instance without reading each other's
+	// contracts
+	tenantID string
+}
+
+// NewCachedStorage wraps the storage of tenantID with a Redis cache at
+// redisURL. An empty URL disables caching.
+func NewCachedStorage(storage Storage, redisURL, tenantID string) (*CachedStorage, error) {
+	c := &CachedStorage{storage: storage, tenantID: tenantID}
+	if redisURL == "" {
+		return c, nil
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	c.redis = redis.NewClient(opts)
+	if err := c.redis.Ping(context.Background()).Err(); err != nil {
+		c.redis.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// contractCacheKey returns the cache key of a contract of the tenant
+func (c *CachedStorage) contractCacheKey(id string) string {
+	return c.keyPrefix() + id
+}
+
+// keyPrefix starts the cache keys of the tenant. The tenant ID is quoted so
+// tenant IDs containing a colon cannot produce another tenant's keys.
+func (c *CachedStorage) keyPrefix() string {
+	return "contract:" + strconv.Quote(c.tenantID) + ":"
+}
+
+// Storage returns the wrapped storage
+func (c *CachedStorage) Storage() Storage {
+	return c.storage
+}
+
+func (c *CachedStorage) Save(id string, contract *Contract) error {
+	if err := c.storage.Save(id, contract); err != nil {
+		return err
+	}
+	c.invalidate(id)
+	return nil
+}
+
+func (c *CachedStorage) SaveIfNotExists(id string, contract *Contract) (bool, error) {
+	creator, ok := c.storage.(contractCreator)
+	if !ok {
+		return false, errConditionalSavesUnsupported
+	}
+	created, err := creator.SaveIfNotExists(id, contract)
+	if err != nil {
+		return false, err
+	}
+	if created {
+		c.invalidate(id)
+	}
+	return created, nil
+}
+
+func (c *CachedStorage) Get(id string) (*Contract, error) {
+	if c.redis == nil {
+		return c.storage.Get(id)
+	}
+
+	ctx := context.Background()
+	if data, err := c.redis.Get(ctx, c.contractCacheKey(id)).Bytes(); err == nil {
+		var contract Contract
+		if err := json.Unmarshal(data, &contract); err == nil {
+			return &contract, nil
+		}
+	} else if err != redis.Nil {
+		log.Printf("Cache read for contract %s failed: %v", id, err)
+	}
+
+	contract, err := c.storage.Get(id)
+	if err != nil || contract == nil {
+		return contract, err
+	}
+	if data, err := json.Marshal(contract); err == nil {
+		if err := c.redis.Set(ctx, c.contractCacheKey(id), data, contractCacheTTL).Err(); err != nil {
+			log.Printf("Cache write for contract %s failed: %v", id, err)
+		}
+	}
+	return contract, nil
+}
+
+func (c *CachedStorage) Delete(id string) error {
+	if err := c.storage.Delete(id); err != nil {
+		return err
+	}
+	c.invalidate(id)
+	return nil
+}
+
+func (c *CachedStorage) GetAll() ([]*Contract, error) {
+	return c.storage.GetAll()
+}
+
+func (c *CachedStorage) Clean() error {
+	if err := c.storage.Clean(); err != nil {
+		return err
+	}
+	if c.redis == nil {
+		return nil
+	}
+	ctx := context.Background()
+	// Glob characters in the tenant ID must not match other tenants' keys
+	pattern := globEscaper.Replace(c.keyPrefix()) + "*"
+	iter := c.redis.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		c.redis.Del(ctx, iter.Val())
+	}
+	return iter.Err()
+}
+
+// globEscaper escapes the characters of Redis glob-style patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// ArchiveContracts drops the archived contracts from the cache
+func (c *CachedStorage) ArchiveContracts(age time.Duration) ([]string, error) {
+	archive, ok := c.storage.(contractArchiver)
+	if !ok {
+		return nil, errArchivingUnsupported
+	}
+	ids, err := archive.ArchiveContracts(age)
+	for _, id := range ids {
+		c.invalidate(id)
+	}
+	return ids, err
+}
+
+func (c *CachedStorage) UpdateFinalPrice(id string, price float64) error {
+	return c.storage.UpdateFinalPrice(id, price)
+}
+
+func (c *CachedStorage) UpdateHitRungs(id string, rungs []float64) error {
+	return c.storage.UpdateHitRungs(id, rungs)
+}
+
+func (c *CachedStorage) UpdateTimeToTarget(id string, ms int64) error {
+	return c.storage.UpdateTimeToTarget(id, ms)
+}
+
+func (c *CachedStorage) invalidate(id string) {
+	if c.redis == nil {
+		return
+	}
+	if err := c.redis.Del(context.Background(), c.contractCacheKey(id)).Err(); err != nil {
+		log.Printf("Cache invalidation for contract %s failed: %v", id, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestCache wraps storage with a cache for tenantID on the Redis instance
+// of STORAGE_TEST_REDIS_URL. Tests using it are skipped when
+// STORAGE_TEST_REDIS_URL is unset.
+func openTestCache(t *testing.T, storage Storage, tenantID string) *CachedStorage {
+	redisURL := os.Getenv("STORAGE_TEST_REDIS_URL")
+	if redisURL == "" {
+		t.Skip("STORAGE_TEST_REDIS_URL is not set")
+	}
+	cache, err := NewCachedStorage(storage, redisURL, tenantID)
+	if err != nil {
+		t.Fatalf("Failed to connect to test Redis: %v", err)
+	}
+	t.Cleanup(func() {
+		cache.Clean()
+		cache.redis.Close()
+	})
+	return cache
+}
+
+func TestContractCacheKeysAreScopedByTenant(t *testing.T) {
+	tenantA := &CachedStorage{tenantID: "a"}
+	tenantB := &CachedStorage{tenantID: "b"}
+	if tenantA.contractCacheKey("contract-1") == tenantB.contractCacheKey("contract-1") {
+		t.Errorf("Tenants a and b share the cache key %s", tenantA.contractCacheKey("contract-1"))
+	}
+
+	// A colon in the tenant ID must not reach into the contract ID
+	colon := &CachedStorage{tenantID: "a:b"}
+	if tenantA.contractCacheKey("b:c") == colon.contractCacheKey("c") {
+		t.Errorf("Tenants a and a:b share the cache key %s", colon.contractCacheKey("c"))
+	}
+}
+
+func TestCachedStorageDoesNotServeOtherTenantsContracts(t *testing.T) {
+	storageA, storageB := newMemoryStorage(), newMemoryStorage()
+	cacheA := openTestCache(t, storageA, "test-cache-a")
+	cacheB := openTestCache(t, storageB, "test-cache-b")
+
+	contract := testContracts("cache", 1)[0]
+	if err := cacheA.Save(contract.ID, contract); err != nil {
+		t.Fatalf("Save as tenant A: %v", err)
+	}
+	// Reading it as tenant A caches it
+	if got, err := cacheA.Get(contract.ID); err != nil || got == nil {
+		t.Fatalf("Get as tenant A = %v, %v, want the contract", got, err)
+	}
+
+	if got, err := cacheB.Get(contract.ID); err != nil || got != nil {
+		t.Errorf("Get as tenant B = %+v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestCachedStorageCleanKeepsOtherTenantsEntries(t *testing.T) {
+	contract := testContracts("cache", 1)[0]
+	cacheA := openTestCache(t, newMemoryStorage(), "test-cache-a")
+	// A tenant ID with glob characters that match tenant A's keys
+	cacheB := openTestCache(t, newMemoryStorage(), "test-cache-*")
+	for _, cache := range []*CachedStorage{cacheA, cacheB} {
+		cache.Save(contract.ID, contract)
+		cache.Get(contract.ID)
+	}
+
+	if err := cacheB.Clean(); err != nil {
+		t.Fatalf("Clean as tenant B: %v", err)
+	}
+
+	ctx := context.Background()
+	if n, err := cacheA.redis.Exists(ctx, cacheA.contractCacheKey(contract.ID)).Result(); err != nil || n != 1 {
+		t.Errorf("Tenant A's cache entry is gone after tenant B cleaned its cache (%v)", err)
+	}
+	if n, err := cacheB.redis.Exists(ctx, cacheB.contractCacheKey(contract.ID)).Result(); err != nil || n != 0 {
+		t.Errorf("Tenant B's cache entry is still cached after Clean (%v)", err)
+	}
+}
+
+func TestCachedStorageDropsArchivedContracts(t *testing.T) {
+	storage := newMemoryStorage()
+	cache := openTestCache(t, storage, "test-cache-archive")
+	contract := testContracts("cache", 1)[0]
+	contract.CreatedAt = time.Now().Add(-2 * time.Hour).UnixMilli()
+	contract.IsActive = false
+	cache.Save(contract.ID, contract)
+	// Reading it caches it
+	if got, err := cache.Get(contract.ID); err != nil || got == nil {
+		t.Fatalf("Get = %v, %v, want the contract", got, err)
+	}
+
+	if _, err := cache.ArchiveContracts(time.Hour); err != nil {
+		t.Fatalf("ArchiveContracts: %v", err)
+	}
+	if got, err := cache.Get(contract.ID); err != nil || got != nil {
+		t.Errorf("Get after archiving = %+v, %v, want nil, nil", got, err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// changeBufferSize is the number of recent change events kept for clients
+// resuming a stream with Last-Event-ID
+const changeBufferSize = 1000
+
+// changeSubscriberBuffer is the number of events a stream can fall behind by
+// before it is closed. The client can resume it with Last-Event-ID.
+const changeSubscriberBuffer = 64
+
+// Change event types
+const (
+	ChangeEventContractSaved   = "contract.saved"
+	ChangeEventContractDeleted = "contract.deleted"
+)
+
+// ChangeEvent describes a mutation of a stored contract
+type ChangeEvent struct {
+	ID         uint64          `json:"id"`
+	Type       string          `json:"type"`
+	ContractID string          `json:"contractID"`
+	Timestamp  int64           `json:"timestamp"` // milliseconds
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+// ChangeStream publishes a ChangeEvent for every Save and Delete that
+// succeeds on the wrapped storage, and keeps the most recent ones in a ring
+// buffer
+type ChangeStream struct {
+	storage     Storage
+	seq         uint64
+	ring        [changeBufferSize]ChangeEvent
+	size        int
+	subscribers map[chan ChangeEvent]struct{}
+	mu          sync.Mutex
+}
+
+// NewChangeStream wraps storage with change data capture
+func NewChangeStream(storage Storage) *ChangeStream {
+	return &ChangeStream{
+		storage:     storage,
+		subscribers: make(map[chan ChangeEvent]struct{}),
+	}
+}
+
+// Storage returns the wrapped storage
+func (c *ChangeStream) Storage() Storage {
+	return c.storage
+}
+
+func (c *ChangeStream) Save(id string, contract *Contract) error {
+	if err := c.storage.Save(id, contract); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(contract)
+	if err != nil {
+		return err
+	}
+	c.publish(ChangeEventContractSaved, id, payload)
+	return nil
+}
+
+// SaveIfNotExists publishes a save only if the contract was created
+func (c *ChangeStream) SaveIfNotExists(id string, contract *Contract) (bool, error) {
+	creator, ok := c.storage.(contractCreator)
+	if !ok {
+		return false, errConditionalSavesUnsupported
+	}
+	created, err := creator.SaveIfNotExists(id, contract)
+	if err != nil || !created {
+		return created, err
+	}
+	payload, err := json.Marshal(contract)
+	if err != nil {
+		return created, err
+	}
+	c.publish(ChangeEventContractSaved, id, payload)
+	return created, nil
+}
+
+func (c *ChangeStream) Delete(id string) error {
+	if err := c.storage.Delete(id); err != nil {
+		return err
+	}
+	c.publish(ChangeEventContractDeleted, id, nil)
+	return nil
+}
+
+// ArchiveContracts publishes every archived contract as deleted, as it is
+// no longer served by GET /contract
+func (c *ChangeStream) ArchiveContracts(age time.Duration) ([]string, error) {
+	archive, ok := c.storage.(contractArchiver)
+	if !ok {
+		return nil, errArchivingUnsupported
+	}
+	ids, err := archive.ArchiveContracts(age)
+	for _, id := range ids {
+		c.publish(ChangeEventContractDeleted, id, nil)
+	}
+	return ids, err
+}
+
+func (c *ChangeStream) Get(id string) (*Contract, error) {
+	return c.storage.Get(id)
+}
+
+func (c *ChangeStream) GetAll() ([]*Contract, error) {
+	return c.storage.GetAll()
+}
+
+func (c *ChangeStream) Clean() error {
+	return c.storage.Clean()
+}
+
+func (c *ChangeStream) UpdateFinalPrice(id string, price float64) error {
+	return c.storage.UpdateFinalPrice(id, price)
+}
+
+func (c *ChangeStream) UpdateHitRungs(id string, rungs []float64) error {
+	return c.storage.UpdateHitRungs(id, rungs)
+}
+
+func (c *ChangeStream) UpdateTimeToTarget(id string, ms int64) error {
+	return c.storage.UpdateTimeToTarget(id, ms)
+}
+
+// publish records an event and delivers it to every subscriber. Subscribers
+// that cannot keep up are dropped.
+func (c *ChangeStream) publish(eventType, contractID string, payload json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	event := ChangeEvent{
+		ID:         c.seq,
+		Type:       eventType,
+		ContractID: contractID,
+		Timestamp:  time.Now().UnixMilli(),
+		Payload:    payload,
+	}
+	c.ring[(c.seq-1)%changeBufferSize] = event
+	if c.size < changeBufferSize {
+		c.size++
+	}
+
+	for ch := range c.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(c.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the buffered events after lastID and a channel receiving
+// every later event, which is closed if the subscriber falls behind or the
+// stream is closed. The returned function cancels the subscription.
+func (c *ChangeStream) Subscribe(lastID uint64) ([]ChangeEvent, <-chan ChangeEvent, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var backlog []ChangeEvent
+	oldest := c.seq - uint64(c.size) + 1
+	for id := oldest; id <= c.seq; id++ {
+		if id > lastID {
+			backlog = append(backlog, c.ring[(id-1)%changeBufferSize])
+		}
+	}
+
+	ch := make(chan ChangeEvent, changeSubscriberBuffer)
+	c.subscribers[ch] = struct{}{}
+	cancel := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.subscribers[ch]; ok {
+			delete(c.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// Close ends every subscription, so streams do not hold up a shutdown
+func (c *ChangeStream) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ch := range c.subscribers {
+		delete(c.subscribers, ch)
+		close(ch)
+	}
+}
+
+func writeChangeEvent(w http.ResponseWriter, event ChangeEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// handleCDCStream streams contract change events as server-sent events. A
+// client reconnecting with Last-Event-ID first receives the buffered events
+// it missed.
+func (s *server) handleCDCStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.changes == nil {
+		http.Error(w, "Change data capture not enabled", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	backlog, events, cancel := s.changes.Subscribe(lastID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if err := writeChangeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				logf(r.Context(), "CDC stream closed")
+				return
+			}
+			if err := writeChangeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
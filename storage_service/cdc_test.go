@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCDCTestServer serves the change stream of an in-memory storage
+func newCDCTestServer(t *testing.T) (*ChangeStream, *httptest.Server) {
+	changes := NewChangeStream(newMemoryStorage())
+	srv := &server{storage: changes, changes: changes}
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleCDCStream))
+	t.Cleanup(func() {
+		changes.Close()
+		ts.Close()
+	})
+	return changes, ts
+}
+
+// openCDCStream connects to the change stream of ts, resuming after
+// lastEventID unless it is empty
+func openCDCStream(t *testing.T, ts *httptest.Server, lastEventID string) *bufio.Reader {
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /cdc/stream answered %d", resp.StatusCode)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readChangeEvent reads the next server-sent event of a change stream and
+// returns its event field and decoded data
+func readChangeEvent(t *testing.T, stream *bufio.Reader) (string, ChangeEvent) {
+	t.Helper()
+	var eventType string
+	var event ChangeEvent
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read change event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return eventType, event
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Invalid change event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestCDCStreamSendsEverySave(t *testing.T) {
+	changes, ts := newCDCTestServer(t)
+	stream := openCDCStream(t, ts, "")
+
+	contracts := testContracts("cdc", 3)
+	for _, contract := range contracts {
+		if err := changes.Save(contract.ID, contract); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	for i, contract := range contracts {
+		eventType, event := readChangeEvent(t, stream)
+		if eventType != ChangeEventContractSaved || event.Type != ChangeEventContractSaved {
+			t.Errorf("Event %d has type %q and data type %q, want %q", i, eventType, event.Type, ChangeEventContractSaved)
+		}
+		if event.ContractID != contract.ID || event.ID != uint64(i+1) {
+			t.Errorf("Event %d is %+v, want contract %s with ID %d", i, event, contract.ID, i+1)
+		}
+	}
+}
+
+func TestCDCStreamResumesAfterLastEventID(t *testing.T) {
+	changes, ts := newCDCTestServer(t)
+	contracts := testContracts("cdc", 3)
+	for _, contract := range contracts {
+		changes.Save(contract.ID, contract)
+	}
+	changes.Delete(contracts[0].ID)
+
+	stream := openCDCStream(t, ts, "2")
+	for _, want := range []ChangeEvent{
+		{ID: 3, Type: ChangeEventContractSaved, ContractID: contracts[2].ID},
+		{ID: 4, Type: ChangeEventContractDeleted, ContractID: contracts[0].ID},
+	} {
+		eventType, event := readChangeEvent(t, stream)
+		if eventType != want.Type || event.ID != want.ID || event.ContractID != want.ContractID {
+			t.Errorf("Got %s event %+v, want %+v", eventType, event, want)
+		}
+	}
+}
+
+func TestChangeStreamPublishesArchivedContractsAsDeleted(t *testing.T) {
+	storage := newMemoryStorage()
+	contracts := testContracts("archive", 2)
+	for _, contract := range contracts {
+		contract.CreatedAt = time.Now().Add(-2 * time.Hour).UnixMilli()
+	}
+	contracts[0].IsActive = false
+	for _, contract := range contracts {
+		storage.Save(contract.ID, contract)
+	}
+	cached, err := NewCachedStorage(storage, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := NewChangeStream(cached)
+	defer changes.Close()
+
+	ids, err := changes.ArchiveContracts(time.Hour)
+	if err != nil {
+		t.Fatalf("ArchiveContracts: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != contracts[0].ID {
+		t.Fatalf("ArchiveContracts moved %v, want only %s", ids, contracts[0].ID)
+	}
+
+	events, _, unsubscribe := changes.Subscribe(0)
+	unsubscribe()
+	if len(events) != 1 || events[0].Type != ChangeEventContractDeleted || events[0].ContractID != contracts[0].ID {
+		t.Fatalf("Change events = %+v, want one delete of %s", events, contracts[0].ID)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newContractID returns a random ID for a cloned contract
+func newContractID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// cloneContract returns an active copy of original with the given ID,
+// created now, whose parameters do not share memory with the original
+func cloneContract(original *Contract, id string) *Contract {
+	clone := *original
+	clone.ID = id
+	clone.Parameters = append(json.RawMessage(nil), original.Parameters...)
+	clone.CreatedAt = time.Now().UnixMilli()
+	clone.IsActive = true
+	clone.ClonedFrom = original.ID
+	return &clone
+}
+
+// handleCloneContract handles POST /contract/{id}/clone, which saves a copy
+// of a contract under a new ID with a fresh state and returns it
+func (s *server) handleCloneContract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/contract/")
+	id := strings.TrimSuffix(path, "/clone")
+	if id == "" || id == path || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	original, err := s.storage.Get(id)
+	if err != nil {
+		logf(r.Context(), "Failed to load contract %s: %v", id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if original == nil {
+		http.Error(w, "Contract not found", http.StatusNotFound)
+		return
+	}
+
+	cloneID, err := newContractID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	clone := cloneContract(original, cloneID)
+	if err := s.storage.Save(clone.ID, clone); err != nil {
+		logf(r.Context(), "Failed to save clone of contract %s: %v", id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logf(r.Context(), "Cloned contract %s as %s", id, clone.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(clone); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// importContracts posts contracts to POST /contract/import of srv
+func importContracts(t *testing.T, srv *server, contracts []*Contract) *httptest.ResponseRecorder {
+	body, err := json.Marshal(contracts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/contract/import", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	srv.handleImportContracts(rec, req)
+	return rec
+}
+
+func TestImportContractsClassifiesExistingIDs(t *testing.T) {
+	storage := openTestStorage(t, "test-import")
+	contracts := testContracts("import", 100)
+	if err := storage.SaveBatchOptimized(contracts[:10]); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+
+	rec := importContracts(t, &server{storage: storage}, contracts)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Import answered %d: %s", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Created        []string `json:"created"`
+		AlreadyExisted []string `json:"already_existed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid import response: %v", err)
+	}
+	if len(result.Created) != 90 || len(result.AlreadyExisted) != 10 {
+		t.Fatalf("Import created %d and found %d existing, want 90 and 10", len(result.Created), len(result.AlreadyExisted))
+	}
+	for i, id := range result.AlreadyExisted {
+		if id != contracts[i].ID {
+			t.Errorf("already_existed[%d] = %s, want %s", i, id, contracts[i].ID)
+		}
+	}
+	for i, id := range result.Created {
+		if id != contracts[i+10].ID {
+			t.Errorf("created[%d] = %s, want %s", i, id, contracts[i+10].ID)
+		}
+	}
+
+	stored, err := storage.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(stored) != len(contracts) {
+		t.Errorf("GetAll returned %d contracts after the import, want %d", len(stored), len(contracts))
+	}
+}
+
+func TestImportContractsRejectsWholeBatchOnInvalidContract(t *testing.T) {
+	storage := openTestStorage(t, "test-import")
+	contracts := testContracts("import", 10)
+	contracts[5].Duration = 0
+
+	rec := importContracts(t, &server{storage: storage}, contracts)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Import of an invalid batch answered %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	stored, err := storage.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("Rejected import stored %d contracts", len(stored))
+	}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Contract represents the stored contract data
@@ -20,6 +27,10 @@ type Contract struct {
 	CreatedAt  int64           `json:"created_at"`
 	IsActive   bool            `json:"is_active"`
 	Duration   int             `json:"duration"`
+	// ParentContractID links the legs of a multi-leg contract to it
+	ParentContractID string `json:"parent_contract_id,omitempty"`
+	// ClonedFrom is the ID of the contract this one was cloned from
+	ClonedFrom string `json:"cloned_from,omitempty"`
 }
 
 // Storage interface defines the persistence operations
@@ -29,14 +40,83 @@ type Storage interface {
 	Delete(id string) error
 	GetAll() ([]*Contract, error)
 	Clean() error
+	UpdateFinalPrice(id string, price float64) error
+	UpdateHitRungs(id string, rungs []float64) error
+	UpdateTimeToTarget(id string, ms int64) error
 }
 
+// contractCreator is implemented by storage that can save a contract only if
+// its ID is not stored yet
+type contractCreator interface {
+	SaveIfNotExists(id string, contract *Contract) (bool, error)
+}
+
+// contractArchiver is implemented by storage that can move old inactive
+// contracts to the archive
+type contractArchiver interface {
+	ArchiveContracts(age time.Duration) ([]string, error)
+}
+
+// errConditionalSavesUnsupported is returned by SaveIfNotExists of a wrapper
+// whose storage is not a contractCreator
+var errConditionalSavesUnsupported = errors.New("conditional saves not supported by storage")
+
+// errArchivingUnsupported is returned by ArchiveContracts of a wrapper whose
+// storage is not a contractArchiver
+var errArchivingUnsupported = errors.New("contract archiving not supported by storage")
+
 // PostgresStorage implements Storage interface for PostgreSQL
 type PostgresStorage struct {
 	db *sql.DB
+	// readerDB, when set, is a read replica serving Get and GetAll
+	readerDB *sql.DB
+	// TenantID is the tenant whose contracts the storage reads and writes,
+	// enforced by row level security. It defaults to the tenant ''.
+	TenantID string
 }
 
 func NewPostgresStorage(host, port, user, password, dbname string) (*PostgresStorage, error) {
+	db, err := openDatabase(host, port, user, password, dbname)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStorage{db: db}, nil
+}
+
+// UseReadReplica connects to a read replica of the database and serves Get
+// and GetAll from it
+func (s *PostgresStorage) UseReadReplica(host, port, user, password, dbname string) error {
+	db, err := openDatabase(host, port, user, password, dbname)
+	if err != nil {
+		return err
+	}
+	s.readerDB = db
+	return nil
+}
+
+// reader returns the database serving reads, the primary unless a read
+// replica is configured
+func (s *PostgresStorage) reader() *sql.DB {
+	if s.readerDB != nil {
+		return s.readerDB
+	}
+	return s.db
+}
+
+// contracts returns the primary scoped to the storage's tenant, through
+// which the contracts tables are accessed
+func (s *PostgresStorage) contracts() TenantContextDB {
+	return TenantContextDB{db: s.db, tenantID: s.TenantID}
+}
+
+// contractsReader returns the database serving reads scoped to the storage's
+// tenant
+func (s *PostgresStorage) contractsReader() TenantContextDB {
+	return TenantContextDB{db: s.reader(), tenantID: s.TenantID}
+}
+
+// openDatabase connects to a PostgreSQL instance, retrying for up to a minute
+func openDatabase(host, port, user, password, dbname string) (*sql.DB, error) {
 	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
@@ -66,36 +146,119 @@ func NewPostgresStorage(host, port, user, password, dbname string) (*PostgresSto
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database after 30 attempts: %v", err)
 	}
+	return db, nil
+}
 
-	return &PostgresStorage{db: db}, nil
+func (s *PostgresStorage) Ping() error {
+	return s.db.Ping()
 }
 
 func (s *PostgresStorage) Clean() error {
-	_, err := s.db.Exec("DELETE FROM contracts")
+	if _, err := s.contracts().Exec("DELETE FROM contracts"); err != nil {
+		return err
+	}
+	_, err := s.contracts().Exec("DELETE FROM contract_events")
 	return err
 }
 
 func (s *PostgresStorage) Save(id string, contract *Contract) error {
-	_, err := s.db.Exec(`
-		INSERT INTO contracts (id, type, parameters, created_at, is_active, duration)
-		VALUES ($1, $2, $3, $4, $5, $6)
+	tx, err := s.contracts().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO contracts (id, type, parameters, created_at, is_active, duration, parent_contract_id, cloned_from)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
 		ON CONFLICT (id) DO UPDATE SET
 			type = EXCLUDED.type,
 			parameters = EXCLUDED.parameters,
 			created_at = EXCLUDED.created_at,
 			is_active = EXCLUDED.is_active,
-			duration = EXCLUDED.duration
-	`, contract.ID, contract.Type, contract.Parameters, contract.CreatedAt, contract.IsActive, contract.Duration)
-	return err
+			duration = EXCLUDED.duration,
+			parent_contract_id = EXCLUDED.parent_contract_id,
+			cloned_from = EXCLUDED.cloned_from
+	`, contract.ID, contract.Type, contract.Parameters, contract.CreatedAt, contract.IsActive, contract.Duration, contract.ParentContractID, contract.ClonedFrom)
+	if err != nil {
+		return err
+	}
+	if err := appendContractEvents(tx, ContractEventSaved, []*Contract{contract}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveIfNotExists inserts a contract unless one with the same ID is stored,
+// which is left untouched, and reports whether it was inserted
+func (s *PostgresStorage) SaveIfNotExists(id string, contract *Contract) (bool, error) {
+	tx, err := s.contracts().Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO contracts (id, type, parameters, created_at, is_active, duration, parent_contract_id, cloned_from)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
+		ON CONFLICT (id) DO NOTHING
+	`, id, contract.Type, contract.Parameters, contract.CreatedAt, contract.IsActive, contract.Duration, contract.ParentContractID, contract.ClonedFrom)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		return false, err
+	}
+	if err := appendContractEvents(tx, ContractEventSaved, []*Contract{contract}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SaveBatch upserts all contracts in a single transaction
+func (s *PostgresStorage) SaveBatch(contracts []*Contract) error {
+	tx, err := s.contracts().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO contracts (id, type, parameters, created_at, is_active, duration, parent_contract_id, cloned_from)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
+		ON CONFLICT (id) DO UPDATE SET
+			type = EXCLUDED.type,
+			parameters = EXCLUDED.parameters,
+			created_at = EXCLUDED.created_at,
+			is_active = EXCLUDED.is_active,
+			duration = EXCLUDED.duration,
+			parent_contract_id = EXCLUDED.parent_contract_id,
+			cloned_from = EXCLUDED.cloned_from
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, contract := range contracts {
+		if _, err := stmt.Exec(contract.ID, contract.Type, contract.Parameters, contract.CreatedAt, contract.IsActive, contract.Duration, contract.ParentContractID, contract.ClonedFrom); err != nil {
+			return err
+		}
+	}
+	if err := appendContractEvents(tx, ContractEventSaved, contracts); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *PostgresStorage) Get(id string) (*Contract, error) {
 	var contract Contract
 	var parameters []byte
-	err := s.db.QueryRow(`
-		SELECT id, type, parameters, created_at, is_active, duration
+	err := s.contractsReader().QueryRow(`
+		SELECT id, type, parameters, created_at, is_active, duration, COALESCE(parent_contract_id, ''), COALESCE(cloned_from, '')
 		FROM contracts WHERE id = $1
-	`, id).Scan(&contract.ID, &contract.Type, &parameters, &contract.CreatedAt, &contract.IsActive, &contract.Duration)
+	`, id).Scan(&contract.ID, &contract.Type, &parameters, &contract.CreatedAt, &contract.IsActive, &contract.Duration, &contract.ParentContractID, &contract.ClonedFrom)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -109,183 +272,1432 @@ func (s *PostgresStorage) Get(id string) (*Contract, error) {
 }
 
 func (s *PostgresStorage) Delete(id string) error {
-	_, err := s.db.Exec("DELETE FROM contracts WHERE id = $1", id)
-	return err
+	return s.DeleteBatch([]string{id})
 }
 
-func (s *PostgresStorage) GetAll() ([]*Contract, error) {
-	rows, err := s.db.Query(`
-		SELECT id, type, parameters, created_at, is_active, duration
-		FROM contracts
-	`)
+const (
+	// maxRowsPerInsert keeps multi-row inserts well below PostgreSQL's
+	// 65535 bind parameter limit (8 parameters per row)
+	maxRowsPerInsert = 100
+	// copyThreshold is the batch size above which COPY is used instead of
+	// multi-row inserts
+	copyThreshold = 1000
+)
+
+// SaveBatchOptimized upserts contracts using multi-row INSERT statements of
+// up to maxRowsPerInsert rows, or COPY through a staging table for very large
+// batches. If a contract ID appears more than once the last entry wins.
+func (s *PostgresStorage) SaveBatchOptimized(contracts []*Contract) error {
+	contracts = dedupeContracts(contracts)
+	if len(contracts) == 0 {
+		return nil
+	}
+
+	tx, err := s.contracts().Begin()
 	if err != nil {
-		return make([]*Contract, 0), nil // Return empty slice instead of nil
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var contracts []*Contract
-	for rows.Next() {
-		var contract Contract
-		var parameters []byte
-		err := rows.Scan(&contract.ID, &contract.Type, &parameters, &contract.CreatedAt, &contract.IsActive, &contract.Duration)
-		if err != nil {
-			return make([]*Contract, 0), nil // Return empty slice instead of nil
+	if len(contracts) > copyThreshold {
+		err = copyContracts(tx, contracts)
+	} else {
+		for start := 0; start < len(contracts) && err == nil; start += maxRowsPerInsert {
+			end := start + maxRowsPerInsert
+			if end > len(contracts) {
+				end = len(contracts)
+			}
+			err = insertContracts(tx, contracts[start:end])
 		}
-		contract.Parameters = json.RawMessage(parameters)
-		contracts = append(contracts, &contract)
 	}
-	if contracts == nil {
-		return make([]*Contract, 0), nil // Return empty slice instead of nil
+	if err != nil {
+		return err
 	}
-	return contracts, nil
+	if err := appendContractEvents(tx, ContractEventSaved, contracts); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-type server struct {
-	storage Storage
+// writeContractValues appends a multi-row INSERT INTO contracts statement
+// without a conflict clause to query and returns its arguments
+func writeContractValues(query *strings.Builder, contracts []*Contract) []interface{} {
+	query.WriteString("INSERT INTO contracts (id, type, parameters, created_at, is_active, duration, parent_contract_id, cloned_from) VALUES ")
+	args := make([]interface{}, 0, len(contracts)*8)
+	for i, contract := range contracts {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := i * 8
+		fmt.Fprintf(query, "($%d, $%d, $%d, $%d, $%d, $%d, NULLIF($%d, ''), NULLIF($%d, ''))", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+		args = append(args, contract.ID, contract.Type, contract.Parameters, contract.CreatedAt, contract.IsActive, contract.Duration, contract.ParentContractID, contract.ClonedFrom)
+	}
+	return args
 }
 
-func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func insertContracts(tx *sql.Tx, contracts []*Contract) error {
+	var query strings.Builder
+	args := writeContractValues(&query, contracts)
+	query.WriteString(`
+		ON CONFLICT (id) DO UPDATE SET
+			type = EXCLUDED.type,
+			parameters = EXCLUDED.parameters,
+			created_at = EXCLUDED.created_at,
+			is_active = EXCLUDED.is_active,
+			duration = EXCLUDED.duration,
+			parent_contract_id = EXCLUDED.parent_contract_id,
+			cloned_from = EXCLUDED.cloned_from`)
+
+	_, err := tx.Exec(query.String(), args...)
+	return err
+}
+
+// maxImportContracts caps the number of contracts accepted by one import
+const maxImportContracts = 10000
+
+// ImportContracts inserts the contracts whose IDs are not stored yet in a
+// single transaction, recording a saved event for each. Existing contracts
+// are left untouched. It returns the IDs that were created and the IDs that
+// already existed, in input order.
+func (s *PostgresStorage) ImportContracts(contracts []*Contract) ([]string, []string, error) {
+	tx, err := s.contracts().Begin()
+	if err != nil {
+		return nil, nil, err
 	}
+	defer tx.Rollback()
 
-	// Try to ping the database
-	if s.storage != nil {
-		if db, ok := s.storage.(*PostgresStorage); ok {
-			if err := db.db.Ping(); err != nil {
-				log.Printf("Health check failed: %v", err)
-				http.Error(w, fmt.Sprintf("Database not healthy: %v", err), http.StatusServiceUnavailable)
-				return
+	inserted := make(map[string]bool, len(contracts))
+	for start := 0; start < len(contracts); start += maxRowsPerInsert {
+		end := start + maxRowsPerInsert
+		if end > len(contracts) {
+			end = len(contracts)
+		}
+		var query strings.Builder
+		args := writeContractValues(&query, contracts[start:end])
+		query.WriteString(" ON CONFLICT (id) DO NOTHING RETURNING id")
+
+		rows, err := tx.Query(query.String(), args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, nil, err
 			}
+			inserted[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+	created := make([]string, 0, len(inserted))
+	existed := make([]string, 0, len(contracts)-len(inserted))
+	createdContracts := make([]*Contract, 0, len(inserted))
+	for _, contract := range contracts {
+		if inserted[contract.ID] {
+			created = append(created, contract.ID)
+			createdContracts = append(createdContracts, contract)
+		} else {
+			existed = append(existed, contract.ID)
+		}
+	}
+	if err := appendContractEvents(tx, ContractEventSaved, createdContracts); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return created, existed, nil
 }
 
-func (s *server) handleSaveContract(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// copyContracts streams contracts into a temporary table with COPY and
+// upserts them from there, since COPY itself cannot handle conflicts
+func copyContracts(tx *sql.Tx, contracts []*Contract) error {
+	if _, err := tx.Exec(`CREATE TEMP TABLE contracts_staging (LIKE contracts INCLUDING DEFAULTS) ON COMMIT DROP`); err != nil {
+		return err
 	}
 
-	var contract Contract
-	if err := json.NewDecoder(r.Body).Decode(&contract); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	stmt, err := tx.Prepare(pq.CopyIn("contracts_staging", "id", "type", "parameters", "created_at", "is_active", "duration", "parent_contract_id", "cloned_from"))
+	if err != nil {
+		return err
 	}
-
-	if err := s.storage.Save(contract.ID, &contract); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	for _, contract := range contracts {
+		if _, err := stmt.Exec(contract.ID, contract.Type, string(contract.Parameters), contract.CreatedAt, contract.IsActive, contract.Duration, contract.ParentContractID, contract.ClonedFrom); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
 	}
 
-	w.WriteHeader(http.StatusOK)
+	_, err = tx.Exec(`
+		INSERT INTO contracts (id, type, parameters, created_at, is_active, duration, parent_contract_id, cloned_from)
+		SELECT id, type, parameters, created_at, is_active, duration, NULLIF(parent_contract_id, ''), NULLIF(cloned_from, '') FROM contracts_staging
+		ON CONFLICT (id) DO UPDATE SET
+			type = EXCLUDED.type,
+			parameters = EXCLUDED.parameters,
+			created_at = EXCLUDED.created_at,
+			is_active = EXCLUDED.is_active,
+			duration = EXCLUDED.duration,
+			parent_contract_id = EXCLUDED.parent_contract_id,
+			cloned_from = EXCLUDED.cloned_from
+	`)
+	return err
 }
 
-func (s *server) handleGetContract(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		contracts, err := s.storage.GetAll()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if contracts == nil {
-			contracts = make([]*Contract, 0) // Return empty slice instead of nil
-		}
-		if err := json.NewEncoder(w).Encode(contracts); err != nil {
-			log.Printf("Error encoding response: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+// dedupeContracts keeps the last occurrence of each contract ID, as a single
+// upsert statement cannot touch the same row twice
+func dedupeContracts(contracts []*Contract) []*Contract {
+	index := make(map[string]int, len(contracts))
+	deduped := make([]*Contract, 0, len(contracts))
+	for _, contract := range contracts {
+		if i, ok := index[contract.ID]; ok {
+			deduped[i] = contract
+			continue
 		}
-		return
+		index[contract.ID] = len(deduped)
+		deduped = append(deduped, contract)
 	}
+	return deduped
+}
 
-	contract, err := s.storage.Get(id)
+// UpdateFinalPrice records the price a contract settled at. It returns
+// sql.ErrNoRows if the contract does not exist.
+func (s *PostgresStorage) UpdateFinalPrice(id string, price float64) error {
+	result, err := s.contracts().Exec("UPDATE contracts SET final_price = $2 WHERE id = $1", id, price)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
-	if contract == nil {
-		http.Error(w, "Contract not found", http.StatusNotFound)
-		return
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
 	}
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(contract); err != nil {
-		log.Printf("Error encoding response: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// UpdateHitRungs records the rungs a LuckyLadder contract hit. It returns
+// sql.ErrNoRows if the contract does not exist.
+func (s *PostgresStorage) UpdateHitRungs(id string, rungs []float64) error {
+	data, err := json.Marshal(rungs)
+	if err != nil {
+		return err
 	}
+	result, err := s.contracts().Exec("UPDATE contracts SET hit_rungs = $2 WHERE id = $1", id, data)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func (s *server) handleDeleteContract(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// UpdateTimeToTarget records how many milliseconds a MomentumCatcher contract
+// took to hit its target. It returns sql.ErrNoRows if the contract does not
+// exist.
+func (s *PostgresStorage) UpdateTimeToTarget(id string, ms int64) error {
+	result, err := s.contracts().Exec("UPDATE contracts SET time_to_target_ms = $2 WHERE id = $1", id, ms)
+	if err != nil {
+		return err
 	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
 
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "ID is required", http.StatusBadRequest)
-		return
+// DeleteBatch removes all given contracts in a single statement
+func (s *PostgresStorage) DeleteBatch(ids []string) error {
+	tx, err := s.contracts().Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	if err := s.storage.Delete(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if _, err := tx.Exec("DELETE FROM contracts WHERE id = ANY($1)", pq.Array(ids)); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		payload, _ := json.Marshal(map[string]string{"id": id})
+		if err := appendEvent(tx, id, ContractEventDeleted, payload); err != nil {
+			return err
+		}
 	}
+	return tx.Commit()
+}
 
-	w.WriteHeader(http.StatusOK)
+// archivedColumns are the columns shared by contracts and archived_contracts
+const archivedColumns = "id, type, parameters, created_at, is_active, duration, final_price, hit_rungs, time_to_target_ms, parent_contract_id, cloned_from, tenant_id"
+
+// ArchiveOlderThan moves inactive contracts created more than age ago from
+// contracts to archived_contracts in a single transaction, recording an
+// archived event for each, and returns how many were moved
+func (s *PostgresStorage) ArchiveOlderThan(age time.Duration) (int64, error) {
+	ids, err := s.ArchiveContracts(age)
+	return int64(len(ids)), err
 }
 
-func (s *server) handleCleanDB(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// ArchiveContracts is ArchiveOlderThan returning the IDs of the moved
+// contracts
+func (s *PostgresStorage) ArchiveContracts(age time.Duration) ([]string, error) {
+	cutoff := time.Now().Add(-age).UnixMilli()
+
+	tx, err := s.contracts().Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	if err := s.storage.Clean(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	rows, err := tx.Query(`
+		WITH moved AS (
+			DELETE FROM contracts
+			WHERE is_active = FALSE AND created_at < $1
+			RETURNING `+archivedColumns+`
+		)
+		INSERT INTO archived_contracts (`+archivedColumns+`)
+		SELECT `+archivedColumns+` FROM moved
+		ON CONFLICT (id) DO UPDATE SET
+			type = EXCLUDED.type,
+			parameters = EXCLUDED.parameters,
+			created_at = EXCLUDED.created_at,
+			is_active = EXCLUDED.is_active,
+			duration = EXCLUDED.duration,
+			final_price = EXCLUDED.final_price,
+			hit_rungs = EXCLUDED.hit_rungs,
+			time_to_target_ms = EXCLUDED.time_to_target_ms,
+			parent_contract_id = EXCLUDED.parent_contract_id,
+			cloned_from = EXCLUDED.cloned_from
+		RETURNING id
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	w.WriteHeader(http.StatusOK)
+	for _, id := range ids {
+		if err := appendEvent(tx, id, ContractEventArchived, json.RawMessage("{}")); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
-func main() {
-	log.Printf("Starting storage service...")
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8001"
+// GetArchived returns every archived contract
+func (s *PostgresStorage) GetArchived() ([]*Contract, error) {
+	rows, err := s.contracts().Query(`
+		SELECT id, type, parameters, created_at, is_active, duration, COALESCE(parent_contract_id, ''), COALESCE(cloned_from, '')
+		FROM archived_contracts
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-
-	log.Printf("Database configuration: host=%s port=%s user=%s dbname=%s",
-		dbHost, dbPort, dbUser, dbName)
+	contracts := make([]*Contract, 0)
+	for rows.Next() {
+		var contract Contract
+		var parameters []byte
+		if err := rows.Scan(&contract.ID, &contract.Type, &parameters, &contract.CreatedAt, &contract.IsActive, &contract.Duration, &contract.ParentContractID, &contract.ClonedFrom); err != nil {
+			return nil, err
+		}
+		contract.Parameters = json.RawMessage(parameters)
+		contracts = append(contracts, &contract)
+	}
+	return contracts, rows.Err()
+}
 
-	storage, err := NewPostgresStorage(dbHost, dbPort, dbUser, dbPassword, dbName)
+// SaveSimulationSnapshot replaces the stored list of contracts subscribed to
+// the pricing server's simulation engine
+func (s *PostgresStorage) SaveSimulationSnapshot(ids []string) error {
+	data, err := json.Marshal(ids)
 	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO simulation_snapshot (id, contract_ids, saved_at)
+		VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE SET
+			contract_ids = EXCLUDED.contract_ids,
+			saved_at = EXCLUDED.saved_at
+	`, data, time.Now().UnixMilli())
+	return err
+}
+
+// GetSimulationSnapshot returns the stored snapshot, or nil if none was saved
+func (s *PostgresStorage) GetSimulationSnapshot() ([]string, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT contract_ids FROM simulation_snapshot WHERE id = 1").Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// SaveProductSnapshots replaces the stored contract proxy states of the
+// pricing server
+func (s *PostgresStorage) SaveProductSnapshots(snapshots map[string]json.RawMessage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM product_snapshots"); err != nil {
+		return err
+	}
+	savedAt := time.Now().UnixMilli()
+	for contractID, state := range snapshots {
+		if _, err := tx.Exec(
+			"INSERT INTO product_snapshots (contract_id, state, saved_at) VALUES ($1, $2, $3)",
+			contractID, []byte(state), savedAt,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetProductSnapshots returns the stored contract proxy states keyed by
+// contract ID
+func (s *PostgresStorage) GetProductSnapshots() (map[string]json.RawMessage, error) {
+	rows, err := s.db.Query("SELECT contract_id, state FROM product_snapshots")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var contractID string
+		var state []byte
+		if err := rows.Scan(&contractID, &state); err != nil {
+			return nil, err
+		}
+		snapshots[contractID] = json.RawMessage(state)
+	}
+	return snapshots, rows.Err()
+}
+
+// Contract event types
+const (
+	ContractEventSaved    = "saved"
+	ContractEventDeleted  = "deleted"
+	ContractEventArchived = "archived"
+)
+
+// ContractEvent is a single entry in a contract's history
+type ContractEvent struct {
+	ID         int64           `json:"id"`
+	ContractID string          `json:"contract_id"`
+	EventType  string          `json:"event_type"`
+	Payload    json.RawMessage `json:"payload"`
+	OccurredAt int64           `json:"occurred_at"`
+}
+
+// AppendEvent records an event in a contract's history
+func (s *PostgresStorage) AppendEvent(contractID, eventType string, payload json.RawMessage) error {
+	return appendEvent(s.contracts(), contractID, eventType, payload)
+}
+
+// GetEvents returns the history of a contract, oldest first
+func (s *PostgresStorage) GetEvents(contractID string) ([]ContractEvent, error) {
+	rows, err := s.contracts().Query(`
+		SELECT id, contract_id, event_type, payload, occurred_at
+		FROM contract_events
+		WHERE contract_id = $1
+		ORDER BY id
+	`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]ContractEvent, 0)
+	for rows.Next() {
+		var event ContractEvent
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.ContractID, &event.EventType, &payload, &event.OccurredAt); err != nil {
+			return nil, err
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// execer is implemented by *sql.DB, *sql.Tx and TenantContextDB
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func appendEvent(db execer, contractID, eventType string, payload json.RawMessage) error {
+	_, err := db.Exec(`
+		INSERT INTO contract_events (contract_id, event_type, payload, occurred_at)
+		VALUES ($1, $2, $3, $4)
+	`, contractID, eventType, []byte(payload), time.Now().UnixMilli())
+	return err
+}
+
+// appendContractEvents records an event carrying each contract's new state
+func appendContractEvents(tx *sql.Tx, eventType string, contracts []*Contract) error {
+	for _, contract := range contracts {
+		payload, err := json.Marshal(contract)
+		if err != nil {
+			return err
+		}
+		if err := appendEvent(tx, contract.ID, eventType, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStorage) GetAll() ([]*Contract, error) {
+	rows, err := s.contractsReader().Query(`
+		SELECT id, type, parameters, created_at, is_active, duration, COALESCE(parent_contract_id, ''), COALESCE(cloned_from, '')
+		FROM contracts
+	`)
+	if err != nil {
+		return make([]*Contract, 0), nil // Return empty slice instead of nil
+	}
+	defer rows.Close()
+
+	var contracts []*Contract
+	for rows.Next() {
+		var contract Contract
+		var parameters []byte
+		err := rows.Scan(&contract.ID, &contract.Type, &parameters, &contract.CreatedAt, &contract.IsActive, &contract.Duration, &contract.ParentContractID, &contract.ClonedFrom)
+		if err != nil {
+			return make([]*Contract, 0), nil // Return empty slice instead of nil
+		}
+		contract.Parameters = json.RawMessage(parameters)
+		contracts = append(contracts, &contract)
+	}
+	if contracts == nil {
+		return make([]*Contract, 0), nil // Return empty slice instead of nil
+	}
+	return contracts, nil
+}
+
+// PnLReport summarises the potential payoff of active contracts
+type PnLReport struct {
+	TotalPotentialPayoff float64            `json:"total_potential_payoff"`
+	ByType               map[string]float64 `json:"by_type"`
+	ActiveContractCount  int                `json:"active_contract_count"`
+}
+
+// PnLSummary aggregates the payoff of active contracts by product type.
+// Contracts without a numeric payoff are counted but contribute nothing.
+func (s *PostgresStorage) PnLSummary() (*PnLReport, error) {
+	rows, err := s.contracts().Query(`
+		SELECT type,
+			COUNT(*),
+			COALESCE(SUM(CASE WHEN jsonb_typeof(parameters->'payoff') = 'number'
+				THEN CAST(parameters->>'payoff' AS FLOAT) END), 0)
+		FROM contracts
+		WHERE is_active
+		GROUP BY type
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &PnLReport{ByType: make(map[string]float64)}
+	for rows.Next() {
+		var contractType string
+		var count int
+		var payoff float64
+		if err := rows.Scan(&contractType, &count, &payoff); err != nil {
+			return nil, err
+		}
+		report.ByType[snakeCase(contractType)] += payoff
+		report.TotalPotentialPayoff += payoff
+		report.ActiveContractCount += count
+	}
+	return report, rows.Err()
+}
+
+// snakeCase converts a product class name such as LuckyLadder to lucky_ladder
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// PriceStats describes the distribution of settlement prices
+type PriceStats struct {
+	MinFinalPrice    float64 `json:"min_final_price"`
+	MaxFinalPrice    float64 `json:"max_final_price"`
+	AvgFinalPrice    float64 `json:"avg_final_price"`
+	StddevFinalPrice float64 `json:"stddev_final_price"`
+}
+
+// PriceStats aggregates the final prices of inactive contracts. The standard
+// deviation is the population standard deviation; all values are 0 when no
+// contract has a final price.
+func (s *PostgresStorage) PriceStats() (*PriceStats, error) {
+	var min, max, avg, stddev sql.NullFloat64
+	err := s.contracts().QueryRow(`
+		SELECT MIN(final_price), MAX(final_price), AVG(final_price), STDDEV_POP(final_price)
+		FROM contracts
+		WHERE NOT is_active AND final_price IS NOT NULL
+	`).Scan(&min, &max, &avg, &stddev)
+	if err != nil {
+		return nil, err
+	}
+	return &PriceStats{
+		MinFinalPrice:    min.Float64,
+		MaxFinalPrice:    max.Float64,
+		AvgFinalPrice:    avg.Float64,
+		StddevFinalPrice: stddev.Float64,
+	}, nil
+}
+
+// RungHitFrequency counts, for every rung offered by a settled LuckyLadder
+// contract, how many contracts hit it. Rungs are keyed by their JSON value.
+func (s *PostgresStorage) RungHitFrequency() (map[string]int, error) {
+	rows, err := s.contracts().Query(`
+		SELECT rung, SUM(hit)
+		FROM (
+			SELECT r #>> '{}' AS rung,
+				CASE WHEN hit_rungs @> jsonb_build_array(r) THEN 1 ELSE 0 END AS hit
+			FROM contracts, jsonb_array_elements(parameters->'rungs') AS r
+			WHERE type = 'LuckyLadder' AND hit_rungs IS NOT NULL
+		) AS rung_hits
+		GROUP BY rung
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	frequency := make(map[string]int)
+	for rows.Next() {
+		var rung string
+		var hits int
+		if err := rows.Scan(&rung, &hits); err != nil {
+			return nil, err
+		}
+		frequency[rung] = hits
+	}
+	return frequency, rows.Err()
+}
+
+// timeToTargetBuckets is the number of one second buckets in the time to
+// target histogram; slower hits are counted in the last bucket
+const timeToTargetBuckets = 30
+
+// TimeToTargetHistogram counts MomentumCatcher contracts by how long they took
+// to hit their target, in one second buckets labelled "0-1s" to "29-30s" plus
+// "30s+". Contracts that settled without hitting their target are counted
+// under "expired".
+func (s *PostgresStorage) TimeToTargetHistogram() (map[string]int, error) {
+	histogram := make(map[string]int, timeToTargetBuckets+2)
+	for i := 0; i < timeToTargetBuckets; i++ {
+		histogram[fmt.Sprintf("%d-%ds", i, i+1)] = 0
+	}
+	overflow := fmt.Sprintf("%ds+", timeToTargetBuckets)
+	histogram[overflow] = 0
+
+	rows, err := s.contracts().Query(`
+		SELECT LEAST(time_to_target_ms / 1000, $1), COUNT(*)
+		FROM contracts
+		WHERE type = 'MomentumCatcher' AND time_to_target_ms IS NOT NULL
+		GROUP BY 1
+	`, timeToTargetBuckets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket, count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		if bucket >= timeToTargetBuckets {
+			histogram[overflow] += count
+		} else {
+			histogram[fmt.Sprintf("%d-%ds", bucket, bucket+1)] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var expired int
+	err = s.contracts().QueryRow(`
+		SELECT COUNT(*)
+		FROM contracts
+		WHERE type = 'MomentumCatcher' AND NOT is_active AND time_to_target_ms IS NULL
+	`).Scan(&expired)
+	if err != nil {
+		return nil, err
+	}
+	histogram["expired"] = expired
+	return histogram, nil
+}
+
+type server struct {
+	storage Storage
+	changes *ChangeStream
+}
+
+// backend returns the storage behind any caching or logging layer, which
+// provides the optional Ping and reporting methods
+func (s *server) backend() Storage {
+	storage := s.storage
+	for {
+		wrapper, ok := storage.(interface{ Storage() Storage })
+		if !ok {
+			return storage
+		}
+		storage = wrapper.Storage()
+	}
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Try to ping the database
+	if s.storage != nil {
+		if db, ok := s.backend().(interface{ Ping() error }); ok {
+			if err := db.Ping(); err != nil {
+				logf(r.Context(), "Health check failed: %v", err)
+				http.Error(w, fmt.Sprintf("Database not healthy: %v", err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+}
+
+func (s *server) handleSaveContract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var contract Contract
+	if err := json.NewDecoder(r.Body).Decode(&contract); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("if_not_exists") == "true" {
+		s.createContract(w, r, &contract)
+		return
+	}
+
+	if err := s.storage.Save(contract.ID, &contract); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// createContract handles POST /contract?if_not_exists=true, which stores a
+// contract only if its ID is not stored yet and answers {"created": bool}.
+// The save goes through every caching, buffering and logging layer.
+func (s *server) createContract(w http.ResponseWriter, r *http.Request, contract *Contract) {
+	creator, ok := s.storage.(contractCreator)
+	if !ok {
+		http.Error(w, "Conditional saves not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	created, err := creator.SaveIfNotExists(contract.ID, contract)
+	if errors.Is(err, errConditionalSavesUnsupported) {
+		http.Error(w, "Conditional saves not supported by storage", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		logf(r.Context(), "Failed to create contract %s: %v", contract.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]bool{"created": created}); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+// validateImportedContract checks a contract submitted to /contract/import
+func validateImportedContract(contract *Contract) error {
+	if contract.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if contract.Type == "" {
+		return fmt.Errorf("type is required")
+	}
+	var parameters map[string]json.RawMessage
+	if err := json.Unmarshal(contract.Parameters, &parameters); err != nil || parameters == nil {
+		return fmt.Errorf("parameters must be a JSON object")
+	}
+	if contract.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if contract.CreatedAt < 0 {
+		return fmt.Errorf("created_at must not be negative")
+	}
+	return nil
+}
+
+// handleImportContracts creates the contracts of a JSON array in the format
+// returned by GET /contract. Contracts whose ID already exists are skipped
+// and reported in already_existed. Nothing is written if any contract is
+// invalid.
+func (s *server) handleImportContracts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	importer, ok := s.backend().(interface {
+		ImportContracts(contracts []*Contract) ([]string, []string, error)
+	})
+	if !ok {
+		http.Error(w, "Contract import not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	var contracts []*Contract
+	if err := json.NewDecoder(r.Body).Decode(&contracts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(contracts) > maxImportContracts {
+		http.Error(w, fmt.Sprintf("Import is limited to %d contracts", maxImportContracts), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	seen := make(map[string]bool, len(contracts))
+	now := time.Now().UnixMilli()
+	for i, contract := range contracts {
+		if contract == nil {
+			http.Error(w, fmt.Sprintf("contract %d: must be an object", i), http.StatusBadRequest)
+			return
+		}
+		if err := validateImportedContract(contract); err != nil {
+			http.Error(w, fmt.Sprintf("contract %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		if seen[contract.ID] {
+			http.Error(w, fmt.Sprintf("contract %d: duplicate id %s", i, contract.ID), http.StatusBadRequest)
+			return
+		}
+		seen[contract.ID] = true
+		if contract.CreatedAt == 0 {
+			contract.CreatedAt = now
+		}
+	}
+
+	created, existed, err := importer.ImportContracts(contracts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logf(r.Context(), "Imported %d contracts, %d already existed", len(created), len(existed))
+
+	if s.changes != nil {
+		byID := make(map[string]*Contract, len(contracts))
+		for _, contract := range contracts {
+			byID[contract.ID] = contract
+		}
+		for _, id := range created {
+			if payload, err := json.Marshal(byID[id]); err == nil {
+				s.changes.publish(ChangeEventContractSaved, id, payload)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]string{
+		"created":         created,
+		"already_existed": existed,
+	}); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+func (s *server) handleGetContract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		contracts, err := s.storage.GetAll()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if contracts == nil {
+			contracts = make([]*Contract, 0) // Return empty slice instead of nil
+		}
+		if err := json.NewEncoder(w).Encode(contracts); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		return
+	}
+
+	contract, err := s.storage.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if contract == nil {
+		http.Error(w, "Contract not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(contract); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *server) handleDeleteContract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.storage.Delete(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleCleanDB(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.storage.Clean(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handlePnLSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reporter, ok := s.backend().(interface{ PnLSummary() (*PnLReport, error) })
+	if !ok {
+		http.Error(w, "P&L summary not supported by storage", http.StatusNotImplemented)
+		return
+	}
+	report, err := reporter.PnLSummary()
+	if err != nil {
+		logf(r.Context(), "Failed to compute P&L summary: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+func (s *server) handleUpdateFinalPrice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID         string   `json:"id"`
+		FinalPrice *float64 `json:"final_price"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" || req.FinalPrice == nil {
+		http.Error(w, "id and final_price are required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.storage.UpdateFinalPrice(req.ID, *req.FinalPrice)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Contract not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handlePriceStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reporter, ok := s.backend().(interface{ PriceStats() (*PriceStats, error) })
+	if !ok {
+		http.Error(w, "Price statistics not supported by storage", http.StatusNotImplemented)
+		return
+	}
+	stats, err := reporter.PriceStats()
+	if err != nil {
+		logf(r.Context(), "Failed to compute price statistics: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+func (s *server) handleUpdateHitRungs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID       string    `json:"id"`
+		HitRungs []float64 `json:"hit_rungs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.HitRungs == nil {
+		req.HitRungs = []float64{}
+	}
+
+	err := s.storage.UpdateHitRungs(req.ID, req.HitRungs)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Contract not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleRungAnalytics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reporter, ok := s.backend().(interface {
+		RungHitFrequency() (map[string]int, error)
+	})
+	if !ok {
+		http.Error(w, "Rung analytics not supported by storage", http.StatusNotImplemented)
+		return
+	}
+	frequency, err := reporter.RungHitFrequency()
+	if err != nil {
+		logf(r.Context(), "Failed to compute rung hit frequency: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(frequency); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+func (s *server) handleUpdateTimeToTarget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID             string `json:"id"`
+		TimeToTargetMs *int64 `json:"time_to_target_ms"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" || req.TimeToTargetMs == nil {
+		http.Error(w, "id and time_to_target_ms are required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.storage.UpdateTimeToTarget(req.ID, *req.TimeToTargetMs)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Contract not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleTimeToTarget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reporter, ok := s.backend().(interface {
+		TimeToTargetHistogram() (map[string]int, error)
+	})
+	if !ok {
+		http.Error(w, "Time to target analytics not supported by storage", http.StatusNotImplemented)
+		return
+	}
+	histogram, err := reporter.TimeToTargetHistogram()
+	if err != nil {
+		logf(r.Context(), "Failed to compute time to target histogram: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(histogram); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+func (s *server) handleContractEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Path is /contract/{id}/events
+	path := strings.TrimPrefix(r.URL.Path, "/contract/")
+	id := strings.TrimSuffix(path, "/events")
+	if id == "" || id == path || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	history, ok := s.backend().(interface {
+		GetEvents(contractID string) ([]ContractEvent, error)
+	})
+	if !ok {
+		http.Error(w, "Contract events not supported by storage", http.StatusNotImplemented)
+		return
+	}
+	events, err := history.GetEvents(id)
+	if err != nil {
+		logf(r.Context(), "Failed to load events for contract %s: %v", id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+func (s *server) handleArchivedContracts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	archive, ok := s.backend().(interface {
+		GetArchived() ([]*Contract, error)
+	})
+	if !ok {
+		http.Error(w, "Contract archiving not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	contracts, err := archive.GetArchived()
+	if err != nil {
+		logf(r.Context(), "Failed to load archived contracts: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(contracts); err != nil {
+		logf(r.Context(), "Error encoding response: %v", err)
+	}
+}
+
+// runArchiver moves old inactive contracts to the archive every interval.
+// storage should be the outermost layer, so archived contracts are dropped
+// from the cache and published as deleted.
+func runArchiver(storage Storage, interval, age time.Duration) {
+	archive, ok := storage.(contractArchiver)
+	if !ok {
+		log.Printf("Contract archiving not supported by storage, archiver disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids, err := archive.ArchiveContracts(age)
+		if err != nil {
+			log.Printf("Failed to archive contracts: %v", err)
+			continue
+		}
+		if len(ids) > 0 {
+			log.Printf("Archived %d contracts older than %s", len(ids), age)
+		}
+	}
+}
+
+func (s *server) handleSimulationSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshots, ok := s.backend().(interface {
+		SaveSimulationSnapshot(ids []string) error
+		GetSimulationSnapshot() ([]string, error)
+	})
+	if !ok {
+		http.Error(w, "Simulation snapshots not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			ContractIDs []string `json:"contract_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.ContractIDs == nil {
+			req.ContractIDs = []string{}
+		}
+		if err := snapshots.SaveSimulationSnapshot(req.ContractIDs); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		ids, err := snapshots.GetSimulationSnapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if ids == nil {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string][]string{"contract_ids": ids}); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) handleProductSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, ok := s.backend().(interface {
+		SaveProductSnapshots(snapshots map[string]json.RawMessage) error
+		GetProductSnapshots() (map[string]json.RawMessage, error)
+	})
+	if !ok {
+		http.Error(w, "Product snapshots not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Snapshots map[string]json.RawMessage `json:"snapshots"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := snapshots.SaveProductSnapshots(req.Snapshots); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		states, err := snapshots.GetProductSnapshots()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]map[string]json.RawMessage{"snapshots": states}); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func main() {
+	migrate := flag.Bool("migrate", false, "apply pending database migrations and exit")
+	rollback := flag.Bool("rollback", false, "roll back the most recent database migration and exit")
+	flag.Parse()
+
+	log.Printf("Starting storage service...")
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8001"
+	}
+
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	dbUser := os.Getenv("DB_USER")
+	dbPassword := resolveSecret("DB_PASSWORD")
+	dbName := os.Getenv("DB_NAME")
+
+	log.Printf("Database configuration: host=%s port=%s user=%s dbname=%s",
+		dbHost, dbPort, dbUser, dbName)
+
+	pgStorage, err := NewPostgresStorage(dbHost, dbPort, dbUser, dbPassword, dbName)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	pgStorage.TenantID = os.Getenv("STORAGE_TENANT_ID")
+	if replicaHost := os.Getenv("DB_READ_REPLICA_HOST"); replicaHost != "" && !*migrate && !*rollback {
+		replicaPort := os.Getenv("DB_READ_REPLICA_PORT")
+		if replicaPort == "" {
+			replicaPort = dbPort
+		}
+		log.Printf("Reading contracts from replica host=%s port=%s", replicaHost, replicaPort)
+		if err := pgStorage.UseReadReplica(replicaHost, replicaPort, dbUser, dbPassword, dbName); err != nil {
+			log.Fatalf("Failed to connect to read replica: %v", err)
+		}
+	}
+
+	if *migrate {
+		if err := ApplyMigrations(pgStorage.db); err != nil {
+			log.Fatalf("Failed to apply migrations: %v", err)
+		}
+		log.Printf("Migrations applied")
+		return
+	}
+	if *rollback {
+		if err := RollbackMigration(pgStorage.db); err != nil {
+			log.Fatalf("Failed to roll back migration: %v", err)
+		}
+		return
+	}
+
+	var storage Storage = pgStorage
+	var asyncStorage *AsyncPostgresStorage
+	if bufferSize, err := strconv.Atoi(os.Getenv("STORAGE_WRITE_BUFFER_SIZE")); err == nil && bufferSize > 0 {
+		log.Printf("Enabling write-behind buffer with capacity %d", bufferSize)
+		asyncStorage = NewAsyncPostgresStorage(pgStorage, bufferSize)
+		storage = asyncStorage
+	}
+
+	var wal *WriteAheadLog
+	if walPath := os.Getenv("WAL_PATH"); walPath != "" {
+		// Buffered saves are acknowledged before they reach the database, so
+		// the log could not tell which of them were lost
+		if asyncStorage != nil {
+			log.Fatalf("WAL_PATH cannot be combined with STORAGE_WRITE_BUFFER_SIZE")
+		}
+		log.Printf("Enabling write-ahead log at %s", walPath)
+		wal, err = NewWriteAheadLog(walPath, storage)
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead log: %v", err)
+		}
+		storage = wal
+	}
+
+	cachedStorage, err := NewCachedStorage(storage, os.Getenv("REDIS_CACHE_URL"), pgStorage.TenantID)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis cache: %v", err)
+	}
+
+	changes := NewChangeStream(cachedStorage)
+	srv := &server{storage: changes, changes: changes}
+
+	archiveInterval, intervalErr := time.ParseDuration(os.Getenv("ARCHIVE_INTERVAL"))
+	archiveAge, ageErr := time.ParseDuration(os.Getenv("ARCHIVE_AFTER"))
+	if intervalErr == nil && ageErr == nil && archiveInterval > 0 && archiveAge > 0 {
+		log.Printf("Archiving inactive contracts older than %s every %s", archiveAge, archiveInterval)
+		go runArchiver(srv.storage, archiveInterval, archiveAge)
 	}
 
-	srv := &server{storage: storage}
-
 	http.HandleFunc("/health", srv.handleHealth)
 	http.HandleFunc("/contract", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -299,10 +1711,67 @@ func main() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
+	http.HandleFunc("/contract/import", srv.handleImportContracts)
+	http.HandleFunc("/contract/pnl-summary", srv.handlePnLSummary)
+	http.HandleFunc("/contract/final-price", srv.handleUpdateFinalPrice)
+	http.HandleFunc("/contract/price-stats", srv.handlePriceStats)
+	http.HandleFunc("/contract/hit-rungs", srv.handleUpdateHitRungs)
+	http.HandleFunc("/contract/lucky-ladder/rung-analytics", srv.handleRungAnalytics)
+	http.HandleFunc("/contract/time-to-target", srv.handleUpdateTimeToTarget)
+	http.HandleFunc("/contract/momentum-catcher/time-to-target", srv.handleTimeToTarget)
+	http.HandleFunc("/contract/archived", srv.handleArchivedContracts)
+	http.HandleFunc("/contract/template", srv.handleContractTemplates)
+	http.HandleFunc("/contract/template/", srv.handleContractTemplate)
+	http.HandleFunc("/contract/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/clone") {
+			srv.handleCloneContract(w, r)
+			return
+		}
+		srv.handleContractEvents(w, r)
+	})
+	http.HandleFunc("/simulation/snapshot", srv.handleSimulationSnapshot)
+	http.HandleFunc("/simulation/products", srv.handleProductSnapshots)
+	http.HandleFunc("/simulation/sessions", srv.handleMigratedSessions)
+	http.HandleFunc("/cdc/stream", srv.handleCDCStream)
 	http.HandleFunc("/clean", srv.handleCleanDB)
 
-	log.Printf("Storage service HTTP server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
+	tlsConfig, err := mtlsConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure mTLS: %v", err)
+	}
+	httpServer := &http.Server{Addr: ":" + port, Handler: TracingMiddleware(http.DefaultServeMux), TLSConfig: tlsConfig}
+	httpServer.RegisterOnShutdown(changes.Close)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("Storage service HTTPS server requiring client certificates starting on port %s", port)
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Storage service HTTP server starting on port %s", port)
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("Shutting down storage service...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server shutdown error: %v", err)
+	}
+
+	// Make sure every buffered write reaches the database before exiting
+	if asyncStorage != nil {
+		log.Printf("Draining buffered writes...")
+		asyncStorage.Close()
+	}
+	if wal != nil {
+		wal.Close()
 	}
 }
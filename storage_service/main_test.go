@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTestStorage returns a storage for tenantID without contracts, on the
+// database of STORAGE_TEST_DSN migrated to the latest schema. Tests using it
+// are skipped when STORAGE_TEST_DSN is unset. Row level security does not
+// apply to superusers, so the DSN should use the service account.
+func openTestStorage(t testing.TB, tenantID string) *PostgresStorage {
+	dsn := os.Getenv("STORAGE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("STORAGE_TEST_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := ApplyMigrations(db); err != nil {
+		db.Close()
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	storage := &PostgresStorage{db: db, TenantID: tenantID}
+	if err := storage.Clean(); err != nil {
+		db.Close()
+		t.Fatalf("Failed to clean test database: %v", err)
+	}
+	t.Cleanup(func() {
+		storage.Clean()
+		db.Close()
+	})
+	return storage
+}
+
+// testContracts returns n contracts with IDs starting with prefix
+func testContracts(prefix string, n int) []*Contract {
+	contracts := make([]*Contract, n)
+	for i := range contracts {
+		contracts[i] = &Contract{
+			ID:         fmt.Sprintf("%s-%d", prefix, i),
+			Type:       "LuckyLadder",
+			Parameters: json.RawMessage(`{"payoff": 10, "rungs": [100, 101]}`),
+			CreatedAt:  time.Now().UnixMilli(),
+			IsActive:   true,
+			Duration:   60000,
+		}
+	}
+	return contracts
+}
+
+func TestSaveBatchOptimizedStoresEveryContract(t *testing.T) {
+	storage := openTestStorage(t, "test-batch")
+	contracts := testContracts("batch", 200)
+
+	if err := storage.SaveBatchOptimized(contracts); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+
+	stored, err := storage.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	ids := make(map[string]bool, len(stored))
+	for _, contract := range stored {
+		ids[contract.ID] = true
+	}
+	if len(stored) != len(contracts) {
+		t.Errorf("GetAll returned %d contracts, want %d", len(stored), len(contracts))
+	}
+	for _, contract := range contracts {
+		if !ids[contract.ID] {
+			t.Errorf("Contract %s is missing", contract.ID)
+		}
+	}
+}
+
+func TestSaveBatchOptimizedIsFasterThanSequentialSaves(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test skipped in short mode")
+	}
+	storage := openTestStorage(t, "test-batch-speed")
+
+	started := time.Now()
+	for _, contract := range testContracts("sequential", 200) {
+		if err := storage.Save(contract.ID, contract); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	sequential := time.Since(started)
+
+	started = time.Now()
+	if err := storage.SaveBatchOptimized(testContracts("batched", 200)); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+	batched := time.Since(started)
+
+	t.Logf("200 sequential saves took %s, one batch took %s", sequential, batched)
+	if sequential < 3*batched {
+		t.Errorf("Batch saves are %.1fx faster than sequential saves, want at least 3x", float64(sequential)/float64(batched))
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	storage := openTestStorage(b, "bench-save")
+	contracts := testContracts("bench-save", b.N)
+	b.ResetTimer()
+	for _, contract := range contracts {
+		if err := storage.Save(contract.ID, contract); err != nil {
+			b.Fatalf("Save: %v", err)
+		}
+	}
+}
+
+func BenchmarkSaveBatchOptimized(b *testing.B) {
+	storage := openTestStorage(b, "bench-batch")
+	contracts := testContracts("bench-batch", b.N)
+	b.ResetTimer()
+	if err := storage.SaveBatchOptimized(contracts); err != nil {
+		b.Fatalf("SaveBatchOptimized: %v", err)
+	}
+}
+
+func TestSaveIfNotExistsCreatesOnlyOnce(t *testing.T) {
+	storage := openTestStorage(t, "test-create")
+	contracts := testContracts("create", 2)
+
+	created, err := storage.SaveIfNotExists(contracts[0].ID, contracts[0])
+	if err != nil || !created {
+		t.Fatalf("First SaveIfNotExists = %v, %v, want true", created, err)
+	}
+	duplicate := contracts[1]
+	duplicate.ID = contracts[0].ID
+	duplicate.Type = "MomentumCatcher"
+	created, err = storage.SaveIfNotExists(duplicate.ID, duplicate)
+	if err != nil || created {
+		t.Fatalf("Second SaveIfNotExists = %v, %v, want false", created, err)
+	}
+
+	stored, err := storage.Get(contracts[0].ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored == nil || stored.Type != "LuckyLadder" {
+		t.Fatalf("Get returned %+v, want the first contract left untouched", stored)
+	}
+}
+
+// createContractRequest posts contract to POST /contract?if_not_exists=true
+// of srv and returns whether it was created
+func createContractRequest(t *testing.T, srv *server, contract *Contract) bool {
+	t.Helper()
+	body, err := json.Marshal(contract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/contract?if_not_exists=true", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	srv.handleSaveContract(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /contract?if_not_exists=true answered %d: %s", rec.Code, rec.Body)
+	}
+	var result struct {
+		Created bool `json:"created"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result.Created
+}
+
+func TestCreateContractGoesThroughEveryLayer(t *testing.T) {
+	storage := newMemoryStorage()
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	wal, err := NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	cached, err := NewCachedStorage(wal, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := NewChangeStream(cached)
+	defer changes.Close()
+	srv := &server{storage: changes, changes: changes}
+
+	contract := testContracts("create", 1)[0]
+	if !createContractRequest(t, srv, contract) {
+		t.Fatal("First create reported created=false")
+	}
+	duplicate := *contract
+	duplicate.Type = "MomentumCatcher"
+	if createContractRequest(t, srv, &duplicate) {
+		t.Fatal("Duplicate create reported created=true")
+	}
+
+	if stored, _ := storage.Get(contract.ID); stored == nil || stored.Type != "LuckyLadder" {
+		t.Fatalf("Stored contract is %+v, want the first one", stored)
+	}
+	events, _, unsubscribe := changes.Subscribe(0)
+	unsubscribe()
+	if len(events) != 1 || events[0].Type != ChangeEventContractSaved || events[0].ContractID != contract.ID {
+		t.Fatalf("Change events = %+v, want one save of %s", events, contract.ID)
+	}
+
+	// The creation was logged, so nothing is left to replay
+	uncommitted, _, err := readWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uncommitted) != 0 {
+		t.Fatalf("WAL has %d uncommitted saves, want 0", len(uncommitted))
+	}
+	wal.mu.Lock()
+	entries := wal.entries
+	wal.mu.Unlock()
+	if entries != 4 {
+		t.Fatalf("WAL has %d entries, want a save and a commit per create", entries)
+	}
+}
+
+func TestArchiveOlderThanMovesOldInactiveContracts(t *testing.T) {
+	storage := openTestStorage(t, "test-archive")
+	contracts := testContracts("archive", 3)
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	// An old inactive contract, an old active one and a recent inactive one
+	contracts[0].CreatedAt, contracts[0].IsActive = old, false
+	contracts[1].CreatedAt = old
+	contracts[2].IsActive = false
+	if err := storage.SaveBatchOptimized(contracts); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+
+	archived, err := storage.ArchiveOlderThan(time.Hour)
+	if err != nil {
+		t.Fatalf("ArchiveOlderThan: %v", err)
+	}
+	if archived != 1 {
+		t.Fatalf("ArchiveOlderThan moved %d contracts, want 1", archived)
+	}
+
+	if got, err := storage.Get(contracts[0].ID); err != nil || got != nil {
+		t.Errorf("Get of the archived contract = %+v, %v, want nil, nil", got, err)
+	}
+	for _, contract := range contracts[1:] {
+		if got, err := storage.Get(contract.ID); err != nil || got == nil {
+			t.Errorf("Get of %s = %v, %v, want it left in contracts", contract.ID, got, err)
+		}
+	}
+	stored, err := storage.GetArchived()
+	if err != nil {
+		t.Fatalf("GetArchived: %v", err)
+	}
+	if len(stored) != 1 || stored[0].ID != contracts[0].ID {
+		t.Fatalf("GetArchived returned %+v, want only %s", stored, contracts[0].ID)
+	}
+}
+
+// getJSONResponse serves a GET of path with handler and decodes the answer
+// into v
+func getJSONResponse(t *testing.T, handler http.HandlerFunc, path string, v interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s answered %d: %s", path, rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s answered %q: %v", path, rec.Body, err)
+	}
+}
+
+func TestPnLSummarySumsPayoffsOfActiveContracts(t *testing.T) {
+	storage := openTestStorage(t, "test-pnl")
+	contracts := testContracts("pnl", 6)
+	contracts[1].Parameters = json.RawMessage(`{"payoff": 20, "rungs": [100, 101]}`)
+	// Non-numeric payoffs are counted but add nothing
+	contracts[2].Parameters = json.RawMessage(`{"payoff": "ten", "rungs": [100, 101]}`)
+	contracts[3].Type, contracts[3].Parameters = "MomentumCatcher", json.RawMessage(`{"payoff": 5, "target": 110}`)
+	contracts[4].Type, contracts[4].Parameters = "MomentumCatcher", json.RawMessage(`{"payoff": 7.5, "target": 110}`)
+	// Settled contracts are no longer exposure
+	contracts[5].Parameters, contracts[5].IsActive = json.RawMessage(`{"payoff": 100, "rungs": [100, 101]}`), false
+	if err := storage.SaveBatchOptimized(contracts); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+
+	srv := &server{storage: storage}
+	var report PnLReport
+	getJSONResponse(t, srv.handlePnLSummary, "/contract/pnl-summary", &report)
+	if report.TotalPotentialPayoff != 42.5 || report.ActiveContractCount != 5 {
+		t.Errorf("Summary has total %v over %d contracts, want 42.5 over 5", report.TotalPotentialPayoff, report.ActiveContractCount)
+	}
+	if report.ByType["lucky_ladder"] != 30 || report.ByType["momentum_catcher"] != 12.5 {
+		t.Errorf("Summary by type is %v, want lucky_ladder 30 and momentum_catcher 12.5", report.ByType)
+	}
+}
+
+func TestPriceStatsAggregatesFinalPricesOfInactiveContracts(t *testing.T) {
+	storage := openTestStorage(t, "test-price-stats")
+	contracts := testContracts("price-stats", 11)
+	for _, contract := range contracts[:10] {
+		contract.IsActive = false
+	}
+	if err := storage.SaveBatchOptimized(contracts); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+	// Final prices 100 to 109, and one of an active contract that is left out
+	for i, contract := range contracts {
+		if err := storage.UpdateFinalPrice(contract.ID, 100+float64(i)); err != nil {
+			t.Fatalf("UpdateFinalPrice: %v", err)
+		}
+	}
+	if err := storage.UpdateFinalPrice("price-stats-missing", 1); err != sql.ErrNoRows {
+		t.Errorf("UpdateFinalPrice of a missing contract returned %v, want sql.ErrNoRows", err)
+	}
+
+	srv := &server{storage: storage}
+	var stats PriceStats
+	getJSONResponse(t, srv.handlePriceStats, "/contract/price-stats", &stats)
+	if stats.MinFinalPrice != 100 || stats.MaxFinalPrice != 109 {
+		t.Errorf("Stats range is %v to %v, want 100 to 109", stats.MinFinalPrice, stats.MaxFinalPrice)
+	}
+	if math.Abs(stats.AvgFinalPrice-104.5) > 1e-9 {
+		t.Errorf("Mean final price is %v, want 104.5", stats.AvgFinalPrice)
+	}
+	if want := math.Sqrt(8.25); math.Abs(stats.StddevFinalPrice-want) > 1e-9 {
+		t.Errorf("Final price standard deviation is %v, want %v", stats.StddevFinalPrice, want)
+	}
+}
+
+func TestRungAnalyticsCountsHitsOfSettledLuckyLadders(t *testing.T) {
+	storage := openTestStorage(t, "test-rungs")
+	contracts := testContracts("rungs", 6)
+	for _, contract := range contracts {
+		contract.Parameters = json.RawMessage(`{"payoff": 10, "rungs": [100, 101, 102]}`)
+		contract.IsActive = false
+	}
+	if err := storage.SaveBatchOptimized(contracts); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+	// The last contract is not settled yet and has no hit rungs
+	for i, hit := range [][]float64{{100}, {100, 101}, {100, 101, 102}, {}, {101}} {
+		if err := storage.UpdateHitRungs(contracts[i].ID, hit); err != nil {
+			t.Fatalf("UpdateHitRungs: %v", err)
+		}
+	}
+
+	srv := &server{storage: storage}
+	var frequency map[string]int
+	getJSONResponse(t, srv.handleRungAnalytics, "/contract/lucky-ladder/rung-analytics", &frequency)
+	if want := map[string]int{"100": 3, "101": 3, "102": 1}; !reflect.DeepEqual(frequency, want) {
+		t.Fatalf("Rung analytics are %v, want %v", frequency, want)
+	}
+}
+
+func TestTimeToTargetHistogramBucketsSettledMomentumCatchers(t *testing.T) {
+	storage := openTestStorage(t, "test-time-to-target")
+	contracts := testContracts("time-to-target", 9)
+	for _, contract := range contracts[:8] {
+		contract.Type = "MomentumCatcher"
+		contract.Parameters = json.RawMessage(`{"payoff": 10, "target": 110}`)
+		contract.IsActive = false
+	}
+	// Contract 6 is still running and contract 8 is a settled LuckyLadder
+	contracts[6].IsActive = true
+	contracts[8].IsActive = false
+	if err := storage.SaveBatchOptimized(contracts); err != nil {
+		t.Fatalf("SaveBatchOptimized: %v", err)
+	}
+	// Contracts 5 and 7 expired without hitting their target
+	for i, ms := range []int64{500, 800, 1500, 29999, 45000} {
+		if err := storage.UpdateTimeToTarget(contracts[i].ID, ms); err != nil {
+			t.Fatalf("UpdateTimeToTarget: %v", err)
+		}
+	}
+
+	srv := &server{storage: storage}
+	var histogram map[string]int
+	getJSONResponse(t, srv.handleTimeToTarget, "/contract/momentum-catcher/time-to-target", &histogram)
+	want := map[string]int{"30s+": 1, "expired": 2}
+	for i := 0; i < 30; i++ {
+		want[fmt.Sprintf("%d-%ds", i, i+1)] = 0
+	}
+	want["0-1s"], want["1-2s"], want["29-30s"] = 2, 1, 1
+	if !reflect.DeepEqual(histogram, want) {
+		t.Fatalf("Time to target histogram is %v, want %v", histogram, want)
+	}
+}
+
+func TestContractEventsRecordEverySaveAndDelete(t *testing.T) {
+	storage := openTestStorage(t, "test-events")
+	contract := testContracts("events", 1)[0]
+	if err := storage.Save(contract.ID, contract); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	settled := *contract
+	settled.IsActive = false
+	if err := storage.Save(settled.ID, &settled); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := storage.Delete(contract.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	srv := &server{storage: storage}
+	var events []ContractEvent
+	getJSONResponse(t, srv.handleContractEvents, "/contract/"+contract.ID+"/events", &events)
+	if len(events) != 3 {
+		t.Fatalf("Contract has %d events, want 3", len(events))
+	}
+	for i, eventType := range []string{ContractEventSaved, ContractEventSaved, ContractEventDeleted} {
+		if events[i].EventType != eventType || events[i].ContractID != contract.ID {
+			t.Errorf("Event %d is %s of %s, want %s of %s", i+1, events[i].EventType, events[i].ContractID, eventType, contract.ID)
+		}
+		if i > 0 && (events[i].ID <= events[i-1].ID || events[i].OccurredAt < events[i-1].OccurredAt) {
+			t.Errorf("Event %d (%d at %d) is not after event %d", i+1, events[i].ID, events[i].OccurredAt, i)
+		}
+	}
+	// Save events carry the state that was saved
+	var saved Contract
+	if err := json.Unmarshal(events[1].Payload, &saved); err != nil || saved.IsActive {
+		t.Errorf("Second save event carries %s, want the inactive contract", events[1].Payload)
+	}
+}
+
+func TestContractTemplatesSaveListGetAndDelete(t *testing.T) {
+	storage := openTestStorage(t, "test-templates")
+	srv := &server{storage: storage}
+
+	rec := httptest.NewRecorder()
+	srv.handleContractTemplates(rec, httptest.NewRequest(http.MethodPost, "/contract/template", strings.NewReader(
+		`{"name": "Three rungs", "type": "LuckyLadder", "parameters": {"rungs": [101, 102, 103], "duration": 60000, "payoff": 10}}`)))
+	var saved ContractTemplate
+	if err := json.NewDecoder(rec.Body).Decode(&saved); rec.Code != http.StatusOK || err != nil || saved.ID == "" {
+		t.Fatalf("POST /contract/template answered %d with %+v, want the template with a new ID", rec.Code, saved)
+	}
+	path := "/contract/template/" + saved.ID
+
+	var template ContractTemplate
+	getJSONResponse(t, srv.handleContractTemplate, path, &template)
+	var parameters map[string]interface{}
+	json.Unmarshal(template.Parameters, &parameters)
+	if template.Name != "Three rungs" || template.Type != "LuckyLadder" || parameters["payoff"] != 10.0 {
+		t.Errorf("GET %s answered %+v, want the saved template", path, template)
+	}
+	var list []*ContractTemplate
+	getJSONResponse(t, srv.handleContractTemplates, "/contract/template", &list)
+	listed := false
+	for _, template := range list {
+		listed = listed || template.ID == saved.ID
+	}
+	if !listed {
+		t.Errorf("Template list does not include %s", saved.ID)
+	}
+
+	for _, expected := range []int{http.StatusOK, http.StatusNotFound} {
+		rec = httptest.NewRecorder()
+		srv.handleContractTemplate(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		if rec.Code != expected {
+			t.Fatalf("DELETE %s answered %d, want %d", path, rec.Code, expected)
+		}
+	}
+	rec = httptest.NewRecorder()
+	srv.handleContractTemplate(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET %s of a deleted template answered %d, want 404", path, rec.Code)
+	}
+}
+
+func TestCloneContractSavesACopyUnderANewID(t *testing.T) {
+	storage := openTestStorage(t, "test-clone")
+	srv := &server{storage: storage}
+	original := testContracts("clone", 1)[0]
+	original.IsActive = false
+	original.CreatedAt = time.Now().Add(-time.Hour).UnixMilli()
+	if err := storage.Save(original.ID, original); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.handleCloneContract(rec, httptest.NewRequest(http.MethodPost, "/contract/"+original.ID+"/clone", nil))
+	var clone Contract
+	if err := json.NewDecoder(rec.Body).Decode(&clone); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("POST /contract/%s/clone answered %d (%v), want the clone", original.ID, rec.Code, err)
+	}
+	if clone.ID == "" || clone.ID == original.ID {
+		t.Fatalf("Clone has ID %q, want a new ID", clone.ID)
+	}
+
+	stored, err := storage.Get(clone.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("Clone %s was not saved: %v", clone.ID, err)
+	}
+	var parameters, originalParameters map[string]interface{}
+	json.Unmarshal(stored.Parameters, &parameters)
+	json.Unmarshal(original.Parameters, &originalParameters)
+	if stored.Type != original.Type || stored.Duration != original.Duration || !reflect.DeepEqual(parameters, originalParameters) {
+		t.Errorf("Stored clone is %+v, want the type, duration and parameters of %+v", stored, original)
+	}
+	if stored.ClonedFrom != original.ID {
+		t.Errorf("Stored clone has cloned_from %q, want %s", stored.ClonedFrom, original.ID)
+	}
+	if !stored.IsActive || stored.CreatedAt <= original.CreatedAt {
+		t.Errorf("Stored clone is active=%v created at %d, want an active contract created after %d", stored.IsActive, stored.CreatedAt, original.CreatedAt)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleCloneContract(rec, httptest.NewRequest(http.MethodPost, "/contract/clone-unknown/clone", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Cloning an unknown contract answered %d, want 404", rec.Code)
+	}
+}
+
+// recordingDriver is a database/sql driver whose connections accept every
+// statement, answer queries with no rows and record the statements they
+// received by data source name
+type recordingDriver struct {
+	mu         sync.Mutex
+	statements map[string][]string
+}
+
+var testRecordingDriver = &recordingDriver{statements: make(map[string][]string)}
+
+func init() {
+	sql.Register("recording", testRecordingDriver)
+}
+
+// openRecordingDB returns a database of the recording driver whose
+// statements are recorded under name
+func openRecordingDB(t *testing.T, name string) *sql.DB {
+	db, err := sql.Open("recording", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// recorded returns the statements received by the databases opened as name
+// that are not part of a tenant transaction's setup
+func (d *recordingDriver) recorded(name string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var statements []string
+	for _, statement := range d.statements[name] {
+		if !strings.Contains(statement, "set_config") {
+			statements = append(statements, strings.Join(strings.Fields(statement), " "))
+		}
+	}
+	return statements
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{driver: d, name: name}, nil
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+	name   string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{conn: c, query: query}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordingConn) Commit() error             { return nil }
+func (c *recordingConn) Rollback() error           { return nil }
+
+type recordingStmt struct {
+	conn  *recordingConn
+	query string
+}
+
+func (s *recordingStmt) record() {
+	s.conn.driver.mu.Lock()
+	defer s.conn.driver.mu.Unlock()
+	s.conn.driver.statements[s.conn.name] = append(s.conn.driver.statements[s.conn.name], s.query)
+}
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record()
+	return driver.RowsAffected(1), nil
+}
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record()
+	return noRows{}, nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string              { return nil }
+func (noRows) Close() error                   { return nil }
+func (noRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestReadsGoToTheReplicaAndWritesToThePrimary(t *testing.T) {
+	storage := &PostgresStorage{
+		db:       openRecordingDB(t, "primary"),
+		readerDB: openRecordingDB(t, "replica"),
+		TenantID: "test-replica",
+	}
+	contract := testContracts("replica", 1)[0]
+	if err := storage.Save(contract.ID, contract); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := storage.Delete(contract.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := storage.Get(contract.ID); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := storage.GetAll(); err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+
+	for _, statement := range testRecordingDriver.recorded("primary") {
+		if strings.HasPrefix(statement, "SELECT") {
+			t.Errorf("Primary received the read %q", statement)
+		}
+	}
+	primary := strings.Join(testRecordingDriver.recorded("primary"), "\n")
+	if !strings.Contains(primary, "INSERT INTO contracts") || !strings.Contains(primary, "DELETE FROM contracts") {
+		t.Errorf("Primary received %q, want the save and the delete", primary)
+	}
+	replica := testRecordingDriver.recorded("replica")
+	if len(replica) != 2 || !strings.HasSuffix(replica[0], "FROM contracts WHERE id = $1") || !strings.HasSuffix(replica[1], "FROM contracts") {
+		t.Errorf("Replica received %q, want the Get and GetAll queries", replica)
+	}
+
+	// Without a replica the primary serves the reads as well
+	storage = &PostgresStorage{db: openRecordingDB(t, "primary-only"), TenantID: "test-replica"}
+	storage.Get(contract.ID)
+	if statements := testRecordingDriver.recorded("primary-only"); len(statements) != 1 || !strings.HasPrefix(statements[0], "SELECT") {
+		t.Errorf("Primary without a replica received %q, want the Get query", statements)
+	}
+}
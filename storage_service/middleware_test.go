@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracingMiddlewarePropagatesRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logf(r.Context(), "handling contract lookup")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/contract/contract-1", nil)
+	req.Header.Set(requestIDHeader, "trace-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if id := rec.Header().Get(requestIDHeader); id != "trace-123" {
+		t.Errorf("Response %s is %q, want trace-123", requestIDHeader, id)
+	}
+	for _, line := range []string{
+		"request_id=trace-123 handling contract lookup",
+		"request_id=trace-123 method=GET path=/contract/contract-1 status=418 duration=",
+	} {
+		if !strings.Contains(logs.String(), line) {
+			t.Errorf("Logs %q do not contain %q", logs.String(), line)
+		}
+	}
+
+	// Requests without an ID are given one
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if id := rec.Header().Get(requestIDHeader); id == "" || !strings.Contains(logs.String(), "request_id="+id+" method=GET path=/health") {
+		t.Errorf("Response %s is %q, want a generated ID that is logged", requestIDHeader, id)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// noTransactionDirective marks migrations that cannot run inside a
+// transaction, such as CREATE INDEX CONCURRENTLY
+const noTransactionDirective = "-- migrate:no-transaction"
+
+// migration is a pair of embedded up and down SQL files sharing a version
+type migration struct {
+	version string
+	up      string
+	down    string
+}
+
+func loadMigrations() ([]migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	migrations := make([]migration, 0, len(names))
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".up.sql")
+		up, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		down, err := migrationFiles.ReadFile("migrations/" + version + ".down.sql")
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no down file: %v", version, err)
+		}
+		migrations = append(migrations, migration{version: version, up: string(up), down: string(down)})
+	}
+	return migrations, nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	return err
+}
+
+// ApplyMigrations applies every embedded migration that has not been applied
+// yet, in version order
+func ApplyMigrations(db *sql.DB) error {
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		var applied bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+		log.Printf("Applying migration %s", m.version)
+		if err := runMigration(db, m.up, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version); err != nil {
+			return fmt.Errorf("migration %s failed: %v", m.version, err)
+		}
+	}
+	return nil
+}
+
+// RollbackMigration reverts the most recently applied migration
+func RollbackMigration(db *sql.DB) error {
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+	var version string
+	err := db.QueryRow("SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1").Scan(&version)
+	if err == sql.ErrNoRows {
+		log.Printf("No migrations to roll back")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version != version {
+			continue
+		}
+		log.Printf("Rolling back migration %s", m.version)
+		if err := runMigration(db, m.down, "DELETE FROM schema_migrations WHERE version = $1", m.version); err != nil {
+			return fmt.Errorf("rollback of %s failed: %v", m.version, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("applied migration %s is unknown to this binary", version)
+}
+
+// runMigration executes script and then the bookkeeping statement, inside a
+// transaction unless the script opts out
+func runMigration(db *sql.DB, script, bookkeeping, version string) error {
+	if strings.HasPrefix(strings.TrimSpace(script), noTransactionDirective) {
+		if _, err := db.Exec(script); err != nil {
+			return err
+		}
+		_, err := db.Exec(bookkeeping, version)
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(bookkeeping, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS contract_events;
+ALTER TABLE contracts DROP COLUMN IF EXISTS time_to_target_ms;
+ALTER TABLE contracts DROP COLUMN IF EXISTS hit_rungs;
+ALTER TABLE contracts DROP COLUMN IF EXISTS final_price;
@@ -0,0 +1,15 @@
+-- Settlement columns and contract history, for databases created before they
+-- were added to db/init.sql
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS final_price FLOAT;
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS hit_rungs JSONB;
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS time_to_target_ms INT;
+
+CREATE TABLE IF NOT EXISTS contract_events (
+    id BIGSERIAL PRIMARY KEY,
+    contract_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    occurred_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS contract_events_contract_id_idx ON contract_events (contract_id, id);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS simulation_snapshot (
+    id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    contract_ids JSONB NOT NULL,
+    saved_at BIGINT NOT NULL
+);
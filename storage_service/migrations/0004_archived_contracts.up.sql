@@ -0,0 +1,2 @@
+-- Cold storage for inactive contracts moved out of the contracts table
+CREATE TABLE IF NOT EXISTS archived_contracts (LIKE contracts INCLUDING ALL);
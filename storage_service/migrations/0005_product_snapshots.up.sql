@@ -0,0 +1,6 @@
+-- Contract proxy states saved by the pricing server on graceful shutdown
+CREATE TABLE IF NOT EXISTS product_snapshots (
+    contract_id TEXT PRIMARY KEY,
+    state JSONB NOT NULL,
+    saved_at BIGINT NOT NULL
+);
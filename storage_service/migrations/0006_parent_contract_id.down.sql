@@ -0,0 +1,2 @@
+ALTER TABLE archived_contracts DROP COLUMN IF EXISTS parent_contract_id;
+ALTER TABLE contracts DROP COLUMN IF EXISTS parent_contract_id;
@@ -0,0 +1,3 @@
+-- Links the legs of a multi-leg contract to their parent contract
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS parent_contract_id TEXT;
+ALTER TABLE archived_contracts ADD COLUMN IF NOT EXISTS parent_contract_id TEXT;
@@ -0,0 +1,8 @@
+-- Contract parameters saved by users to create contracts from later
+CREATE TABLE IF NOT EXISTS contract_templates (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    parameters JSONB NOT NULL,
+    created_at BIGINT NOT NULL
+);
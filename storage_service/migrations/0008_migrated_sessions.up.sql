@@ -0,0 +1,6 @@
+-- WebSocket sessions handed over by a pricing server that is being replaced
+CREATE TABLE IF NOT EXISTS migrated_sessions (
+    session_id TEXT PRIMARY KEY,
+    session JSONB NOT NULL,
+    saved_at BIGINT NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE archived_contracts DROP COLUMN IF EXISTS cloned_from;
+ALTER TABLE contracts DROP COLUMN IF EXISTS cloned_from;
@@ -0,0 +1,3 @@
+-- Links a cloned contract to the contract it was copied from
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS cloned_from TEXT;
+ALTER TABLE archived_contracts ADD COLUMN IF NOT EXISTS cloned_from TEXT;
@@ -0,0 +1,14 @@
+DROP POLICY IF EXISTS tenant_isolation ON contract_events;
+ALTER TABLE contract_events NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE contract_events DISABLE ROW LEVEL SECURITY;
+ALTER TABLE contract_events DROP COLUMN IF EXISTS tenant_id;
+
+DROP POLICY IF EXISTS tenant_isolation ON archived_contracts;
+ALTER TABLE archived_contracts NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE archived_contracts DISABLE ROW LEVEL SECURITY;
+ALTER TABLE archived_contracts DROP COLUMN IF EXISTS tenant_id;
+
+DROP POLICY IF EXISTS tenant_isolation ON contracts;
+ALTER TABLE contracts NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE contracts DISABLE ROW LEVEL SECURITY;
+ALTER TABLE contracts DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,31 @@
+-- Scopes contracts and their events to the tenant in app.tenant_id, which the
+-- storage service sets at the start of every transaction. Existing rows belong
+-- to the default tenant ''. New rows take the tenant of their transaction.
+ALTER TABLE contracts ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE contracts ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE archived_contracts ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE archived_contracts ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE contract_events ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE contract_events ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+
+-- FORCE applies the policies to the table owner, the service account
+ALTER TABLE contracts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE contracts FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON contracts;
+CREATE POLICY tenant_isolation ON contracts
+	USING (tenant_id = current_setting('app.tenant_id', true))
+	WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE archived_contracts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE archived_contracts FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON archived_contracts;
+CREATE POLICY tenant_isolation ON archived_contracts
+	USING (tenant_id = current_setting('app.tenant_id', true))
+	WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE contract_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE contract_events FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON contract_events;
+CREATE POLICY tenant_isolation ON contract_events
+	USING (tenant_id = current_setting('app.tenant_id', true))
+	WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
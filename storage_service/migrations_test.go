@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyMigrationsIsIdempotent(t *testing.T) {
+	// openTestStorage has already applied every migration once
+	storage := openTestStorage(t, "test-migrations")
+	if err := ApplyMigrations(storage.db); err != nil {
+		t.Fatalf("Second ApplyMigrations: %v", err)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied int
+	if err := storage.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("schema_migrations has %d versions, want %d", applied, len(migrations))
+	}
+
+	var indexDef string
+	err = storage.db.QueryRow(`
+		SELECT indexdef FROM pg_indexes WHERE tablename = 'contracts' AND indexname = 'idx_contracts_parameters'
+	`).Scan(&indexDef)
+	if err != nil {
+		t.Fatalf("Failed to find idx_contracts_parameters: %v", err)
+	}
+	if want := "USING gin (parameters)"; !strings.Contains(indexDef, want) {
+		t.Errorf("idx_contracts_parameters is %q, want a GIN index on parameters", indexDef)
+	}
+}
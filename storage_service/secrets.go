@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// resolveSecret returns the value of a secret from the fields of the Vault
+// KV version 2 secret at VAULT_KV_MOUNT/VAULT_SECRET_PATH (default
+// secret/pricing-server) when VAULT_ADDR is set. It falls back to the
+// environment variable of the same name when Vault is not configured, fails
+// or does not have the secret.
+func resolveSecret(name string) string {
+	if os.Getenv("VAULT_ADDR") == "" {
+		return os.Getenv(name)
+	}
+
+	mount := os.Getenv("VAULT_KV_MOUNT")
+	if mount == "" {
+		mount = "secret"
+	}
+	secretPath := os.Getenv("VAULT_SECRET_PATH")
+	if secretPath == "" {
+		secretPath = "pricing-server"
+	}
+
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		log.Printf("Failed to create Vault client, using %s from the environment: %v", name, err)
+		return os.Getenv(name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	secret, err := client.KVv2(mount).Get(ctx, secretPath)
+	if err != nil {
+		log.Printf("Failed to read %s from Vault, using the environment: %v", name, err)
+		return os.Getenv(name)
+	}
+	value, ok := secret.Data[name].(string)
+	if !ok {
+		return os.Getenv(name)
+	}
+	return value
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SaveMigratedSessions stores the WebSocket sessions handed over by a pricing
+// server, keyed by session ID, replacing sessions with the same IDs
+func (s *PostgresStorage) SaveMigratedSessions(sessions map[string]json.RawMessage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	savedAt := time.Now().UnixMilli()
+	for sessionID, session := range sessions {
+		if _, err := tx.Exec(`
+			INSERT INTO migrated_sessions (session_id, session, saved_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (session_id) DO UPDATE SET
+				session = EXCLUDED.session,
+				saved_at = EXCLUDED.saved_at
+		`, sessionID, []byte(session), savedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// TakeMigratedSessions removes and returns the stored sessions with the
+// given IDs, or every stored session when sessionIDs is empty, so that each
+// session is restored by one pricing server only
+func (s *PostgresStorage) TakeMigratedSessions(sessionIDs []string) (map[string]json.RawMessage, error) {
+	var rows *sql.Rows
+	var err error
+	if len(sessionIDs) == 0 {
+		rows, err = s.db.Query("DELETE FROM migrated_sessions RETURNING session_id, session")
+	} else {
+		rows, err = s.db.Query(
+			"DELETE FROM migrated_sessions WHERE session_id = ANY($1) RETURNING session_id, session",
+			pq.Array(sessionIDs),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var sessionID string
+		var session []byte
+		if err := rows.Scan(&sessionID, &session); err != nil {
+			return nil, err
+		}
+		sessions[sessionID] = json.RawMessage(session)
+	}
+	return sessions, rows.Err()
+}
+
+// PendingMigratedSessions returns which of sessionIDs are still stored, i.e.
+// have not been taken by a pricing server yet
+func (s *PostgresStorage) PendingMigratedSessions(sessionIDs []string) ([]string, error) {
+	rows, err := s.db.Query(
+		"SELECT session_id FROM migrated_sessions WHERE session_id = ANY($1)",
+		pq.Array(sessionIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make([]string, 0)
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return nil, err
+		}
+		pending = append(pending, sessionID)
+	}
+	return pending, rows.Err()
+}
+
+// handleMigratedSessions saves sessions on POST, reports which of the
+// sessions in the id query parameters are still stored on GET, and takes the
+// sessions in the id query parameters, or every session, on DELETE
+func (s *server) handleMigratedSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, ok := s.backend().(interface {
+		SaveMigratedSessions(sessions map[string]json.RawMessage) error
+		TakeMigratedSessions(sessionIDs []string) (map[string]json.RawMessage, error)
+		PendingMigratedSessions(sessionIDs []string) ([]string, error)
+	})
+	if !ok {
+		http.Error(w, "Migrated sessions not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	sessionIDs := r.URL.Query()["id"]
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Sessions map[string]json.RawMessage `json:"sessions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := sessions.SaveMigratedSessions(req.Sessions); err != nil {
+			logf(r.Context(), "Failed to save migrated sessions: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		pending, err := sessions.PendingMigratedSessions(sessionIDs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string][]string{"session_ids": pending}); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+		}
+	case http.MethodDelete:
+		taken, err := sessions.TakeMigratedSessions(sessionIDs)
+		if err != nil {
+			logf(r.Context(), "Failed to take migrated sessions: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]map[string]json.RawMessage{"sessions": taken}); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
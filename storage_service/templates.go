@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ContractTemplate is a set of contract parameters saved under a name, from
+// which the pricing server creates contracts
+type ContractTemplate struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Parameters json.RawMessage `json:"parameters"`
+	CreatedAt  int64           `json:"created_at"`
+}
+
+// contractTemplateStorage is implemented by storages that keep contract
+// templates
+type contractTemplateStorage interface {
+	SaveTemplate(template *ContractTemplate) error
+	ListTemplates() ([]*ContractTemplate, error)
+	GetTemplate(id string) (*ContractTemplate, error)
+	DeleteTemplate(id string) (bool, error)
+}
+
+// SaveTemplate stores a template, replacing any template with the same ID
+func (s *PostgresStorage) SaveTemplate(template *ContractTemplate) error {
+	_, err := s.db.Exec(`
+		INSERT INTO contract_templates (id, name, type, parameters, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			type = EXCLUDED.type,
+			parameters = EXCLUDED.parameters
+	`, template.ID, template.Name, template.Type, []byte(template.Parameters), template.CreatedAt)
+	return err
+}
+
+// ListTemplates returns every template, oldest first
+func (s *PostgresStorage) ListTemplates() ([]*ContractTemplate, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, type, parameters, created_at
+		FROM contract_templates
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := make([]*ContractTemplate, 0)
+	for rows.Next() {
+		var template ContractTemplate
+		var parameters []byte
+		if err := rows.Scan(&template.ID, &template.Name, &template.Type, &parameters, &template.CreatedAt); err != nil {
+			return nil, err
+		}
+		template.Parameters = json.RawMessage(parameters)
+		templates = append(templates, &template)
+	}
+	return templates, rows.Err()
+}
+
+// GetTemplate returns a template, or nil if it does not exist
+func (s *PostgresStorage) GetTemplate(id string) (*ContractTemplate, error) {
+	var template ContractTemplate
+	var parameters []byte
+	err := s.db.QueryRow(`
+		SELECT id, name, type, parameters, created_at
+		FROM contract_templates
+		WHERE id = $1
+	`, id).Scan(&template.ID, &template.Name, &template.Type, &parameters, &template.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	template.Parameters = json.RawMessage(parameters)
+	return &template, nil
+}
+
+// DeleteTemplate removes a template and reports whether it existed
+func (s *PostgresStorage) DeleteTemplate(id string) (bool, error) {
+	result, err := s.db.Exec("DELETE FROM contract_templates WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// newTemplateID returns a random ID for a template saved without one
+func newTemplateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handleContractTemplates saves a template on POST and lists the templates
+// on GET
+func (s *server) handleContractTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, ok := s.backend().(contractTemplateStorage)
+	if !ok {
+		http.Error(w, "Contract templates not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var template ContractTemplate
+		if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if template.Name == "" || template.Type == "" {
+			http.Error(w, "name and type are required", http.StatusBadRequest)
+			return
+		}
+		if len(template.Parameters) == 0 || template.Parameters[0] != '{' {
+			http.Error(w, "parameters must be a JSON object", http.StatusBadRequest)
+			return
+		}
+		if template.ID == "" {
+			id, err := newTemplateID()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			template.ID = id
+		}
+		template.CreatedAt = time.Now().UnixMilli()
+		if err := templates.SaveTemplate(&template); err != nil {
+			logf(r.Context(), "Failed to save template %s: %v", template.ID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(template); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+		}
+	case http.MethodGet:
+		list, err := templates.ListTemplates()
+		if err != nil {
+			logf(r.Context(), "Failed to list templates: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleContractTemplate returns a template on GET and deletes it on DELETE
+func (s *server) handleContractTemplate(w http.ResponseWriter, r *http.Request) {
+	// Path is /contract/template/{id}
+	id := strings.TrimPrefix(r.URL.Path, "/contract/template/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	templates, ok := s.backend().(contractTemplateStorage)
+	if !ok {
+		http.Error(w, "Contract templates not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		template, err := templates.GetTemplate(id)
+		if err != nil {
+			logf(r.Context(), "Failed to load template %s: %v", id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if template == nil {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(template); err != nil {
+			logf(r.Context(), "Error encoding response: %v", err)
+		}
+	case http.MethodDelete:
+		deleted, err := templates.DeleteTemplate(id)
+		if err != nil {
+			logf(r.Context(), "Failed to delete template %s: %v", id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import "database/sql"
+
+// TenantContextDB runs statements against the contracts tables in
+// transactions scoped to a tenant. Each transaction starts by setting
+// app.tenant_id, which the row level security policies of contracts,
+// archived_contracts and contract_events compare with the tenant_id of every
+// row read or written, and which new rows take as their tenant_id.
+type TenantContextDB struct {
+	db       *sql.DB
+	tenantID string
+}
+
+// Begin starts a transaction scoped to the tenant
+func (t TenantContextDB) Begin() (*sql.Tx, error) {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// set_config with is_local true is SET LOCAL, which takes no parameters
+	if _, err := tx.Exec("SELECT set_config('app.tenant_id', $1, true)", t.tenantID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Exec runs a statement in its own transaction scoped to the tenant
+func (t TenantContextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	tx, err := t.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+// Query runs a query in a transaction scoped to the tenant, which ends when
+// the returned rows are closed
+func (t TenantContextDB) Query(query string, args ...interface{}) (*tenantRows, error) {
+	tx, err := t.Begin()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &tenantRows{Rows: rows, tx: tx}, nil
+}
+
+// QueryRow runs a query expected to return at most one row in a transaction
+// scoped to the tenant when the returned row is scanned
+func (t TenantContextDB) QueryRow(query string, args ...interface{}) *tenantRow {
+	return &tenantRow{db: t, query: query, args: args}
+}
+
+// tenantRows are the rows of a TenantContextDB query
+type tenantRows struct {
+	*sql.Rows
+	tx *sql.Tx
+}
+
+// Close closes the rows and ends their transaction
+func (r *tenantRows) Close() error {
+	err := r.Rows.Close()
+	if commitErr := r.tx.Commit(); err == nil && commitErr != sql.ErrTxDone {
+		err = commitErr
+	}
+	return err
+}
+
+// tenantRow is the row of a TenantContextDB query, queried when scanned
+type tenantRow struct {
+	db    TenantContextDB
+	query string
+	args  []interface{}
+}
+
+// Scan queries the row and copies its columns into dest, returning
+// sql.ErrNoRows if there is none
+func (r *tenantRow) Scan(dest ...interface{}) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(r.query, r.args...).Scan(dest...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
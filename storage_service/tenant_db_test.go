@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+// skipIfBypassingRLS skips t when the role of storage is not subject to row
+// level security, which superusers and BYPASSRLS roles are not
+func skipIfBypassingRLS(t *testing.T, storage *PostgresStorage) {
+	var bypass bool
+	if err := storage.db.QueryRow(`
+		SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user
+	`).Scan(&bypass); err != nil {
+		t.Fatalf("Failed to read the test database role: %v", err)
+	}
+	if bypass {
+		t.Skip("STORAGE_TEST_DSN uses a role that bypasses row level security")
+	}
+}
+
+func TestTenantsDoNotSeeEachOthersContracts(t *testing.T) {
+	tenantA := openTestStorage(t, "test-tenant-a")
+	tenantB := openTestStorage(t, "test-tenant-b")
+	skipIfBypassingRLS(t, tenantA)
+
+	contractsA := testContracts("tenant-a", 3)
+	contractsB := testContracts("tenant-b", 3)
+	if err := tenantA.SaveBatchOptimized(contractsA); err != nil {
+		t.Fatalf("SaveBatchOptimized as tenant A: %v", err)
+	}
+	if err := tenantB.SaveBatchOptimized(contractsB); err != nil {
+		t.Fatalf("SaveBatchOptimized as tenant B: %v", err)
+	}
+
+	stored, err := tenantA.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll as tenant A: %v", err)
+	}
+	if len(stored) != len(contractsA) {
+		t.Errorf("GetAll as tenant A returned %d contracts, want %d", len(stored), len(contractsA))
+	}
+	for _, contract := range stored {
+		if contract.ID == contractsB[0].ID || contract.ID == contractsB[1].ID || contract.ID == contractsB[2].ID {
+			t.Errorf("GetAll as tenant A returned contract %s of tenant B", contract.ID)
+		}
+	}
+
+	other := contractsB[0].ID
+	if contract, err := tenantA.Get(other); err != nil || contract != nil {
+		t.Errorf("Get(%s) as tenant A = %v, %v, want nil, nil", other, contract, err)
+	}
+	if events, err := tenantA.GetEvents(other); err != nil || len(events) != 0 {
+		t.Errorf("GetEvents(%s) as tenant A returned %d events and %v, want none", other, len(events), err)
+	}
+
+	if err := tenantA.Delete(other); err != nil {
+		t.Fatalf("Delete(%s) as tenant A: %v", other, err)
+	}
+	if contract, err := tenantB.Get(other); err != nil || contract == nil {
+		t.Errorf("Get(%s) as tenant B after tenant A deleted it = %v, %v, want the contract", other, contract, err)
+	}
+	if events, err := tenantB.GetEvents(other); err != nil || len(events) == 0 {
+		t.Errorf("GetEvents(%s) as tenant B returned %d events and %v, want its history", other, len(events), err)
+	}
+}
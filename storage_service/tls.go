@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// mtlsConfigFromEnv returns the TLS configuration of the HTTP server when
+// STORAGE_MTLS is true, or nil otherwise. The server presents the certificate
+// in STORAGE_TLS_CERT_FILE and STORAGE_TLS_KEY_FILE and only accepts clients
+// with a certificate signed by a CA in STORAGE_CLIENT_CA_FILE.
+func mtlsConfigFromEnv() (*tls.Config, error) {
+	enabled, _ := strconv.ParseBool(os.Getenv("STORAGE_MTLS"))
+	if !enabled {
+		return nil, nil
+	}
+
+	certFile := os.Getenv("STORAGE_TLS_CERT_FILE")
+	keyFile := os.Getenv("STORAGE_TLS_KEY_FILE")
+	caFile := os.Getenv("STORAGE_CLIENT_CA_FILE")
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, fmt.Errorf("STORAGE_MTLS requires STORAGE_TLS_CERT_FILE, STORAGE_TLS_KEY_FILE and STORAGE_CLIENT_CA_FILE")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA certificate: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
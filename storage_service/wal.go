@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// walCompactThreshold is the number of entries after which the log is
+// truncated once every save in it has been committed or aborted
+const walCompactThreshold = 1000
+
+// WAL entry operations
+const (
+	walOpSave   = "save"
+	walOpCommit = "commit"
+	walOpAbort  = "abort"
+)
+
+// walEntry is one line of the write-ahead log. A save entry is acknowledged
+// by a commit entry with the same sequence number once the database write
+// succeeds, or by an abort entry once it fails and the error is returned.
+type walEntry struct {
+	Seq      uint64    `json:"seq"`
+	Op       string    `json:"op"`
+	ID       string    `json:"id,omitempty"`
+	Contract *Contract `json:"contract,omitempty"`
+	// IfNotExists marks a save made with SaveIfNotExists, which is replayed
+	// the same way so it cannot overwrite a contract stored meanwhile
+	IfNotExists bool `json:"if_not_exists,omitempty"`
+}
+
+// WriteAheadLog records every Save in an append-only file, synced to disk,
+// before writing it to the wrapped storage, so saves interrupted by a crash
+// can be replayed on the next start
+type WriteAheadLog struct {
+	storage Storage
+	file    *os.File
+	seq     uint64
+	// pending maps the sequence numbers of unacknowledged saves to their
+	// contract IDs
+	pending map[uint64]string
+	entries int
+	mu      sync.Mutex
+}
+
+// NewWriteAheadLog opens the log at path, replays any saves that were not
+// committed into storage, and wraps storage with the log
+func NewWriteAheadLog(path string, storage Storage) (*WriteAheadLog, error) {
+	uncommitted, seq, err := readWAL(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining []walEntry
+	for _, entry := range uncommitted {
+		if err := replayWALEntry(storage, entry); err != nil {
+			log.Printf("Failed to replay WAL entry %d for contract %s: %v", entry.Seq, entry.ID, err)
+			remaining = append(remaining, entry)
+			continue
+		}
+		log.Printf("Replayed WAL entry %d for contract %s", entry.Seq, entry.ID)
+	}
+
+	// Rewrite the log with only the saves that still need replaying
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &WriteAheadLog{
+		storage: storage,
+		file:    file,
+		seq:     seq,
+		pending: make(map[uint64]string),
+	}
+	for _, entry := range remaining {
+		if err := w.append(entry); err != nil {
+			file.Close()
+			return nil, err
+		}
+		w.pending[entry.Seq] = entry.ID
+	}
+	return w, nil
+}
+
+// replayWALEntry writes an uncommitted save to storage
+func replayWALEntry(storage Storage, entry walEntry) error {
+	if !entry.IfNotExists {
+		return storage.Save(entry.ID, entry.Contract)
+	}
+	creator, ok := storage.(contractCreator)
+	if !ok {
+		return errConditionalSavesUnsupported
+	}
+	_, err := creator.SaveIfNotExists(entry.ID, entry.Contract)
+	return err
+}
+
+// readWAL returns the uncommitted saves in the log at path, in order, and the
+// highest sequence number used. Only the latest save of each contract is
+// returned, as replaying an older one would overwrite it.
+func readWAL(path string) ([]walEntry, uint64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var saves []walEntry
+	committed := make(map[uint64]bool)
+	aborted := make(map[uint64]bool)
+	var seq uint64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn final line from a crash mid-append is skipped
+			log.Printf("Skipping unreadable WAL entry: %v", err)
+			continue
+		}
+		if entry.Seq > seq {
+			seq = entry.Seq
+		}
+		switch entry.Op {
+		case walOpSave:
+			saves = append(saves, entry)
+		case walOpCommit:
+			committed[entry.Seq] = true
+		case walOpAbort:
+			aborted[entry.Seq] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	latest := make(map[string]uint64)
+	for _, entry := range saves {
+		if !aborted[entry.Seq] {
+			latest[entry.ID] = entry.Seq
+		}
+	}
+	var uncommitted []walEntry
+	for _, entry := range saves {
+		if !committed[entry.Seq] && !aborted[entry.Seq] && latest[entry.ID] == entry.Seq {
+			uncommitted = append(uncommitted, entry)
+		}
+	}
+	return uncommitted, seq, nil
+}
+
+// Storage returns the wrapped storage
+func (w *WriteAheadLog) Storage() Storage {
+	return w.storage
+}
+
+func (w *WriteAheadLog) Save(id string, contract *Contract) error {
+	return w.logSave(walEntry{Op: walOpSave, ID: id, Contract: contract}, func() error {
+		return w.storage.Save(id, contract)
+	})
+}
+
+// SaveIfNotExists logs the save like Save, marked to be replayed only if the
+// contract is still not stored
+func (w *WriteAheadLog) SaveIfNotExists(id string, contract *Contract) (bool, error) {
+	creator, ok := w.storage.(contractCreator)
+	if !ok {
+		return false, errConditionalSavesUnsupported
+	}
+	var created bool
+	err := w.logSave(walEntry{Op: walOpSave, ID: id, Contract: contract, IfNotExists: true}, func() error {
+		var err error
+		created, err = creator.SaveIfNotExists(id, contract)
+		return err
+	})
+	return created, err
+}
+
+// logSave appends the save entry, runs save, and commits or aborts the entry
+// depending on its result
+func (w *WriteAheadLog) logSave(entry walEntry, save func() error) error {
+	id := entry.ID
+	w.mu.Lock()
+	w.seq++
+	seq := w.seq
+	entry.Seq = seq
+	err := w.append(entry)
+	if err == nil {
+		w.pending[seq] = id
+	}
+	w.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write WAL entry: %v", err)
+	}
+
+	saveErr := save()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	op := walOpCommit
+	if saveErr != nil {
+		// The caller is told the save failed, so it must not be replayed
+		op = walOpAbort
+	}
+	if err := w.append(walEntry{Seq: seq, Op: op}); err != nil {
+		// The save will be replayed on the next start unless a later save of
+		// the contract supersedes it
+		log.Printf("Failed to %s WAL entry %d: %v", op, seq, err)
+	}
+	delete(w.pending, seq)
+	if saveErr == nil {
+		// Older saves of the contract left from a failed replay will not be
+		// replayed any more
+		for pendingSeq, pendingID := range w.pending {
+			if pendingID == id && pendingSeq < seq {
+				delete(w.pending, pendingSeq)
+			}
+		}
+	}
+	w.compact()
+	return saveErr
+}
+
+// append writes entry to the log and syncs it to disk. Callers must hold mu.
+func (w *WriteAheadLog) append(entry walEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	w.entries++
+	return w.file.Sync()
+}
+
+// compact truncates the log once it is long and fully committed. Callers
+// must hold mu.
+func (w *WriteAheadLog) compact() {
+	if len(w.pending) > 0 || w.entries < walCompactThreshold {
+		return
+	}
+	if err := w.file.Truncate(0); err != nil {
+		log.Printf("Failed to truncate WAL: %v", err)
+		return
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		log.Printf("Failed to rewind WAL: %v", err)
+		return
+	}
+	w.entries = 0
+}
+
+// Close closes the log file
+func (w *WriteAheadLog) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+func (w *WriteAheadLog) Get(id string) (*Contract, error) {
+	return w.storage.Get(id)
+}
+
+func (w *WriteAheadLog) Delete(id string) error {
+	return w.storage.Delete(id)
+}
+
+func (w *WriteAheadLog) GetAll() ([]*Contract, error) {
+	return w.storage.GetAll()
+}
+
+func (w *WriteAheadLog) Clean() error {
+	return w.storage.Clean()
+}
+
+// ArchiveContracts is not logged, as an interrupted archive leaves the
+// contracts in place for the next run
+func (w *WriteAheadLog) ArchiveContracts(age time.Duration) ([]string, error) {
+	archive, ok := w.storage.(contractArchiver)
+	if !ok {
+		return nil, errArchivingUnsupported
+	}
+	return archive.ArchiveContracts(age)
+}
+
+func (w *WriteAheadLog) UpdateFinalPrice(id string, price float64) error {
+	return w.storage.UpdateFinalPrice(id, price)
+}
+
+func (w *WriteAheadLog) UpdateHitRungs(id string, rungs []float64) error {
+	return w.storage.UpdateHitRungs(id, rungs)
+}
+
+func (w *WriteAheadLog) UpdateTimeToTarget(id string, ms int64) error {
+	return w.storage.UpdateTimeToTarget(id, ms)
+}
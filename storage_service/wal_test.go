@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDatabaseDown = errors.New("database is down")
+
+// memoryStorage is a Storage keeping contracts in memory. Saves fail with
+// errDatabaseDown while failSaves is set, and panic with it while crashSaves
+// is set, as if the process died during the database write.
+type memoryStorage struct {
+	contracts  map[string]*Contract
+	archived   map[string]*Contract
+	failSaves  bool
+	crashSaves bool
+	mu         sync.Mutex
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		contracts: make(map[string]*Contract),
+		archived:  make(map[string]*Contract),
+	}
+}
+
+func (s *memoryStorage) Save(id string, contract *Contract) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.crashSaves {
+		panic(errDatabaseDown)
+	}
+	if s.failSaves {
+		return errDatabaseDown
+	}
+	s.contracts[id] = contract
+	return nil
+}
+
+func (s *memoryStorage) SaveIfNotExists(id string, contract *Contract) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.crashSaves {
+		panic(errDatabaseDown)
+	}
+	if s.failSaves {
+		return false, errDatabaseDown
+	}
+	if _, ok := s.contracts[id]; ok {
+		return false, nil
+	}
+	s.contracts[id] = contract
+	return true, nil
+}
+
+func (s *memoryStorage) Get(id string) (*Contract, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.contracts[id], nil
+}
+
+func (s *memoryStorage) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.contracts, id)
+	return nil
+}
+
+func (s *memoryStorage) GetAll() ([]*Contract, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contracts := make([]*Contract, 0, len(s.contracts))
+	for _, contract := range s.contracts {
+		contracts = append(contracts, contract)
+	}
+	return contracts, nil
+}
+
+func (s *memoryStorage) Clean() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contracts = make(map[string]*Contract)
+	return nil
+}
+
+func (s *memoryStorage) ArchiveContracts(age time.Duration) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := time.Now().Add(-age).UnixMilli()
+	var ids []string
+	for id, contract := range s.contracts {
+		if !contract.IsActive && contract.CreatedAt < cutoff {
+			s.archived[id] = contract
+			delete(s.contracts, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memoryStorage) UpdateFinalPrice(id string, price float64) error { return nil }
+func (s *memoryStorage) UpdateHitRungs(id string, rungs []float64) error { return nil }
+func (s *memoryStorage) UpdateTimeToTarget(id string, ms int64) error    { return nil }
+
+// crashDuringSave saves contract through wal to a storage that crashes
+// during the database write, leaving the save uncommitted in the log
+func crashDuringSave(t *testing.T, path string, contract *Contract) {
+	t.Helper()
+	crashing := newMemoryStorage()
+	crashing.crashSaves = true
+	wal, err := NewWriteAheadLog(path, crashing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Save did not reach the database")
+		}
+	}()
+	wal.Save(contract.ID, contract)
+}
+
+func TestWriteAheadLogReplaysSaveInterruptedByCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	contract := testContracts("wal", 1)[0]
+	crashDuringSave(t, path, contract)
+
+	// The service restarts
+	storage := newMemoryStorage()
+	wal, err := NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	got, err := wal.Get(contract.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got == nil || got.ID != contract.ID || got.Type != contract.Type || got.CreatedAt != contract.CreatedAt {
+		t.Fatalf("Get returned %+v after replay, want %+v", got, contract)
+	}
+}
+
+func TestWriteAheadLogDoesNotReplayFailedSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	failing := newMemoryStorage()
+	failing.failSaves = true
+	wal, err := NewWriteAheadLog(path, failing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := testContracts("wal", 1)[0]
+	if err := wal.Save(contract.ID, contract); !errors.Is(err, errDatabaseDown) {
+		t.Fatalf("Save returned %v while the database is down, want %v", err, errDatabaseDown)
+	}
+	if len(wal.pending) != 0 {
+		t.Errorf("Failed save left %d pending WAL entries", len(wal.pending))
+	}
+	wal.Close()
+
+	storage := newMemoryStorage()
+	wal, err = NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	if got, _ := storage.Get(contract.ID); got != nil {
+		t.Fatalf("Save of %s that was reported as failed was replayed", contract.ID)
+	}
+}
+
+func TestWriteAheadLogCompactsAfterFailedSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	storage := newMemoryStorage()
+	wal, err := NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	contract := testContracts("wal", 1)[0]
+	storage.failSaves = true
+	wal.Save(contract.ID, contract)
+	storage.failSaves = false
+
+	// Each save appends a save and a commit entry
+	for i := 0; i < walCompactThreshold/2; i++ {
+		if err := wal.Save(contract.ID, contract); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	if wal.entries >= walCompactThreshold {
+		t.Errorf("WAL holds %d entries after %d saves, want it compacted", wal.entries, walCompactThreshold/2+1)
+	}
+}
+
+func TestWriteAheadLogDoesNotReplaySupersededSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	contracts := testContracts("wal", 1)
+	crashDuringSave(t, path, contracts[0])
+
+	// The database is down on restart, then a newer state is saved
+	storage := newMemoryStorage()
+	storage.failSaves = true
+	wal, err := NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.failSaves = false
+	newer := *contracts[0]
+	newer.IsActive = false
+	if err := wal.Save(newer.ID, &newer); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if len(wal.pending) != 0 {
+		t.Errorf("Superseded save left %d pending WAL entries", len(wal.pending))
+	}
+	wal.Close()
+
+	storage = newMemoryStorage()
+	wal, err = NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	if got, _ := storage.Get(newer.ID); got != nil {
+		t.Fatalf("Superseded save of %s was replayed", newer.ID)
+	}
+}
+
+func TestWriteAheadLogDoesNotReplayCommittedSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	wal, err := NewWriteAheadLog(path, newMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := testContracts("wal", 1)[0]
+	if err := wal.Save(contract.ID, contract); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	wal.Close()
+
+	storage := newMemoryStorage()
+	wal, err = NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	if got, _ := storage.Get(contract.ID); got != nil {
+		t.Fatalf("Committed save of %s was replayed", contract.ID)
+	}
+}
+
+func TestWriteAheadLogKeepsSaveWhoseReplayFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	contract := testContracts("wal", 1)[0]
+	crashDuringSave(t, path, contract)
+
+	// The database is down on the first restart
+	failing := newMemoryStorage()
+	failing.failSaves = true
+	wal, err := NewWriteAheadLog(path, failing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	storage := newMemoryStorage()
+	wal, err = NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	if got, _ := storage.Get(contract.ID); got == nil {
+		t.Fatalf("Save of %s was not replayed after a failed replay", contract.ID)
+	}
+}
+
+func TestWriteAheadLogReplaysConditionalSaveWithoutOverwriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.wal")
+	contracts := testContracts("wal", 2)
+	crashing := newMemoryStorage()
+	crashing.crashSaves = true
+	wal, err := NewWriteAheadLog(path, crashing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("SaveIfNotExists did not reach the database")
+			}
+		}()
+		wal.SaveIfNotExists(contracts[0].ID, contracts[0])
+	}()
+	wal.Close()
+
+	// Another writer stored the contract before the restart
+	stored := &Contract{ID: contracts[0].ID, Type: "MomentumCatcher"}
+	storage := newMemoryStorage()
+	storage.contracts[stored.ID] = stored
+	wal, err = NewWriteAheadLog(path, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	if got, _ := storage.Get(stored.ID); got != stored {
+		t.Fatalf("Replay overwrote the stored contract with %+v", got)
+	}
+	created, err := wal.SaveIfNotExists(contracts[1].ID, contracts[1])
+	if err != nil || !created {
+		t.Fatalf("SaveIfNotExists of a new contract = %v, %v, want true", created, err)
+	}
+	created, err = wal.SaveIfNotExists(contracts[1].ID, contracts[0])
+	if err != nil || created {
+		t.Fatalf("SaveIfNotExists of a stored contract = %v, %v, want false", created, err)
+	}
+}